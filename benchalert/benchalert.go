@@ -0,0 +1,89 @@
+// Command benchalert watches measurements written by runbench and notifies a webhook when a new
+// result is anomalous compared to previous results of the same scenario, closing the loop from
+// measurement to notification without InfluxDB.
+//
+// Usage: benchalert --webhook-url=<url> [--output-dir=<dir>] [--window=20] [--threshold=3]
+//
+// Every --poll-interval it looks for .line files under --output-dir (<outputDir>/<scenario>/<gitTime>-<gitHash>.line)
+// which have not been processed yet. Each value of --metrics in a new file is compared with the
+// trailing --window values of the same series (measurement, field and tags other than the revision)
+// with earlier git time and is anomalous if its z-score exceeds --threshold. Series with fewer than
+// --min-history earlier values are not evaluated. Anomalies of a file are posted to --webhook-url as
+// a single JSON object whose 'text' is a human-readable summary (which Slack-compatible webhooks
+// display) and 'anomalies' lists them in detail.
+//
+// Processed files are recorded in --state-file along with the hash of their contents, files modified
+// after being processed (e.g. by runbench --force) are evaluated again. Files of a failed webhook
+// request are retried during the next poll. When the state file doesn't exist, existing files are
+// recorded as history without being evaluated.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	outputDir    = flag.String("output-dir", filepath.Join(os.TempDir(), "kopia-benchmark-outputs"), "Output directory of runbench")
+	stateFile    = flag.String("state-file", "", "File recording processed files (default: <output-dir>/.benchalert-state)")
+	webhookURL   = flag.String("webhook-url", "", "URL to POST alerts to")
+	metricList   = flag.String("metrics", "process_summary.duration,process_ram_summary.max_ram_rss,process_summary.repo_size", "Comma-separated list of <measurement>.<field> to evaluate")
+	window       = flag.Int("window", 20, "Number of previous values each new value is compared to")
+	minHistory   = flag.Int("min-history", 5, "Minimum number of previous values needed to evaluate a series")
+	threshold    = flag.Float64("threshold", 3, "Minimum absolute z-score of an anomalous value")
+	pollInterval = flag.Duration("poll-interval", time.Minute, "Interval between checks for new results")
+	once         = flag.Bool("once", false, "Process new results once and exit")
+)
+
+func main() {
+	flag.Parse()
+
+	if *webhookURL == "" {
+		log.Fatal("missing --webhook-url")
+	}
+
+	if *window < *minHistory || *minHistory < 2 {
+		log.Fatal("--min-history must be at least 2 and at most --window")
+	}
+
+	if *stateFile == "" {
+		*stateFile = filepath.Join(*outputDir, ".benchalert-state")
+	}
+
+	metrics := map[string]bool{}
+	for _, m := range strings.Split(*metricList, ",") {
+		metrics[strings.TrimSpace(m)] = true
+	}
+
+	w, err := newWatcher(metrics)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer cancel()
+
+	for {
+		if err := w.poll(ctx); err != nil {
+			log.Printf("error processing results: %v", err)
+		}
+
+		if *once {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("stopping")
+			return
+		case <-time.After(*pollInterval):
+		}
+	}
+}
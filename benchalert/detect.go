@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// anomaly is a value which deviates from the trailing window of its series.
+type anomaly struct {
+	Series  string  `json:"series"`
+	Rev     string  `json:"rev"`
+	Value   float64 `json:"value"`
+	Mean    float64 `json:"mean"`
+	StdDev  float64 `json:"stddev"`
+	ZScore  float64 `json:"zscore"`
+	History int     `json:"history"`
+}
+
+// evaluate returns anomalous values of the file, compared with values of other files with
+// earlier git time.
+func (w *watcher) evaluate(rf *resultFile) []anomaly {
+	var result []anomaly
+
+	for key, v := range rf.values {
+		var prev []float64
+
+		for _, h := range w.history[key] {
+			if h.file != rf.rel && h.timestamp < v.timestamp {
+				prev = append(prev, h.value)
+			}
+		}
+
+		if len(prev) > *window {
+			prev = prev[len(prev)-*window:]
+		}
+
+		if len(prev) < *minHistory {
+			continue
+		}
+
+		mean, stddev := meanStdDev(prev)
+
+		// constant series have no meaningful z-score.
+		if stddev == 0 {
+			continue
+		}
+
+		z := (v.value - mean) / stddev
+		if math.Abs(z) < *threshold {
+			continue
+		}
+
+		result = append(result, anomaly{
+			Series:  key,
+			Rev:     v.rev,
+			Value:   v.value,
+			Mean:    mean,
+			StdDev:  stddev,
+			ZScore:  z,
+			History: len(prev),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Series < result[j].Series
+	})
+
+	return result
+}
+
+func meanStdDev(values []float64) (mean, stddev float64) {
+	for _, v := range values {
+		mean += v
+	}
+
+	mean /= float64(len(values))
+
+	for _, v := range values {
+		stddev += (v - mean) * (v - mean)
+	}
+
+	return mean, math.Sqrt(stddev / float64(len(values)-1))
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"runbench/pkg/bench"
+)
+
+// tags which differ between revisions of the same series.
+var revisionTags = map[string]bool{
+	"rev":     true,
+	"mod":     true,
+	"gitTime": true,
+}
+
+// value is a single value of a series.
+type value struct {
+	file      string
+	rev       string
+	timestamp int64
+	value     float64
+}
+
+// watcher keeps history of all series and evaluates new results against it.
+type watcher struct {
+	metrics   map[string]bool
+	processed map[string]string // content hash by relative path
+	loaded    map[string]string // content hash of files in history by relative path
+	history   map[string][]value
+	initial   bool
+}
+
+func newWatcher(metrics map[string]bool) (*watcher, error) {
+	w := &watcher{metrics: metrics, processed: map[string]string{}, loaded: map[string]string{}, history: map[string][]value{}}
+
+	f, err := os.Open(*stateFile)
+	if os.IsNotExist(err) {
+		w.initial = true
+		return w, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		hash, rel, ok := strings.Cut(s.Text(), " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid line in %v: %q", *stateFile, s.Text())
+		}
+
+		w.processed[rel] = hash
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %v: %w", *stateFile, err)
+	}
+
+	return w, nil
+}
+
+// poll reads all result files, adding processed ones to history and evaluating new ones in
+// the order of their git time.
+func (w *watcher) poll(ctx context.Context) error {
+	var pending []*resultFile
+
+	err := filepath.WalkDir(*outputDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || !strings.HasSuffix(p, ".line") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(*outputDir, p)
+		if err != nil {
+			return err
+		}
+
+		rf, err := w.readFile(p, rel)
+		if err != nil {
+			return err
+		}
+
+		if w.processed[rel] == rf.hash {
+			if w.loaded[rf.rel] != rf.hash {
+				w.addToHistory(rf)
+			}
+
+			return nil
+		}
+
+		pending = append(pending, rf)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].timestamp < pending[j].timestamp
+	})
+
+	for _, rf := range pending {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if !w.initial {
+			if anomalies := w.evaluate(rf); len(anomalies) > 0 {
+				if err := notify(ctx, rf.rel, anomalies); err != nil {
+					return fmt.Errorf("unable to notify about %v: %w", rf.rel, err)
+				}
+			}
+		}
+
+		w.addToHistory(rf)
+
+		if err := w.record(rf); err != nil {
+			return err
+		}
+	}
+
+	if w.initial {
+		log.Printf("recorded %v existing files as history", len(pending))
+		w.initial = false
+	}
+
+	return nil
+}
+
+// resultFile holds values of evaluated metrics read from a single .line file.
+type resultFile struct {
+	rel       string
+	hash      string
+	timestamp int64
+	values    map[string]value
+}
+
+func (w *watcher) readFile(fname, rel string) (*resultFile, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.Sum256(data)
+	rf := &resultFile{rel: rel, hash: hex.EncodeToString(h[:]), values: map[string]value{}}
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	s.Buffer(nil, 1<<20)
+
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p, err := bench.ParseLine(line)
+		if err != nil {
+			log.Printf("skipping invalid line in %v: %v", rel, err)
+			continue
+		}
+
+		for field, v := range p.Fields {
+			if !w.metrics[p.Measurement+"."+field] {
+				continue
+			}
+
+			rf.timestamp = p.Timestamp
+			rf.values[seriesKey(p, field)] = value{file: rel, rev: p.Tags["rev"], timestamp: p.Timestamp, value: v}
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %v: %w", rel, err)
+	}
+
+	return rf, nil
+}
+
+// seriesKey identifies the series of the field of the point, which are values of the same
+// scenario on the same platform in the same configuration across revisions.
+func seriesKey(p bench.ParsedPoint, field string) string {
+	var tags []string
+
+	for k, v := range p.Tags {
+		if !revisionTags[k] {
+			tags = append(tags, k+"="+v)
+		}
+	}
+
+	sort.Strings(tags)
+
+	return p.Measurement + "." + field + "," + strings.Join(tags, ",")
+}
+
+// addToHistory replaces values of a previous version of the file in history with its values.
+func (w *watcher) addToHistory(rf *resultFile) {
+	for key, vals := range w.history {
+		kept := vals[:0]
+
+		for _, v := range vals {
+			if v.file != rf.rel {
+				kept = append(kept, v)
+			}
+		}
+
+		w.history[key] = kept
+	}
+
+	for key, v := range rf.values {
+		w.history[key] = append(w.history[key], v)
+	}
+
+	w.loaded[rf.rel] = rf.hash
+
+	for _, vals := range w.history {
+		sort.Slice(vals, func(i, j int) bool {
+			return vals[i].timestamp < vals[j].timestamp
+		})
+	}
+}
+
+func (w *watcher) record(rf *resultFile) error {
+	f, err := os.OpenFile(*stateFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	if _, err := fmt.Fprintf(f, "%v %v\n", rf.hash, rf.rel); err != nil {
+		return err
+	}
+
+	w.processed[rf.rel] = rf.hash
+
+	return f.Close()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// alert is the JSON body posted to the webhook.
+type alert struct {
+	Text      string    `json:"text"`
+	File      string    `json:"file"`
+	Anomalies []anomaly `json:"anomalies"`
+}
+
+func notify(ctx context.Context, rel string, anomalies []anomaly) error {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%v anomalous benchmark results in %v:", len(anomalies), rel)
+
+	for _, a := range anomalies {
+		fmt.Fprintf(&sb, "\n• %v: %.4g (mean %.4g, z-score %+.1f)", a.Series, a.Value, a.Mean, a.ZScore)
+	}
+
+	log.Print(sb.String())
+
+	body, err := json.Marshal(alert{Text: sb.String(), File: rel, Anomalies: anomalies})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, *webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %v", resp.Status)
+	}
+
+	return nil
+}
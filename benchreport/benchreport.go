@@ -0,0 +1,280 @@
+// Command benchreport renders trend reports from measurements written by runbench, so that results
+// can be browsed without InfluxDB and Grafana.
+//
+// It reads all .line files under --output-dir (<outputDir>/<scenario>/<gitTime>-<gitHash>.line)
+// and for each scenario, platform and state plots duration, maximum RSS and repository size over git
+// time of the measured kopia revisions. The state is made of all other tags of the measurements,
+// such as step, cache, memory_limit or --run-tags, so that differently measured points don't
+// share a series.
+//
+// Usage: benchreport [--output-dir=<dir>] [--report-dir=<dir>] [--format=html|markdown]
+//
+// HTML reports are a single self-contained index.html with inline SVG charts, Markdown reports
+// are a single README.md with a table per scenario.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+const (
+	formatHTML     = "html"
+	formatMarkdown = "markdown"
+)
+
+var (
+	outputDir = flag.String("output-dir", filepath.Join(os.TempDir(), "kopia-benchmark-outputs"), "Output directory of runbench")
+	reportDir = flag.String("report-dir", "", "Directory to write the report to (default: <output-dir>/report)")
+	format    = flag.String("format", formatHTML, "Report format: html or markdown")
+	lastRows  = flag.Int("last-rows", 20, "Number of most recent revisions listed in tables")
+)
+
+// metrics are the plotted metrics, as measurement and field written by runbench.
+var metrics = []metric{
+	{"duration", "process_summary", "duration", "s"},
+	{"max RSS", "process_ram_summary", "max_ram_rss", "bytes"},
+	{"repo size", "process_summary", "repo_size", "bytes"},
+}
+
+// revisionTags are tags which identify the revision or run rather than what was measured, so
+// they don't distinguish series.
+var revisionTags = map[string]bool{
+	"rev":     true,
+	"mod":     true,
+	"gitTime": true,
+	"run":     true,
+	"seed":    true,
+	"path":    true,
+}
+
+type metric struct {
+	name        string
+	measurement string
+	field       string
+	unit        string
+}
+
+// revision is the result of benchmarking a single kopia revision.
+type revision struct {
+	gitTime time.Time
+	rev     string
+	values  map[string]float64 // by metric name
+}
+
+// series are revisions of a scenario on a platform in a state, sorted by git time.
+type series struct {
+	scenario  string
+	platform  string
+	state     string // space-separated key=value of other tags, sorted by key
+	revisions []*revision
+}
+
+// title returns the description of the series in reports.
+func (s *series) title() string {
+	if s.state == "" {
+		return fmt.Sprintf("%v (%v)", s.scenario, s.platform)
+	}
+
+	return fmt.Sprintf("%v (%v, %v)", s.scenario, s.platform, s.state)
+}
+
+func main() {
+	flag.Parse()
+
+	if *reportDir == "" {
+		*reportDir = filepath.Join(*outputDir, "report")
+	}
+
+	all, err := readSeries(*outputDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.MkdirAll(*reportDir, 0o755); err != nil {
+		log.Fatal(err)
+	}
+
+	var fname string
+
+	switch *format {
+	case formatHTML:
+		fname = filepath.Join(*reportDir, "index.html")
+		err = writeReport(fname, all, renderHTML)
+	case formatMarkdown:
+		fname = filepath.Join(*reportDir, "README.md")
+		err = writeReport(fname, all, renderMarkdown)
+	default:
+		log.Fatalf("unsupported --format %q", *format)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("wrote report of %v series to %v", len(all), fname)
+}
+
+// readSeries reads all .line files under dir, skipping the report directory.
+func readSeries(dir string) ([]*series, error) {
+	byKey := map[string]*series{}
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() && p == *reportDir {
+			return filepath.SkipDir
+		}
+
+		if d.IsDir() || !strings.HasSuffix(p, ".line") {
+			return nil
+		}
+
+		return readLineFile(p, byKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*series
+
+	for _, s := range byKey {
+		sort.Slice(s.revisions, func(i, j int) bool {
+			return s.revisions[i].gitTime.Before(s.revisions[j].gitTime)
+		})
+
+		result = append(result, s)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].scenario != result[j].scenario {
+			return result[i].scenario < result[j].scenario
+		}
+
+		if result[i].platform != result[j].platform {
+			return result[i].platform < result[j].platform
+		}
+
+		return result[i].state < result[j].state
+	})
+
+	return result, nil
+}
+
+// readLineFile adds measurements from a single file written by runbench, which has results of one revision.
+func readLineFile(fname string, byKey map[string]*series) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	revs := map[string]*revision{}
+
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p, err := bench.ParseLine(line)
+		if err != nil {
+			log.Printf("skipping invalid line in %v: %v", fname, err)
+			continue
+		}
+
+		scen := p.Tags["scenario"]
+		if scen == "" || !isPlotted(p.Measurement) {
+			continue
+		}
+
+		platform := strings.Trim(p.Tags["os"]+"/"+p.Tags["arch"], "/")
+		state := stateOf(p.Tags)
+		key := scen + "\x00" + platform + "\x00" + state
+
+		r := revs[key]
+		if r == nil {
+			r = &revision{gitTime: time.Unix(0, p.Timestamp), rev: p.Tags["rev"], values: map[string]float64{}}
+			revs[key] = r
+
+			if byKey[key] == nil {
+				byKey[key] = &series{scenario: scen, platform: platform, state: state}
+			}
+
+			byKey[key].revisions = append(byKey[key].revisions, r)
+		}
+
+		for _, m := range metrics {
+			if v, ok := p.Fields[m.field]; ok && p.Measurement == m.measurement {
+				r.values[m.name] = v
+			}
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return fmt.Errorf("error reading %v: %w", fname, err)
+	}
+
+	return nil
+}
+
+// isPlotted returns whether any of the plotted metrics is a field of the measurement.
+func isPlotted(measurement string) bool {
+	for _, m := range metrics {
+		if m.measurement == measurement {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stateOf returns the state of the series of a point, which is all tags except the scenario,
+// platform and revisionTags.
+func stateOf(tags map[string]string) string {
+	var state []string
+
+	for k, v := range tags {
+		switch {
+		case k == "scenario" || k == "os" || k == "arch":
+		case revisionTags[k]:
+		default:
+			state = append(state, k+"="+v)
+		}
+	}
+
+	sort.Strings(state)
+
+	return strings.Join(state, " ")
+}
+
+func writeReport(fname string, all []*series, render func(f *os.File, all []*series) error) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	if err := render(f, all); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testLines are measurements of one revision written by runbench for two steps of a scenario,
+// each with both cache states, along with points which aren't plotted.
+const testLines = `process_summary,rev=abc,mod=false,gitTime=1,scenario=s1,os=linux,arch=amd64,step=initial,cache=cold duration=10,repo_size=100 1000
+process_summary,rev=abc,mod=false,gitTime=1,scenario=s1,os=linux,arch=amd64,step=initial,cache=warm duration=8,repo_size=100 1000
+process_summary,rev=abc,mod=false,gitTime=1,scenario=s1,os=linux,arch=amd64,step=incremental,cache=cold duration=3,repo_size=120 1000
+process_summary,rev=abc,mod=false,gitTime=1,scenario=s1,os=linux,arch=amd64,step=incremental,cache=warm duration=2,repo_size=120 1000
+process_ram_summary,rev=abc,mod=false,gitTime=1,scenario=s1,os=linux,arch=amd64,step=initial,cache=cold max_ram_rss=500 1000
+repo_growth,rev=abc,mod=false,gitTime=1,scenario=s1,os=linux,arch=amd64,run=0,seed=5 size=100 1000
+prometheus_metric,rev=abc,mod=false,gitTime=1,scenario=s1,os=linux,arch=amd64,metric=foo value=1 1000
+`
+
+func TestReadLineFileSeriesByState(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "1-abc.line")
+
+	if err := os.WriteFile(fname, []byte(testLines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	byKey := map[string]*series{}
+
+	if err := readLineFile(fname, byKey); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]map[string]float64{}
+
+	for _, s := range byKey {
+		if len(s.revisions) != 1 {
+			t.Fatalf("%v has %v revisions, want 1", s.title(), len(s.revisions))
+		}
+
+		got[s.title()] = s.revisions[0].values
+	}
+
+	want := map[string]map[string]float64{
+		"s1 (linux/amd64, cache=cold step=initial)":     {"duration": 10, "repo size": 100, "max RSS": 500},
+		"s1 (linux/amd64, cache=warm step=initial)":     {"duration": 8, "repo size": 100},
+		"s1 (linux/amd64, cache=cold step=incremental)": {"duration": 3, "repo size": 120},
+		"s1 (linux/amd64, cache=warm step=incremental)": {"duration": 2, "repo size": 120},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+const (
+	chartWidth  = 600
+	chartHeight = 160
+	chartMargin = 4
+)
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"chart":  chartSVG,
+	"value":  formatValue,
+	"recent": recentRevisions,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>kopia benchmark trends</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { border: 1px solid #ccc; padding: 2px 8px; text-align: right; }
+.chart { display: inline-block; margin-right: 1em; }
+polyline { fill: none; stroke: #1f77b4; stroke-width: 2; }
+</style>
+</head>
+<body>
+<h1>kopia benchmark trends</h1>
+<ul>
+{{range .Series}}<li><a href="#{{.ID}}">{{.Title}}</a></li>
+{{end}}</ul>
+{{range $s := .Series}}
+<h2 id="{{$s.ID}}">{{$s.Title}}</h2>
+{{range $.Metrics}}<div class="chart"><div>{{.Name}} ({{.Unit}})</div>{{chart $s.Revisions .Name}}</div>
+{{end}}
+<table>
+<tr><th>git time</th><th>revision</th>{{range $.Metrics}}<th>{{.Name}}</th>{{end}}</tr>
+{{range $r := recent $s.Revisions}}<tr><td>{{$r.GitTime}}</td><td>{{$r.Rev}}</td>{{range $.Metrics}}<td>{{value $r .Name}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// htmlSeries is series as exposed to the template.
+type htmlSeries struct {
+	ID        string
+	Title     string
+	Revisions []*htmlRevision
+}
+
+type htmlRevision struct {
+	GitTime string
+	Rev     string
+	Values  map[string]float64
+}
+
+type htmlMetric struct {
+	Name string
+	Unit string
+}
+
+func renderHTML(f *os.File, all []*series) error {
+	data := struct {
+		Series  []htmlSeries
+		Metrics []htmlMetric
+	}{}
+
+	for _, m := range metrics {
+		data.Metrics = append(data.Metrics, htmlMetric{m.name, m.unit})
+	}
+
+	for i, s := range all {
+		hs := htmlSeries{ID: fmt.Sprintf("s%v", i), Title: s.title()}
+
+		for _, r := range s.revisions {
+			hs.Revisions = append(hs.Revisions, &htmlRevision{
+				GitTime: r.gitTime.UTC().Format("2006-01-02 15:04"),
+				Rev:     shortRev(r.rev),
+				Values:  r.values,
+			})
+		}
+
+		data.Series = append(data.Series, hs)
+	}
+
+	return htmlTemplate.Execute(f, data)
+}
+
+// chartSVG renders values of the metric of consecutive revisions as a line chart.
+func chartSVG(revs []*htmlRevision, name string) template.HTML {
+	var values []float64
+
+	for _, r := range revs {
+		if v, ok := r.Values[name]; ok {
+			values = append(values, v)
+		}
+	}
+
+	if len(values) == 0 {
+		return "no data"
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+
+	for _, v := range values {
+		lo = math.Min(lo, v)
+		hi = math.Max(hi, v)
+	}
+
+	if hi == lo {
+		hi = lo + 1
+	}
+
+	var pts []string
+
+	for i, v := range values {
+		x := float64(chartMargin)
+		if len(values) > 1 {
+			x += float64(i) * (chartWidth - 2*chartMargin) / float64(len(values)-1)
+		}
+
+		y := chartMargin + (hi-v)/(hi-lo)*(chartHeight-2*chartMargin)
+
+		pts = append(pts, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+
+	return template.HTML(fmt.Sprintf(`<svg width="%v" height="%v" style="border:1px solid #eee"><polyline points="%v"/></svg><div>min %v, max %v</div>`,
+		chartWidth, chartHeight, strings.Join(pts, " "), formatNumber(lo), formatNumber(hi)))
+}
+
+func recentRevisions(revs []*htmlRevision) []*htmlRevision {
+	var result []*htmlRevision
+
+	for i := len(revs) - 1; i >= 0 && len(result) < *lastRows; i-- {
+		result = append(result, revs[i])
+	}
+
+	return result
+}
+
+func formatValue(r *htmlRevision, name string) string {
+	v, ok := r.Values[name]
+	if !ok {
+		return "-"
+	}
+
+	return formatNumber(v)
+}
+
+// formatNumber formats large values with binary suffixes and small ones with one decimal.
+func formatNumber(v float64) string {
+	for _, u := range []struct {
+		suffix string
+		mult   float64
+	}{{"Gi", 1 << 30}, {"Mi", 1 << 20}, {"Ki", 1 << 10}} {
+		if math.Abs(v) >= 10*u.mult {
+			return fmt.Sprintf("%.1f %v", v/u.mult, u.suffix)
+		}
+	}
+
+	return fmt.Sprintf("%.1f", v)
+}
+
+func shortRev(rev string) string {
+	if len(rev) > 10 {
+		return rev[0:10]
+	}
+
+	return rev
+}
+
+func renderMarkdown(f *os.File, all []*series) error {
+	var w io.Writer = f
+
+	fmt.Fprintf(w, "# kopia benchmark trends\n")
+
+	for _, s := range all {
+		fmt.Fprintf(w, "\n## %v\n\n", s.title())
+
+		header := "| git time | revision |"
+		sep := "|---|---|"
+
+		for _, m := range metrics {
+			header += fmt.Sprintf(" %v (%v) | change |", m.name, m.unit)
+			sep += "---:|---:|"
+		}
+
+		fmt.Fprintf(w, "%v\n%v\n", header, sep)
+
+		start := len(s.revisions) - *lastRows
+		if start < 0 {
+			start = 0
+		}
+
+		for i := len(s.revisions) - 1; i >= start; i-- {
+			r := s.revisions[i]
+			row := fmt.Sprintf("| %v | %v |", r.gitTime.UTC().Format("2006-01-02 15:04"), shortRev(r.rev))
+
+			for _, m := range metrics {
+				v, ok := r.values[m.name]
+				if !ok {
+					row += " - | |"
+					continue
+				}
+
+				change := ""
+				if i > 0 {
+					if prev, ok := s.revisions[i-1].values[m.name]; ok && prev != 0 {
+						change = fmt.Sprintf("%+.1f%%", 100*(v-prev)/prev)
+					}
+				}
+
+				row += fmt.Sprintf(" %v | %v |", formatNumber(v), change)
+			}
+
+			fmt.Fprintf(w, "%v\n", row)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,256 @@
+// Command comparebench runs equivalent backup and restore workloads with kopia, restic and
+// borgbackup on the same dataset, so that kopia's performance can be tracked against them.
+//
+// Usage: comparebench --dataset=<dir> [--tools=kopia,restic,borg] [--repeat=N]
+//
+// For each tool and repetition a new repository is created in the work directory (encrypted
+// with each tool's default algorithms) and the following phases are measured:
+//
+//	backup       - initial backup of the dataset
+//	incremental  - second backup of the unchanged dataset
+//	restore      - restore of the latest backup to an empty directory
+//
+// Measurements are written to --output (stdout by default) as 'tool_comparison' lines tagged with
+// the tool, phase and --run-tags, with average duration, throughput, peak resident memory and
+// average CPU usage of the tool and the size of the repository after the phase. Tools which are not
+// installed must be excluded using --tools.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+const (
+	repoPassword = "dummy"
+
+	phaseBackup      = "backup"
+	phaseIncremental = "incremental"
+	phaseRestore     = "restore"
+)
+
+var (
+	kopiaExe         = flag.String("kopia-exe", os.ExpandEnv("$HOME/go/bin/kopia"), "Path to kopia")
+	resticExe        = flag.String("restic-exe", "restic", "Path to restic")
+	borgExe          = flag.String("borg-exe", "borg", "Path to borg")
+	dataset          = flag.String("dataset", "", "Directory to back up and restore")
+	tools            = flag.String("tools", "kopia,restic,borg", "Comma-separated list of compared tools")
+	repeat           = flag.Int("repeat", 3, "Number of repetitions of all phases for each tool")
+	workDir          = flag.String("work-dir", "", "Directory for repositories and restored files (default: new temporary directory)")
+	samplingInterval = flag.Duration("sampling-interval", 100*time.Millisecond, "Interval between samples of the measured process")
+	keep             = flag.Bool("keep", false, "Keep the work directory")
+	outputFile       = flag.String("output", "", "File to append measurements to (default: stdout)")
+	runTags          = flag.String("run-tags", "", "Comma-separated list of tags to attach to measurements")
+)
+
+var phases = []string{phaseBackup, phaseIncremental, phaseRestore}
+
+// newTool returns the tool with the given name.
+func newTool(name string) (backupTool, error) {
+	switch name {
+	case "kopia":
+		return &kopiaTool{}, nil
+	case "restic":
+		return &resticTool{}, nil
+	case "borg":
+		return &borgTool{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported tool %q", name)
+	}
+}
+
+// phaseResult is the measurement of a single phase.
+type phaseResult struct {
+	*bench.Result
+
+	repoSize int64
+}
+
+func main() {
+	flag.Parse()
+
+	if *dataset == "" {
+		log.Fatal("missing --dataset")
+	}
+
+	if *repeat <= 0 {
+		log.Fatal("--repeat must be positive")
+	}
+
+	tags, err := bench.ParseTags(*runTags)
+	if err != nil {
+		log.Fatalf("invalid --run-tags: %v", err)
+	}
+
+	names := strings.Split(*tools, ",")
+
+	for _, n := range names {
+		if _, err := newTool(n); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := run(context.Background(), names, tags); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, names []string, tags []bench.Tag) error {
+	dir := *workDir
+	if dir == "" {
+		d, err := os.MkdirTemp("", "comparebench")
+		if err != nil {
+			return err
+		}
+
+		dir = d
+	}
+
+	if !*keep {
+		defer os.RemoveAll(dir)
+	}
+
+	datasetSize, _, err := bench.DirSize(*dataset)
+	if err != nil {
+		return err
+	}
+
+	out, err := bench.OpenOutput(*outputFile)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	sink := bench.NewLineProtocolSink(out)
+
+	for _, name := range names {
+		results := map[string][]*phaseResult{}
+
+		for i := 0; i < *repeat; i++ {
+			log.Printf("%v: repetition %v of %v", name, i+1, *repeat)
+
+			t, _ := newTool(name)
+
+			rr, err := runPhases(ctx, t, filepath.Join(dir, fmt.Sprintf("%v-%v", name, i)))
+			if err != nil {
+				return fmt.Errorf("%v: %w", name, err)
+			}
+
+			for p, r := range rr {
+				results[p] = append(results[p], r)
+			}
+		}
+
+		for _, p := range phases {
+			if err := sink.Write(summarize(name, p, results[p], datasetSize, tags)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// runPhases measures all phases using a new repository in dir.
+func runPhases(ctx context.Context, t backupTool, dir string) (map[string]*phaseResult, error) {
+	repoDir := filepath.Join(dir, "repo")
+	target := filepath.Join(dir, "restored")
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	if err := t.init(ctx, repoDir); err != nil {
+		return nil, err
+	}
+
+	res := map[string]*phaseResult{}
+
+	for n, p := range []string{phaseBackup, phaseIncremental} {
+		r, err := measure(ctx, t.backup(ctx, *dataset, n+1), repoDir)
+		if err != nil {
+			return nil, fmt.Errorf("%v failed: %w", p, err)
+		}
+
+		res[p] = r
+	}
+
+	c, err := t.restore(ctx, *dataset, target)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := measure(ctx, c, repoDir)
+	if err != nil {
+		return nil, fmt.Errorf("restore failed: %w", err)
+	}
+
+	res[phaseRestore] = r
+
+	// restored data is large and not needed after the measurement.
+	return res, os.RemoveAll(target)
+}
+
+// measure runs the command and returns its measurement with the resulting repository size.
+func measure(ctx context.Context, c *exec.Cmd, repoDir string) (*phaseResult, error) {
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+
+	r := &bench.CommandRunner{Interval: *samplingInterval}
+
+	res, err := r.Run(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	size, _, err := bench.DirSize(repoDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &phaseResult{res, size}, nil
+}
+
+func summarize(tool, phase string, results []*phaseResult, datasetSize int64, tags []bench.Tag) bench.Point {
+	var (
+		br       []*bench.Result
+		repoSize int64
+	)
+
+	for _, r := range results {
+		br = append(br, r.Result)
+		repoSize += r.repoSize
+	}
+
+	s := bench.Summarize(br)
+	throughput := float64(datasetSize) / s.AvgDuration
+
+	log.Printf("%v %v: %.1fs, %.1f MiB/s, max RSS %.1f MiB", tool, phase, s.AvgDuration, throughput/(1<<20), s.MaxRAM)
+
+	return bench.Point{
+		Measurement: "tool_comparison",
+		Tags: append([]bench.Tag{
+			{Key: "tool", Value: tool},
+			{Key: "phase", Value: phase},
+		}, tags...),
+		Fields: []bench.Field{
+			{Key: "duration", Value: bench.Fixed{Value: s.AvgDuration, Digits: 1}},
+			{Key: "throughput_bytes_per_sec", Value: throughput},
+			{Key: "max_ram_rss", Value: s.MaxRAM},
+			{Key: "avg_cpu_percent", Value: s.AvgCPU},
+			{Key: "repo_size", Value: repoSize / int64(len(results))},
+			{Key: "dataset_size", Value: datasetSize},
+		},
+		Time: time.Now(),
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"runbench/pkg/bench"
+)
+
+// backupTool runs equivalent operations of a backup tool against a repository in a local directory.
+type backupTool interface {
+	// init creates an empty repository.
+	init(ctx context.Context, repoDir string) error
+
+	// backup returns a command backing up the dataset, the n-th backup of the repository.
+	backup(ctx context.Context, dataset string, n int) *exec.Cmd
+
+	// restore returns a command restoring the latest backup of the dataset to target.
+	restore(ctx context.Context, dataset, target string) (*exec.Cmd, error)
+}
+
+type kopiaTool struct {
+	k bench.Kopia
+}
+
+func (t *kopiaTool) init(ctx context.Context, repoDir string) error {
+	t.k = bench.Kopia{Exe: *kopiaExe, ConfigFile: filepath.Join(filepath.Dir(repoDir), "kopia.config"), Password: repoPassword}
+
+	return t.k.CreateRepository(ctx, repoDir)
+}
+
+func (t *kopiaTool) backup(ctx context.Context, dataset string, n int) *exec.Cmd {
+	return t.k.Command(ctx, "snapshot", "create", dataset, "--no-progress")
+}
+
+func (t *kopiaTool) restore(ctx context.Context, dataset, target string) (*exec.Cmd, error) {
+	root, err := t.k.LatestRoot(ctx, dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.k.Command(ctx, "restore", root, target), nil
+}
+
+type resticTool struct {
+	repoDir string
+}
+
+func (t *resticTool) command(ctx context.Context, args ...string) *exec.Cmd {
+	c := exec.CommandContext(ctx, *resticExe, args...)
+	c.Env = append(os.Environ(), "RESTIC_REPOSITORY="+t.repoDir, "RESTIC_PASSWORD="+repoPassword)
+
+	return c
+}
+
+func (t *resticTool) init(ctx context.Context, repoDir string) error {
+	t.repoDir = repoDir
+
+	return runUnmeasured(t.command(ctx, "init"))
+}
+
+func (t *resticTool) backup(ctx context.Context, dataset string, n int) *exec.Cmd {
+	return t.command(ctx, "backup", "--quiet", dataset)
+}
+
+func (t *resticTool) restore(ctx context.Context, dataset, target string) (*exec.Cmd, error) {
+	return t.command(ctx, "restore", "latest", "--target="+target), nil
+}
+
+type borgTool struct {
+	repoDir string
+}
+
+func (t *borgTool) command(ctx context.Context, args ...string) *exec.Cmd {
+	c := exec.CommandContext(ctx, *borgExe, args...)
+	c.Env = append(os.Environ(), "BORG_PASSPHRASE="+repoPassword, "BORG_UNKNOWN_UNENCRYPTED_REPO_ACCESS_IS_OK=yes")
+
+	return c
+}
+
+func (t *borgTool) init(ctx context.Context, repoDir string) error {
+	t.repoDir = repoDir
+
+	return runUnmeasured(t.command(ctx, "init", "--encryption=repokey", repoDir))
+}
+
+func (t *borgTool) backup(ctx context.Context, dataset string, n int) *exec.Cmd {
+	return t.command(ctx, "create", t.repoDir+"::backup-"+strconv.Itoa(n), dataset)
+}
+
+func (t *borgTool) restore(ctx context.Context, dataset, target string) (*exec.Cmd, error) {
+	out, err := t.command(ctx, "list", "--last=1", "--short", t.repoDir).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	// borg extracts into the working directory.
+	c := t.command(ctx, "extract", t.repoDir+"::"+strings.TrimSpace(string(out)))
+	c.Dir = target
+
+	return c, os.MkdirAll(target, 0o755)
+}
+
+// runUnmeasured runs a command preparing the repository, errors include its output.
+func runUnmeasured(c *exec.Cmd) error {
+	if out, err := c.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v failed: %w: %s", filepath.Base(c.Path), err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
@@ -0,0 +1,312 @@
+// Command contentionbench measures contention between kopia clients sharing a repository: it runs
+// --clients clients, each with its own config file and hostname, which snapshot different datasets
+// into the same filesystem repository concurrently.
+//
+// Usage: contentionbench --dataset=<dir>[,<dir>...] [--clients=N] [--rounds=N] [--baseline]
+//
+// Datasets are assigned to clients round-robin. Each round starts with an empty repository unless
+// --incremental is given, in which case later rounds measure incremental snapshots. With --baseline
+// each client first snapshots its dataset alone, so that the slowdown caused by sharing the
+// repository can be reported.
+//
+// Measurements are written to --output (stdout by default):
+//
+//	contention_summary  - average wall time of a round, aggregate throughput, distribution of
+//	                      client durations over all rounds and the slowdown against the baseline
+//	contention_client   - average duration and memory usage of each client
+//
+// Measurements are tagged with the number of clients and --run-tags.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+const repoPassword = "dummy"
+
+var (
+	kopiaExe         = flag.String("kopia-exe", os.ExpandEnv("$HOME/go/bin/kopia"), "Path to kopia")
+	datasets         = flag.String("dataset", "", "Comma-separated list of directories to snapshot, assigned to clients round-robin")
+	numClients       = flag.Int("clients", 4, "Number of concurrent clients")
+	rounds           = flag.Int("rounds", 3, "Number of measured rounds")
+	incremental      = flag.Bool("incremental", false, "Keep the repository between rounds")
+	baseline         = flag.Bool("baseline", false, "Measure each client alone before the concurrent rounds")
+	parallel         = flag.Int("parallel", 0, "Value of --parallel passed to each snapshot (default: kopia default)")
+	workDir          = flag.String("work-dir", "", "Directory for the repository and config files (default: new temporary directory)")
+	samplingInterval = flag.Duration("sampling-interval", 100*time.Millisecond, "Interval between samples of the measured processes")
+	keep             = flag.Bool("keep", false, "Keep the work directory")
+	outputFile       = flag.String("output", "", "File to append measurements to (default: stdout)")
+	runTags          = flag.String("run-tags", "", "Comma-separated list of tags to attach to measurements")
+)
+
+// client is a kopia client with its own config file and hostname.
+type client struct {
+	index      int
+	configFile string
+	dataset    string
+
+	// results of concurrent rounds
+	results []*bench.Result
+}
+
+func main() {
+	flag.Parse()
+
+	if *datasets == "" {
+		log.Fatal("missing --dataset")
+	}
+
+	if *numClients <= 0 || *rounds <= 0 {
+		log.Fatal("--clients and --rounds must be positive")
+	}
+
+	tags, err := bench.ParseTags(*runTags)
+	if err != nil {
+		log.Fatalf("invalid --run-tags: %v", err)
+	}
+
+	if err := run(context.Background(), tags); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, tags []bench.Tag) error {
+	dir, cleanup, err := bench.WorkDir(*workDir, "contentionbench", *keep)
+	if err != nil {
+		return err
+	}
+
+	defer cleanup()
+
+	ds := strings.Split(*datasets, ",")
+
+	var clients []*client
+
+	for i := 0; i < *numClients; i++ {
+		p, err := filepath.Abs(ds[i%len(ds)])
+		if err != nil {
+			return err
+		}
+
+		clients = append(clients, &client{
+			index:      i,
+			configFile: filepath.Join(dir, fmt.Sprintf("client-%v.config", i)),
+			dataset:    p,
+		})
+	}
+
+	totalBytes, err := datasetBytes(clients)
+	if err != nil {
+		return err
+	}
+
+	repoDir := filepath.Join(dir, "repo")
+
+	var soloDurations []float64
+
+	if *baseline {
+		for _, c := range clients {
+			if err := createRepository(ctx, repoDir, []*client{c}); err != nil {
+				return err
+			}
+
+			log.Printf("measuring client %v alone", c.index)
+
+			res, err := c.snapshot(ctx)
+			if err != nil {
+				return err
+			}
+
+			soloDurations = append(soloDurations, res.Duration.Seconds())
+		}
+	}
+
+	var roundDurations, clientDurations []float64
+
+	for r := 0; r < *rounds; r++ {
+		if r == 0 || !*incremental {
+			if err := createRepository(ctx, repoDir, clients); err != nil {
+				return err
+			}
+		}
+
+		log.Printf("round %v of %v with %v concurrent clients", r+1, *rounds, len(clients))
+
+		wall, err := runRound(ctx, clients)
+		if err != nil {
+			return err
+		}
+
+		roundDurations = append(roundDurations, wall.Seconds())
+
+		for _, c := range clients {
+			clientDurations = append(clientDurations, c.results[len(c.results)-1].Duration.Seconds())
+		}
+	}
+
+	return writeResults(tags, clients, totalBytes, roundDurations, clientDurations, soloDurations)
+}
+
+// runRound runs snapshots of all clients concurrently and returns the wall time.
+func runRound(ctx context.Context, clients []*client) (time.Duration, error) {
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(clients))
+	)
+
+	t0 := time.Now()
+
+	for i, c := range clients {
+		wg.Add(1)
+
+		go func(i int, c *client) {
+			defer wg.Done()
+
+			var res *bench.Result
+
+			if res, errs[i] = c.snapshot(ctx); res != nil {
+				c.results = append(c.results, res)
+			}
+		}(i, c)
+	}
+
+	wg.Wait()
+
+	wall := time.Since(t0)
+
+	for i, err := range errs {
+		if err != nil {
+			return 0, fmt.Errorf("client %v: %w", i, err)
+		}
+	}
+
+	log.Printf("  completed in %v", wall)
+
+	return wall, nil
+}
+
+func writeResults(tags []bench.Tag, clients []*client, totalBytes int64, roundDurations, clientDurations, soloDurations []float64) error {
+	out, err := bench.OpenOutput(*outputFile)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	tags = append([]bench.Tag{{Key: "clients", Value: strconv.Itoa(len(clients))}}, tags...)
+	now := time.Now()
+
+	avgRound := bench.Mean(roundDurations)
+
+	sort.Float64s(clientDurations)
+
+	fields := []bench.Field{
+		{Key: "duration", Value: bench.Fixed{Value: avgRound, Digits: 1}},
+		{Key: "throughput_bytes_per_sec", Value: float64(totalBytes) / avgRound},
+		{Key: "p50_client_duration", Value: bench.Fixed{Value: bench.Percentile(clientDurations, 50), Digits: 1}},
+		{Key: "p90_client_duration", Value: bench.Fixed{Value: bench.Percentile(clientDurations, 90), Digits: 1}},
+		{Key: "p99_client_duration", Value: bench.Fixed{Value: bench.Percentile(clientDurations, 99), Digits: 1}},
+		{Key: "max_client_duration", Value: bench.Fixed{Value: clientDurations[len(clientDurations)-1], Digits: 1}},
+	}
+
+	if len(soloDurations) > 0 {
+		slowdown := bench.Mean(clientDurations) / bench.Mean(soloDurations)
+		fields = append(fields, bench.Field{Key: "slowdown", Value: slowdown})
+
+		log.Printf("clients are %.2fx slower when sharing the repository", slowdown)
+	}
+
+	points := []bench.Point{{Measurement: "contention_summary", Tags: tags, Fields: fields, Time: now}}
+
+	for _, c := range clients {
+		s := bench.Summarize(c.results)
+
+		points = append(points, bench.Point{
+			Measurement: "contention_client",
+			Tags:        append(append([]bench.Tag(nil), tags...), bench.Tag{Key: "client", Value: strconv.Itoa(c.index)}),
+			Time:        now,
+			Fields:      s.ProcessFields(),
+		})
+	}
+
+	return bench.NewLineProtocolSink(out).Write(points...)
+}
+
+// createRepository creates an empty repository and connects the clients to it.
+func createRepository(ctx context.Context, repoDir string, clients []*client) error {
+	if err := os.RemoveAll(repoDir); err != nil {
+		return err
+	}
+
+	for i, c := range clients {
+		args := []string{"repository", "connect", "filesystem"}
+		if i == 0 {
+			args = []string{"repository", "create", "filesystem"}
+		}
+
+		args = append(args, "--path="+repoDir, fmt.Sprintf("--override-hostname=client-%v", c.index))
+
+		if _, err := c.kopia().Run(ctx, args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// snapshot measures snapshot of the client's dataset.
+func (c *client) snapshot(ctx context.Context) (*bench.Result, error) {
+	args := []string{"snapshot", "create", c.dataset, "--no-progress"}
+	if *parallel > 0 {
+		args = append(args, fmt.Sprintf("--parallel=%v", *parallel))
+	}
+
+	r := &bench.CommandRunner{Interval: *samplingInterval}
+
+	res, err := r.Run(ctx, c.kopia().Command(ctx, args...))
+	if err != nil {
+		return nil, fmt.Errorf("snapshot of %v failed: %w", c.dataset, err)
+	}
+
+	return res, nil
+}
+
+func (c *client) kopia() bench.Kopia {
+	return bench.Kopia{Exe: *kopiaExe, ConfigFile: c.configFile, Password: repoPassword}
+}
+
+// datasetBytes returns the total size of files snapshotted by all clients in one round.
+func datasetBytes(clients []*client) (int64, error) {
+	sizes := map[string]int64{}
+
+	var total int64
+
+	for _, c := range clients {
+		size, ok := sizes[c.dataset]
+		if !ok {
+			var err error
+
+			if size, _, err = bench.DirSize(c.dataset); err != nil {
+				return 0, err
+			}
+
+			sizes[c.dataset] = size
+		}
+
+		total += size
+	}
+
+	return total, nil
+}
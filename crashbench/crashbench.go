@@ -0,0 +1,161 @@
+// Command crashbench measures the cost of recovering from a crash during a snapshot: it kills
+// kopia part way through snapshotting --dataset, then measures the snapshot which resumes from the
+// checkpoints left behind and verifies that the repository is consistent.
+//
+// Usage: crashbench --dataset=<dir> (--kill-after=<duration> | --kill-at-pct=<percent>) [--rounds=N] [--baseline]
+//
+// The kopia process is killed with SIGKILL either after --kill-after or once the repository grew
+// to --kill-at-pct percent of the dataset size (which is only reached by datasets that don't
+// compress well). Each round uses a new filesystem repository. After the resumed snapshot
+// 'kopia snapshot verify' and 'kopia content verify' check consistency of the repository.
+// With --baseline an uninterrupted snapshot of the dataset is measured first, so that the
+// overhead of the crash can be reported.
+//
+// Measurements are written to --output (stdout by default) as 'crash_recovery' lines, one per round,
+// tagged with the kill trigger and --run-tags, with the time of the kill, repository size at that
+// time, duration and memory usage of the resumed snapshot, whether the repository verified
+// consistent and, with --baseline, the overhead of both snapshots over the baseline.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+const repoPassword = "dummy"
+
+var (
+	kopiaExe           = flag.String("kopia-exe", os.ExpandEnv("$HOME/go/bin/kopia"), "Path to kopia")
+	dataset            = flag.String("dataset", "", "Directory to snapshot")
+	killAfter          = flag.Duration("kill-after", 0, "Kill kopia after the given time")
+	killAtPct          = flag.Float64("kill-at-pct", 0, "Kill kopia once the repository reaches the given percentage of the dataset size")
+	checkpointInterval = flag.Duration("checkpoint-interval", 0, "Value of --checkpoint-interval passed to snapshots (default: kopia default)")
+	rounds             = flag.Int("rounds", 3, "Number of measured crashes")
+	baseline           = flag.Bool("baseline", false, "Measure an uninterrupted snapshot first")
+	workDir            = flag.String("work-dir", "", "Directory for repositories and config files (default: new temporary directory)")
+	samplingInterval   = flag.Duration("sampling-interval", 100*time.Millisecond, "Interval between samples of the measured process")
+	keep               = flag.Bool("keep", false, "Keep the work directory")
+	outputFile         = flag.String("output", "", "File to append measurements to (default: stdout)")
+	runTags            = flag.String("run-tags", "", "Comma-separated list of tags to attach to measurements")
+)
+
+func main() {
+	flag.Parse()
+
+	if *dataset == "" {
+		log.Fatal("missing --dataset")
+	}
+
+	if (*killAfter > 0) == (*killAtPct > 0) {
+		log.Fatal("exactly one of --kill-after and --kill-at-pct is required")
+	}
+
+	if *rounds <= 0 {
+		log.Fatal("--rounds must be positive")
+	}
+
+	tags, err := bench.ParseTags(*runTags)
+	if err != nil {
+		log.Fatalf("invalid --run-tags: %v", err)
+	}
+
+	if err := run(context.Background(), tags); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, tags []bench.Tag) error {
+	dir := *workDir
+	if dir == "" {
+		d, err := os.MkdirTemp("", "crashbench")
+		if err != nil {
+			return err
+		}
+
+		dir = d
+	}
+
+	if !*keep {
+		defer os.RemoveAll(dir)
+	}
+
+	datasetSize, _, err := bench.DirSize(*dataset)
+	if err != nil {
+		return err
+	}
+
+	var baselineDuration float64
+
+	if *baseline {
+		r, err := newRound(ctx, filepath.Join(dir, "baseline"))
+		if err != nil {
+			return err
+		}
+
+		res, err := r.snapshot(ctx)
+		if err != nil {
+			return fmt.Errorf("baseline snapshot failed: %w", err)
+		}
+
+		baselineDuration = res.Duration.Seconds()
+
+		log.Printf("baseline snapshot took %.1fs", baselineDuration)
+	}
+
+	out, err := bench.OpenOutput(*outputFile)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	sink := bench.NewLineProtocolSink(out)
+
+	trigger := bench.Tag{Key: "kill_after", Value: killAfter.String()}
+	if *killAtPct > 0 {
+		trigger = bench.Tag{Key: "kill_at_pct", Value: strconv.FormatFloat(*killAtPct, 'f', -1, 64)}
+	}
+
+	for i := 0; i < *rounds; i++ {
+		r, err := newRound(ctx, filepath.Join(dir, fmt.Sprintf("round-%v", i)))
+		if err != nil {
+			return err
+		}
+
+		fields, err := r.measure(ctx, datasetSize)
+		if err != nil {
+			return fmt.Errorf("round %v: %w", i, err)
+		}
+
+		if *baseline {
+			fields = append(fields,
+				bench.Field{Key: "baseline_duration", Value: bench.Fixed{Value: baselineDuration, Digits: 1}},
+				bench.Field{Key: "recovery_overhead_percent", Value: bench.Fixed{Value: 100 * (r.killedAfter.Seconds() + r.resumed.Duration.Seconds() - baselineDuration) / baselineDuration, Digits: 1}})
+		}
+
+		if err := sink.Write(bench.Point{
+			Measurement: "crash_recovery",
+			Tags:        append([]bench.Tag{trigger, {Key: "round", Value: strconv.Itoa(i)}}, tags...),
+			Fields:      fields,
+			Time:        time.Now(),
+		}); err != nil {
+			return err
+		}
+
+		if !*keep {
+			if err := os.RemoveAll(r.dir); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
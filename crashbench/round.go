@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+// round is a single crash followed by recovery, using its own repository.
+type round struct {
+	dir     string
+	repoDir string
+	kopia   bench.Kopia
+
+	killedAfter    time.Duration
+	repoSizeAtKill int64
+	resumed        *bench.Result
+}
+
+func newRound(ctx context.Context, dir string) (*round, error) {
+	r := &round{
+		dir:     dir,
+		repoDir: filepath.Join(dir, "repo"),
+		kopia:   bench.Kopia{Exe: *kopiaExe, ConfigFile: filepath.Join(dir, "kopia.config"), Password: repoPassword},
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	if err := r.kopia.CreateRepository(ctx, r.repoDir); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *round) snapshotCommand(ctx context.Context) *exec.Cmd {
+	args := []string{"snapshot", "create", *dataset, "--no-progress"}
+	if *checkpointInterval > 0 {
+		args = append(args, "--checkpoint-interval="+checkpointInterval.String())
+	}
+
+	c := r.kopia.Command(ctx, args...)
+	c.Stderr = os.Stderr
+
+	return c
+}
+
+// snapshot measures a snapshot of the dataset.
+func (r *round) snapshot(ctx context.Context) (*bench.Result, error) {
+	cr := &bench.CommandRunner{Interval: *samplingInterval}
+
+	return cr.Run(ctx, r.snapshotCommand(ctx))
+}
+
+// measure crashes a snapshot, measures the resumed one and verifies the repository.
+func (r *round) measure(ctx context.Context, datasetSize int64) ([]bench.Field, error) {
+	if err := r.crash(ctx, datasetSize); err != nil {
+		return nil, err
+	}
+
+	res, err := r.snapshot(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("resumed snapshot failed: %w", err)
+	}
+
+	r.resumed = res
+
+	repoSize, _, err := bench.DirSize(r.repoDir)
+	if err != nil {
+		return nil, err
+	}
+
+	consistent := 1
+
+	for _, args := range [][]string{
+		{"snapshot", "verify", "--verify-files-percent=100"},
+		{"content", "verify"},
+	} {
+		if _, err := r.kopia.Run(ctx, args...); err != nil {
+			log.Printf("%v failed: %v", strings.Join(args, " "), err)
+
+			consistent = 0
+		}
+	}
+
+	s := bench.Summarize([]*bench.Result{res})
+
+	log.Printf("killed after %v with %v bytes in repository, resumed snapshot took %.1fs, consistent: %v",
+		r.killedAfter, r.repoSizeAtKill, s.AvgDuration, consistent == 1)
+
+	return []bench.Field{
+		{Key: "killed_after", Value: bench.Fixed{Value: r.killedAfter.Seconds(), Digits: 1}},
+		{Key: "repo_size_at_kill", Value: r.repoSizeAtKill},
+		{Key: "resume_duration", Value: bench.Fixed{Value: s.AvgDuration, Digits: 1}},
+		{Key: "avg_ram_rss", Value: s.AvgRAM},
+		{Key: "max_ram_rss", Value: s.MaxRAM},
+		{Key: "repo_size", Value: repoSize},
+		{Key: "consistent", Value: consistent},
+	}, nil
+}
+
+// crash starts a snapshot and kills it once the kill trigger fires.
+func (r *round) crash(ctx context.Context, datasetSize int64) error {
+	c := r.snapshotCommand(ctx)
+
+	t0 := time.Now()
+
+	if err := c.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.Wait()
+	}()
+
+	for {
+		select {
+		case err := <-done:
+			return fmt.Errorf("snapshot completed before it was killed (%v), use an earlier trigger", err)
+		case <-time.After(*samplingInterval):
+		}
+
+		size, _, err := bench.DirSize(r.repoDir)
+		if err != nil {
+			// blobs may be renamed while walking.
+			continue
+		}
+
+		if *killAfter > 0 && time.Since(t0) < *killAfter {
+			continue
+		}
+
+		if *killAtPct > 0 && float64(size) < float64(datasetSize)**killAtPct/100 {
+			continue
+		}
+
+		if err := c.Process.Kill(); err != nil {
+			return err
+		}
+
+		r.killedAfter = time.Since(t0)
+		r.repoSizeAtKill = size
+
+		<-done
+
+		return nil
+	}
+}
@@ -0,0 +1,234 @@
+// Command diffbench measures 'kopia diff' between two snapshots and 'kopia snapshot verify' of
+// them, so that these commands, which walk whole snapshots, are tracked at scale.
+//
+// Usage: diffbench (--config-file=<file> --snapshots=<id1>,<id2> | --datasets=<dir1>,<dir2>) [--verify-files-percent=0,10,100]
+//
+// Either two snapshots (root object IDs) of an existing repository connected using --config-file
+// are used, or two directories, typically an original dataset and its copy mutated using
+// mutatefiles, are first snapshotted into a new filesystem repository in the work directory.
+//
+// Each command is measured --repeat times. Measurements are written to --output (stdout by
+// default) with average duration and memory and CPU usage of the command:
+//
+//	diff_summary    - 'kopia diff' of the two snapshots, with the number of reported differences
+//	verify_summary  - 'kopia snapshot verify' of both snapshots, tagged with the percentage of
+//	                  files whose contents are read
+//
+// Measurements are tagged with --run-tags.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+const repoPassword = "dummy"
+
+var (
+	kopiaExe           = flag.String("kopia-exe", os.ExpandEnv("$HOME/go/bin/kopia"), "Path to kopia")
+	configFile         = flag.String("config-file", "", "Config file of a connected repository containing --snapshots")
+	snapshots          = flag.String("snapshots", "", "Comma-separated root object IDs of the two snapshots")
+	datasets           = flag.String("datasets", "", "Comma-separated pair of directories to snapshot into a new repository")
+	verifyFilesPercent = flag.String("verify-files-percent", "0,10,100", "Comma-separated list of --verify-files-percent values, empty to skip verify")
+	verifyParallel     = flag.Int("verify-parallel", 0, "Value of --parallel passed to verify (default: kopia default)")
+	skipDiff           = flag.Bool("skip-diff", false, "Don't measure diff")
+	repeat             = flag.Int("repeat", 3, "Number of measured runs of each command")
+	workDir            = flag.String("work-dir", "", "Directory for the created repository (default: new temporary directory)")
+	samplingInterval   = flag.Duration("sampling-interval", 100*time.Millisecond, "Interval between samples of the measured process")
+	keep               = flag.Bool("keep", false, "Keep the work directory")
+	outputFile         = flag.String("output", "", "File to append measurements to (default: stdout)")
+	runTags            = flag.String("run-tags", "", "Comma-separated list of tags to attach to measurements")
+)
+
+func main() {
+	flag.Parse()
+
+	if (*datasets == "") == (*snapshots == "" || *configFile == "") {
+		log.Fatal("either --config-file and --snapshots or --datasets is required")
+	}
+
+	if *repeat <= 0 {
+		log.Fatal("--repeat must be positive")
+	}
+
+	tags, err := bench.ParseTags(*runTags)
+	if err != nil {
+		log.Fatalf("invalid --run-tags: %v", err)
+	}
+
+	var percents []string
+
+	if *verifyFilesPercent != "" {
+		for _, s := range strings.Split(*verifyFilesPercent, ",") {
+			p, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil || p < 0 || p > 100 {
+				log.Fatalf("invalid --verify-files-percent value %q", s)
+			}
+
+			percents = append(percents, strconv.FormatFloat(p, 'f', -1, 64))
+		}
+	}
+
+	if err := run(context.Background(), percents, tags); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, percents []string, tags []bench.Tag) error {
+	dir, cleanup, err := bench.WorkDir(*workDir, "diffbench", *keep)
+	if err != nil {
+		return err
+	}
+
+	defer cleanup()
+
+	k, roots, err := prepareSnapshots(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	out, err := bench.OpenOutput(*outputFile)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	sink := bench.NewLineProtocolSink(out)
+
+	if !*skipDiff {
+		pt, err := measureDiff(ctx, k, roots, tags)
+		if err != nil {
+			return err
+		}
+
+		if err := sink.Write(pt); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range percents {
+		pt, err := measureVerify(ctx, k, roots, p, tags)
+		if err != nil {
+			return err
+		}
+
+		if err := sink.Write(pt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prepareSnapshots returns the connected kopia and root object IDs of --snapshots or of snapshots
+// of --datasets.
+func prepareSnapshots(ctx context.Context, dir string) (bench.Kopia, []string, error) {
+	if *datasets == "" {
+		roots := strings.Split(*snapshots, ",")
+		if len(roots) != 2 {
+			return bench.Kopia{}, nil, fmt.Errorf("--snapshots must have exactly two object IDs")
+		}
+
+		return bench.Kopia{Exe: *kopiaExe, ConfigFile: *configFile, Password: repoPassword}, roots, nil
+	}
+
+	dirs := strings.Split(*datasets, ",")
+	if len(dirs) != 2 {
+		return bench.Kopia{}, nil, fmt.Errorf("--datasets must have exactly two directories")
+	}
+
+	log.Printf("snapshotting %v and %v", dirs[0], dirs[1])
+
+	return bench.SnapshotDatasets(ctx, *kopiaExe, dir, repoPassword, dirs...)
+}
+
+func measureDiff(ctx context.Context, k bench.Kopia, roots []string, tags []bench.Tag) (bench.Point, error) {
+	var stdout bytes.Buffer
+
+	results, err := measure(ctx, k, func() []string {
+		stdout.Reset()
+		return []string{"diff", roots[0], roots[1]}
+	}, &stdout)
+	if err != nil {
+		return bench.Point{}, err
+	}
+
+	// each difference is reported on its own line of the last run
+	diffs := bytes.Count(stdout.Bytes(), []byte("\n"))
+
+	s := bench.Summarize(results)
+
+	log.Printf("diff: %.1fs, %v differences", s.AvgDuration, diffs)
+
+	return bench.Point{
+		Measurement: "diff_summary",
+		Tags:        tags,
+		Fields:      append(s.ProcessFields(), bench.Field{Key: "differences", Value: diffs}),
+		Time:        time.Now(),
+	}, nil
+}
+
+func measureVerify(ctx context.Context, k bench.Kopia, roots []string, percent string, tags []bench.Tag) (bench.Point, error) {
+	args := []string{"snapshot", "verify", "--verify-files-percent=" + percent}
+	if *verifyParallel > 0 {
+		args = append(args, "--parallel="+strconv.Itoa(*verifyParallel))
+	}
+
+	for _, r := range roots {
+		args = append(args, "--directory-id="+r)
+	}
+
+	results, err := measure(ctx, k, func() []string { return args }, nil)
+	if err != nil {
+		return bench.Point{}, err
+	}
+
+	s := bench.Summarize(results)
+
+	log.Printf("verify of %v%% files: %.1fs", percent, s.AvgDuration)
+
+	return bench.Point{
+		Measurement: "verify_summary",
+		Tags:        append([]bench.Tag{{Key: "verify_files_percent", Value: percent}}, tags...),
+		Fields:      s.ProcessFields(),
+		Time:        time.Now(),
+	}, nil
+}
+
+// measure runs the kopia command returned by args --repeat times, with standard output of each
+// run written to stdout if not nil.
+func measure(ctx context.Context, k bench.Kopia, args func() []string, stdout *bytes.Buffer) ([]*bench.Result, error) {
+	var results []*bench.Result
+
+	for i := 0; i < *repeat; i++ {
+		a := args()
+
+		c := k.Command(ctx, a...)
+		c.Stderr = os.Stderr
+
+		if stdout != nil {
+			c.Stdout = stdout
+		}
+
+		r := &bench.CommandRunner{Interval: *samplingInterval}
+
+		res, err := r.Run(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("kopia %v failed: %w", a[0], err)
+		}
+
+		results = append(results, res)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,120 @@
+// Command diskfullbench measures kopia's behavior when the filesystem holding the repository runs
+// out of space: it creates the repository on a loopback filesystem of --fs-size and snapshots a
+// growing source directory until a snapshot fails, then measures the cleanup which frees space.
+//
+// Usage: diskfullbench [--fs-size=<bytes>] [--files-per-snapshot=N] [--file-size=N] [--delete-snapshots-pct=P]
+//
+// Only Linux is supported and root is required to mount the loopback filesystem (ext4 by default).
+// Before each snapshot --files-per-snapshot new random files of --file-size are added to the source
+// directory. After a snapshot fails (or --max-snapshots succeed), the tool checks whether the
+// repository is still usable, deletes --delete-snapshots-pct of the oldest snapshots and runs full
+// maintenance to reclaim their space.
+//
+// Measurements are written to --output (stdout by default):
+//
+//	disk_full_snapshot  - for each snapshot its duration, peak memory, whether it succeeded and the
+//	                      fill of the filesystem after it, tagged with the snapshot index
+//	disk_full_cleanup   - whether the repository verified after the failure, bytes left behind by
+//	                      the failed snapshot, duration and success of maintenance and bytes it freed
+//
+// Measurements are tagged with the filesystem size and --run-tags.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+const repoPassword = "dummy"
+
+var (
+	kopiaExe           = flag.String("kopia-exe", os.ExpandEnv("$HOME/go/bin/kopia"), "Path to kopia")
+	fsSize             = flag.Int64("fs-size", 1<<30, "Size of the filesystem holding the repository in bytes")
+	fsType             = flag.String("fs-type", "ext4", "Type of the filesystem holding the repository")
+	filesPerSnapshot   = flag.Int("files-per-snapshot", 100, "Number of files added before each snapshot")
+	fileSize           = flag.Int("file-size", 1<<20, "Size of added files")
+	maxSnapshots       = flag.Int("max-snapshots", 1000, "Maximum number of snapshots if the filesystem doesn't fill up")
+	deleteSnapshotsPct = flag.Float64("delete-snapshots-pct", 50, "Percentage of oldest snapshots deleted before cleanup")
+	seed               = flag.Int64("seed", 1, "Seed of generated files")
+	workDir            = flag.String("work-dir", "", "Directory for the filesystem image, mount point and source files (default: new temporary directory)")
+	samplingInterval   = flag.Duration("sampling-interval", 100*time.Millisecond, "Interval between samples of the measured process")
+	keep               = flag.Bool("keep", false, "Keep the work directory")
+	outputFile         = flag.String("output", "", "File to append measurements to (default: stdout)")
+	runTags            = flag.String("run-tags", "", "Comma-separated list of tags to attach to measurements")
+)
+
+func main() {
+	flag.Parse()
+
+	if *fsSize <= 0 || *filesPerSnapshot <= 0 || *maxSnapshots <= 0 {
+		log.Fatal("--fs-size, --files-per-snapshot and --max-snapshots must be positive")
+	}
+
+	tags, err := bench.ParseTags(*runTags)
+	if err != nil {
+		log.Fatalf("invalid --run-tags: %v", err)
+	}
+
+	tags = append([]bench.Tag{{Key: "fs_size", Value: strconv.FormatInt(*fsSize, 10)}}, tags...)
+
+	if err := run(context.Background(), tags); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, tags []bench.Tag) error {
+	dir, cleanup, err := bench.WorkDir(*workDir, "diskfullbench", *keep)
+	if err != nil {
+		return err
+	}
+
+	defer cleanup()
+
+	mountPoint := filepath.Join(dir, "mnt")
+
+	unmount, err := bench.MountLoopback(ctx, filepath.Join(dir, "fs.img"), mountPoint, *fsType, *fsSize)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := unmount(); err != nil {
+			log.Printf("unable to unmount: %v", err)
+		}
+	}()
+
+	out, err := bench.OpenOutput(*outputFile)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	f := &filler{
+		kopia:   bench.Kopia{Exe: *kopiaExe, ConfigFile: filepath.Join(dir, "kopia.config"), Password: repoPassword},
+		repoDir: filepath.Join(mountPoint, "repo"),
+		fsDir:   mountPoint,
+		source: &bench.ChurningSource{
+			Dir:      filepath.Join(dir, "source"),
+			FileSize: *fileSize,
+			NewFiles: *filesPerSnapshot,
+			Rand:     rand.New(rand.NewSource(*seed)),
+		},
+		sink: bench.NewLineProtocolSink(out),
+		tags: tags,
+	}
+
+	if err := f.fill(ctx); err != nil {
+		return err
+	}
+
+	return f.cleanup(ctx)
+}
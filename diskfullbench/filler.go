@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+// filler snapshots a growing source into a repository on a small filesystem until it's full.
+type filler struct {
+	kopia   bench.Kopia
+	repoDir string
+	fsDir   string
+	source  *bench.ChurningSource
+	sink    bench.Sink
+	tags    []bench.Tag
+
+	// repository size after the last successful snapshot
+	lastGoodRepoSize int64
+}
+
+// fill snapshots the source until a snapshot fails or --max-snapshots succeed.
+func (f *filler) fill(ctx context.Context) error {
+	if err := os.MkdirAll(f.source.Dir, 0o755); err != nil {
+		return err
+	}
+
+	if err := f.kopia.CreateRepository(ctx, f.repoDir); err != nil {
+		return err
+	}
+
+	// keep all snapshots, the oldest are deleted explicitly by cleanup.
+	if err := f.kopia.KeepSnapshots(ctx, *maxSnapshots+1); err != nil {
+		return err
+	}
+
+	for i := 0; i < *maxSnapshots; i++ {
+		if err := f.source.Churn(i); err != nil {
+			return err
+		}
+
+		c := f.kopia.Command(ctx, "snapshot", "create", f.source.Dir, "--no-progress", "--no-auto-maintenance")
+		c.Stderr = os.Stderr
+
+		r := &bench.CommandRunner{Interval: *samplingInterval}
+
+		res, runErr := r.Run(ctx, c)
+		if res == nil {
+			return runErr
+		}
+
+		used, err := bench.UsedPercent(f.fsDir)
+		if err != nil {
+			return err
+		}
+
+		succeeded := 1
+		if runErr != nil {
+			succeeded = 0
+		}
+
+		s := bench.Summarize([]*bench.Result{res})
+
+		log.Printf("snapshot %v: %.1fs, filesystem %.1f%% full, succeeded: %v", i, s.AvgDuration, used, runErr == nil)
+
+		if err := f.sink.Write(bench.Point{
+			Measurement: "disk_full_snapshot",
+			Tags:        append([]bench.Tag{{Key: "snapshot", Value: strconv.Itoa(i)}}, f.tags...),
+			Fields: []bench.Field{
+				{Key: "duration", Value: bench.Fixed{Value: s.AvgDuration, Digits: 1}},
+				{Key: "max_ram_rss", Value: s.MaxRAM},
+				{Key: "used_percent", Value: bench.Fixed{Value: used, Digits: 1}},
+				{Key: "succeeded", Value: succeeded},
+			},
+			Time: time.Now(),
+		}); err != nil {
+			return err
+		}
+
+		if runErr != nil {
+			return nil
+		}
+
+		if f.lastGoodRepoSize, _, err = bench.DirSize(f.repoDir); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("filesystem did not fill up after %v snapshots", *maxSnapshots)
+
+	return nil
+}
+
+// cleanup checks the repository after the failed snapshot, deletes the oldest snapshots
+// and measures maintenance reclaiming their space.
+func (f *filler) cleanup(ctx context.Context) error {
+	verified := 1
+
+	if _, err := f.kopia.Run(ctx, "snapshot", "verify"); err != nil {
+		log.Printf("repository did not verify after running out of space: %v", err)
+
+		verified = 0
+	}
+
+	sizeBefore, _, err := bench.DirSize(f.repoDir)
+	if err != nil {
+		return err
+	}
+
+	if err := f.deleteOldest(ctx); err != nil {
+		return err
+	}
+
+	c := f.kopia.Command(ctx, "maintenance", "run", "--full", "--safety=none")
+	c.Stderr = os.Stderr
+
+	r := &bench.CommandRunner{Interval: *samplingInterval}
+
+	res, runErr := r.Run(ctx, c)
+	if res == nil {
+		return runErr
+	}
+
+	if runErr != nil {
+		log.Printf("maintenance failed: %v", runErr)
+	}
+
+	sizeAfter, _, err := bench.DirSize(f.repoDir)
+	if err != nil {
+		return err
+	}
+
+	succeeded := 1
+	if runErr != nil {
+		succeeded = 0
+	}
+
+	s := bench.Summarize([]*bench.Result{res})
+
+	log.Printf("maintenance took %.1fs and freed %v bytes", s.AvgDuration, sizeBefore-sizeAfter)
+
+	return f.sink.Write(bench.Point{
+		Measurement: "disk_full_cleanup",
+		Tags:        f.tags,
+		Fields: []bench.Field{
+			{Key: "verified", Value: verified},
+			{Key: "leftover_bytes", Value: sizeBefore - f.lastGoodRepoSize},
+			{Key: "maintenance_duration", Value: bench.Fixed{Value: s.AvgDuration, Digits: 1}},
+			{Key: "maintenance_max_ram_rss", Value: s.MaxRAM},
+			{Key: "maintenance_succeeded", Value: succeeded},
+			{Key: "freed_bytes", Value: sizeBefore - sizeAfter},
+		},
+		Time: time.Now(),
+	})
+}
+
+// deleteOldest deletes --delete-snapshots-pct of the oldest snapshots.
+func (f *filler) deleteOldest(ctx context.Context) error {
+	snapshots, err := f.kopia.Snapshots(ctx, f.source.Dir)
+	if err != nil {
+		return err
+	}
+
+	n := int(float64(len(snapshots)) * *deleteSnapshotsPct / 100)
+
+	for _, s := range snapshots[0:n] {
+		if _, err := f.kopia.Run(ctx, "snapshot", "delete", s.ID, "--delete"); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("deleted %v of %v snapshots", n, len(snapshots))
+
+	return nil
+}
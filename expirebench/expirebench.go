@@ -0,0 +1,237 @@
+// Command expirebench measures the lifecycle cost of a repository with a long snapshot history:
+// it creates hundreds of snapshots, applies a retention policy using 'kopia snapshot expire'
+// and then measures repeated maintenance runs which garbage-collect contents of expired snapshots.
+//
+// Usage: expirebench [--snapshots=N] [--snapshot-interval=1h] [--retention=latest=10,daily=7,weekly=4] [--maintenance-runs=N]
+//
+// The history is built like in maintbench from generated files which change between snapshots,
+// but each snapshot is timestamped --snapshot-interval after the previous one (ending now), so that
+// time-based retention rules apply to a history spanning days or months. All snapshots are kept
+// while building the history, afterwards the global policy is changed to --retention.
+//
+// Measurements are written to --output (stdout by default):
+//
+//	expire_summary       - 'kopia snapshot expire --all --delete' with the number of snapshots
+//	                       before and after expiration
+//	expire_maintenance   - each of --maintenance-runs full maintenance runs, tagged with 'run', with
+//	                       the size of the repository before and after the run
+//
+// Maintenance runs with --safety (none by default), with --safety=full contents are only removed
+// once enough time has passed and the repository typically shrinks in later runs only.
+//
+// Measurements are tagged with the number of snapshots, the retention and --run-tags.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+const repoPassword = "dummy"
+
+var (
+	kopiaExe         = flag.String("kopia-exe", os.ExpandEnv("$HOME/go/bin/kopia"), "Path to kopia")
+	numSnapshots     = flag.Int("snapshots", 300, "Number of snapshots in the history")
+	snapshotInterval = flag.Duration("snapshot-interval", time.Hour, "Time between timestamps of consecutive snapshots")
+	filesPerSnapshot = flag.Int("files-per-snapshot", 20, "Number of files added before each snapshot")
+	fileSize         = flag.Int("file-size", 64<<10, "Size of added files")
+	deleteFilesPct   = flag.Float64("delete-files-pct", 10, "Percentage of existing files removed before each snapshot")
+	retention        = flag.String("retention", "latest=10,hourly=24,daily=7,weekly=4,monthly=0,annual=0", "Comma-separated retention applied before expiration, each rule is a suffix of a kopia --keep-* policy flag")
+	maintenanceRuns  = flag.Int("maintenance-runs", 3, "Number of measured full maintenance runs after expiration")
+	safety           = flag.String("safety", "none", "Value of --safety passed to maintenance")
+	seed             = flag.Int64("seed", 1, "Seed of generated files")
+	workDir          = flag.String("work-dir", "", "Directory for the repository and source files (default: new temporary directory)")
+	samplingInterval = flag.Duration("sampling-interval", 100*time.Millisecond, "Interval between samples of the measured process")
+	keep             = flag.Bool("keep", false, "Keep the work directory")
+	outputFile       = flag.String("output", "", "File to append measurements to (default: stdout)")
+	runTags          = flag.String("run-tags", "", "Comma-separated list of tags to attach to measurements")
+)
+
+var retentionRules = map[string]bool{
+	"latest":  true,
+	"hourly":  true,
+	"daily":   true,
+	"weekly":  true,
+	"monthly": true,
+	"annual":  true,
+}
+
+func main() {
+	flag.Parse()
+
+	if *numSnapshots <= 0 {
+		log.Fatal("--snapshots must be positive")
+	}
+
+	policyArgs, err := parseRetention(*retention)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tags, err := bench.ParseTags(*runTags)
+	if err != nil {
+		log.Fatalf("invalid --run-tags: %v", err)
+	}
+
+	tags = append([]bench.Tag{
+		{Key: "snapshots", Value: strconv.Itoa(*numSnapshots)},
+		{Key: "retention", Value: *retention},
+	}, tags...)
+
+	if err := run(context.Background(), policyArgs, tags); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseRetention converts retention such as 'latest=10,daily=7' to 'kopia policy set' flags.
+func parseRetention(s string) ([]string, error) {
+	var args []string
+
+	for _, r := range strings.Split(s, ",") {
+		p := strings.SplitN(strings.TrimSpace(r), "=", 2)
+		if len(p) != 2 || !retentionRules[p[0]] {
+			return nil, fmt.Errorf("invalid retention rule %q", r)
+		}
+
+		if _, err := strconv.Atoi(p[1]); err != nil {
+			return nil, fmt.Errorf("invalid retention rule %q", r)
+		}
+
+		args = append(args, "--keep-"+p[0]+"="+p[1])
+	}
+
+	return args, nil
+}
+
+func run(ctx context.Context, policyArgs []string, tags []bench.Tag) error {
+	dir, cleanup, err := bench.WorkDir(*workDir, "expirebench", *keep)
+	if err != nil {
+		return err
+	}
+
+	defer cleanup()
+
+	h := &history{
+		kopia:     bench.Kopia{Exe: *kopiaExe, ConfigFile: filepath.Join(dir, "kopia.config"), Password: repoPassword},
+		repoDir:   filepath.Join(dir, "repo"),
+		sourceDir: filepath.Join(dir, "source"),
+	}
+
+	if err := h.build(ctx); err != nil {
+		return err
+	}
+
+	out, err := bench.OpenOutput(*outputFile)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	sink := bench.NewLineProtocolSink(out)
+
+	if _, err := h.kopia.Run(ctx, append([]string{"policy", "set", "--global"}, policyArgs...)...); err != nil {
+		return err
+	}
+
+	pt, err := measureExpire(ctx, h, tags)
+	if err != nil {
+		return err
+	}
+
+	if err := sink.Write(pt); err != nil {
+		return err
+	}
+
+	for i := 1; i <= *maintenanceRuns; i++ {
+		pt, err := measureMaintenance(ctx, h, i, tags)
+		if err != nil {
+			return err
+		}
+
+		if err := sink.Write(pt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func measureExpire(ctx context.Context, h *history, tags []bench.Tag) (bench.Point, error) {
+	before, err := h.countSnapshots(ctx)
+	if err != nil {
+		return bench.Point{}, err
+	}
+
+	c := h.kopia.Command(ctx, "snapshot", "expire", "--all", "--delete")
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+
+	r := &bench.CommandRunner{Interval: *samplingInterval}
+
+	res, err := r.Run(ctx, c)
+	if err != nil {
+		return bench.Point{}, fmt.Errorf("expire failed: %w", err)
+	}
+
+	after, err := h.countSnapshots(ctx)
+	if err != nil {
+		return bench.Point{}, err
+	}
+
+	log.Printf("expired %v of %v snapshots in %v", before-after, before, res.Duration)
+
+	return bench.Point{
+		Measurement: "expire_summary",
+		Tags:        tags,
+		Fields: append(bench.Summarize([]*bench.Result{res}).ProcessFields(),
+			bench.Field{Key: "snapshots_before", Value: before},
+			bench.Field{Key: "snapshots_after", Value: after}),
+		Time: time.Now(),
+	}, nil
+}
+
+func measureMaintenance(ctx context.Context, h *history, run int, tags []bench.Tag) (bench.Point, error) {
+	sizeBefore, blobsBefore, err := bench.DirSize(h.repoDir)
+	if err != nil {
+		return bench.Point{}, err
+	}
+
+	c := h.kopia.Command(ctx, "maintenance", "run", "--full", "--safety="+*safety)
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+
+	r := &bench.CommandRunner{Interval: *samplingInterval}
+
+	res, err := r.Run(ctx, c)
+	if err != nil {
+		return bench.Point{}, fmt.Errorf("maintenance failed: %w", err)
+	}
+
+	sizeAfter, blobsAfter, err := bench.DirSize(h.repoDir)
+	if err != nil {
+		return bench.Point{}, err
+	}
+
+	log.Printf("maintenance #%v completed in %v, repository shrank from %v to %v bytes", run, res.Duration, sizeBefore, sizeAfter)
+
+	return bench.Point{
+		Measurement: "expire_maintenance",
+		Tags:        append([]bench.Tag{{Key: "run", Value: strconv.Itoa(run)}}, tags...),
+		Fields: append(bench.Summarize([]*bench.Result{res}).ProcessFields(),
+			bench.Field{Key: "repo_size_before", Value: sizeBefore},
+			bench.Field{Key: "repo_size_after", Value: sizeAfter},
+			bench.Field{Key: "num_blobs_before", Value: blobsBefore},
+			bench.Field{Key: "num_blobs_after", Value: blobsAfter}),
+		Time: time.Now(),
+	}, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+// format of timestamps accepted by 'kopia snapshot create --start-time/--end-time'.
+const kopiaTimeFormat = "2006-01-02 15:04:05 MST"
+
+// history builds a repository with many snapshots of a changing source directory, timestamped
+// --snapshot-interval apart.
+type history struct {
+	kopia     bench.Kopia
+	repoDir   string
+	sourceDir string
+}
+
+func (h *history) build(ctx context.Context) error {
+	source := &bench.ChurningSource{
+		Dir:       h.sourceDir,
+		FileSize:  *fileSize,
+		NewFiles:  *filesPerSnapshot,
+		DeletePct: *deleteFilesPct,
+		Rand:      rand.New(rand.NewSource(*seed)),
+	}
+
+	if err := os.MkdirAll(h.sourceDir, 0o755); err != nil {
+		return err
+	}
+
+	if err := h.kopia.CreateRepository(ctx, h.repoDir); err != nil {
+		return err
+	}
+
+	// keep all snapshots, the retention is applied explicitly by the measured expiration.
+	if err := h.kopia.KeepSnapshots(ctx, *numSnapshots+1); err != nil {
+		return err
+	}
+
+	start := time.Now().UTC().Add(-time.Duration(*numSnapshots) * *snapshotInterval)
+
+	for i := 0; i < *numSnapshots; i++ {
+		if err := source.Churn(i); err != nil {
+			return err
+		}
+
+		ts := start.Add(time.Duration(i) * *snapshotInterval).Format(kopiaTimeFormat)
+
+		if _, err := h.kopia.Run(ctx, "snapshot", "create", h.sourceDir, "--no-progress", "--no-auto-maintenance",
+			"--start-time="+ts, "--end-time="+ts); err != nil {
+			return err
+		}
+
+		if (i+1)%50 == 0 || i+1 == *numSnapshots {
+			log.Printf("created %v of %v snapshots", i+1, *numSnapshots)
+		}
+	}
+
+	return nil
+}
+
+func (h *history) countSnapshots(ctx context.Context) (int, error) {
+	snapshots, err := h.kopia.Snapshots(ctx, h.sourceDir)
+
+	return len(snapshots), err
+}
@@ -0,0 +1,48 @@
+package main
+
+// archive formats of datasets.
+const (
+	formatTar = "tar" // extracted using tar, which detects compression
+	formatZip = "zip" // extracted using archive/zip
+)
+
+// dataset describes a downloadable corpus.
+type dataset struct {
+	description string
+	url         string
+	format      string
+
+	// checksumURL is a file in sha256sum format published along with the archive, which may
+	// be wrapped in a PGP signature. When empty the archive is pinned in --checksums.
+	checksumURL string
+}
+
+var catalog = map[string]dataset{
+	"linux-5.14.8": {
+		description: "Linux kernel 5.14.8 source tree (~1.1 GB, 75K files)",
+		url:         "https://cdn.kernel.org/pub/linux/kernel/v5.x/linux-5.14.8.tar.xz",
+		format:      formatTar,
+		checksumURL: "https://cdn.kernel.org/pub/linux/kernel/v5.x/sha256sums.asc",
+	},
+	"linux-6.1": {
+		description: "Linux kernel 6.1 source tree (~1.3 GB, 78K files)",
+		url:         "https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.1.tar.xz",
+		format:      formatTar,
+		checksumURL: "https://cdn.kernel.org/pub/linux/kernel/v6.x/sha256sums.asc",
+	},
+	"silesia": {
+		description: "Silesia compression corpus (~210 MB, 12 files of mixed types)",
+		url:         "https://sun.aei.polsl.pl/~sdeor/corpus/silesia.zip",
+		format:      formatZip,
+	},
+	"enwik8": {
+		description: "First 10^8 bytes of an English Wikipedia XML dump (Hutter Prize)",
+		url:         "http://mattmahoney.net/dc/enwik8.zip",
+		format:      formatZip,
+	},
+	"enwik9": {
+		description: "First 10^9 bytes of an English Wikipedia XML dump (Hutter Prize)",
+		url:         "http://mattmahoney.net/dc/enwik9.zip",
+		format:      formatZip,
+	},
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func extract(format, archive, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	switch format {
+	case formatTar:
+		c := exec.Command("tar", "-xf", archive, "-C", dir)
+		c.Stderr = os.Stderr
+
+		return c.Run()
+
+	case formatZip:
+		return extractZip(archive, dir)
+
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+func extractZip(archive, dir string) error {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	for _, f := range r.File {
+		fname := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if !strings.HasPrefix(fname, filepath.Clean(dir)+string(filepath.Separator)) {
+			return fmt.Errorf("invalid file name %q", f.Name)
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(fname, 0o755); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := extractZipFile(f, fname); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func extractZipFile(f *zip.File, fname string) error {
+	if err := os.MkdirAll(filepath.Dir(fname), 0o755); err != nil {
+		return err
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+
+	defer rc.Close()
+
+	return writeFile(fname, rc)
+}
+
+func writeFile(fname string, r io.Reader) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// completeMarker is written into the dataset directory after successful extraction.
+const completeMarker = ".fetchdataset"
+
+// fetch downloads, verifies and extracts the dataset unless it's already extracted and returns
+// the directory of the dataset.
+func fetch(name string, ds dataset) (string, error) {
+	dir := filepath.Join(*cacheDir, name)
+
+	if _, err := os.Stat(filepath.Join(dir, completeMarker)); err == nil {
+		log.Printf("%v already fetched", name)
+		return dir, nil
+	}
+
+	expected, err := expectedChecksum(ds)
+	if err != nil {
+		return "", err
+	}
+
+	if expected == "" && *requireChecksums {
+		return "", fmt.Errorf("no checksum of %v is published or pinned in %v", path.Base(ds.url), *checksumsFile)
+	}
+
+	archive := filepath.Join(*cacheDir, "downloads", path.Base(ds.url))
+
+	actual, err := download(ds.url, archive)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case expected == "":
+		log.Printf("pinning checksum of %v in %v", path.Base(ds.url), *checksumsFile)
+
+		if err := pinChecksum(path.Base(ds.url), actual); err != nil {
+			return "", err
+		}
+
+	case expected != actual:
+		os.Remove(archive)
+		return "", fmt.Errorf("checksum mismatch of %v: expected %v, got %v", path.Base(ds.url), expected, actual)
+	}
+
+	// extract next to the final directory and rename it so that interrupted extractions are not
+	// mistaken for complete datasets.
+	tmp := dir + ".tmp"
+
+	if err := os.RemoveAll(tmp); err != nil {
+		return "", err
+	}
+
+	log.Printf("extracting %v", archive)
+
+	if err := extract(ds.format, archive, tmp); err != nil {
+		return "", fmt.Errorf("unable to extract %v: %w", archive, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmp, completeMarker), []byte(actual+"\n"), 0o644); err != nil {
+		return "", err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmp, dir); err != nil {
+		return "", err
+	}
+
+	if !*keepDownloads {
+		os.Remove(archive)
+	}
+
+	return dir, nil
+}
+
+// download downloads the URL into the file unless it already exists and returns its SHA-256.
+func download(url, fname string) (string, error) {
+	if _, err := os.Stat(fname); err == nil {
+		log.Printf("using previously downloaded %v", fname)
+		return fileChecksum(fname)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fname), 0o755); err != nil {
+		return "", err
+	}
+
+	log.Printf("downloading %v", url)
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to download %v: %v", url, resp.Status)
+	}
+
+	f, err := os.Create(fname + ".partial")
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+	p := &progressWriter{total: resp.ContentLength, last: time.Now()}
+
+	if _, err := io.Copy(io.MultiWriter(f, h, p), resp.Body); err != nil {
+		return "", fmt.Errorf("unable to download %v: %w", url, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(fname+".partial", fname); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// progressWriter logs progress of a download every few seconds.
+type progressWriter struct {
+	total   int64
+	written int64
+	last    time.Time
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+
+	if time.Since(p.last) > 10*time.Second {
+		p.last = time.Now()
+
+		if p.total > 0 {
+			log.Printf("  %.1f%% of %v MB", 100*float64(p.written)/float64(p.total), p.total>>20)
+		} else {
+			log.Printf("  %v MB", p.written>>20)
+		}
+	}
+
+	return len(b), nil
+}
+
+func fileChecksum(fname string) (string, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// expectedChecksum returns the published or pinned checksum of the archive of the dataset,
+// or an empty string if there's none.
+func expectedChecksum(ds dataset) (string, error) {
+	base := path.Base(ds.url)
+
+	if ds.checksumURL == "" {
+		f, err := os.Open(*checksumsFile)
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		defer f.Close()
+
+		return findChecksum(f, base)
+	}
+
+	resp, err := http.Get(ds.checksumURL)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to download %v: %v", ds.checksumURL, resp.Status)
+	}
+
+	sum, err := findChecksum(resp.Body, base)
+	if err == nil && sum == "" {
+		return "", fmt.Errorf("%v has no checksum of %v", ds.checksumURL, base)
+	}
+
+	return sum, err
+}
+
+// findChecksum finds the checksum of the file in sha256sum format, ignoring any other lines
+// such as those of a PGP signature.
+func findChecksum(r io.Reader, base string) (string, error) {
+	s := bufio.NewScanner(r)
+
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) == 2 && len(f[0]) == sha256.Size*2 && strings.TrimPrefix(f[1], "*") == base {
+			return strings.ToLower(f[0]), nil
+		}
+	}
+
+	return "", s.Err()
+}
+
+func pinChecksum(base, sum string) error {
+	f, err := os.OpenFile(*checksumsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(f, "%v  %v\n", sum, base); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
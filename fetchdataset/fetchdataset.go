@@ -0,0 +1,78 @@
+// Command fetchdataset downloads well-known public corpora into a local dataset cache and verifies
+// their checksums, so that scenarios can snapshot realistic real-world data which is the same on
+// every benchmark host.
+//
+// Usage: fetchdataset [--cache-dir=~/backup-sources] [--list] <dataset>...
+//
+// Each dataset is downloaded to <cache-dir>/downloads and extracted into <cache-dir>/<dataset>,
+// whose path is printed on standard output, so that scripts can use:
+//
+//	DATASET_DIR=$(fetchdataset silesia)
+//
+// Datasets which were already extracted are not downloaded again. Checksums of archives are
+// verified against checksum files published along with them where the publisher provides one
+// (Linux kernel releases), otherwise against --checksums (<cache-dir>/SHA256SUMS by default, in the
+// format of sha256sum). Checksums of archives missing from --checksums are recorded there on their
+// first download, which pins them for subsequent downloads; the file can be copied to other hosts
+// to ensure they fetch identical data, or --require-checksums can be used to refuse unpinned ones.
+//
+// Available datasets are listed with --list, see catalog.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var (
+	cacheDir         = flag.String("cache-dir", os.ExpandEnv("$HOME/backup-sources"), "Directory to download and extract datasets to")
+	checksumsFile    = flag.String("checksums", "", "File with pinned SHA-256 checksums of archives (default: <cache-dir>/SHA256SUMS)")
+	requireChecksums = flag.Bool("require-checksums", false, "Refuse to download archives without published or pinned checksum")
+	keepDownloads    = flag.Bool("keep-downloads", true, "Keep downloaded archives after extraction")
+	list             = flag.Bool("list", false, "List available datasets")
+)
+
+func main() {
+	flag.Parse()
+
+	if *list {
+		var names []string
+		for name := range catalog {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			fmt.Printf("%-16v %v\n", name, catalog[name].description)
+		}
+
+		return
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatal("no datasets given, use --list to list available datasets")
+	}
+
+	if *checksumsFile == "" {
+		*checksumsFile = filepath.Join(*cacheDir, "SHA256SUMS")
+	}
+
+	for _, name := range flag.Args() {
+		ds, ok := catalog[name]
+		if !ok {
+			log.Fatalf("unknown dataset %q, use --list to list available datasets", name)
+		}
+
+		dir, err := fetch(name, ds)
+		if err != nil {
+			log.Fatalf("unable to fetch %v: %v", name, err)
+		}
+
+		fmt.Println(dir)
+	}
+}
@@ -0,0 +1,3 @@
+module fetchdataset
+
+go 1.18
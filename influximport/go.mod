@@ -0,0 +1,3 @@
+module influximport
+
+go 1.18
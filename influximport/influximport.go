@@ -0,0 +1,217 @@
+// Command influximport imports measurements written by runbench into InfluxDB.
+//
+// It walks --output-dir (<outputDir>/<scenario>/<gitTime>-<gitHash>.line) and writes all files
+// which have not been imported yet to the InfluxDB v2 write API in batches of --batch-size lines,
+// retrying failed writes with exponential backoff.
+//
+// Usage: influximport --influx-url=<url> --org=<org> --bucket=<bucket> [--output-dir=<dir>]
+//
+// The token is read from $INFLUX_TOKEN, URL, org and bucket default to $INFLUX_HOST, $INFLUX_ORG
+// and $INFLUX_BUCKET like for the influx CLI.
+//
+// Imported files are recorded in --state-file along with the hash of their contents, so that
+// repeated runs (e.g. from cron after each runbench iteration) only import new or modified files.
+// Files are recorded after the batch containing their last line has been written, so an
+// interrupted import is resumed by rerunning it. Writing the same points again is harmless since
+// InfluxDB overwrites points with identical series and timestamp.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	outputDir  = flag.String("output-dir", filepath.Join(os.TempDir(), "kopia-benchmark-outputs"), "Output directory of runbench")
+	stateFile  = flag.String("state-file", "", "File recording imported files (default: <output-dir>/.influximport-state)")
+	influxURL  = flag.String("influx-url", os.Getenv("INFLUX_HOST"), "URL of InfluxDB")
+	org        = flag.String("org", os.Getenv("INFLUX_ORG"), "InfluxDB organization")
+	bucket     = flag.String("bucket", os.Getenv("INFLUX_BUCKET"), "InfluxDB bucket")
+	batchSize  = flag.Int("batch-size", 5000, "Maximum number of lines written in a single request")
+	maxRetries = flag.Int("max-retries", 5, "Maximum number of retries of each batch")
+	retryDelay = flag.Duration("retry-delay", time.Second, "Delay before the first retry, doubled for each following retry")
+	timeout    = flag.Duration("timeout", time.Minute, "Timeout of a single write request")
+	dryRun     = flag.Bool("dry-run", false, "Only list files which would be imported")
+)
+
+// lineFile is a .line file to be imported.
+type lineFile struct {
+	rel   string
+	hash  string
+	lines [][]byte
+}
+
+func main() {
+	flag.Parse()
+
+	if *stateFile == "" {
+		*stateFile = filepath.Join(*outputDir, ".influximport-state")
+	}
+
+	if !*dryRun {
+		if *influxURL == "" || *org == "" || *bucket == "" {
+			log.Fatal("--influx-url, --org and --bucket are required")
+		}
+
+		if os.Getenv("INFLUX_TOKEN") == "" {
+			log.Fatal("$INFLUX_TOKEN must be set")
+		}
+	}
+
+	if *batchSize <= 0 {
+		log.Fatal("--batch-size must be positive")
+	}
+
+	st, err := loadState(*stateFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pending, skipped, err := findPending(*outputDir, st)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("found %v new files to import, %v already imported", len(pending), skipped)
+
+	if *dryRun {
+		for _, f := range pending {
+			log.Printf("would import %v (%v lines)", f.rel, len(f.lines))
+		}
+
+		return
+	}
+
+	w := &writer{
+		url:   strings.TrimSuffix(*influxURL, "/"),
+		token: os.Getenv("INFLUX_TOKEN"),
+	}
+
+	lines, err := importFiles(w, st, pending)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("imported %v lines from %v files", lines, len(pending))
+}
+
+// findPending returns files under dir not recorded in the state, sorted by path.
+func findPending(dir string, st *state) (pending []*lineFile, skipped int, err error) {
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || !strings.HasSuffix(p, ".line") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		f, err := readLineFile(p, filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+
+		if st.imported(f.rel, f.hash) {
+			skipped++
+			return nil
+		}
+
+		pending = append(pending, f)
+
+		return nil
+	})
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].rel < pending[j].rel
+	})
+
+	return pending, skipped, err
+}
+
+// readLineFile reads non-empty lines of the file.
+func readLineFile(fname, rel string) (*lineFile, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &lineFile{rel: rel, hash: contentHash(data)}
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	s.Buffer(nil, 1<<20)
+
+	for s.Scan() {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		f.lines = append(f.lines, append([]byte(nil), line...))
+	}
+
+	return f, s.Err()
+}
+
+// importFiles writes lines of all files in batches, recording each file in the state once
+// all its lines have been written.
+func importFiles(w *writer, st *state, files []*lineFile) (int, error) {
+	var (
+		batch     bytes.Buffer
+		batchLen  int
+		completed []*lineFile
+		total     int
+	)
+
+	flush := func() error {
+		if batchLen > 0 {
+			if err := w.writeWithRetries(batch.Bytes()); err != nil {
+				return err
+			}
+
+			total += batchLen
+		}
+
+		for _, f := range completed {
+			if err := st.record(f.rel, f.hash); err != nil {
+				return err
+			}
+		}
+
+		batch.Reset()
+
+		batchLen = 0
+		completed = nil
+
+		return nil
+	}
+
+	for _, f := range files {
+		for _, line := range f.lines {
+			batch.Write(line)
+			batch.WriteByte('\n')
+
+			if batchLen++; batchLen >= *batchSize {
+				if err := flush(); err != nil {
+					return total, err
+				}
+			}
+		}
+
+		completed = append(completed, f)
+	}
+
+	return total, flush()
+}
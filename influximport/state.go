@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// state records imported files, one "<hash> <path>" line per file, so that later imports can skip them.
+type state struct {
+	fname  string
+	hashes map[string]string
+}
+
+func loadState(fname string) (*state, error) {
+	st := &state{fname: fname, hashes: map[string]string{}}
+
+	f, err := os.Open(fname)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		hash, rel, ok := strings.Cut(s.Text(), " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid line in %v: %q", fname, s.Text())
+		}
+
+		// later entries win if the file was imported again after being modified.
+		st.hashes[rel] = hash
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %v: %w", fname, err)
+	}
+
+	return st, nil
+}
+
+func (s *state) imported(rel, hash string) bool {
+	return s.hashes[rel] == hash
+}
+
+// record appends the file to the state file, which is synced so that it's not lost on crash.
+func (s *state) record(rel, hash string) error {
+	f, err := os.OpenFile(s.fname, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	if _, err := fmt.Fprintf(f, "%v %v\n", hash, rel); err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	s.hashes[rel] = hash
+
+	return f.Close()
+}
+
+func contentHash(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
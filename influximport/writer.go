@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// writer writes batches of lines using the InfluxDB v2 write API.
+type writer struct {
+	url    string
+	token  string
+	client http.Client
+}
+
+// retryableError is a failed write which may succeed when retried.
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
+// writeWithRetries writes the batch, retrying network errors, throttling and server errors.
+func (w *writer) writeWithRetries(batch []byte) error {
+	delay := *retryDelay
+
+	for attempt := 0; ; attempt++ {
+		err := w.write(batch)
+		if err == nil {
+			return nil
+		}
+
+		re, ok := err.(retryableError)
+		if !ok || attempt >= *maxRetries {
+			return err
+		}
+
+		wait := delay
+		if re.retryAfter > wait {
+			wait = re.retryAfter
+		}
+
+		log.Printf("write failed (attempt %v of %v), retrying in %v: %v", attempt+1, *maxRetries+1, wait, err)
+		time.Sleep(wait)
+
+		delay *= 2
+	}
+}
+
+func (w *writer) write(batch []byte) error {
+	var body bytes.Buffer
+
+	gz := gzip.NewWriter(&body)
+	if _, err := gz.Write(batch); err != nil {
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	q := url.Values{
+		"org":       {*org},
+		"bucket":    {*bucket},
+		"precision": {"ns"},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url+"/api/v2/write?"+q.Encode(), &body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Token "+w.token)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	w.client.Timeout = *timeout
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return retryableError{err: err}
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	err = fmt.Errorf("write failed with %v: %s", resp.Status, bytes.TrimSpace(msg))
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5 {
+		re := retryableError{err: err}
+
+		if secs, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil {
+			re.retryAfter = time.Duration(secs) * time.Second
+		}
+
+		return re
+	}
+
+	return err
+}
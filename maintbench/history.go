@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+
+	"runbench/pkg/bench"
+)
+
+// history builds a repository with many snapshots of a changing source directory.
+type history struct {
+	kopia     bench.Kopia
+	repoDir   string
+	sourceDir string
+
+	rnd    *rand.Rand
+	source *bench.ChurningSource
+}
+
+func (h *history) build(ctx context.Context) error {
+	h.rnd = rand.New(rand.NewSource(*seed))
+	h.source = &bench.ChurningSource{
+		Dir:       h.sourceDir,
+		FileSize:  *fileSize,
+		NewFiles:  *filesPerSnapshot,
+		DeletePct: *deleteFilesPct,
+		Rand:      h.rnd,
+	}
+
+	if err := os.MkdirAll(h.sourceDir, 0o755); err != nil {
+		return err
+	}
+
+	if err := h.kopia.CreateRepository(ctx, h.repoDir); err != nil {
+		return err
+	}
+
+	// keep all snapshots, the history is pruned explicitly below.
+	if err := h.kopia.KeepSnapshots(ctx, *numSnapshots+1); err != nil {
+		return err
+	}
+
+	for i := 0; i < *numSnapshots; i++ {
+		if err := h.source.Churn(i); err != nil {
+			return err
+		}
+
+		if _, err := h.kopia.Run(ctx, "snapshot", "create", h.sourceDir, "--no-progress", "--no-auto-maintenance"); err != nil {
+			return err
+		}
+
+		if (i+1)%10 == 0 || i+1 == *numSnapshots {
+			log.Printf("created %v of %v snapshots", i+1, *numSnapshots)
+		}
+	}
+
+	return h.deleteSnapshots(ctx)
+}
+
+// deleteSnapshots deletes --delete-snapshots-pct of snapshots, orphaning contents only they reference.
+func (h *history) deleteSnapshots(ctx context.Context) error {
+	snapshots, err := h.kopia.Snapshots(ctx, h.sourceDir)
+	if err != nil {
+		return err
+	}
+
+	n := int(float64(len(snapshots)) * *deleteSnapshotsPct / 100)
+	perm := h.rnd.Perm(len(snapshots))[0:n]
+	sort.Ints(perm)
+
+	for _, idx := range perm {
+		if _, err := h.kopia.Run(ctx, "snapshot", "delete", snapshots[idx].ID, "--delete"); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("deleted %v of %v snapshots", n, len(snapshots))
+
+	return nil
+}
@@ -0,0 +1,156 @@
+// Command maintbench measures kopia repository maintenance: it builds a repository with a
+// configurable snapshot history and then measures 'kopia maintenance run'.
+//
+// Usage: maintbench [--snapshots=N] [--files-per-snapshot=N] [--delete-snapshots-pct=P] [--mode=full|quick]
+//
+// The history is built from generated files: before each snapshot --files-per-snapshot new files of
+// --file-size are added to the source directory and --delete-files-pct of existing files are removed.
+// After all snapshots are taken, --delete-snapshots-pct of them (chosen using --seed) are deleted,
+// which leaves contents only referenced by deleted snapshots orphaned, to be garbage-collected by
+// full maintenance. Maintenance runs with --safety (none by default) so that orphaned contents are
+// removed immediately instead of after the usual safety margins.
+//
+// Measurements are written to --output (stdout by default) as a single 'maintenance_summary' line
+// with duration, memory and CPU usage of the maintenance process, its block I/O (where the platform
+// reports it) and the size of the repository before and after maintenance.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+const (
+	repoPassword = "dummy"
+
+	modeFull  = "full"
+	modeQuick = "quick"
+)
+
+var (
+	kopiaExe           = flag.String("kopia-exe", os.ExpandEnv("$HOME/go/bin/kopia"), "Path to kopia")
+	numSnapshots       = flag.Int("snapshots", 50, "Number of snapshots in the history")
+	filesPerSnapshot   = flag.Int("files-per-snapshot", 100, "Number of files added before each snapshot")
+	fileSize           = flag.Int("file-size", 64<<10, "Size of added files")
+	deleteFilesPct     = flag.Float64("delete-files-pct", 20, "Percentage of existing files removed before each snapshot")
+	deleteSnapshotsPct = flag.Float64("delete-snapshots-pct", 50, "Percentage of snapshots deleted after building the history")
+	seed               = flag.Int64("seed", 1, "Seed of generated files and deleted snapshots")
+	mode               = flag.String("mode", modeFull, "Maintenance mode: full or quick")
+	safety             = flag.String("safety", "none", "Value of --safety passed to maintenance")
+	workDir            = flag.String("work-dir", "", "Directory for the repository and source files (default: new temporary directory)")
+	samplingInterval   = flag.Duration("sampling-interval", 100*time.Millisecond, "Interval between samples of the measured process")
+	keep               = flag.Bool("keep", false, "Keep the work directory")
+	outputFile         = flag.String("output", "", "File to append measurements to (default: stdout)")
+	runTags            = flag.String("run-tags", "", "Comma-separated list of tags to attach to measurements")
+)
+
+func main() {
+	flag.Parse()
+
+	if *mode != modeFull && *mode != modeQuick {
+		log.Fatalf("unsupported --mode %q", *mode)
+	}
+
+	if *numSnapshots <= 0 {
+		log.Fatal("--snapshots must be positive")
+	}
+
+	tags, err := bench.ParseTags(*runTags)
+	if err != nil {
+		log.Fatalf("invalid --run-tags: %v", err)
+	}
+
+	if err := run(context.Background(), tags); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, tags []bench.Tag) error {
+	dir, cleanup, err := bench.WorkDir(*workDir, "maintbench", *keep)
+	if err != nil {
+		return err
+	}
+
+	defer cleanup()
+
+	h := &history{
+		kopia:     bench.Kopia{Exe: *kopiaExe, ConfigFile: filepath.Join(dir, "kopia.config"), Password: repoPassword},
+		repoDir:   filepath.Join(dir, "repo"),
+		sourceDir: filepath.Join(dir, "source"),
+	}
+
+	if err := h.build(ctx); err != nil {
+		return err
+	}
+
+	sizeBefore, blobsBefore, err := bench.DirSize(h.repoDir)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("running %v maintenance of repository with %v blobs (%v bytes)", *mode, blobsBefore, sizeBefore)
+
+	args := []string{"maintenance", "run", "--safety=" + *safety}
+	if *mode == modeFull {
+		args = append(args, "--full")
+	}
+
+	c := h.kopia.Command(ctx, args...)
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+
+	r := &bench.CommandRunner{Interval: *samplingInterval}
+
+	res, err := r.Run(ctx, c)
+	if err != nil {
+		return fmt.Errorf("maintenance failed: %w", err)
+	}
+
+	sizeAfter, blobsAfter, err := bench.DirSize(h.repoDir)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("maintenance completed in %v, repository now has %v blobs (%v bytes)", res.Duration, blobsAfter, sizeAfter)
+
+	s := bench.Summarize([]*bench.Result{res})
+
+	fields := append(s.ProcessFields(),
+		bench.Field{Key: "repo_size_before", Value: sizeBefore},
+		bench.Field{Key: "repo_size_after", Value: sizeAfter},
+		bench.Field{Key: "num_blobs_before", Value: blobsBefore},
+		bench.Field{Key: "num_blobs_after", Value: blobsAfter})
+
+	if read, written, ok := bench.BlockIO(c.ProcessState); ok {
+		fields = append(fields,
+			bench.Field{Key: "read_bytes", Value: read},
+			bench.Field{Key: "write_bytes", Value: written})
+	}
+
+	tags = append([]bench.Tag{
+		{Key: "mode", Value: *mode},
+		{Key: "snapshots", Value: strconv.Itoa(*numSnapshots)},
+	}, tags...)
+
+	out, err := bench.OpenOutput(*outputFile)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	return bench.NewLineProtocolSink(out).Write(bench.Point{
+		Measurement: "maintenance_summary",
+		Tags:        tags,
+		Fields:      fields,
+		Time:        time.Now(),
+	})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	outputFormatDir = "dir"
+	outputFormatTar = "tar"
+	outputFormatZip = "zip"
+)
+
+// archiveTime is the modification time of archive entries when --mtime-base is not set,
+// so that archives are byte-for-byte reproducible.
+var archiveTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// archiveWriter is the common subset of tar and zip writers.
+type archiveWriter interface {
+	addDir(name string) error
+	addFile(name string, size int64, mode os.FileMode, owner fileOwner, mtime time.Time) (io.Writer, error)
+	Close() error
+}
+
+type tarArchive struct {
+	w *tar.Writer
+}
+
+func (a tarArchive) addDir(name string) error {
+	return a.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0o700,
+		ModTime:  archiveTime,
+		Format:   tar.FormatPAX,
+	})
+}
+
+func (a tarArchive) addFile(name string, size int64, mode os.FileMode, owner fileOwner, mtime time.Time) (io.Writer, error) {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     int64(mode.Perm()),
+		Uid:      owner.uid,
+		Gid:      owner.gid,
+		ModTime:  mtime,
+		Format:   tar.FormatPAX,
+	}
+
+	if mode&os.ModeSetuid != 0 {
+		hdr.Mode |= 0o4000
+	}
+
+	if mode&os.ModeSetgid != 0 {
+		hdr.Mode |= 0o2000
+	}
+
+	if mode&os.ModeSticky != 0 {
+		hdr.Mode |= 0o1000
+	}
+
+	if err := a.w.WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+
+	return a.w, nil
+}
+
+func (a tarArchive) Close() error {
+	return a.w.Close()
+}
+
+type zipArchive struct {
+	w *zip.Writer
+}
+
+func (a zipArchive) addDir(name string) error {
+	hdr := &zip.FileHeader{Name: name + "/", Modified: archiveTime}
+	hdr.SetMode(os.ModeDir | 0o700)
+
+	_, err := a.w.CreateHeader(hdr)
+
+	return err
+}
+
+func (a zipArchive) addFile(name string, size int64, mode os.FileMode, owner fileOwner, mtime time.Time) (io.Writer, error) {
+	// zip has no portable representation of owners.
+	hdr := &zip.FileHeader{
+		Name:               name,
+		Method:             zip.Store,
+		Modified:           mtime,
+		UncompressedSize64: uint64(size),
+	}
+	hdr.SetMode(mode)
+
+	return a.w.CreateHeader(hdr)
+}
+
+func (a zipArchive) Close() error {
+	return a.w.Close()
+}
+
+// writeArchive writes the dataset as tar or zip stream to --output-file or stdout if it's "-".
+// Unlike directory output, files are generated sequentially so that the archive is deterministic.
+func writeArchive() error {
+	var (
+		out     io.Writer = os.Stdout
+		outFile *os.File
+	)
+
+	if *outputFile != "-" {
+		f, err := os.Create(*outputFile)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		out = f
+		outFile = f
+	}
+
+	bw := bufio.NewWriterSize(throttle(out), 1<<20)
+
+	var a archiveWriter
+
+	switch *outputFormat {
+	case outputFormatTar:
+		a = tarArchive{tar.NewWriter(bw)}
+	case outputFormatZip:
+		a = zipArchive{zip.NewWriter(bw)}
+	}
+
+	dirs := map[string]bool{}
+
+	p := startProgress("archived", int64(*numFiles), int64(*numFiles)*expectedContentLength())
+	defer p.stop()
+
+	for i := 0; i < *numFiles; i++ {
+		dir, name := filePath(i)
+		id := strconv.Itoa(i)
+
+		if *windowsNames {
+			if err := checkWindowsPathLength(dir, name); err != nil {
+				return err
+			}
+		}
+
+		rel, err := filepath.Rel(*outputDir, filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+
+		if err := addParentDirs(a, path.Dir(rel), dirs); err != nil {
+			return err
+		}
+
+		mode, ok := fileMode(id)
+		if !ok {
+			mode = 0o644
+		}
+
+		mtime, ok := fileModTime(id)
+		if !ok {
+			mtime = archiveTime
+		}
+
+		owner, _ := fileOwnerOf(id)
+
+		w, err := a.addFile(rel, contentLength(id), mode, owner, mtime)
+		if err != nil {
+			return err
+		}
+
+		h := sha256.New()
+
+		if manifest != nil {
+			w = io.MultiWriter(w, h)
+		}
+
+		if err := writeContents(w, id); err != nil {
+			return err
+		}
+
+		if manifest != nil {
+			manifest.add(rel, contentLength(id), h.Sum(nil))
+		}
+
+		p.fileDone()
+	}
+
+	if err := a.Close(); err != nil {
+		return err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("error writing archive: %w", err)
+	}
+
+	if outFile != nil && *fsync != fsyncNever {
+		if err := outFile.Sync(); err != nil {
+			return fmt.Errorf("error syncing archive: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// addParentDirs adds entries for dir and its parents which have not been added yet.
+func addParentDirs(a archiveWriter, dir string, added map[string]bool) error {
+	if dir == "." || dir == "/" || added[dir] {
+		return nil
+	}
+
+	if err := addParentDirs(a, path.Dir(dir), added); err != nil {
+		return err
+	}
+
+	added[dir] = true
+
+	return a.addDir(dir)
+}
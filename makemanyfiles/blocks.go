@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Each block of --block-headers bytes starts with a header identifying where the block belongs,
+// so that a verifier can tell corrupted blocks from blocks swapped between files or offsets:
+//
+//	[0:8]   magic
+//	[8:16]  seed
+//	[16:24] offset of the block in the file
+//	[24:28] CRC-32 of the rest of the block
+//	[28]    length of content ID
+//	[29:64] content ID
+//
+// Blocks at the end of a file shorter than the header have no header.
+const (
+	blockHeaderSize  = 64
+	blockMagic       = "MMFBLK01"
+	maxBlockIDLength = blockHeaderSize - 29
+)
+
+// blockHeaderWriter overwrites the beginning of each block written through it with a block header.
+type blockHeaderWriter struct {
+	w   io.Writer
+	id  string
+	buf []byte
+	n   int
+	off int64
+}
+
+func newBlockHeaderWriter(w io.Writer, contentID string) *blockHeaderWriter {
+	return &blockHeaderWriter{w: w, id: contentID, buf: make([]byte, *blockHeaders)}
+}
+
+func (b *blockHeaderWriter) Write(p []byte) (int, error) {
+	total := len(p)
+
+	for len(p) > 0 {
+		c := copy(b.buf[b.n:], p)
+		b.n += c
+		p = p[c:]
+
+		if b.n == len(b.buf) {
+			if err := b.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return total, nil
+}
+
+// flush writes the buffered block, the last block of the file may be shorter.
+func (b *blockHeaderWriter) flush() error {
+	blk := b.buf[0:b.n]
+
+	if len(blk) >= blockHeaderSize {
+		putBlockHeader(blk, b.id, b.off)
+	}
+
+	if _, err := b.w.Write(blk); err != nil {
+		return err
+	}
+
+	b.off += int64(b.n)
+	b.n = 0
+
+	return nil
+}
+
+func putBlockHeader(blk []byte, contentID string, off int64) {
+	copy(blk, blockMagic)
+	binary.BigEndian.PutUint64(blk[8:], uint64(*seed))
+	binary.BigEndian.PutUint64(blk[16:], uint64(off))
+	binary.BigEndian.PutUint32(blk[24:], crc32.ChecksumIEEE(blk[blockHeaderSize:]))
+
+	id := blk[29:blockHeaderSize]
+	for i := range id {
+		id[i] = 0
+	}
+
+	blk[28] = byte(copy(id, contentID))
+}
+
+// blockHeader is a parsed block header.
+type blockHeader struct {
+	seed int64
+	off  int64
+	id   string
+}
+
+// parseBlockHeader returns header of the block, errors describe corruption of the block.
+func parseBlockHeader(blk []byte) (blockHeader, error) {
+	if len(blk) < blockHeaderSize || string(blk[0:8]) != blockMagic {
+		return blockHeader{}, errors.New("missing block header")
+	}
+
+	if crc32.ChecksumIEEE(blk[blockHeaderSize:]) != binary.BigEndian.Uint32(blk[24:]) {
+		return blockHeader{}, errors.New("block checksum mismatch")
+	}
+
+	l := int(blk[28])
+	if l > maxBlockIDLength {
+		return blockHeader{}, errors.New("invalid block header")
+	}
+
+	return blockHeader{
+		seed: int64(binary.BigEndian.Uint64(blk[8:])),
+		off:  int64(binary.BigEndian.Uint64(blk[16:])),
+		id:   string(blk[29 : 29+l]),
+	}, nil
+}
+
+// verifyDataset checks files of a dataset generated with the same flags, reporting missing,
+// corrupted and misplaced blocks, and fails if any file doesn't have expected contents.
+func verifyDataset() {
+	t0 := time.Now()
+
+	var failed int32
+
+	err := forEachFile(*numFiles, "verified", func(i int) error {
+		dir, name := filePath(i)
+		fname := filepath.Join(dir, name)
+
+		problems, err := verifyFile(fname, strconv.Itoa(i))
+		if err != nil {
+			return err
+		}
+
+		for _, p := range problems {
+			log.Printf("%v: %v", fname, p)
+		}
+
+		if len(problems) > 0 {
+			atomic.AddInt32(&failed, 1)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if failed > 0 {
+		log.Fatalf("%v of %v files in %v failed verification", failed, *numFiles, *outputDir)
+	}
+
+	log.Printf("verified %v files in %v in %v", *numFiles, *outputDir, time.Since(t0))
+}
+
+// verifyFile returns problems found in the file with the given content ID.
+func verifyFile(fname, contentID string) ([]string, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{"missing"}, nil
+		}
+
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var problems []string
+
+	blockSize := *blockHeaders
+	if blockSize <= 0 {
+		blockSize = 1 << 20
+	}
+
+	actual := sha256.New()
+	blk := make([]byte, blockSize)
+
+	for off := int64(0); ; off += int64(len(blk)) {
+		n, err := io.ReadFull(f, blk)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+
+		actual.Write(blk[0:n])
+
+		if *blockHeaders > 0 && n >= blockHeaderSize {
+			problems = append(problems, verifyBlock(blk[0:n], contentID, off)...)
+		}
+
+		if n < len(blk) {
+			break
+		}
+	}
+
+	expected := sha256.New()
+	if err := writeContents(expected, contentID); err != nil {
+		return nil, err
+	}
+
+	if len(problems) == 0 && !bytes.Equal(actual.Sum(nil), expected.Sum(nil)) {
+		problems = append(problems, "contents don't match")
+	}
+
+	return problems, nil
+}
+
+func verifyBlock(blk []byte, contentID string, off int64) []string {
+	h, err := parseBlockHeader(blk)
+	if err != nil {
+		return []string{fmt.Sprintf("offset %v: %v", off, err)}
+	}
+
+	if h.seed != *seed || h.id != truncateBlockID(contentID) || h.off != off {
+		return []string{fmt.Sprintf("offset %v: found block of content %q at offset %v with seed %v", off, h.id, h.off, h.seed)}
+	}
+
+	return nil
+}
+
+func truncateBlockID(contentID string) string {
+	if len(contentID) > maxBlockIDLength {
+		return contentID[0:maxBlockIDLength]
+	}
+
+	return contentID
+}
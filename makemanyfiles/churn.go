@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// churnOp is a single modification of the dataset made by --churn.
+type churnOp struct {
+	seq  int
+	kind int
+	i    int
+}
+
+const (
+	churnChange = iota
+	churnAdd
+	churnDelete
+)
+
+// churnDataset keeps rewriting, adding and deleting files of a dataset previously generated with
+// the same flags at --churn-rate operations per second until --churn-duration elapses or the process
+// is interrupted. Operations are weighted by --change-pct, --add-pct and --delete-pct, and their
+// sequence only depends on --seed and --mutation-seed, though timing relative to other processes
+// (such as a running snapshot) is not reproducible.
+func churnDataset() {
+	if *numFiles <= 0 {
+		log.Fatal("--churn requires --num-files of the dataset")
+	}
+
+	total := *changePct + *addPct + *deletePct
+	if *changePct < 0 || *addPct < 0 || *deletePct < 0 || total <= 0 {
+		log.Fatal("--churn requires positive --change-pct, --add-pct or --delete-pct")
+	}
+
+	if *churnRate <= 0 {
+		log.Fatal("--churn-rate must be positive")
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+
+	defer signal.Stop(stop)
+
+	var deadline <-chan time.Time
+
+	if *churnDuration > 0 {
+		deadline = time.After(*churnDuration)
+	}
+
+	t0 := time.Now()
+
+	var (
+		wg     sync.WaitGroup
+		counts [3]int32
+	)
+
+	// operations on the same file are always handled by the same worker so they don't race.
+	ops := make([]chan churnOp, *parallel)
+
+	for w := range ops {
+		ops[w] = make(chan churnOp)
+
+		wg.Add(1)
+
+		go func(ops <-chan churnOp) {
+			defer wg.Done()
+
+			for op := range ops {
+				if err := applyChurnOp(op); err != nil {
+					log.Fatal(err)
+				}
+
+				atomic.AddInt32(&counts[op.kind], 1)
+			}
+		}(ops[w])
+	}
+
+	rnd := rand.New(rand.NewSource(*seed*1000003 + int64(*mutationSeed)))
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / *churnRate))
+
+	defer ticker.Stop()
+
+	log.Printf("churning %v at %v operations per second", *outputDir, *churnRate)
+
+churn:
+	for seq := 0; ; seq++ {
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			break churn
+		case <-stop:
+			break churn
+		}
+
+		op := churnOp{seq: seq, kind: churnChange, i: rnd.Intn(*numFiles)}
+
+		switch v := rnd.Float64() * total; {
+		case v < *addPct:
+			op.kind = churnAdd
+		case v < *addPct+*deletePct:
+			op.kind = churnDelete
+		}
+
+		ops[op.i%*parallel] <- op
+	}
+
+	for _, ch := range ops {
+		close(ch)
+	}
+
+	wg.Wait()
+
+	log.Printf("churned %v: changed %v, added %v and deleted %v files in %v",
+		*outputDir, counts[churnChange], counts[churnAdd], counts[churnDelete], time.Since(t0))
+}
+
+// applyChurnOp performs a single churn operation, rewriting a deleted file recreates it.
+func applyChurnOp(op churnOp) error {
+	switch op.kind {
+	case churnAdd:
+		dir, name := filePathForKey(fmt.Sprintf("%v.c%v.%v", *seed, *mutationSeed, op.seq), op.i)
+		return writeFileAt(dir, name, fmt.Sprintf("c%v.%v", *mutationSeed, op.seq))
+
+	case churnDelete:
+		dir, name := filePath(op.i)
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return nil
+
+	default:
+		dir, name := filePath(op.i)
+		return writeFileAt(dir, name, fmt.Sprintf("%v.c%v.%v", op.i, *mutationSeed, op.seq))
+	}
+}
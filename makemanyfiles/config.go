@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// datasetsConfig is the contents of the file passed via --config describing several datasets
+// to generate in one invocation, for example:
+//
+//	parallel: 16
+//	flags:
+//	  seed: 42
+//	datasets:
+//	  - output-dir: /data/src
+//	    profile: source-tree
+//	    total-size: 10G
+//	  - output-dir: /data/photos
+//	    profile: photos
+//	    num-files: 1000
+//
+// Keys are flag names (without leading dashes). 'flags' apply to all datasets and are overridden
+// by values of each dataset, flags passed on the command line take precedence over both.
+// 'parallel' is the total number of workers shared by datasets generated concurrently.
+type datasetsConfig struct {
+	Parallel int                 `yaml:"parallel"`
+	Flags    map[string]string   `yaml:"flags"`
+	Datasets []map[string]string `yaml:"datasets"`
+}
+
+// flags which can't be set in the config file or per dataset.
+var configReservedFlags = map[string]bool{"config": true, "parallel": true}
+
+// generateFromConfig generates datasets described by --config, each in a separate process running
+// this executable, so that datasets don't share flag state.
+func generateFromConfig() error {
+	b, err := os.ReadFile(*configFile)
+	if err != nil {
+		return fmt.Errorf("unable to read config file: %w", err)
+	}
+
+	var cfg datasetsConfig
+
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return fmt.Errorf("unable to parse config file %q: %w", *configFile, err)
+	}
+
+	if len(cfg.Datasets) == 0 {
+		return errors.New("no datasets in config file")
+	}
+
+	budget := cfg.Parallel
+	if budget <= 0 {
+		budget = *parallel
+	}
+
+	if err := checkConfigFlags(cfg.Flags); err != nil {
+		return fmt.Errorf("invalid flags in config file: %w", err)
+	}
+
+	for i, ds := range cfg.Datasets {
+		if err := checkConfigFlags(ds); err != nil {
+			return fmt.Errorf("invalid config of dataset %v: %w", i, err)
+		}
+	}
+
+	// generate up to budget datasets at once, splitting workers evenly among them.
+	concurrent := len(cfg.Datasets)
+	if concurrent > budget {
+		concurrent = budget
+	}
+
+	workers := budget / concurrent
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	sem := make(chan struct{}, concurrent)
+	t0 := time.Now()
+
+	for i, ds := range cfg.Datasets {
+		args := datasetArgs(cfg.Flags, ds, workers)
+
+		sem <- struct{}{}
+
+		wg.Add(1)
+
+		go func(i int, args []string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			log.Printf("generating dataset %v: %v", i, args)
+
+			cmd := exec.CommandContext(ctx, exe, args...)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+
+			if err := cmd.Run(); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("dataset %v failed: %w", i, err)
+				}
+				mu.Unlock()
+
+				cancel()
+			}
+		}(i, args)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	log.Printf("generated %v datasets in %v", len(cfg.Datasets), time.Since(t0))
+
+	return nil
+}
+
+func checkConfigFlags(values map[string]string) error {
+	for name := range values {
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("unknown flag %q", name)
+		}
+
+		if configReservedFlags[name] {
+			return fmt.Errorf("flag %q cannot be set in config file", name)
+		}
+	}
+
+	return nil
+}
+
+// datasetArgs returns command line of the process generating the dataset, later flags override earlier ones.
+func datasetArgs(common, dataset map[string]string, workers int) []string {
+	var args []string
+
+	for _, values := range []map[string]string{common, dataset} {
+		var names []string
+
+		for name := range values {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			args = append(args, fmt.Sprintf("--%v=%v", name, values[name]))
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		if !configReservedFlags[f.Name] {
+			args = append(args, fmt.Sprintf("--%v=%v", f.Name, f.Value.String()))
+		}
+	})
+
+	return append(args, fmt.Sprintf("--parallel=%v", workers))
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	// number of chunks drawn from the shared pool and usage of each pool chunk.
+	pooledChunks   = new(int64)
+	pooledBytes    = new(int64)
+	totalBytes     = new(int64)
+	poolChunkUsage []int32
+)
+
+// writeFile writes file whose contents are derived from seed and contentID.
+func writeFile(fname, contentID string) error {
+	if err := writeFileContents(fname, contentID); err != nil {
+		return err
+	}
+
+	return setMetadata(fname, contentID)
+}
+
+func writeFileContents(fname, contentID string) error {
+	if len(modes) > 0 {
+		// make sure existing file being rewritten is writable, its final mode is set afterwards.
+		if err := os.Chmod(fname, 0o600); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	f, w, finish, err := createOutputFile(fname)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	if err := writeContents(throttle(w), contentID); err != nil {
+		return err
+	}
+
+	if err := finish(); err != nil {
+		return err
+	}
+
+	if *fsync == fsyncPerFile {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return fileWritten()
+}
+
+// maxHKDFLength is the maximum output of HKDF-SHA256, longer contents use keyStream.
+const maxHKDFLength = 255 * sha256.Size
+
+// writeContents writes contents identified by contentID, with --block-headers each block starts with a header.
+func writeContents(w io.Writer, contentID string) error {
+	if *blockHeaders <= 0 {
+		return writeRawContents(w, contentID)
+	}
+
+	bw := newBlockHeaderWriter(w, contentID)
+
+	if err := writeRawContents(bw, contentID); err != nil {
+		return err
+	}
+
+	return bw.flush()
+}
+
+func writeRawContents(w io.Writer, contentID string) error {
+	length := fileLengthOf(contentID)
+
+	for i := 0; i < *fileDataRepeat; i++ {
+		var err error
+
+		switch {
+		case *dedupRatio > 0:
+			err = writeChunkedContent(w, contentID, length)
+
+		case length <= maxHKDFLength && *generator == generatorHKDF && *contentType == contentBinary:
+			r := hkdf.New(sha256.New, []byte(contentID), []byte(fmt.Sprintf("%v", *seed)), nil)
+			_, err = io.CopyN(w, r, int64(length))
+
+		default:
+			var r io.Reader
+
+			if r, err = contentStream(contentID); err == nil {
+				_, err = io.CopyN(w, r, int64(length))
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fileLengthOf returns the length of data (repeated --file-data-repeat times) of the file with
+// the given content ID. With --size-sigma lengths are log-normally distributed with median
+// --file-length (or length of the --mix class), otherwise all files have the same length.
+func fileLengthOf(contentID string) int {
+	median := baseFileLength(contentID)
+
+	if *sizeSigma <= 0 {
+		return median
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "size.%v.%v", *seed, contentID)
+	sum := h.Sum(nil)
+
+	// Box-Muller transform of two uniformly distributed values in (0,1]
+	u1 := float64(binary.BigEndian.Uint64(sum)>>11+1) / (1 << 53)
+	u2 := float64(binary.BigEndian.Uint64(sum[8:])>>11) / (1 << 53)
+	z := math.Sqrt(-2*math.Log(u1)) * math.Cos(2*math.Pi*u2)
+
+	length := float64(median) * math.Exp(*sizeSigma*z)
+
+	if max := float64(*maxFileLength); max > 0 && length > max {
+		length = max
+	}
+
+	if length < 1 {
+		length = 1
+	}
+
+	return int(length)
+}
+
+// contentLength returns the length of the file with the given content ID.
+func contentLength(contentID string) int64 {
+	return int64(fileLengthOf(contentID)) * int64(*fileDataRepeat)
+}
+
+// expectedContentLength returns the median length of generated files, with --mix the average.
+func expectedContentLength() int64 {
+	return meanBaseFileLength() * int64(*fileDataRepeat)
+}
+
+// isComplete returns true if the file exists and has expected length and, with --resume-verify,
+// expected contents.
+func isComplete(fname, contentID string) (bool, error) {
+	st, err := os.Stat(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	if !st.Mode().IsRegular() || st.Size() != contentLength(contentID) {
+		return false, nil
+	}
+
+	if !*resumeVerify {
+		return true, nil
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return false, err
+	}
+
+	defer f.Close()
+
+	actual := sha256.New()
+	if _, err := io.Copy(actual, f); err != nil {
+		return false, err
+	}
+
+	expected := sha256.New()
+	if err := writeContents(expected, contentID); err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(actual.Sum(nil), expected.Sum(nil)), nil
+}
+
+// writeChunkedContent writes contents of the file split into --chunk-size chunks, each of which is
+// either unique or, with probability given by --dedup-ratio, one of --dedup-pool-size chunks
+// shared by all files. The choice only depends on seed, content ID and chunk number.
+func writeChunkedContent(w io.Writer, contentID string, fileLength int) error {
+	for off, j := 0, 0; off < fileLength; off, j = off+*chunkSize, j+1 {
+		length := *chunkSize
+		if rem := fileLength - off; rem < length {
+			length = rem
+		}
+
+		h := sha256.New()
+		fmt.Fprintf(h, "chunk.%v.%v.%v", *seed, contentID, j)
+		sel := h.Sum(nil)
+
+		secret := fmt.Sprintf("%v.%v", contentID, j)
+
+		// top 53 bits of the hash give uniformly distributed value in [0,1)
+		if float64(binary.BigEndian.Uint64(sel)>>11)/(1<<53) < *dedupRatio {
+			idx := int(binary.BigEndian.Uint64(sel[8:]) % uint64(*dedupPoolSize))
+			secret = fmt.Sprintf("pool.%v", idx)
+
+			atomic.AddInt64(pooledChunks, 1)
+			atomic.AddInt64(pooledBytes, int64(length))
+			atomic.AddInt32(&poolChunkUsage[idx], 1)
+		}
+
+		atomic.AddInt64(totalBytes, int64(length))
+
+		r, err := contentStream(secret)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.CopyN(w, r, int64(length)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keyStream returns an unbounded pseudo-random stream derived from the secret and seed,
+// which unlike HKDF output is not limited to 255 hashes. By default it's AES-CTR keyed
+// using HKDF, --generator selects faster alternatives.
+func keyStream(secret string) (io.Reader, error) {
+	var key [32]byte
+
+	if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(secret), []byte(fmt.Sprintf("%v", *seed)), nil), key[:]); err != nil {
+		return nil, err
+	}
+
+	switch *generator {
+	case generatorChaCha8:
+		return newChaCha8Reader(key), nil
+	case generatorXoshiro:
+		return newXoshiroReader(key), nil
+	}
+
+	b, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.StreamReader{
+		S: cipher.NewCTR(b, make([]byte, aes.BlockSize)),
+		R: zeroReader{},
+	}, nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+
+	return len(b), nil
+}
+
+// logDedupStats logs how many bytes are expected to be deduplicated, pool chunks are
+// deduplicated after their first occurrence.
+func logDedupStats() {
+	var distinctPooled int64
+
+	for _, c := range poolChunkUsage {
+		if c > 0 {
+			distinctPooled++
+		}
+	}
+
+	total := atomic.LoadInt64(totalBytes)
+	dupBytes := atomic.LoadInt64(pooledBytes) - distinctPooled*int64(*chunkSize)
+
+	if dupBytes < 0 {
+		dupBytes = 0
+	}
+
+	log.Printf("%v chunks drawn from shared pool (%v distinct), approximately %v of %v bytes (%.1f %%) are duplicates",
+		atomic.LoadInt64(pooledChunks), distinctPooled, dupBytes, total, 100*float64(dupBytes)/float64(total))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// dbPageUpdated returns true if the page of file i is overwritten by the current mutation.
+func dbPageUpdated(i int, page int64) bool {
+	key := fmt.Sprintf("%v.%v.%v.%v", *seed, *mutationSeed, i, page)
+
+	return 100*float64(mutationHash("dbpage", key)>>11)/(1<<53) < *dbUpdatePct
+}
+
+// dbPageContents returns contents of the page written by the current mutation.
+func dbPageContents(i int, page int64) (io.Reader, error) {
+	return contentStream(fmt.Sprintf("%v.db%v.%v", i, *mutationSeed, page))
+}
+
+// mutateDatabaseFiles simulates database activity between snapshots: --db-update-pct of
+// --db-page-size pages of each file are overwritten in place and the new pages are appended
+// to a write-ahead log file, one per mutation. Which pages change only depends on --seed and
+// --mutation-seed.
+func mutateDatabaseFiles() {
+	pageSize, err := parseSize(*dbPageSize)
+	if err != nil || pageSize <= 0 {
+		log.Fatalf("invalid --db-page-size %q", *dbPageSize)
+	}
+
+	if *dbUpdatePct > 100 {
+		log.Fatal("invalid --db-update-pct")
+	}
+
+	t0 := time.Now()
+
+	var pages int64
+
+	err = forEachFile(*numFiles, "updated", func(i int) error {
+		n, err := updateDatabaseFile(i, pageSize)
+		atomic.AddInt64(&pages, n)
+
+		return err
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	walName, walSize, err := writeWAL(pageSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("updated %v pages of %v database files in %v and wrote %v bytes to %v in %v",
+		pages, *numFiles, *outputDir, walSize, walName, time.Since(t0))
+}
+
+func updateDatabaseFile(i int, pageSize int64) (int64, error) {
+	dir, name := filePath(i)
+
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var updated int64
+
+	for page := int64(0); page*pageSize < st.Size(); page++ {
+		if !dbPageUpdated(i, page) {
+			continue
+		}
+
+		length := pageSize
+		if rem := st.Size() - page*pageSize; rem < length {
+			length = rem
+		}
+
+		r, err := dbPageContents(i, page)
+		if err != nil {
+			return updated, err
+		}
+
+		if _, err := f.Seek(page*pageSize, io.SeekStart); err != nil {
+			return updated, err
+		}
+
+		if _, err := io.CopyN(throttle(f), r, length); err != nil {
+			return updated, err
+		}
+
+		updated++
+	}
+
+	return updated, f.Close()
+}
+
+// writeWAL writes log of pages updated by the mutation, each preceded by a header line.
+func writeWAL(pageSize int64) (string, int64, error) {
+	fname := filepath.Join(targetDirs[0], fmt.Sprintf("wal-%v-%v.log", *seed, *mutationSeed))
+
+	f, err := os.Create(fname)
+	if err != nil {
+		return "", 0, err
+	}
+
+	defer f.Close()
+
+	bw := bufio.NewWriterSize(throttle(f), 1<<20)
+
+	for i := 0; i < *numFiles; i++ {
+		dir, name := filePath(i)
+
+		st, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return "", 0, err
+		}
+
+		for page := int64(0); page*pageSize < st.Size(); page++ {
+			if !dbPageUpdated(i, page) {
+				continue
+			}
+
+			length := pageSize
+			if rem := st.Size() - page*pageSize; rem < length {
+				length = rem
+			}
+
+			fmt.Fprintf(bw, "WAL file=%v page=%v length=%v\n", i, page, length)
+
+			r, err := dbPageContents(i, page)
+			if err != nil {
+				return "", 0, err
+			}
+
+			if _, err := io.CopyN(bw, r, length); err != nil {
+				return "", 0, err
+			}
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return "", 0, err
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return fname, size, f.Close()
+}
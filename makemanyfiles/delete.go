@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// deleteDataset removes files which generating the dataset with the same flags would create,
+// together with shard and tree directories left empty. Other files in --output-dir are kept.
+func deleteDataset() {
+	if *numFiles <= 0 {
+		log.Fatal("--delete requires --num-files of the dataset")
+	}
+
+	t0 := time.Now()
+
+	var deleted int32
+
+	err := forEachFile(*numFiles, "deleted", func(i int) error {
+		if err := removeHardlinks(i); err != nil {
+			return err
+		}
+
+		dir, name := filePath(i)
+
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		atomic.AddInt32(&deleted, 1)
+
+		removeEmptyParents(dir)
+
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("deleted %v files from %v in %v", deleted, *outputDir, time.Since(t0))
+}
+
+// removeEmptyParents removes dir and its parents below --output-dir as long as they are empty.
+func removeEmptyParents(dir string) {
+	for {
+		rel, err := filepath.Rel(targetRoot(dir), dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			return
+		}
+
+		// fails for directories which still have entries, including ones not created by us.
+		if os.Remove(dir) != nil {
+			return
+		}
+
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+var printDigest = flag.Bool("digest", false, "Print the digest of the dataset described by the flags without writing it, which equals contentHash of its --manifest")
+
+// datasetDigest computes contentHash of the manifest of the dataset described by the flags by
+// generating contents of all files in memory. Unlike the manifest of a written dataset it doesn't
+// depend on the filesystem, so it's the same on all platforms for the same flags.
+func datasetDigest() (string, error) {
+	b := newManifestBuilder()
+
+	if err := forEachFile(*numFiles, "hashed", func(i int) error {
+		dir, name := filePath(i)
+		id := strconv.Itoa(i)
+
+		h := sha256.New()
+		if err := writeContents(h, id); err != nil {
+			return err
+		}
+
+		sum := h.Sum(nil)
+
+		addPath := func(dir, name string) error {
+			rel, err := filepath.Rel(targetDir(i), filepath.Join(dir, name))
+			if err != nil {
+				return err
+			}
+
+			b.add(path.Clean(filepath.ToSlash(rel)), contentLength(id), sum)
+
+			return nil
+		}
+
+		if err := addPath(dir, name); err != nil {
+			return err
+		}
+
+		// hard links are separate files in the manifest.
+		for k := 0; k < *hardlinksPerFile; k++ {
+			if err := addPath(linkPath(i, k)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b.hash[:]), nil
+}
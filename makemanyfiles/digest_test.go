@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+)
+
+// goldenDigests are digests of standard datasets, which must not change across platforms and
+// releases, otherwise results of scenarios using them aren't comparable with historical ones.
+// Datasets of setup-sources.sh are truncated to their first files, since contents and names of
+// each file only depend on the seed and its index.
+var goldenDigests = []struct {
+	name   string
+	args   []string
+	digest string
+}{
+	{"100k-flat-compressible", []string{"--num-files=1000", "--file-length=1000", "--file-data-repeat=10"}, "2dd2b7072720bbba1be6252c391f5262e0bc120eab420d3959f2a87756051a2f"},
+	{"150k-flat-compressible added", []string{"--num-files=1000", "--file-length=1000", "--file-data-repeat=10", "--seed=7654321"}, "4a9cc5214e2d9001cfd146d628d7028771958275192d48f9bb1e0444e524246f"},
+	{"1k-flat-large-compressible", []string{"--num-files=10", "--file-length=1000", "--file-data-repeat=1000"}, "52eb9938e2458b3468c8cb78b30212920352c28703535c15879046cfd2f9693d"},
+	{"1mfiles-sharded_256", []string{"--num-files=1000", "--file-length=1000", "--shard1=2"}, "18c566a3fd3fa7d5d224baed3cb552aff2a105cb0e21277a7587249fce767064"},
+	{"100kfiles-sharded_16_256", []string{"--num-files=1000", "--file-length=1000", "--shard1=2", "--shard2=2"}, "1d5c21b912cce902b761391ea46c0c80ceede49eb9aecc701250a5c3337fa92e"},
+	{"chacha8", []string{"--num-files=20", "--file-length=100000", "--generator=chacha8"}, "cde7db5d4850a960f99419dea4dfd7dc4b11e1149cf2261d1416ec4ab21dd635"},
+	{"xoshiro", []string{"--num-files=20", "--file-length=100000", "--generator=xoshiro"}, "fcc13d4b13be152095c7325b293c3e83c81730b5a9868660ef799d59ad995e95"},
+	{"dedup", []string{"--num-files=20", "--file-length=100000", "--dedup-ratio=0.5", "--chunk-size=4096", "--dedup-pool-size=10"}, "982f1c9143e19640eb7fd564c893955e2b8ba2d45de95c89d06491f748977816"},
+	{"block headers", []string{"--num-files=20", "--file-length=100000", "--block-headers=4096"}, "22ceceb57459c7aa7190e0b177f9c420bf992cc4c00c8a03709774ced6df9167"},
+	{"size sigma", []string{"--num-files=100", "--file-length=4096", "--size-sigma=1", "--max-file-length=65536"}, "43f1627a2ed8d9b3985a071659b9c9fc88ec3fec368c476a466d7fe69baa82ed"},
+	{"mix", []string{"--num-files=100", "--mix=90%:4k,10%:64k"}, "e0ebcf672c99a38034281875f18e90b5290b024b2ca055359567a7b19736eaa3"},
+	{"text log", []string{"--num-files=20", "--file-length=10000", "--content=text"}, "cec5bf63856936b4e3d0503441711fdce52194dd8852401a005c45edcf4f6247"},
+	{"text source", []string{"--num-files=20", "--file-length=10000", "--content=text", "--text-style=source"}, "81263cc1685ab0e8eecccc130542df7e93755e5f49696ec3035af9b80fb2eb7d"},
+	{"mail maildir", []string{"--num-files=20", "--file-length=4096", "--content=mail", "--maildir-folders=3"}, "e08b19a8df2d39486b85ee4e77f26494b06480a313d49304a9b44f5855b3df1d"},
+	{"tree", []string{"--num-files=100", "--file-length=1000", "--tree-depth=2", "--dirs-per-dir=3", "--files-per-dir=10"}, "4a5b460c3a8d64433ca7887c06052a0e70715f86d1c0912f9b82e57c1f79165b"},
+	{"unicode names", []string{"--num-files=100", "--file-length=1000", "--name-style=unicode-mixed"}, "f0c7126b172d7d44c495500846debf6b500393e52b309b6f5afbdb20a39584c2"},
+	{"windows names", []string{"--num-files=100", "--file-length=1000", "--name-style=spaces-and-quotes", "--windows-names"}, "7b8f654ef8a5bd5820c702f9c43c1e29ca1a2a11e5d294d0454018ec46373b66"},
+	{"hardlinks", []string{"--num-files=20", "--file-length=1000", "--hardlinks-per-file=2", "--shard1=1"}, "c89dfd0dcb1b58c7ed8efbad87b91dc119db9fe1c2c5797c29e293048a1f83aa"},
+	{"source-tree profile", []string{"--profile=source-tree", "--num-files=100"}, "9b81b62808e1a827d1cafcea50b9bcb50fa8b2c82ce7a05cb37c6b807f6650b0"},
+	{"photos profile", []string{"--profile=photos", "--num-files=5"}, "d473b5960066dc27b43be307bbb21e22f21569efd8893fcf4ef1e8f4690d95cb"},
+	{"maildir profile", []string{"--profile=maildir", "--num-files=100"}, "dcca360f8e31a8184eca7bf5a5dc7c4616b8fd5bfebff87056992c00686b0ffb"},
+	{"mixed-office profile", []string{"--profile=mixed-office", "--num-files=50"}, "810152e6f99fbebd2d20efb36cf6fffab9c777e0bb42fb160a7b3ae75254ab5f"},
+}
+
+func TestGoldenDigests(t *testing.T) {
+	for _, tc := range goldenDigests {
+		t.Run(tc.name, func(t *testing.T) {
+			setFlags(t, append([]string{"--quiet", "--output-dir=."}, tc.args...)...)
+
+			if err := applyProfile(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := parseTargets(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := setupDataset(); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := datasetDigest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tc.digest {
+				t.Errorf("digest = %v, want %v, the dataset changed", got, tc.digest)
+			}
+		})
+	}
+}
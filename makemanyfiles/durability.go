@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	fsyncNever   = "never"
+	fsyncPerFile = "per-file"
+	fsyncPer1000 = "per-1000"
+
+	// directIOAlignment is the alignment of buffers and offsets required for O_DIRECT writes.
+	directIOAlignment  = 4096
+	directIOBufferSize = 1 << 20
+)
+
+// filesWritten counts files written since start for --fsync=per-1000.
+var filesWritten = new(int64)
+
+func validateDurabilityFlags() error {
+	switch *fsync {
+	case fsyncNever, fsyncPerFile, fsyncPer1000:
+	default:
+		return fmt.Errorf("unsupported --fsync %q", *fsync)
+	}
+
+	if *directIO && !directIOSupported {
+		return fmt.Errorf("--direct is not supported on this platform")
+	}
+
+	return nil
+}
+
+// createOutputFile creates a file for writing generated contents, with --direct bypassing page cache.
+func createOutputFile(fname string) (*os.File, io.Writer, func() error, error) {
+	if !*directIO {
+		f, err := os.Create(fname)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		return f, f, func() error { return nil }, nil
+	}
+
+	f, err := openDirect(fname)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	dw := newDirectWriter(f, fname)
+
+	return f, dw, dw.finish, nil
+}
+
+// fileWritten is called after each file has been written and closed.
+func fileWritten() error {
+	if *fsync == fsyncPer1000 && atomic.AddInt64(filesWritten, 1)%1000 == 0 {
+		return syncAll()
+	}
+
+	return nil
+}
+
+// finishDurability flushes data written since the last sync at the end of generation.
+func finishDurability() error {
+	if *fsync == fsyncPer1000 {
+		return syncAll()
+	}
+
+	return nil
+}
+
+// directWriter buffers writes in an aligned buffer so they can be issued with O_DIRECT,
+// the unaligned tail of the file is written without O_DIRECT by finish.
+type directWriter struct {
+	f     *os.File
+	fname string
+	buf   []byte
+	n     int
+	off   int64
+}
+
+func newDirectWriter(f *os.File, fname string) *directWriter {
+	b := make([]byte, directIOBufferSize+directIOAlignment)
+	skip := 0
+
+	if rem := int(uintptr(unsafe.Pointer(&b[0])) % directIOAlignment); rem != 0 {
+		skip = directIOAlignment - rem
+	}
+
+	return &directWriter{f: f, fname: fname, buf: b[skip : skip+directIOBufferSize]}
+}
+
+func (w *directWriter) Write(p []byte) (int, error) {
+	total := len(p)
+
+	for len(p) > 0 {
+		c := copy(w.buf[w.n:], p)
+		w.n += c
+		p = p[c:]
+
+		if w.n == len(w.buf) {
+			if err := w.flush(w.n); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return total, nil
+}
+
+// flush writes the first n bytes of the buffer, n must be aligned.
+func (w *directWriter) flush(n int) error {
+	if _, err := w.f.WriteAt(w.buf[0:n], w.off); err != nil {
+		return err
+	}
+
+	w.off += int64(n)
+	w.n = copy(w.buf, w.buf[n:w.n])
+
+	return nil
+}
+
+func (w *directWriter) finish() error {
+	if err := w.flush(w.n &^ (directIOAlignment - 1)); err != nil {
+		return err
+	}
+
+	if w.n == 0 {
+		return nil
+	}
+
+	tail, err := os.OpenFile(w.fname, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	defer tail.Close()
+
+	if _, err := tail.WriteAt(w.buf[0:w.n], w.off); err != nil {
+		return err
+	}
+
+	if *fsync == fsyncPerFile {
+		return tail.Sync()
+	}
+
+	return nil
+}
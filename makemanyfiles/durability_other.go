@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+const directIOSupported = false
+
+func openDirect(fname string) (*os.File, error) {
+	return nil, errors.New("O_DIRECT is not supported")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+const (
+	generatorHKDF    = "hkdf"
+	generatorChaCha8 = "chacha8"
+	generatorXoshiro = "xoshiro"
+)
+
+func validateGenerator() error {
+	switch *generator {
+	case generatorHKDF, generatorChaCha8, generatorXoshiro:
+		return nil
+	default:
+		return fmt.Errorf("unsupported --generator %q", *generator)
+	}
+}
+
+// chacha8Reader produces the key stream of ChaCha with 8 rounds, zero nonce and counter
+// starting at zero. It's not meant to be secure, only fast and well distributed.
+type chacha8Reader struct {
+	key     [8]uint32
+	counter uint64
+	buf     [64]byte
+	pos     int
+}
+
+func newChaCha8Reader(key [32]byte) *chacha8Reader {
+	r := &chacha8Reader{pos: 64}
+
+	for i := range r.key {
+		r.key[i] = binary.LittleEndian.Uint32(key[i*4:])
+	}
+
+	return r
+}
+
+func quarterRound(a, b, c, d uint32) (uint32, uint32, uint32, uint32) {
+	a += b
+	d = bits.RotateLeft32(d^a, 16)
+	c += d
+	b = bits.RotateLeft32(b^c, 12)
+	a += b
+	d = bits.RotateLeft32(d^a, 8)
+	c += d
+	b = bits.RotateLeft32(b^c, 7)
+
+	return a, b, c, d
+}
+
+func (r *chacha8Reader) block() {
+	in := [16]uint32{
+		0x61707865, 0x3320646e, 0x79622d32, 0x6b206574,
+		r.key[0], r.key[1], r.key[2], r.key[3], r.key[4], r.key[5], r.key[6], r.key[7],
+		uint32(r.counter), uint32(r.counter >> 32), 0, 0,
+	}
+
+	x := in
+
+	for i := 0; i < 8; i += 2 {
+		x[0], x[4], x[8], x[12] = quarterRound(x[0], x[4], x[8], x[12])
+		x[1], x[5], x[9], x[13] = quarterRound(x[1], x[5], x[9], x[13])
+		x[2], x[6], x[10], x[14] = quarterRound(x[2], x[6], x[10], x[14])
+		x[3], x[7], x[11], x[15] = quarterRound(x[3], x[7], x[11], x[15])
+
+		x[0], x[5], x[10], x[15] = quarterRound(x[0], x[5], x[10], x[15])
+		x[1], x[6], x[11], x[12] = quarterRound(x[1], x[6], x[11], x[12])
+		x[2], x[7], x[8], x[13] = quarterRound(x[2], x[7], x[8], x[13])
+		x[3], x[4], x[9], x[14] = quarterRound(x[3], x[4], x[9], x[14])
+	}
+
+	for i := range x {
+		binary.LittleEndian.PutUint32(r.buf[i*4:], x[i]+in[i])
+	}
+
+	r.counter++
+	r.pos = 0
+}
+
+func (r *chacha8Reader) Read(b []byte) (int, error) {
+	n := 0
+
+	for n < len(b) {
+		if r.pos == len(r.buf) {
+			r.block()
+		}
+
+		c := copy(b[n:], r.buf[r.pos:])
+		r.pos += c
+		n += c
+	}
+
+	return n, nil
+}
+
+// xoshiroReader produces output of xoshiro256** generator.
+type xoshiroReader struct {
+	s   [4]uint64
+	buf [8]byte
+	pos int
+}
+
+func newXoshiroReader(key [32]byte) *xoshiroReader {
+	r := &xoshiroReader{pos: 8}
+
+	for i := range r.s {
+		r.s[i] = binary.LittleEndian.Uint64(key[i*8:])
+	}
+
+	// all-zero state is the only invalid one.
+	if r.s == [4]uint64{} {
+		r.s[0] = 1
+	}
+
+	return r
+}
+
+func (r *xoshiroReader) next() uint64 {
+	s := &r.s
+	result := bits.RotateLeft64(s[1]*5, 7) * 9
+	t := s[1] << 17
+
+	s[2] ^= s[0]
+	s[3] ^= s[1]
+	s[1] ^= s[2]
+	s[0] ^= s[3]
+	s[2] ^= t
+	s[3] = bits.RotateLeft64(s[3], 45)
+
+	return result
+}
+
+func (r *xoshiroReader) Read(b []byte) (int, error) {
+	n := 0
+
+	// drain bytes left over from the previous read.
+	for r.pos < 8 && n < len(b) {
+		b[n] = r.buf[r.pos]
+		r.pos++
+		n++
+	}
+
+	for ; n+8 <= len(b); n += 8 {
+		binary.LittleEndian.PutUint64(b[n:], r.next())
+	}
+
+	if n < len(b) {
+		binary.LittleEndian.PutUint64(r.buf[:], r.next())
+		r.pos = copy(b[n:], r.buf[:])
+		n = len(b)
+	}
+
+	return n, nil
+}
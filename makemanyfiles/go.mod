@@ -2,4 +2,7 @@ module makemanyfiles
 
 go 1.18
 
-require golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d
+require (
+	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d
+	gopkg.in/yaml.v3 v3.0.1
+)
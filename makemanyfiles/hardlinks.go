@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// linkPath returns the directory and name of k-th hard link to file i. With --tree-depth links
+// are spread across directories of the tree.
+func linkPath(i, k int) (string, string) {
+	return filePathForKey(fmt.Sprintf("%v.%v.link.%v", *seed, i, k), (i**hardlinksPerFile+k+1)%*numFiles)
+}
+
+// createHardlinks creates --hardlinks-per-file links to file i, replacing existing entries.
+func createHardlinks(i int) error {
+	dir, name := filePath(i)
+	target := filepath.Join(dir, name)
+
+	for k := 0; k < *hardlinksPerFile; k++ {
+		linkDir, linkName := linkPath(i, k)
+
+		if err := os.MkdirAll(linkDir, 0o700); err != nil {
+			return fmt.Errorf("error creating directory: %w", err)
+		}
+
+		fname := filepath.Join(linkDir, linkName)
+
+		if err := os.Remove(fname); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := os.Link(target, fname); err != nil {
+			return err
+		}
+
+		dirModified(linkDir)
+	}
+
+	return nil
+}
+
+// removeHardlinks removes links to file i created by createHardlinks.
+func removeHardlinks(i int) error {
+	for k := 0; k < *hardlinksPerFile; k++ {
+		dir, name := linkPath(i, k)
+
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		removeEmptyParents(dir)
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hugeInsertion is a run of bytes inserted into a huge file by a shift mutation.
+type hugeInsertion struct {
+	offset int64 // offset in the original (unmutated) file
+	data   []byte
+}
+
+// parseSize parses size with optional K, M, G or T (binary) suffix.
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+
+	if n := len(s); n > 0 {
+		switch strings.ToUpper(s[n-1:]) {
+		case "K":
+			mult = 1 << 10
+		case "M":
+			mult = 1 << 20
+		case "G":
+			mult = 1 << 30
+		case "T":
+			mult = 1 << 40
+		}
+
+		if mult > 1 {
+			s = s[0 : n-1]
+		}
+	}
+
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return v * mult, nil
+}
+
+// hugeBlockHash returns a hash used to make decisions about the block.
+func hugeBlockHash(kind string, b int64) uint64 {
+	h := sha256.New()
+	fmt.Fprintf(h, "huge.%v.%v.%v", kind, *seed, b)
+
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+func hugePct(kind string, b int64) float64 {
+	return 100 * float64(hugeBlockHash(kind, b)>>11) / (1 << 53)
+}
+
+// hugeInsertions returns --shift-mutations insertions for --mutation-seed sorted by offset.
+// Each inserts 1-24 bytes at a random offset within a non-sparse block, which shifts all
+// following data and tests how quickly the splitter resynchronizes.
+func hugeInsertions(size, blockSize int64) []hugeInsertion {
+	var res []hugeInsertion
+
+	for k := 0; k < *shiftMutations; k++ {
+		h := sha256.New()
+		fmt.Fprintf(h, "huge.shift.%v.%v.%v", *seed, *mutationSeed, k)
+		sum := h.Sum(nil)
+
+		off := int64(binary.BigEndian.Uint64(sum) % uint64(size))
+		if hugePct("sparse", off/blockSize) < *sparsePct {
+			continue
+		}
+
+		res = append(res, hugeInsertion{off, sum[8 : 8+1+int(sum[8])%24]})
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].offset < res[j].offset })
+
+	return res
+}
+
+// writeHugeFile writes a single file of --huge-file-size made of --huge-block-size blocks, each of
+// which is either unique, a copy of an earlier block (--huge-dup-pct) or a hole (--sparse-pct).
+func writeHugeFile() error {
+	size, err := parseSize(*hugeFileSize)
+	if err != nil {
+		return err
+	}
+
+	blockSize, err := parseSize(*hugeBlockSize)
+	if err != nil || blockSize <= 0 {
+		return fmt.Errorf("invalid --huge-block-size %q", *hugeBlockSize)
+	}
+
+	fname := filepath.Join(*outputDir, fmt.Sprintf("huge-%v.bin", *seed))
+	t0 := time.Now()
+
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	bw := bufio.NewWriterSize(throttle(f), 1<<20)
+	insertions := hugeInsertions(size, blockSize)
+
+	var written, inserted, holes, dups int64
+
+	// holes are not written, so they don't count towards completion.
+	p := startProgress("wrote", 0, size*(100-int64(*sparsePct))/100)
+	defer p.stop()
+
+	for b := int64(0); b*blockSize < size; b++ {
+		start := b * blockSize
+
+		length := blockSize
+		if rem := size - start; rem < length {
+			length = rem
+		}
+
+		if hugePct("sparse", b) < *sparsePct {
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+
+			if _, err := f.Seek(length, io.SeekCurrent); err != nil {
+				return err
+			}
+
+			holes++
+
+			continue
+		}
+
+		source := b
+		if b > 0 && hugePct("dup", b) < *hugeDupPct {
+			source = int64(hugeBlockHash("dupsource", b) % uint64(b))
+			dups++
+		}
+
+		r, err := contentStream(fmt.Sprintf("huge.%v", source))
+		if err != nil {
+			return err
+		}
+
+		// write the block, splitting it at insertion points which fall within it.
+		pos := start
+
+		for len(insertions) > 0 && insertions[0].offset < start+length {
+			n := insertions[0].offset - pos
+			if _, err := io.CopyN(bw, r, n); err != nil {
+				return err
+			}
+
+			if _, err := bw.Write(insertions[0].data); err != nil {
+				return err
+			}
+
+			pos += n
+			inserted += int64(len(insertions[0].data))
+			insertions = insertions[1:]
+		}
+
+		if _, err := io.CopyN(bw, r, start+length-pos); err != nil {
+			return err
+		}
+
+		written += length
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	// extend the file in case it ends with a hole.
+	if err := f.Truncate(size + inserted); err != nil {
+		return err
+	}
+
+	if *fsync != fsyncNever {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("wrote %v bytes to %v (%v bytes of data, %v holes, %v duplicate blocks, %v bytes inserted) in %v",
+		size+inserted, fname, written, holes, dups, inserted, time.Since(t0))
+
+	return nil
+}
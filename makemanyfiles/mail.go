@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const contentMail = "mail"
+
+var (
+	mailFolders  = []string{"INBOX", "Sent", "Drafts", "Archive", "Lists", "Work", "Family", "Receipts", "Travel", "Newsletters"}
+	mailFlags    = []string{"S", "S", "S", "RS", "FS", "", "ST"}
+	mailNames    = []string{"alice", "bob", "carol", "dave", "eve", "frank", "grace", "heidi", "ivan", "judy", "mallory", "oscar"}
+	mailDomains  = []string{"example.com", "example.org", "mail.example.net", "lists.example.com"}
+	mailAgents   = []string{"Mozilla/5.0 Thunderbird/102.0", "Apple Mail (2.3696)", "Microsoft Outlook 16.0", "mutt/2.2.1"}
+	mailTimeBase = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// maildirPath returns the directory and name of the message derived from hash h when laying
+// out the dataset as --maildir-folders folders, each with cur, new and tmp subdirectories.
+// Most messages are in cur, with few in new and tmp, like on a mail server.
+func maildirPath(outDir string, h []byte) (string, string) {
+	folder := int(binary.BigEndian.Uint32(h[0:4]) % uint32(*maildirFolders))
+
+	folderName := "." + mailFolders[folder%len(mailFolders)]
+	if folder >= len(mailFolders) {
+		folderName = fmt.Sprintf("%v%v", folderName, folder/len(mailFolders))
+	}
+
+	if folder == 0 {
+		// INBOX is the top-level maildir.
+		folderName = ""
+	}
+
+	sub := "cur"
+
+	switch v := h[4] % 100; {
+	case v < 1:
+		sub = "tmp"
+	case v < 15:
+		sub = "new"
+	}
+
+	delivered := mailTimeBase.Add(time.Duration(binary.BigEndian.Uint32(h[5:9])%(4*365*24*3600)) * time.Second)
+	hexName := hex.EncodeToString(h)
+
+	// <time>.M<usec>P<pid>Q<n>.<host>, see https://cr.yp.to/proto/maildir.html
+	name := fmt.Sprintf("%v.M%vP%vQ%v.mail.%v", delivered.Unix(), binary.BigEndian.Uint32(h[9:13])%1000000,
+		binary.BigEndian.Uint16(h[13:15]), h[15], hexName[0:12])
+
+	if sub == "cur" {
+		name += ":2," + mailFlags[int(h[16])%len(mailFlags)]
+	}
+
+	if *windowsNames {
+		name = windowsSafeName(name)
+	}
+
+	return filepath.Join(outDir, folderName, sub), name
+}
+
+// mailHeader writes headers of a message to sb.
+func (t *textReader) mailHeader(sb *strings.Builder) {
+	from := t.pick(mailNames) + "@" + t.pick(mailDomains)
+	to := t.pick(mailNames) + "@" + t.pick(mailDomains)
+
+	secs, _ := t.next(4 * 365 * 24 * 3600)
+	date := mailTimeBase.Add(time.Duration(secs) * time.Second)
+
+	id, _ := t.next(1 << 62)
+
+	fmt.Fprintf(sb, "Return-Path: <%v>\n", from)
+	fmt.Fprintf(sb, "Received: from %v by mx.%v with ESMTPS id %x; %v\n", t.pick(mailDomains), t.pick(mailDomains), id, date.Format(time.RFC1123Z))
+	fmt.Fprintf(sb, "Message-ID: <%x@%v>\n", id, t.pick(mailDomains))
+	fmt.Fprintf(sb, "Date: %v\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(sb, "From: %v <%v>\n", strings.ToUpper(from[0:1])+strings.Split(from, "@")[0][1:], from)
+	fmt.Fprintf(sb, "To: %v\n", to)
+	fmt.Fprintf(sb, "Subject: %v\n", t.words(2, 7))
+	fmt.Fprintf(sb, "User-Agent: %v\n", t.pick(mailAgents))
+	sb.WriteString("MIME-Version: 1.0\nContent-Type: text/plain; charset=utf-8\nContent-Transfer-Encoding: 8bit\n\n")
+}
+
+// mailLine writes a line of message body to sb, paragraphs of prose with occasional quotes.
+func (t *textReader) mailLine(sb *strings.Builder) {
+	switch kind, _ := t.next(12); kind {
+	case 0:
+		sb.WriteByte('\n')
+	case 1:
+		sb.WriteString("> " + t.words(4, 12) + "\n")
+	default:
+		sb.WriteString(t.words(6, 14) + ".\n")
+	}
+}
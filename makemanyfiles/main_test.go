@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+// setFlags sets flags as if makemanyfiles was run with args, all flags are restored when the test finishes.
+func setFlags(t *testing.T, args ...string) {
+	t.Helper()
+
+	saved := map[string]string{}
+
+	flag.VisitAll(func(f *flag.Flag) {
+		saved[f.Name] = f.Value.String()
+	})
+
+	savedCommandLine, savedMix := flag.CommandLine, mixClasses
+
+	t.Cleanup(func() {
+		flag.CommandLine, mixClasses = savedCommandLine, savedMix
+
+		flag.VisitAll(func(f *flag.Flag) {
+			if v := saved[f.Name]; f.Value.String() != v {
+				if err := f.Value.Set(v); err != nil {
+					t.Errorf("unable to restore --%v: %v", f.Name, err)
+				}
+			}
+		})
+	})
+
+	// a separate set sharing the flag values tells flag.Visit which ones args pass.
+	fs := flag.NewFlagSet("makemanyfiles", flag.ContinueOnError)
+
+	flag.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	flag.CommandLine = fs
+	mixClasses = nil
+}
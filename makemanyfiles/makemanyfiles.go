@@ -9,106 +9,473 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
-
-	"golang.org/x/crypto/hkdf"
 )
 
 var (
-	outputDir      = flag.String("output-dir", "", "")
-	seed           = flag.Int64("seed", 123, "Seed")
-	numFiles       = flag.Int("num-files", 0, "Number of files")
-	fileLength     = flag.Int("file-length", 0, "Length of each file")
-	shard1         = flag.Int("shard1", 0, "First level shard length")
-	shard2         = flag.Int("shard2", 0, "Second level shard length")
-	shard3         = flag.Int("shard3", 0, "Third level shard length")
-	parallel       = flag.Int("parallel", 4, "Parallel")
-	fileDataRepeat = flag.Int("file-data-repeat", 1, "Repeat contents of each file")
+	outputDir        = flag.String("output-dir", "", "")
+	outputTargets    = flag.String("output-targets", "", "Comma-separated output directories, e.g. on different disks, files are assigned to them round-robin, optionally weighted with ':<weight>' suffix")
+	configFile       = flag.String("config", "", "YAML file describing multiple datasets to generate with flag values for each and total --parallel budget")
+	outputFormat     = flag.String("output-format", outputFormatDir, "Output format: dir, tar or zip")
+	outputFile       = flag.String("output-file", "", "Archive file to write with --output-format=tar or zip, '-' for stdout")
+	seed             = flag.Int64("seed", 123, "Seed")
+	numFiles         = flag.Int("num-files", 0, "Number of files")
+	fileLength       = flag.Int("file-length", 0, "Length of each file")
+	totalSize        = flag.String("total-size", "", "Total size of the dataset (with K, M, G or T suffix), determines --num-files")
+	totalSizeTol     = flag.Float64("total-size-tolerance", 1, "Maximum deviation from --total-size in percent")
+	shard1           = flag.Int("shard1", 0, "First level shard length")
+	shard2           = flag.Int("shard2", 0, "Second level shard length")
+	shard3           = flag.Int("shard3", 0, "Third level shard length")
+	parallel         = flag.Int("parallel", 4, "Parallel")
+	generator        = flag.String("generator", generatorHKDF, "Content generator: hkdf, chacha8 or xoshiro (faster, but producing different contents)")
+	contentType      = flag.String("content", contentBinary, "Content of files: binary (pseudo-random), text (line-oriented ASCII) or mail (e-mail messages)")
+	maildirFolders   = flag.Int("maildir-folders", 0, "Lay out files as messages in this many maildir folders with cur, new and tmp subdirectories")
+	textStyle        = flag.String("text-style", textStyleLog, "Style of --content=text: log or source")
+	profile          = flag.String("profile", "", "Dataset profile: source-tree, photos, vm-images, maildir, mail-server or mixed-office")
+	mix              = flag.String("mix", "", "Composition of file lengths instead of --file-length, e.g. '90%:4k,9%:1M,1%:1G'")
+	sizeSigma        = flag.Float64("size-sigma", 0, "Make file lengths log-normally distributed with median --file-length and given sigma")
+	maxFileLength    = flag.Int("max-file-length", 0, "Maximum file length with --size-sigma")
+	hardlinksPerFile = flag.Int("hardlinks-per-file", 0, "Number of hard links to each file, created in other directories (hardlink farm)")
+	fileDataRepeat   = flag.Int("file-data-repeat", 1, "Repeat contents of each file")
+	dedupRatio       = flag.Float64("dedup-ratio", 0, "Fraction of content chunks drawn from a pool shared across files")
+	chunkSize        = flag.Int("chunk-size", 1<<20, "Size of content chunks used with --dedup-ratio")
+	dedupPoolSize    = flag.Int("dedup-pool-size", 100, "Number of distinct chunks in the shared pool used with --dedup-ratio")
+	appendFiles      = flag.Bool("append", false, "Add files starting at --start-index to an existing dataset, leaving existing files alone")
+	startIndex       = flag.Int("start-index", 0, "Number of the first file written with --append")
+	blockHeaders     = flag.Int("block-headers", 0, "Start each block of this size with a header identifying file, offset and seed, 0 to disable")
+	stats            = flag.Bool("stats", false, "Print statistics of existing directory given by --output-dir instead of generating files")
+	verify           = flag.Bool("verify", false, "Verify files of a dataset previously generated with the same flags, reporting corrupted and swapped blocks with --block-headers")
+	resume           = flag.Bool("resume", false, "Skip files which already exist with the correct size")
+	resumeVerify     = flag.Bool("resume-verify", false, "Like --resume, but also verify contents of existing files")
+	nameStyle        = flag.String("name-style", nameStyleHex, "Style of file names: hex, unicode-mixed, very-long or spaces-and-quotes")
+	names            = flag.String("names", "", "Generate human-like file and directory names from a word list with 'wordlist:<file>', overrides --name-style")
+	windowsNames     = flag.Bool("windows-names", false, "Generate only names and paths valid on Windows")
+	maxPathLength    = flag.Int("max-path-length", 200, "Maximum length of paths relative to --output-dir with --windows-names, leaving room for the output directory within the 260 character limit")
+	treeDepth        = flag.Int("tree-depth", 0, "Generate nested directory tree of given depth instead of shards")
+	dirsPerDir       = flag.Int("dirs-per-dir", 10, "Number of subdirectories of each non-leaf directory with --tree-depth")
+	filesPerDir      = flag.Int("files-per-dir", 100, "Number of files in each directory with --tree-depth")
+	deleteFiles      = flag.Bool("delete", false, "Delete files of a dataset previously generated with the same flags, leaving other files alone")
+	mutate           = flag.Bool("mutate", false, "Modify existing dataset generated with the same flags instead of generating it")
+	mutationSeed     = flag.Int("mutation-seed", 1, "Seed of the mutation, use different values for successive mutations of the same dataset")
+	churn            = flag.Bool("churn", false, "Keep changing, adding and deleting files of existing dataset generated with the same flags until stopped")
+	churnRate        = flag.Float64("churn-rate", 10, "Number of file operations per second with --churn")
+	churnDuration    = flag.Duration("churn-duration", 0, "How long to run --churn, 0 to run until interrupted")
+	changePct        = flag.Float64("change-pct", 0, "Percentage of files to rewrite with --mutate")
+	addPct           = flag.Float64("add-pct", 0, "Number of files to add with --mutate, as percentage of --num-files")
+	renamePct        = flag.Float64("rename-pct", 0, "Percentage of files to rename or move to another directory without changing contents with --mutate")
+	renameDirPct     = flag.Float64("rename-dir-pct", 0, "Percentage of directories to rename with --mutate")
+	dbUpdatePct      = flag.Float64("db-update-pct", 0, "With --mutate, treat files as databases and overwrite this percentage of their pages in place, logging new pages to a WAL file")
+	dbPageSize       = flag.String("db-page-size", "8K", "Size of database pages with --db-update-pct")
+	deletePct        = flag.Float64("delete-pct", 0, "Percentage of files to delete with --mutate")
+	mtimeBase        = flag.String("mtime-base", "", "Modification time of files (RFC 3339), by default files keep the time they were written")
+	fileModes        = flag.String("file-modes", "", "Comma-separated octal modes assigned to files deterministically, e.g. '644,600,755,444'")
+	fileOwners       = flag.String("file-owners", "", "Comma-separated uid:gid pairs assigned to files deterministically when running as root")
+	fsync            = flag.String("fsync", fsyncNever, "When to fsync written files: never, per-file or per-1000 (sync filesystems after every 1000 files)")
+	directIO         = flag.Bool("direct", false, "Write files with O_DIRECT, bypassing page cache (Linux only)")
+	quiet            = flag.Bool("quiet", false, "Don't report progress")
+	progressInterval = flag.Duration("progress-interval", 5*time.Second, "How often to report progress")
+	manifestFile     = flag.String("manifest", "", "Write JSON manifest describing the dataset to this file")
+	maxWriteMBps     = flag.Float64("max-write-mbps", 0, "Maximum rate of writing file contents in MB/s, 0 for unlimited")
+	hugeFileSize     = flag.String("huge-file-size", "", "Generate a single file of given size (with K, M, G or T suffix) instead of many files")
+	hugeBlockSize    = flag.String("huge-block-size", "1M", "Size of blocks of the huge file which can be sparse or duplicated")
+	sparsePct        = flag.Float64("sparse-pct", 0, "Percentage of blocks of the huge file which are holes")
+	hugeDupPct       = flag.Float64("huge-dup-pct", 0, "Percentage of blocks of the huge file which duplicate earlier blocks")
+	shiftMutations   = flag.Int("shift-mutations", 0, "Number of short byte runs to insert into the huge file at offsets chosen by --mutation-seed, shifting subsequent data")
+	mtimeSpread      = flag.Duration("mtime-spread", 0, "Spread modification times deterministically over this duration after --mtime-base")
 )
 
-var counter = new(int32)
-
 func main() {
 	flag.Parse()
 
-	if *outputDir == "" {
-		log.Fatal("missing --output-dir")
+	if *configFile != "" {
+		if err := generateFromConfig(); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if err := applyProfile(); err != nil {
+		log.Fatal(err)
+	}
+
+	// the digest only depends on paths relative to the output directory.
+	if *printDigest && *outputDir == "" && *outputTargets == "" {
+		*outputDir = "."
+	}
+
+	if err := parseTargets(); err != nil {
+		log.Fatal(err)
+	}
+
+	switch *outputFormat {
+	case outputFormatDir:
+		if *outputDir == "" {
+			log.Fatal("missing --output-dir")
+		}
+
+		if *stats {
+			if err := printStats(); err != nil {
+				log.Fatal(err)
+			}
+
+			return
+		}
+
+	case outputFormatTar, outputFormatZip:
+		if *outputFile == "" {
+			log.Fatal("missing --output-file")
+		}
+
+		if *mutate || *resume || *resumeVerify || *outputTargets != "" {
+			log.Fatal("--mutate, --resume and --output-targets are not supported with archive output")
+		}
+
+	default:
+		log.Fatalf("unsupported --output-format %q", *outputFormat)
+	}
+
+	if *dedupRatio < 0 || *dedupRatio > 1 {
+		log.Fatal("--dedup-ratio must be between 0 and 1")
+	}
+
+	if *dedupRatio > 0 && (*chunkSize <= 0 || *dedupPoolSize <= 0) {
+		log.Fatal("--chunk-size and --dedup-pool-size must be positive")
+	}
+
+	if err := validateDurabilityFlags(); err != nil {
+		log.Fatal(err)
+	}
+
+	if *maxWriteMBps > 0 {
+		writeLimiter = &rateLimiter{bytesPerSec: *maxWriteMBps * 1e6}
+	}
+
+	if err := setupDataset(); err != nil {
+		log.Fatal(err)
 	}
 
 	t0 := time.Now()
 
-	os.Mkdir(*outputDir, 0o700)
+	if *manifestFile != "" {
+		manifest = newManifestBuilder()
+	}
 
-	var wg sync.WaitGroup
+	if *deleteFiles && (*outputFormat != outputFormatDir || *hugeFileSize != "" || *mutate || *churn || manifest != nil) {
+		log.Fatal("--delete can't be combined with archive output, --huge-file-size, --mutate, --churn or --manifest")
+	}
 
-	for w := 0; w < *parallel; w++ {
-		wg.Add(1)
+	if *hardlinksPerFile > 0 && (*outputFormat != outputFormatDir || *hugeFileSize != "" || len(targetDirs) > 1) {
+		log.Fatal("--hardlinks-per-file is not supported with archive output, --huge-file-size or --output-targets")
+	}
 
-		w := w
+	if *hugeFileSize != "" && len(targetDirs) > 1 {
+		log.Fatal("--huge-file-size is not supported with --output-targets")
+	}
 
-		go func() {
-			defer wg.Done()
+	if *appendFiles && (*outputFormat != outputFormatDir || *hugeFileSize != "" || *mutate || *churn || *deleteFiles || *numFiles <= 0 || *startIndex < 0) {
+		log.Fatal("--append requires --num-files and non-negative --start-index and can't be combined with archive output, --huge-file-size, --mutate, --churn or --delete")
+	}
 
-			for i := 0; i < *numFiles; i++ {
-				if i%*parallel != w {
-					continue
-				}
+	if *blockHeaders > 0 && *blockHeaders < blockHeaderSize {
+		log.Fatalf("--block-headers must be at least %v", blockHeaderSize)
+	}
 
-				h := sha256.New()
-				fmt.Fprintf(h, "%v.%v", *seed, i)
-				fname := hex.EncodeToString(h.Sum(nil))
-				outDir := *outputDir
+	if *verify && (*outputFormat != outputFormatDir || *hugeFileSize != "" || *mutate || *churn || *deleteFiles || *appendFiles) {
+		log.Fatal("--verify can't be combined with archive output, --huge-file-size, --mutate, --churn, --delete or --append")
+	}
 
-				if s := *shard1; s > 0 {
-					outDir = filepath.Join(outDir, fname[0:s])
-					fname = fname[s:]
+	if *maildirFolders > 0 && (*treeDepth > 0 || *shard1 > 0 || *shard2 > 0 || *shard3 > 0) {
+		log.Fatal("--maildir-folders can't be combined with --tree-depth or shards")
+	}
 
-					os.Mkdir(outDir, 0o700)
-				}
+	if *churn && (*outputFormat != outputFormatDir || *hugeFileSize != "" || *mutate) {
+		log.Fatal("--churn can't be combined with archive output, --huge-file-size or --mutate")
+	}
 
-				if s := *shard2; s > 0 {
-					outDir = filepath.Join(outDir, fname[0:s])
-					fname = fname[s:]
+	if *printDigest && (*outputFormat != outputFormatDir || *hugeFileSize != "" || *mutate || *churn || *deleteFiles || *verify || *appendFiles || *resume || *resumeVerify || manifest != nil) {
+		log.Fatal("--digest can't be combined with archive output, --huge-file-size, --mutate, --churn, --delete, --verify, --append, --resume or --manifest")
+	}
 
-					os.Mkdir(outDir, 0o700)
-				}
+	if *outputFormat == outputFormatDir && !*deleteFiles && !*verify && !*printDigest {
+		if err := createTargets(); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-				if s := *shard3; s > 0 {
-					outDir = filepath.Join(outDir, fname[0:s])
-					fname = fname[s:]
+	switch {
+	case *hugeFileSize != "":
+		if err := writeHugeFile(); err != nil {
+			log.Fatal(err)
+		}
 
-					os.Mkdir(outDir, 0o700)
-				}
+	case *printDigest:
+		d, err := datasetDigest()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println(d)
+
+	case *outputFormat != outputFormatDir:
+		if err := writeArchive(); err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("archived %v files of %v x %v to %v in %v", *numFiles, *fileDataRepeat, describeFileLength(), *outputFile, time.Since(t0))
+
+	case *deleteFiles:
+		deleteDataset()
+
+	case *verify:
+		verifyDataset()
+
+	case *mutate && *dbUpdatePct > 0:
+		mutateDatabaseFiles()
+
+	case *mutate:
+		mutateDataset()
+
+	case *churn:
+		churnDataset()
+
+	default:
+		generateDataset(t0)
+	}
+
+	if err := finishDurability(); err != nil {
+		log.Fatal(err)
+	}
+
+	if *dedupRatio > 0 {
+		logDedupStats()
+	}
+
+	if manifest != nil {
+		if err := writeManifest(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// setupDataset validates flags which determine names and contents of files of the dataset and
+// derives the state needed to generate them.
+func setupDataset() error {
+	if err := validateGenerator(); err != nil {
+		return err
+	}
+
+	if err := validateContent(); err != nil {
+		return err
+	}
+
+	if err := validateNameStyle(); err != nil {
+		return err
+	}
+
+	if err := loadNames(); err != nil {
+		return err
+	}
+
+	if err := parseMetadataFlags(); err != nil {
+		return err
+	}
+
+	poolChunkUsage = make([]int32, *dedupPoolSize)
+
+	if err := parseMix(); err != nil {
+		return err
+	}
+
+	if err := applyTotalSize(); err != nil {
+		return err
+	}
+
+	if *treeDepth > 0 {
+		return setupTree()
+	}
+
+	return nil
+}
+
+// writeManifest writes --manifest, for directory output by hashing all files under --output-dir.
+func writeManifest() error {
+	if *outputFormat == outputFormatDir {
+		if err := manifest.addDir(); err != nil {
+			return err
+		}
+	}
 
-				if err := writeFile(filepath.Join(outDir, fname), i); err != nil {
-					log.Fatal(err)
+	log.Printf("writing manifest of %v files to %v", manifest.m.FileCount, *manifestFile)
+
+	return manifest.write(*manifestFile)
+}
+
+// generateDataset writes all files of the dataset to --output-dir, with --append only files
+// starting at --start-index which don't exist yet.
+func generateDataset(t0 time.Time) {
+	var skipped int32
+
+	first := 0
+	if *appendFiles {
+		first = *startIndex
+	}
+
+	err := forEachFile(*numFiles, "wrote", func(j int) error {
+		i := first + j
+		dir, name := filePath(i)
+		id := strconv.Itoa(i)
+
+		if *appendFiles {
+			if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+				atomic.AddInt32(&skipped, 1)
+				return nil
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		if *resume || *resumeVerify {
+			fname := filepath.Join(dir, name)
+
+			ok, err := isComplete(fname, id)
+			if err != nil {
+				return err
+			}
+
+			if ok {
+				atomic.AddInt32(&skipped, 1)
+
+				if err := setMetadata(fname, id); err != nil {
+					return err
 				}
 
-				if c := atomic.AddInt32(counter, 1); c%1000 == 0 && c < int32(*numFiles) {
-					log.Printf("wrote %v/%v files", c, *numFiles)
+				return createHardlinks(i)
+			}
+		}
+
+		if err := writeFileAt(dir, name, id); err != nil {
+			return err
+		}
+
+		return createHardlinks(i)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := setDirModTimes(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("wrote %v files of %v x %v to %v in %v", *numFiles-int(skipped), *fileDataRepeat, describeFileLength(), *outputDir, time.Since(t0))
+
+	if skipped > 0 {
+		log.Printf("skipped %v existing files", skipped)
+	}
+
+	if *hardlinksPerFile > 0 {
+		log.Printf("created %v hard links", *numFiles**hardlinksPerFile)
+	}
+}
+
+// forEachFile invokes fn for numbers [0,n) using --parallel workers and reports progress.
+// Each worker handles a fixed subset of numbers, after the first error workers stop and the error is returned.
+func forEachFile(n int, verb string, fn func(i int) error) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		failed   int32
+	)
+
+	p := startProgress(verb, int64(n), int64(n)*expectedContentLength())
+	defer p.stop()
+
+	for w := 0; w < *parallel; w++ {
+		wg.Add(1)
+
+		go func(w int) {
+			defer wg.Done()
+
+			for i := w; i < n && atomic.LoadInt32(&failed) == 0; i += *parallel {
+				if err := fn(i); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+
+					atomic.StoreInt32(&failed, 1)
+
+					return
 				}
+
+				p.fileDone()
 			}
-		}()
+		}(w)
 	}
 
 	wg.Wait()
-	log.Printf("wrote %v files of %v x %v bytes to %v in %v", atomic.LoadInt32(counter), *fileDataRepeat, *fileLength, *outputDir, time.Since(t0))
+
+	return firstErr
 }
 
-func writeFile(fname string, n int) error {
-	f, err := os.Create(fname)
-	if err != nil {
-		return err
+// writeFileAt creates the directory and writes file with contents identified by contentID.
+func writeFileAt(outDir, fname, contentID string) error {
+	if *windowsNames {
+		if err := checkWindowsPathLength(outDir, fname); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(outDir, 0o700); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+
+	return writeFile(filepath.Join(outDir, fname), contentID)
+}
+
+// filePath returns the directory and name of file i.
+func filePath(i int) (string, string) {
+	return filePathForKey(fmt.Sprintf("%v.%v", *seed, i), i)
+}
+
+// filePathForKey returns the directory and name of file whose name is derived from the key,
+// with --tree-depth the file is placed in the same directory as file i.
+func filePathForKey(key string, i int) (string, string) {
+	h := sha256.New()
+	io.WriteString(h, key)
+	sum := h.Sum(nil)
+	fname := hex.EncodeToString(sum)
+	outDir := targetDir(i)
+
+	if *maildirFolders > 0 {
+		return maildirPath(outDir, sum)
 	}
 
-	defer f.Close()
+	if *treeDepth > 0 {
+		return filepath.Join(outDir, treeDir(i / *filesPerDir)), styleName(sum, fname) + fileExtension(sum)
+	}
+
+	if s := *shard1; s > 0 {
+		outDir = filepath.Join(outDir, fname[0:s])
+		fname = fname[s:]
+	}
+
+	if s := *shard2; s > 0 {
+		outDir = filepath.Join(outDir, fname[0:s])
+		fname = fname[s:]
+	}
 
-	for i := 0; i < *fileDataRepeat; i++ {
-		r := hkdf.New(sha256.New, []byte(fmt.Sprintf("%v", n)), []byte(fmt.Sprintf("%v", *seed)), nil)
-		_, err = io.CopyN(f, r, int64(*fileLength))
+	if s := *shard3; s > 0 {
+		outDir = filepath.Join(outDir, fname[0:s])
+		fname = fname[s:]
 	}
 
-	return err
+	// shard directories always use hex names.
+	return outDir, styleName(sum, fname) + fileExtension(sum)
 }
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// datasetManifest describes generated dataset, contentHash identifies it exactly.
+type datasetManifest struct {
+	Seed        int64             `json:"seed"`
+	Parameters  map[string]string `json:"parameters"`
+	FileCount   int               `json:"fileCount"`
+	TotalBytes  int64             `json:"totalBytes"`
+	ShardCounts map[string]int    `json:"shardCounts"` // number of files in each top-level directory, "." for files at the top level
+	ContentHash string            `json:"contentHash"`
+	CreatedAt   time.Time         `json:"createdAt"`
+}
+
+// manifest accumulates --manifest, nil if not requested.
+var manifest *manifestBuilder
+
+// manifestBuilder accumulates manifest from files added in any order.
+type manifestBuilder struct {
+	mu   sync.Mutex
+	m    datasetManifest
+	hash [sha256.Size]byte // XOR of hashes of (path, content hash) of all files
+}
+
+func newManifestBuilder() *manifestBuilder {
+	b := &manifestBuilder{
+		m: datasetManifest{
+			Seed:        *seed,
+			Parameters:  map[string]string{},
+			ShardCounts: map[string]int{},
+		},
+	}
+
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name != "manifest" {
+			b.m.Parameters[f.Name] = f.Value.String()
+		}
+	})
+
+	return b
+}
+
+// add adds file with the given slash-separated path relative to the dataset root.
+func (b *manifestBuilder) add(rel string, size int64, contentHash []byte) {
+	h := sha256.New()
+	io.WriteString(h, rel)
+	h.Write([]byte{0})
+	h.Write(contentHash)
+	sum := h.Sum(nil)
+
+	shard, _, ok := strings.Cut(rel, "/")
+	if !ok {
+		shard = "."
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i := range b.hash {
+		b.hash[i] ^= sum[i]
+	}
+
+	b.m.FileCount++
+	b.m.TotalBytes += size
+	b.m.ShardCounts[shard]++
+}
+
+// addDir adds all files under --output-dir or --output-targets, hashing them using --parallel workers.
+func (b *manifestBuilder) addDir() error {
+	var paths []string
+
+	// manifest from the previous run may be stored in the output directory.
+	manifestAbs, _ := filepath.Abs(*manifestFile)
+
+	for _, dir := range targetDirs {
+		if err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if abs, _ := filepath.Abs(p); abs == manifestAbs {
+				return nil
+			}
+
+			if d.Type().IsRegular() {
+				paths = append(paths, p)
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+	)
+
+	for w := 0; w < *parallel; w++ {
+		wg.Add(1)
+
+		w := w
+
+		go func() {
+			defer wg.Done()
+
+			for i := w; i < len(paths); i += *parallel {
+				if err := b.addFile(paths[i]); err != nil {
+					errMu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errMu.Unlock()
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return firstErr
+}
+
+func (b *manifestBuilder) addFile(fname string) error {
+	rel, err := filepath.Rel(targetRoot(fname), fname)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+
+	b.add(path.Clean(filepath.ToSlash(rel)), n, h.Sum(nil))
+
+	return nil
+}
+
+// write writes manifest as JSON to the given file.
+func (b *manifestBuilder) write(fname string) error {
+	b.m.ContentHash = hex.EncodeToString(b.hash[:])
+	b.m.CreatedAt = time.Now().UTC()
+
+	data, err := json.MarshalIndent(b.m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(fname, append(data, '\n'), 0o600)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// mtimeBaseTime is parsed --mtime-base, zero if not set.
+	mtimeBaseTime time.Time
+
+	modes  []os.FileMode
+	owners []fileOwner
+
+	// modifiedDirs are directories whose entries were written or deleted, with --mtime-base
+	// their modification times are reset once all files are done.
+	modifiedDirsMu sync.Mutex
+	modifiedDirs   = map[string]bool{}
+)
+
+type fileOwner struct {
+	uid, gid int
+}
+
+func parseMetadataFlags() error {
+	if *mtimeBase != "" {
+		t, err := time.Parse(time.RFC3339, *mtimeBase)
+		if err != nil {
+			return fmt.Errorf("invalid --mtime-base: %w", err)
+		}
+
+		mtimeBaseTime = t
+	}
+
+	if *mtimeSpread < 0 {
+		return fmt.Errorf("--mtime-spread must not be negative")
+	}
+
+	for _, m := range splitList(*fileModes) {
+		v, err := strconv.ParseUint(m, 8, 32)
+		if err != nil || v > 0o7777 {
+			return fmt.Errorf("invalid mode %q in --file-modes", m)
+		}
+
+		modes = append(modes, os.FileMode(v&0o777)|unixModeBits(v))
+	}
+
+	for _, o := range splitList(*fileOwners) {
+		var fo fileOwner
+
+		if _, err := fmt.Sscanf(o, "%d:%d", &fo.uid, &fo.gid); err != nil {
+			return fmt.Errorf("invalid owner %q in --file-owners, must be uid:gid", o)
+		}
+
+		owners = append(owners, fo)
+	}
+
+	if len(owners) > 0 && *outputFormat == outputFormatDir && os.Geteuid() != 0 {
+		log.Printf("not running as root, ignoring --file-owners")
+
+		owners = nil
+	}
+
+	return nil
+}
+
+func splitList(s string) []string {
+	var res []string
+
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
+
+// unixModeBits converts setuid, setgid and sticky bits of a numeric mode to os.FileMode.
+func unixModeBits(v uint64) os.FileMode {
+	var m os.FileMode
+
+	if v&0o4000 != 0 {
+		m |= os.ModeSetuid
+	}
+
+	if v&0o2000 != 0 {
+		m |= os.ModeSetgid
+	}
+
+	if v&0o1000 != 0 {
+		m |= os.ModeSticky
+	}
+
+	return m
+}
+
+// metadataHash returns a hash of seed, content ID and the kind of metadata being derived from it.
+func metadataHash(kind, contentID string) uint64 {
+	h := sha256.New()
+	fmt.Fprintf(h, "%v.%v.%v", kind, *seed, contentID)
+
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+// fileMode returns the mode of file with the given content ID and whether it's set by --file-modes.
+func fileMode(contentID string) (os.FileMode, bool) {
+	if len(modes) == 0 {
+		return 0, false
+	}
+
+	return modes[metadataHash("mode", contentID)%uint64(len(modes))], true
+}
+
+// fileOwnerOf returns the owner of file with the given content ID and whether it's set by --file-owners.
+func fileOwnerOf(contentID string) (fileOwner, bool) {
+	if len(owners) == 0 {
+		return fileOwner{}, false
+	}
+
+	return owners[metadataHash("owner", contentID)%uint64(len(owners))], true
+}
+
+// fileModTime returns modification time of file with the given content ID and whether it's set by --mtime-base.
+func fileModTime(contentID string) (time.Time, bool) {
+	if mtimeBaseTime.IsZero() {
+		return time.Time{}, false
+	}
+
+	mtime := mtimeBaseTime
+
+	if *mtimeSpread > 0 {
+		mtime = mtime.Add(time.Duration(metadataHash("mtime", contentID) % uint64(*mtimeSpread)))
+	}
+
+	return mtime, true
+}
+
+// setMetadata sets mode, owner and modification time of a file written with the given content ID.
+// Since they are derived from the content ID, rewritten files get new metadata.
+func setMetadata(fname, contentID string) error {
+	dirModified(filepath.Dir(fname))
+
+	if o, ok := fileOwnerOf(contentID); ok {
+		if err := os.Chown(fname, o.uid, o.gid); err != nil {
+			return err
+		}
+	}
+
+	// chown clears setuid and setgid bits, so mode is set afterwards.
+	if m, ok := fileMode(contentID); ok {
+		if err := os.Chmod(fname, m); err != nil {
+			return err
+		}
+	}
+
+	if mtime, ok := fileModTime(contentID); ok {
+		return os.Chtimes(fname, mtime, mtime)
+	}
+
+	return nil
+}
+
+// dirModified records that entries of the directory have changed.
+func dirModified(dir string) {
+	if mtimeBaseTime.IsZero() {
+		return
+	}
+
+	modifiedDirsMu.Lock()
+	defer modifiedDirsMu.Unlock()
+
+	modifiedDirs[dir] = true
+}
+
+// setDirModTimes sets modification times of modified directories and their parents up to
+// --output-dir to --mtime-base, so that the tree doesn't depend on when and in which order
+// files were written.
+func setDirModTimes() error {
+	modifiedDirsMu.Lock()
+	defer modifiedDirsMu.Unlock()
+
+	done := map[string]bool{}
+
+	for dir := range modifiedDirs {
+		for {
+			if !done[dir] {
+				done[dir] = true
+
+				if err := os.Chtimes(dir, mtimeBaseTime, mtimeBaseTime); err != nil {
+					return err
+				}
+			}
+
+			rel, err := filepath.Rel(targetRoot(dir), dir)
+			if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+				break
+			}
+
+			dir = filepath.Dir(dir)
+		}
+	}
+
+	return nil
+}
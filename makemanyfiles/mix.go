@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// mixClass is one class of --mix, a percentage of files having the same length.
+type mixClass struct {
+	pct    float64
+	length int
+}
+
+// mixClasses is parsed --mix, nil if all files are based on --file-length.
+var mixClasses []mixClass
+
+// parseMix parses --mix, a comma-separated list of '<percent>%:<size>' classes adding up to 100 %.
+func parseMix() error {
+	if *mix == "" {
+		return nil
+	}
+
+	total := 0.0
+
+	for _, c := range strings.Split(*mix, ",") {
+		pct, size, ok := strings.Cut(c, ":")
+		if !ok {
+			return fmt.Errorf("invalid --mix class %q, must be <percent>%%:<size>", c)
+		}
+
+		p, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(pct), "%"), 64)
+		if err != nil || p <= 0 {
+			return fmt.Errorf("invalid percentage of --mix class %q", c)
+		}
+
+		l, err := parseSize(strings.TrimSpace(size))
+		if err != nil || l <= 0 {
+			return fmt.Errorf("invalid size of --mix class %q", c)
+		}
+
+		mixClasses = append(mixClasses, mixClass{p, int(l)})
+		total += p
+	}
+
+	if math.Abs(total-100) > 0.001 {
+		return fmt.Errorf("percentages of --mix add up to %v, not 100", total)
+	}
+
+	return nil
+}
+
+// baseFileLength returns the length of the file with the given content ID before --size-sigma
+// is applied, which with --mix depends on the class the file is assigned to.
+func baseFileLength(contentID string) int {
+	if mixClasses == nil {
+		return *fileLength
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "mix.%v.%v", *seed, contentID)
+
+	v := 100 * float64(binary.BigEndian.Uint64(h.Sum(nil))>>11) / (1 << 53)
+
+	for _, c := range mixClasses {
+		if v < c.pct {
+			return c.length
+		}
+
+		v -= c.pct
+	}
+
+	return mixClasses[len(mixClasses)-1].length
+}
+
+// meanBaseFileLength returns the average of baseFileLength.
+func meanBaseFileLength() int64 {
+	if mixClasses == nil {
+		return int64(*fileLength)
+	}
+
+	var sum float64
+
+	for _, c := range mixClasses {
+		sum += c.pct / 100 * float64(c.length)
+	}
+
+	return int64(sum)
+}
+
+// describeFileLength describes lengths of files for log messages.
+func describeFileLength() string {
+	if mixClasses != nil {
+		return fmt.Sprintf("mix %v", *mix)
+	}
+
+	return fmt.Sprintf("%v bytes", *fileLength)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// mutateDataset deletes, rewrites, renames and adds files of a dataset previously generated with
+// the same flags, and renames its directories. Which files are affected only depends on --seed and
+// --mutation-seed, so the same mutation of the same dataset always produces identical results.
+// Files added or moved by earlier mutations are not affected.
+func mutateDataset() {
+	if *numFiles <= 0 {
+		log.Fatal("--mutate requires --num-files of the dataset")
+	}
+
+	if *changePct < 0 || *deletePct < 0 || *addPct < 0 || *renamePct < 0 || *changePct+*deletePct+*renamePct > 100 {
+		log.Fatal("invalid --change-pct, --delete-pct or --rename-pct")
+	}
+
+	if *renameDirPct < 0 || *renameDirPct > 100 {
+		log.Fatal("invalid --rename-dir-pct")
+	}
+
+	t0 := time.Now()
+
+	// directories are determined before files are moved out of them.
+	dirs := datasetDirs()
+
+	var changed, deleted, renamed int32
+
+	err := forEachFile(*numFiles, "mutated", func(i int) error {
+		dir, name := filePath(i)
+		fname := filepath.Join(dir, name)
+
+		v := mutationValue(i)
+
+		switch {
+		case v < *deletePct:
+			if err := os.Remove(fname); err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+
+				return err
+			}
+
+			atomic.AddInt32(&deleted, 1)
+
+			dirModified(dir)
+
+		case v < *deletePct+*changePct:
+			if _, err := os.Stat(fname); err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+
+				return err
+			}
+
+			if err := writeFile(fname, fmt.Sprintf("%v.m%v", i, *mutationSeed)); err != nil {
+				return err
+			}
+
+			atomic.AddInt32(&changed, 1)
+
+		case v < *deletePct+*changePct+*renamePct:
+			newDir, newName := renamedFilePath(i)
+
+			if err := os.MkdirAll(newDir, 0o700); err != nil {
+				return fmt.Errorf("error creating directory: %w", err)
+			}
+
+			if err := os.Rename(fname, filepath.Join(newDir, newName)); err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+
+				return err
+			}
+
+			atomic.AddInt32(&renamed, 1)
+
+			dirModified(dir)
+			dirModified(newDir)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	numAdded := int(float64(*numFiles) * *addPct / 100)
+
+	err = forEachFile(numAdded, "added", func(k int) error {
+		dir, name := filePathForKey(fmt.Sprintf("%v.m%v.%v", *seed, *mutationSeed, k), k%*numFiles)
+		return writeFileAt(dir, name, fmt.Sprintf("m%v.%v", *mutationSeed, k))
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	renamedDirs, err := renameDirs(dirs)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := setDirModTimes(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("mutated %v: changed %v, deleted %v, renamed %v and added %v files, renamed %v directories in %v",
+		*outputDir, changed, deleted, renamed, numAdded, renamedDirs, time.Since(t0))
+}
+
+// renamedFilePath returns the new location of file i moved by the mutation, which with --tree-depth
+// is the directory of another file in the same output target.
+func renamedFilePath(i int) (string, string) {
+	key := fmt.Sprintf("%v.r%v.%v", *seed, *mutationSeed, i)
+
+	j := int(mutationHash("move", key) % uint64(*numFiles))
+	if n := len(targetCycle); n > 1 {
+		if j = j - j%n + i%n; j >= *numFiles {
+			j = i
+		}
+	}
+
+	return filePathForKey(key, j)
+}
+
+// datasetDirs returns directories containing files of the dataset and their parents below output
+// directories, deepest first.
+func datasetDirs() []string {
+	seen := map[string]bool{}
+
+	for i := 0; i < *numFiles; i++ {
+		dir, _ := filePath(i)
+
+		for !seen[dir] {
+			rel, err := filepath.Rel(targetRoot(dir), dir)
+			if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+				break
+			}
+
+			seen[dir] = true
+			dir = filepath.Dir(dir)
+		}
+	}
+
+	var dirs []string
+	for d := range seen {
+		dirs = append(dirs, d)
+	}
+
+	sort.Slice(dirs, func(i, j int) bool {
+		di, dj := strings.Count(dirs[i], string(filepath.Separator)), strings.Count(dirs[j], string(filepath.Separator))
+		if di != dj {
+			return di > dj
+		}
+
+		return dirs[i] < dirs[j]
+	})
+
+	return dirs
+}
+
+// renameDirs renames --rename-dir-pct of directories within their parents. Directories are renamed
+// deepest first, so paths of the remaining ones stay valid.
+func renameDirs(dirs []string) (int, error) {
+	if *renameDirPct <= 0 {
+		return 0, nil
+	}
+
+	renamed := 0
+
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(targetRoot(dir), dir)
+		if err != nil {
+			return renamed, err
+		}
+
+		key := fmt.Sprintf("%v.r%v.%v", *seed, *mutationSeed, filepath.ToSlash(rel))
+
+		if 100*float64(mutationHash("rename-dir", key)>>11)/(1<<53) >= *renameDirPct {
+			continue
+		}
+
+		h := sha256.Sum256([]byte(key))
+		newDir := filepath.Join(filepath.Dir(dir), styleName(h[:], hex.EncodeToString(h[:])[0:8]))
+
+		if err := os.Rename(dir, newDir); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return renamed, err
+		}
+
+		renamed++
+
+		dirModified(filepath.Dir(dir))
+	}
+
+	return renamed, nil
+}
+
+// mutationHash returns a hash of the key for the given purpose.
+func mutationHash(purpose, key string) uint64 {
+	h := sha256.New()
+	fmt.Fprintf(h, "%v.%v", purpose, key)
+
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+// mutationValue returns a number in [0,100) which determines what happens to file i.
+func mutationValue(i int) float64 {
+	h := sha256.New()
+	fmt.Fprintf(h, "mutate.%v.%v.%v", *seed, *mutationSeed, i)
+
+	return 100 * float64(binary.BigEndian.Uint64(h.Sum(nil))>>11) / (1 << 53)
+}
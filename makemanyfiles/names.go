@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	nameStyleHex             = "hex"
+	nameStyleUnicodeMixed    = "unicode-mixed"
+	nameStyleVeryLong        = "very-long"
+	nameStyleSpacesAndQuotes = "spaces-and-quotes"
+)
+
+// unicodeFragments are pieces of unicode-mixed names covering multiple scripts, precomposed
+// and decomposed forms of the same characters, right-to-left text, ligatures and emoji.
+var unicodeFragments = []string{
+	"a", "Z", "\u00e9", "e\u0301", "ß", "\u00c5", "A\u030a", "ø", "ı", "İ", "ﬁ", "№",
+	"Ω", "λ", "Ж", "я", "中", "文", "日本", "한", "ع", "ש", "ก", "ह",
+	"🙂", "👍🏽", "🇵🇱", "∑", "½", " ", "ǅ", "ŉ",
+}
+
+// trickyWords are pieces of spaces-and-quotes names which commonly break quoting in scripts
+// and command lines.
+var trickyWords = []string{
+	"it's", `"quoted"`, "`tick`", "$HOME", "a & b", "(copy)", "semi;colon", "bang!",
+	"#hash", "100%", `back\slash`, "-dash", " leading", "trailing ", "two  spaces", "*star?",
+}
+
+// windowsReservedNames are device names which can't be used as file names on Windows,
+// even with an extension.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// maxWindowsNameLength is the maximum length of a path component on NTFS in UTF-16 code units.
+const maxWindowsNameLength = 255
+
+func validateNameStyle() error {
+	switch *nameStyle {
+	case nameStyleHex, nameStyleUnicodeMixed, nameStyleVeryLong, nameStyleSpacesAndQuotes:
+		return nil
+	default:
+		return fmt.Errorf("unsupported --name-style %q", *nameStyle)
+	}
+}
+
+// styleName returns a file or directory name in --name-style (or from --names word list) derived from hash h, hexName
+// is the name used by the hex style. All styles keep a part of hexName so that names are unique.
+func styleName(h []byte, hexName string) string {
+	n := styleNameUnsafe(h, hexName)
+
+	if *windowsNames {
+		return windowsSafeName(n)
+	}
+
+	return n
+}
+
+func styleNameUnsafe(h []byte, hexName string) string {
+	if wordlist != nil {
+		return wordlistName(h, hexName)
+	}
+
+	switch *nameStyle {
+	case nameStyleUnicodeMixed:
+		var sb strings.Builder
+
+		for _, b := range h[0:12] {
+			sb.WriteString(unicodeFragments[int(b)%len(unicodeFragments)])
+		}
+
+		return sb.String() + "-" + shortHex(hexName)
+
+	case nameStyleVeryLong:
+		// between 200 and 255 bytes, the limit of most filesystems.
+		n := 200 + int(h[0])%56
+
+		return strings.Repeat(hexName, n/len(hexName)+1)[0:n]
+
+	case nameStyleSpacesAndQuotes:
+		var words []string
+
+		for _, b := range h[0:4] {
+			words = append(words, trickyWords[int(b)%len(trickyWords)])
+		}
+
+		return strings.Join(words, " ") + " " + shortHex(hexName)
+
+	default:
+		return hexName
+	}
+}
+
+func shortHex(hexName string) string {
+	if len(hexName) > 16 {
+		return hexName[0:16]
+	}
+
+	return hexName
+}
+
+// windowsSafeName replaces characters not allowed in file names on Windows, trailing spaces and dots
+// with '_' and avoids reserved device names.
+func windowsSafeName(n string) string {
+	var sb strings.Builder
+
+	for _, r := range n {
+		if r < 32 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			r = '_'
+		}
+
+		sb.WriteRune(r)
+	}
+
+	n = sb.String()
+
+	for utf16Length(n) > maxWindowsNameLength {
+		_, size := utf8.DecodeLastRuneInString(n)
+		n = n[0 : len(n)-size]
+	}
+
+	if trimmed := strings.TrimRight(n, " ."); len(trimmed) < len(n) {
+		n = trimmed + strings.Repeat("_", len(n)-len(trimmed))
+	}
+
+	base, _, _ := strings.Cut(n, ".")
+	if windowsReservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+		n = "_" + n
+	}
+
+	return n
+}
+
+// checkWindowsPathLength verifies that path of a file relative to --output-dir does not exceed
+// --max-path-length UTF-16 code units.
+func checkWindowsPathLength(dir, name string) error {
+	rel, err := filepath.Rel(targetRoot(dir), filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+
+	if l := utf16Length(rel); l > *maxPathLength {
+		return fmt.Errorf("relative path %q is %v characters long, which exceeds --max-path-length=%v", rel, l, *maxPathLength)
+	}
+
+	return nil
+}
+
+func utf16Length(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// profiles are named presets of flags modeled on real-world datasets. Flags passed explicitly
+// on the command line take precedence over the profile.
+var profiles = map[string]map[string]string{
+	// many small, compressible files in a moderately deep tree with a little duplication
+	// (vendored and generated code).
+	"source-tree": {
+		"tree-depth":       "4",
+		"dirs-per-dir":     "5",
+		"files-per-dir":    "12",
+		"file-length":      "4096",
+		"size-sigma":       "1.2",
+		"max-file-length":  "1048576",
+		"file-data-repeat": "4",
+		"dedup-ratio":      "0.05",
+		"chunk-size":       "4096",
+		"dedup-pool-size":  "200",
+		"file-modes":       "644,644,644,755",
+	},
+
+	// incompressible files of a few megabytes organized by year and month.
+	"photos": {
+		"tree-depth":      "2",
+		"dirs-per-dir":    "12",
+		"files-per-dir":   "60",
+		"file-length":     "3145728",
+		"size-sigma":      "0.4",
+		"max-file-length": "31457280",
+		"mtime-base":      "2010-01-01T00:00:00Z",
+		"mtime-spread":    "105120h",
+	},
+
+	// a handful of large disk images sharing much of their contents (same base OS).
+	"vm-images": {
+		"num-files":       "8",
+		"file-length":     "1073741824",
+		"size-sigma":      "0.5",
+		"max-file-length": "8589934592",
+		"dedup-ratio":     "0.4",
+		"chunk-size":      "4194304",
+		"dedup-pool-size": "256",
+	},
+
+	// folders with thousands of small, highly compressible messages.
+	"maildir": {
+		"tree-depth":       "1",
+		"dirs-per-dir":     "20",
+		"files-per-dir":    "2000",
+		"file-length":      "2048",
+		"size-sigma":       "1",
+		"max-file-length":  "8160",
+		"file-data-repeat": "4",
+		"file-modes":       "600",
+	},
+
+	// mail server storing millions of messages in maildir folders with realistic headers.
+	"mail-server": {
+		"num-files":       "1000000",
+		"maildir-folders": "200",
+		"content":         "mail",
+		"file-length":     "4096",
+		"size-sigma":      "1.2",
+		"max-file-length": "10485760",
+		"file-modes":      "600",
+		"mtime-base":      "2018-01-01T00:00:00Z",
+		"mtime-spread":    "35040h",
+	},
+
+	// documents and spreadsheets of varied sizes with occasional copies and versions.
+	"mixed-office": {
+		"tree-depth":       "3",
+		"dirs-per-dir":     "5",
+		"files-per-dir":    "30",
+		"file-length":      "102400",
+		"size-sigma":       "1.5",
+		"max-file-length":  "104857600",
+		"file-data-repeat": "2",
+		"dedup-ratio":      "0.15",
+		"chunk-size":       "65536",
+		"dedup-pool-size":  "500",
+		"name-style":       "spaces-and-quotes",
+	},
+}
+
+// applyProfile sets flags from --profile which have not been passed explicitly.
+func applyProfile() error {
+	if *profile == "" {
+		return nil
+	}
+
+	values, ok := profiles[*profile]
+	if !ok {
+		var names []string
+
+		for n := range profiles {
+			names = append(names, n)
+		}
+
+		sort.Strings(names)
+
+		return fmt.Errorf("unknown --profile %q, must be one of: %v", *profile, strings.Join(names, ", "))
+	}
+
+	explicit := map[string]bool{}
+
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for name, value := range values {
+		if explicit[name] {
+			continue
+		}
+
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("invalid profile value for --%v: %w", name, err)
+		}
+	}
+
+	return nil
+}
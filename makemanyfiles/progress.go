@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// bytesWritten is the number of bytes of file contents written so far.
+var bytesWritten = new(int64)
+
+// progress periodically logs the number of processed files, rates and estimated time remaining.
+type progress struct {
+	verb       string
+	totalFiles int64 // zero if progress is measured in bytes
+	totalBytes int64
+	files      int64
+	bytes0     int64
+	start      time.Time
+	done       chan struct{}
+}
+
+// startProgress starts reporting progress every --progress-interval unless --quiet is set.
+// If totalFiles is zero, completion is computed from bytes written instead of files.
+func startProgress(verb string, totalFiles, totalBytes int64) *progress {
+	p := &progress{
+		verb:       verb,
+		totalFiles: totalFiles,
+		totalBytes: totalBytes,
+		bytes0:     atomic.LoadInt64(bytesWritten),
+		start:      time.Now(),
+		done:       make(chan struct{}),
+	}
+
+	if !*quiet && *progressInterval > 0 {
+		go p.run()
+	}
+
+	return p
+}
+
+func (p *progress) run() {
+	t := time.NewTicker(*progressInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-t.C:
+			log.Print(p.String())
+		}
+	}
+}
+
+// fileDone records that a file has been processed.
+func (p *progress) fileDone() {
+	atomic.AddInt64(&p.files, 1)
+}
+
+func (p *progress) stop() {
+	close(p.done)
+}
+
+func (p *progress) String() string {
+	elapsed := time.Since(p.start).Seconds()
+	files := atomic.LoadInt64(&p.files)
+	bytes := atomic.LoadInt64(bytesWritten) - p.bytes0
+
+	var done float64
+
+	switch {
+	case p.totalFiles > 0:
+		done = float64(files) / float64(p.totalFiles)
+	case p.totalBytes > 0:
+		done = float64(bytes) / float64(p.totalBytes)
+	}
+
+	eta := "unknown"
+	if done > 0 {
+		eta = time.Duration(elapsed * (1 - done) / done * float64(time.Second)).Round(time.Second).String()
+	}
+
+	what := fmt.Sprintf("%v/%v files (%.1f %%), %.0f files/s", files, p.totalFiles, 100*done, float64(files)/elapsed)
+	if p.totalFiles == 0 {
+		what = fmt.Sprintf("%v/%v bytes (%.1f %%)", bytes, p.totalBytes, 100*done)
+	}
+
+	return fmt.Sprintf("%v %v, %.1f MB/s, ETA %v", p.verb, what, float64(bytes)/elapsed/1e6, eta)
+}
+
+type countingWriter struct {
+	w io.Writer
+}
+
+func (c countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	atomic.AddInt64(bytesWritten, int64(n))
+
+	return n, err
+}
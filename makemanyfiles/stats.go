@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// statsSampleSize is the number of bytes read from the beginning of each file to estimate compressibility.
+const statsSampleSize = 64 << 10
+
+// sizeBuckets are upper bounds of buckets of the file size histogram.
+var sizeBuckets = []int64{0, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20, 256 << 20, 1 << 30}
+
+type datasetStats struct {
+	files, dirs, symlinks int
+	totalSize             int64
+	sizeHistogram         []int // number of files in each of sizeBuckets and one more for larger files
+	depthHistogram        map[int]int
+	sampledBytes          int64
+	compressedBytes       int64
+}
+
+// printStats scans --output-dir (which may be any existing directory) and prints its statistics.
+func printStats() error {
+	st := &datasetStats{
+		sizeHistogram:  make([]int, len(sizeBuckets)+1),
+		depthHistogram: map[int]int{},
+	}
+
+	var paths []string
+
+	for _, root := range targetDirs {
+		if err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+
+			if rel == "." {
+				return nil
+			}
+
+			switch {
+			case d.IsDir():
+				st.dirs++
+
+			case d.Type()&fs.ModeSymlink != 0:
+				st.symlinks++
+
+			case d.Type().IsRegular():
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+
+				st.addFile(info.Size(), strings.Count(filepath.ToSlash(rel), "/"))
+				paths = append(paths, p)
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := st.sampleCompressibility(paths); err != nil {
+		return err
+	}
+
+	st.print(os.Stdout)
+
+	return nil
+}
+
+func (st *datasetStats) addFile(size int64, depth int) {
+	st.files++
+	st.totalSize += size
+	st.depthHistogram[depth]++
+
+	b := sort.Search(len(sizeBuckets), func(i int) bool { return size <= sizeBuckets[i] })
+	st.sizeHistogram[b]++
+}
+
+// sampleCompressibility compresses the beginning of each file using --parallel workers.
+func (st *datasetStats) sampleCompressibility(paths []string) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	for w := 0; w < *parallel; w++ {
+		wg.Add(1)
+
+		go func(w int) {
+			defer wg.Done()
+
+			var buf bytes.Buffer
+
+			zw, _ := flate.NewWriter(&buf, flate.BestSpeed)
+
+			for i := w; i < len(paths); i += *parallel {
+				sampled, compressed, err := compressSample(paths[i], &buf, zw)
+
+				mu.Lock()
+				st.sampledBytes += sampled
+				st.compressedBytes += compressed
+
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(w)
+	}
+
+	wg.Wait()
+
+	return firstErr
+}
+
+func compressSample(fname string, buf *bytes.Buffer, zw *flate.Writer) (sampled, compressed int64, err error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer f.Close()
+
+	buf.Reset()
+	zw.Reset(buf)
+
+	n, err := io.Copy(zw, io.LimitReader(f, statsSampleSize))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return 0, 0, err
+	}
+
+	return n, int64(buf.Len()), nil
+}
+
+func (st *datasetStats) print(w io.Writer) {
+	fmt.Fprintf(w, "directory:   %v\n", *outputDir)
+	fmt.Fprintf(w, "files:       %v\n", st.files)
+	fmt.Fprintf(w, "directories: %v\n", st.dirs)
+	fmt.Fprintf(w, "symlinks:    %v\n", st.symlinks)
+	fmt.Fprintf(w, "total size:  %v bytes\n", st.totalSize)
+
+	if st.files > 0 {
+		fmt.Fprintf(w, "avg size:    %v bytes\n", st.totalSize/int64(st.files))
+	}
+
+	if st.sampledBytes > 0 {
+		fmt.Fprintf(w, "compressibility: %.1f %% saved (deflate of first %v KiB of each file)\n",
+			100*(1-float64(st.compressedBytes)/float64(st.sampledBytes)), statsSampleSize>>10)
+	}
+
+	fmt.Fprintf(w, "\nsize histogram:\n")
+
+	for i, c := range st.sizeHistogram {
+		if c == 0 {
+			continue
+		}
+
+		label := "larger"
+		if i < len(sizeBuckets) {
+			label = "<= " + formatSize(sizeBuckets[i])
+		}
+
+		fmt.Fprintf(w, "  %-10v %10v %6.1f %%\n", label, c, 100*float64(c)/float64(st.files))
+	}
+
+	fmt.Fprintf(w, "\ndepth histogram:\n")
+
+	var depths []int
+	for d := range st.depthHistogram {
+		depths = append(depths, d)
+	}
+
+	sort.Ints(depths)
+
+	for _, d := range depths {
+		c := st.depthHistogram[d]
+		fmt.Fprintf(w, "  %-10v %10v %6.1f %%\n", d, c, 100*float64(c)/float64(st.files))
+	}
+}
+
+// formatSize formats size using the largest K, M or G suffix which represents it exactly.
+func formatSize(v int64) string {
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}} {
+		if v >= u.mult && v%u.mult == 0 {
+			return fmt.Sprintf("%v%v", v/u.mult, u.suffix)
+		}
+	}
+
+	return fmt.Sprintf("%v", v)
+}
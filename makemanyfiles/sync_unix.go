@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "syscall"
+
+// syncAll flushes all filesystem buffers.
+func syncAll() error {
+	syscall.Sync()
+
+	return nil
+}
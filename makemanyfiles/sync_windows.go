@@ -0,0 +1,8 @@
+package main
+
+import "errors"
+
+// syncAll is not available on Windows, use --fsync=per-file instead.
+func syncAll() error {
+	return errors.New("--fsync=per-1000 is not supported on Windows")
+}
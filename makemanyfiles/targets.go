@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// targetCycle lists output directories in the order in which consecutive files are assigned to
+// them, each directory repeated according to its weight.
+var targetCycle []string
+
+// targetDirs are distinct output directories.
+var targetDirs []string
+
+// parseTargets parses --output-targets, a comma-separated list of directories with optional
+// ':<weight>' suffix. Without it all files are written to --output-dir.
+func parseTargets() error {
+	if *outputTargets == "" {
+		targetDirs = []string{*outputDir}
+		targetCycle = targetDirs
+
+		return nil
+	}
+
+	if *outputDir != "" {
+		return errors.New("--output-targets can't be combined with --output-dir")
+	}
+
+	for _, t := range strings.Split(*outputTargets, ",") {
+		dir, weight := t, 1
+
+		if p := strings.LastIndex(t, ":"); p > 0 {
+			if w, err := strconv.Atoi(t[p+1:]); err == nil {
+				if w <= 0 {
+					return fmt.Errorf("invalid weight of target %q", t)
+				}
+
+				dir, weight = t[0:p], w
+			}
+		}
+
+		if dir == "" {
+			return fmt.Errorf("invalid target %q", t)
+		}
+
+		targetDirs = append(targetDirs, dir)
+
+		for i := 0; i < weight; i++ {
+			targetCycle = append(targetCycle, dir)
+		}
+	}
+
+	// only used in messages from now on.
+	*outputDir = strings.Join(targetDirs, ",")
+
+	return nil
+}
+
+// targetDir returns the output directory of file i, consecutive files are assigned to targets
+// in weighted round-robin fashion so that parallel workers write to all of them.
+func targetDir(i int) string {
+	return targetCycle[i%len(targetCycle)]
+}
+
+// targetRoot returns the output directory which contains the path.
+func targetRoot(p string) string {
+	for _, dir := range targetDirs {
+		if rel, err := filepath.Rel(dir, p); err == nil && !strings.HasPrefix(rel, "..") {
+			return dir
+		}
+	}
+
+	return targetDirs[0]
+}
+
+// createTargets creates output directories which don't exist yet.
+func createTargets() error {
+	for _, dir := range targetDirs {
+		if err := os.Mkdir(dir, 0o700); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	contentBinary = "binary"
+	contentText   = "text"
+
+	textStyleLog    = "log"
+	textStyleSource = "source"
+
+	// textStyleMail produces e-mail messages with --content=mail.
+	textStyleMail = "mail"
+)
+
+func validateContent() error {
+	switch *contentType {
+	case contentBinary, contentMail:
+		return nil
+	case contentText:
+	default:
+		return fmt.Errorf("unsupported --content %q", *contentType)
+	}
+
+	switch *textStyle {
+	case textStyleLog, textStyleSource:
+		return nil
+	default:
+		return fmt.Errorf("unsupported --text-style %q", *textStyle)
+	}
+}
+
+// contentStream returns an unbounded stream of file contents derived from the secret and seed,
+// pseudo-random bytes, with --content=text lines of ASCII text or with --content=mail e-mail messages.
+func contentStream(secret string) (io.Reader, error) {
+	r, err := keyStream(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch *contentType {
+	case contentText:
+		return newTextReader(r, *textStyle), nil
+	case contentMail:
+		return newTextReader(r, textStyleMail), nil
+	}
+
+	return r, nil
+}
+
+var (
+	textWords = strings.Fields(`the of and to in is for on with as by at from that this be are was not
+		request response server client user session cache index block blob content snapshot
+		manifest policy repository upload download retry timeout error warning connection
+		started finished completed failed pending queued received sent opened closed
+		read write flush sync compact rewrite verify delete create update load store`)
+	textLevels     = []string{"DEBUG", "INFO", "INFO", "INFO", "WARN", "ERROR"}
+	textComponents = []string{"api", "auth", "cache", "db", "http", "indexer", "scheduler", "storage", "uploader", "worker"}
+	textKeys       = []string{"id", "user", "duration", "bytes", "count", "attempt", "status", "path"}
+	textKeywords   = []string{"if", "for", "return", "var", "func", "switch", "case", "defer", "go", "else"}
+	textTypes      = []string{"int", "string", "error", "bool", "[]byte", "int64", "*Context", "map[string]int"}
+	textOperators  = []string{":=", "=", "==", "!=", "+=", "<", ">=", "&&", "||"}
+
+	// textEpoch is the time of the first log line, later lines advance it by random intervals.
+	textEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// textReader turns a pseudo-random stream into line-oriented ASCII text which compresses and
+// splits like real log files or source code.
+type textReader struct {
+	src    io.Reader
+	style  string
+	rnd    [4096]byte
+	rndPos int
+	line   []byte
+	pos    int
+	now    time.Time
+	indent int
+
+	// mail headers have been written.
+	headerDone bool
+}
+
+func newTextReader(src io.Reader, style string) *textReader {
+	return &textReader{src: src, style: style, rndPos: 4096, now: textEpoch}
+}
+
+func (t *textReader) Read(b []byte) (int, error) {
+	n := 0
+
+	for n < len(b) {
+		if t.pos == len(t.line) {
+			if err := t.nextLine(); err != nil {
+				return n, err
+			}
+		}
+
+		c := copy(b[n:], t.line[t.pos:])
+		t.pos += c
+		n += c
+	}
+
+	return n, nil
+}
+
+// next returns a pseudo-random value in [0,n).
+func (t *textReader) next(n int) (int, error) {
+	if t.rndPos+8 > len(t.rnd) {
+		if _, err := io.ReadFull(t.src, t.rnd[:]); err != nil {
+			return 0, err
+		}
+
+		t.rndPos = 0
+	}
+
+	v := binary.LittleEndian.Uint64(t.rnd[t.rndPos:])
+	t.rndPos += 8
+
+	return int(v % uint64(n)), nil
+}
+
+// pick returns a pseudo-random element of the list, errors of the source stream are reported by nextLine.
+func (t *textReader) pick(list []string) string {
+	i, _ := t.next(len(list))
+	return list[i]
+}
+
+func (t *textReader) words(min, max int) string {
+	n, _ := t.next(max - min + 1)
+
+	w := make([]string, min+n)
+	for i := range w {
+		w[i] = t.pick(textWords)
+	}
+
+	return strings.Join(w, " ")
+}
+
+func (t *textReader) nextLine() error {
+	// make sure the source has enough data for the whole line so that errors are not lost.
+	if _, err := t.next(1); err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+
+	switch {
+	case t.style == textStyleSource:
+		t.sourceLine(&sb)
+	case t.style == textStyleMail && !t.headerDone:
+		t.mailHeader(&sb)
+		t.headerDone = true
+	case t.style == textStyleMail:
+		t.mailLine(&sb)
+	default:
+		t.logLine(&sb)
+	}
+
+	t.line = []byte(sb.String())
+	t.pos = 0
+
+	return nil
+}
+
+func (t *textReader) logLine(sb *strings.Builder) {
+	ms, _ := t.next(2000)
+	t.now = t.now.Add(time.Duration(ms) * time.Millisecond)
+
+	fmt.Fprintf(sb, "%v %-5v [%v] %v", t.now.Format("2006-01-02T15:04:05.000Z"), t.pick(textLevels), t.pick(textComponents), t.words(2, 9))
+
+	kv, _ := t.next(4)
+	for i := 0; i < kv; i++ {
+		v, _ := t.next(100000)
+		fmt.Fprintf(sb, " %v=%v", t.pick(textKeys), v)
+	}
+
+	sb.WriteByte('\n')
+}
+
+func (t *textReader) sourceLine(sb *strings.Builder) {
+	kind, _ := t.next(10)
+
+	if kind == 0 && t.indent > 0 {
+		t.indent--
+		sb.WriteString(strings.Repeat("\t", t.indent) + "}\n")
+
+		return
+	}
+
+	sb.WriteString(strings.Repeat("\t", t.indent))
+
+	switch {
+	case kind == 1:
+		sb.WriteString("// " + t.words(3, 10) + "\n")
+
+	case kind == 2 && t.indent < 5:
+		fmt.Fprintf(sb, "%v %v %v %v {\n", t.pick(textKeywords), t.pick(textWords), t.pick(textOperators), t.pick(textWords))
+		t.indent++
+
+	case kind == 3:
+		sb.WriteByte('\n')
+
+	case kind == 4:
+		fmt.Fprintf(sb, "var %v %v\n", t.pick(textWords), t.pick(textTypes))
+
+	default:
+		v, _ := t.next(1000)
+		fmt.Fprintf(sb, "%v %v %v(%v, %v)\n", t.pick(textWords), t.pick(textOperators), t.pick(textWords), t.pick(textWords), v)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// writeLimiter limits the total rate of writes of all workers, nil if --max-write-mbps is not set.
+var writeLimiter *rateLimiter
+
+type rateLimiter struct {
+	mu          sync.Mutex
+	bytesPerSec float64
+	next        time.Time // time at which the next write may start
+}
+
+// wait blocks until n bytes can be written without exceeding the rate.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+
+	start := l.next
+	l.next = l.next.Add(time.Duration(float64(n) / l.bytesPerSec * float64(time.Second)))
+
+	l.mu.Unlock()
+
+	time.Sleep(time.Until(start))
+}
+
+type throttledWriter struct {
+	w io.Writer
+}
+
+func (t throttledWriter) Write(b []byte) (int, error) {
+	writeLimiter.wait(len(b))
+
+	return t.w.Write(b)
+}
+
+// throttle returns a writer limited by --max-write-mbps which counts bytes written for progress reporting.
+func throttle(w io.Writer) io.Writer {
+	if writeLimiter == nil {
+		return countingWriter{w}
+	}
+
+	return countingWriter{throttledWriter{w}}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+)
+
+// applyTotalSize sets --num-files so that the total size of files is as close as possible to
+// --total-size. With --size-sigma or --mix lengths of individual files are summed, which is exact since
+// they only depend on the seed and file number.
+func applyTotalSize() error {
+	if *totalSize == "" {
+		return nil
+	}
+
+	target, err := parseSize(*totalSize)
+	if err != nil {
+		return fmt.Errorf("invalid --total-size: %w", err)
+	}
+
+	explicit := false
+
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "num-files" {
+			explicit = true
+		}
+	})
+
+	if explicit {
+		return errors.New("--total-size can't be combined with --num-files")
+	}
+
+	if expectedContentLength() <= 0 {
+		return errors.New("--total-size requires positive --file-length or --mix")
+	}
+
+	var n int
+	var total int64
+
+	if *sizeSigma <= 0 && mixClasses == nil {
+		n = int(math.Round(float64(target) / float64(expectedContentLength())))
+		total = int64(n) * expectedContentLength()
+	} else {
+		for total < target {
+			l := contentLength(strconv.Itoa(n))
+
+			// stop before the file which would overshoot more than stopping short.
+			if total+l-target > target-total {
+				break
+			}
+
+			total += l
+			n++
+		}
+	}
+
+	if n == 0 {
+		return fmt.Errorf("--total-size=%v is smaller than a single file", *totalSize)
+	}
+
+	if diff := math.Abs(float64(total-target)) / float64(target) * 100; diff > *totalSizeTol {
+		return fmt.Errorf("%v files total %v bytes, %.1f %% off --total-size=%v, use smaller --file-length or larger --total-size-tolerance", n, total, diff, *totalSize)
+	}
+
+	*numFiles = n
+
+	log.Printf("generating %v files totaling %v bytes for --total-size=%v", n, total, *totalSize)
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestApplyTotalSize(t *testing.T) {
+	cases := []struct {
+		name           string
+		args           []string
+		wantNumFiles   int    // 0 to only check the total size
+		wantFileLength int    // 0 to not check
+		wantErr        string // substring of the error
+	}{
+		{
+			name:         "fixed length",
+			args:         []string{"--file-length=1000", "--total-size=1M"},
+			wantNumFiles: 1049,
+		},
+		{
+			name:         "repeated data",
+			args:         []string{"--file-length=1000", "--file-data-repeat=4", "--total-size=4M"},
+			wantNumFiles: 1049,
+		},
+		{
+			name: "size sigma",
+			args: []string{"--file-length=4096", "--size-sigma=1", "--total-size=10M"},
+		},
+		{
+			name: "mix",
+			args: []string{"--mix=90%:4k,10%:64k", "--total-size=10M"},
+		},
+		{
+			name:           "profile file length",
+			args:           []string{"--profile=photos", "--total-size=1G"},
+			wantFileLength: 3145728,
+		},
+		{
+			name:           "explicit flags take precedence over profile",
+			args:           []string{"--profile=photos", "--file-length=100000", "--size-sigma=0", "--total-size=10M"},
+			wantNumFiles:   105,
+			wantFileLength: 100000,
+		},
+		{
+			name:    "explicit num-files",
+			args:    []string{"--num-files=3", "--file-length=1000", "--total-size=1M"},
+			wantErr: "can't be combined with --num-files",
+		},
+		{
+			name:    "missing file length",
+			args:    []string{"--total-size=1M"},
+			wantErr: "requires positive --file-length or --mix",
+		},
+		{
+			name:    "smaller than a file",
+			args:    []string{"--file-length=1000000", "--total-size=1K"},
+			wantErr: "smaller than a single file",
+		},
+		{
+			name:    "outside tolerance",
+			args:    []string{"--file-length=300000", "--total-size=1M"},
+			wantErr: "off --total-size",
+		},
+		{
+			name:    "invalid size",
+			args:    []string{"--file-length=1000", "--total-size=1X"},
+			wantErr: "invalid --total-size",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setFlags(t, tc.args...)
+
+			if err := applyProfile(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := parseMix(); err != nil {
+				t.Fatal(err)
+			}
+
+			err := applyTotalSize()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("applyTotalSize() = %v, want error containing %q", err, tc.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("applyTotalSize() failed: %v", err)
+			}
+
+			if tc.wantNumFiles != 0 && *numFiles != tc.wantNumFiles {
+				t.Errorf("--num-files = %v, want %v", *numFiles, tc.wantNumFiles)
+			}
+
+			if tc.wantFileLength != 0 && *fileLength != tc.wantFileLength {
+				t.Errorf("--file-length = %v, want %v", *fileLength, tc.wantFileLength)
+			}
+
+			target, _ := parseSize(*totalSize)
+
+			var total int64
+			for i := 0; i < *numFiles; i++ {
+				total += contentLength(strconv.Itoa(i))
+			}
+
+			if diff := math.Abs(float64(total-target)) / float64(target) * 100; diff > *totalSizeTol {
+				t.Errorf("%v files total %v bytes, %.1f %% off %v", *numFiles, total, diff, target)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// levelStart[l] is the index of the first directory at depth l when directories of the tree
+// are numbered breadth-first, the root directory being 0.
+var levelStart []int
+
+// setupTree validates tree flags and, if --num-files is not given, sets it to fill the entire tree.
+func setupTree() error {
+	if *shard1 > 0 || *shard2 > 0 || *shard3 > 0 {
+		return errors.New("--tree-depth can't be combined with --shard1, --shard2 or --shard3")
+	}
+
+	if *dirsPerDir <= 0 || *filesPerDir <= 0 {
+		return errors.New("--dirs-per-dir and --files-per-dir must be positive")
+	}
+
+	levelStart = []int{0}
+	numDirs, levelSize := 1, 1
+
+	for l := 1; l <= *treeDepth; l++ {
+		levelStart = append(levelStart, numDirs)
+		levelSize *= *dirsPerDir
+		numDirs += levelSize
+	}
+
+	if *numFiles == 0 {
+		*numFiles = numDirs * *filesPerDir
+	}
+
+	last := *numFiles
+	if *appendFiles {
+		last += *startIndex
+	}
+
+	if last > numDirs**filesPerDir {
+		return fmt.Errorf("%v files exceed capacity of the tree (%v directories of %v files)", last, numDirs, *filesPerDir)
+	}
+
+	return nil
+}
+
+// treeDir returns the path of the directory with given breadth-first index relative to the root of the tree.
+func treeDir(dirIndex int) string {
+	level := len(levelStart) - 1
+	for levelStart[level] > dirIndex {
+		level--
+	}
+
+	pos := dirIndex - levelStart[level]
+
+	// position of the directory and its ancestors within their levels.
+	components := make([]string, level)
+
+	for l := level; l > 0; l-- {
+		components[l-1] = dirName(l, pos)
+		pos /= *dirsPerDir
+	}
+
+	return filepath.Join(components...)
+}
+
+// dirName returns the name of the directory at the given position within the level of the tree.
+func dirName(level, pos int) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "dir.%v.%v.%v", *seed, level, pos)
+
+	sum := h.Sum(nil)
+
+	return styleName(sum, hex.EncodeToString(sum)[0:8])
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const namesWordlistPrefix = "wordlist:"
+
+// wordlist holds words loaded with --names=wordlist:<file>, nil if names are generated by --name-style.
+var wordlist []string
+
+var (
+	binaryExtensions = []string{".bin", ".dat", ".jpg", ".png", ".pdf", ".zip", ".mp4", ".docx"}
+	logExtensions    = []string{".log", ".txt", ".out"}
+	sourceExtensions = []string{".go", ".c", ".h", ".py", ".js", ".java", ".rs"}
+)
+
+// loadNames loads the word list from --names, which has one word per line, ignoring empty lines and
+// lines starting with '#'.
+func loadNames() error {
+	if *names == "" {
+		return nil
+	}
+
+	if !strings.HasPrefix(*names, namesWordlistPrefix) {
+		return fmt.Errorf("unsupported --names %q, must be wordlist:<file>", *names)
+	}
+
+	b, err := os.ReadFile(strings.TrimPrefix(*names, namesWordlistPrefix))
+	if err != nil {
+		return fmt.Errorf("unable to read word list: %w", err)
+	}
+
+	for _, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		wordlist = append(wordlist, l)
+	}
+
+	if len(wordlist) == 0 {
+		return errors.New("word list is empty")
+	}
+
+	return nil
+}
+
+// wordlistName returns a name of one to three words derived from hash h, with a part of hexName
+// appended to keep names unique.
+func wordlistName(h []byte, hexName string) string {
+	seps := []string{"-", "_", " ", ""}
+	sep := seps[int(h[0])%len(seps)]
+
+	words := make([]string, 1+int(h[1])%3)
+	for i := range words {
+		w := wordlist[(int(h[2+i*2])<<8|int(h[3+i*2]))%len(wordlist)]
+
+		// with no separator words are capitalized like CamelCase.
+		if sep == "" {
+			r, size := utf8.DecodeRuneInString(w)
+			w = string(unicode.ToUpper(r)) + w[size:]
+		}
+
+		words[i] = w
+	}
+
+	unique := hexName
+	if len(unique) > 8 {
+		unique = unique[0:8]
+	}
+
+	name := strings.Join(words, sep)
+
+	if sep == "" {
+		sep = "-"
+	}
+
+	return name + sep + unique
+}
+
+// fileExtension returns the extension of file names generated from the word list matching --content,
+// empty with other name styles.
+func fileExtension(h []byte) string {
+	if wordlist == nil {
+		return ""
+	}
+
+	exts := binaryExtensions
+
+	if *contentType == contentText {
+		exts = logExtensions
+		if *textStyle == textStyleSource {
+			exts = sourceExtensions
+		}
+	}
+
+	return exts[int(h[8])%len(exts)]
+}
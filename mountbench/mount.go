@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+// snapshotMount mounts a snapshot on the mount point.
+type snapshotMount struct {
+	kopia      bench.Kopia
+	snapshot   string
+	mountPoint string
+}
+
+// mount is a running 'kopia mount' process.
+type mount struct {
+	cmd  *exec.Cmd
+	done chan error
+}
+
+// start mounts the snapshot and waits until its files are visible under the mount point.
+func (sm *snapshotMount) start(ctx context.Context) (*mount, error) {
+	if err := os.MkdirAll(sm.mountPoint, 0o755); err != nil {
+		return nil, err
+	}
+
+	c := sm.kopia.Command(ctx, "mount", sm.snapshot, sm.mountPoint, "--metrics-listen-addr="+*metricsAddress)
+	c.Stderr = os.Stderr
+
+	if err := c.Start(); err != nil {
+		return nil, fmt.Errorf("unable to start kopia mount: %w", err)
+	}
+
+	m := &mount{cmd: c, done: make(chan error, 1)}
+
+	go func() {
+		m.done <- c.Wait()
+	}()
+
+	if err := m.waitForMounted(sm.mountPoint); err != nil {
+		m.stop()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// waitForMounted waits until the mount point is not empty.
+func (m *mount) waitForMounted(mountPoint string) error {
+	deadline := time.Now().Add(*mountTimeout)
+
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-m.done:
+			m.done <- err
+			return fmt.Errorf("kopia mount exited: %v", err)
+		default:
+		}
+
+		if entries, err := os.ReadDir(mountPoint); err == nil && len(entries) > 0 {
+			return nil
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return fmt.Errorf("snapshot was not mounted on %v within %v", mountPoint, *mountTimeout)
+}
+
+// monitor starts sampling the kopia process and its Prometheus metrics.
+func (m *mount) monitor(ctx context.Context) (*bench.Monitor, error) {
+	ps, err := bench.NewProcessSampler(ctx, m.cmd.Process.Pid)
+	if err != nil {
+		return nil, err
+	}
+
+	return bench.StartMonitor(ctx, *samplingInterval, ps, &bench.PrometheusSampler{URL: "http://" + *metricsAddress + "/metrics"}), nil
+}
+
+// stop interrupts kopia, which unmounts the snapshot, and waits for it to exit, killing it if
+// it doesn't exit in time.
+func (m *mount) stop() {
+	if err := m.cmd.Process.Signal(os.Interrupt); err != nil {
+		m.cmd.Process.Kill()
+	}
+
+	select {
+	case <-m.done:
+	case <-time.After(10 * time.Second):
+		m.cmd.Process.Kill()
+		<-m.done
+	}
+}
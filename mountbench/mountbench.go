@@ -0,0 +1,201 @@
+// Command mountbench measures reading files of a snapshot mounted using 'kopia mount': it mounts
+// the snapshot and runs read workloads against the mount point while sampling the kopia process.
+//
+// Usage: mountbench (--config-file=<file> --snapshot=<id> | --dataset=<dir>) [--workloads=sequential,random] [--readers=N]
+//
+// Either an existing repository connected using --config-file and a --snapshot (root object ID)
+// in it is mounted, or --dataset is first snapshotted into a new filesystem repository in the work
+// directory.
+//
+// Workloads:
+//
+//	sequential - every file of the snapshot is read from start to end, by --readers concurrent readers
+//	random     - --random-reads reads of --block-size at random offsets of random files, by
+//	             --readers concurrent readers
+//
+// Each workload is measured --repeat times using a fresh mount, with kopia's cache cleared before
+// mounting unless --warm-cache is given. Measurements are written to --output (stdout by default)
+// as 'mount_read_summary' lines tagged with the workload, number of readers and --run-tags, with
+// average duration, throughput and read latencies of the workload (a read of the sequential
+// workload is a whole file) and memory and CPU usage of the kopia process serving the mount.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+const (
+	repoPassword = "dummy"
+
+	workloadSequential = "sequential"
+	workloadRandom     = "random"
+)
+
+var (
+	kopiaExe         = flag.String("kopia-exe", os.ExpandEnv("$HOME/go/bin/kopia"), "Path to kopia")
+	configFile       = flag.String("config-file", "", "Config file of a connected repository containing --snapshot")
+	snapshotID       = flag.String("snapshot", "", "Root object ID of the snapshot to mount")
+	dataset          = flag.String("dataset", "", "Directory to snapshot into a new repository and mount")
+	workloads        = flag.String("workloads", workloadSequential+","+workloadRandom, "Comma-separated list of read workloads")
+	readers          = flag.Int("readers", 4, "Number of concurrent readers")
+	randomReads      = flag.Int("random-reads", 10000, "Number of reads of the random workload")
+	blockSize        = flag.Int("block-size", 64<<10, "Size of reads of the random workload")
+	seed             = flag.Int64("seed", 1, "Seed of the random workload")
+	repeat           = flag.Int("repeat", 3, "Number of measured runs of each workload")
+	warmCache        = flag.Bool("warm-cache", false, "Don't clear kopia's cache before mounting")
+	workDir          = flag.String("work-dir", "", "Directory for the mount point and the created repository (default: new temporary directory)")
+	metricsAddress   = flag.String("metrics-address", "127.0.0.1:6668", "Address of the Prometheus endpoint of kopia mount")
+	mountTimeout     = flag.Duration("mount-timeout", time.Minute, "Maximum time to wait for the snapshot to be mounted")
+	samplingInterval = flag.Duration("sampling-interval", 100*time.Millisecond, "Interval between samples of the kopia process")
+	keep             = flag.Bool("keep", false, "Keep the work directory")
+	outputFile       = flag.String("output", "", "File to append measurements to (default: stdout)")
+	runTags          = flag.String("run-tags", "", "Comma-separated list of tags to attach to measurements")
+)
+
+func main() {
+	flag.Parse()
+
+	if (*dataset == "") == (*snapshotID == "" || *configFile == "") {
+		log.Fatal("either --config-file and --snapshot or --dataset is required")
+	}
+
+	if *readers <= 0 || *repeat <= 0 || *randomReads <= 0 || *blockSize <= 0 {
+		log.Fatal("--readers, --repeat, --random-reads and --block-size must be positive")
+	}
+
+	tags, err := bench.ParseTags(*runTags)
+	if err != nil {
+		log.Fatalf("invalid --run-tags: %v", err)
+	}
+
+	wl := strings.Split(*workloads, ",")
+
+	for _, w := range wl {
+		if w != workloadSequential && w != workloadRandom {
+			log.Fatalf("unsupported workload %q", w)
+		}
+	}
+
+	if err := run(context.Background(), wl, tags); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, wl []string, tags []bench.Tag) error {
+	dir, cleanup, err := bench.WorkDir(*workDir, "mountbench", *keep)
+	if err != nil {
+		return err
+	}
+
+	defer cleanup()
+
+	sm := &snapshotMount{
+		kopia:      bench.Kopia{Exe: *kopiaExe, ConfigFile: *configFile, Password: repoPassword},
+		snapshot:   *snapshotID,
+		mountPoint: filepath.Join(dir, "mnt"),
+	}
+
+	if *dataset != "" {
+		log.Printf("snapshotting %v", *dataset)
+
+		k, roots, err := bench.SnapshotDatasets(ctx, *kopiaExe, dir, repoPassword, *dataset)
+		if err != nil {
+			return err
+		}
+
+		sm.kopia, sm.snapshot = k, roots[0]
+	}
+
+	out, err := bench.OpenOutput(*outputFile)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	sink := bench.NewLineProtocolSink(out)
+
+	for _, w := range wl {
+		pt, err := measure(ctx, sm, w, tags)
+		if err != nil {
+			return err
+		}
+
+		if err := sink.Write(pt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// measure runs the workload --repeat times, each against a fresh mount of the snapshot.
+func measure(ctx context.Context, sm *snapshotMount, workload string, tags []bench.Tag) (bench.Point, error) {
+	var (
+		results   []*bench.Result
+		bytesRead int64
+		latencies []float64
+	)
+
+	for i := 0; i < *repeat; i++ {
+		if !*warmCache {
+			if _, err := sm.kopia.Run(ctx, "cache", "clear"); err != nil {
+				return bench.Point{}, err
+			}
+		}
+
+		m, err := sm.start(ctx)
+		if err != nil {
+			return bench.Point{}, err
+		}
+
+		mon, err := m.monitor(ctx)
+		if err != nil {
+			m.stop()
+			return bench.Point{}, err
+		}
+
+		st, err := runWorkload(ctx, sm.mountPoint, workload)
+		res := mon.Stop()
+
+		m.stop()
+
+		if err != nil {
+			return bench.Point{}, fmt.Errorf("%v workload failed: %w", workload, err)
+		}
+
+		results = append(results, res)
+		bytesRead += st.bytes
+		latencies = append(latencies, st.latencies...)
+	}
+
+	s := bench.Summarize(results)
+	throughput := float64(bytesRead) / float64(len(results)) / s.AvgDuration
+
+	log.Printf("%v with %v readers: %.1fs, %.1f MiB/s", workload, *readers, s.AvgDuration, throughput/(1<<20))
+
+	return bench.Point{
+		Measurement: "mount_read_summary",
+		Tags: append([]bench.Tag{
+			{Key: "workload", Value: workload},
+			{Key: "readers", Value: strconv.Itoa(*readers)},
+		}, tags...),
+		Fields: append(s.ProcessFields(),
+			bench.Field{Key: "throughput_bytes_per_sec", Value: throughput},
+			bench.Field{Key: "bytes_read", Value: bytesRead / int64(len(results))},
+			bench.Field{Key: "reads", Value: len(latencies) / len(results)},
+			bench.Field{Key: "p50_read_latency_ms", Value: bench.Fixed{Value: bench.Percentile(latencies, 50), Digits: 3}},
+			bench.Field{Key: "p99_read_latency_ms", Value: bench.Fixed{Value: bench.Percentile(latencies, 99), Digits: 3}}),
+		Time: time.Now(),
+	}, nil
+}
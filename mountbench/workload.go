@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// workloadStats describes reads performed by a single run of a workload.
+type workloadStats struct {
+	bytes     int64
+	latencies []float64 // milliseconds
+}
+
+type mountedFile struct {
+	path string
+	size int64
+}
+
+// runWorkload reads files under the mount point using --readers concurrent readers.
+func runWorkload(ctx context.Context, mountPoint, workload string) (*workloadStats, error) {
+	files, err := listFiles(mountPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files in the mounted snapshot")
+	}
+
+	var (
+		mu    sync.Mutex
+		stats workloadStats
+		wg    sync.WaitGroup
+		errs  = make(chan error, *readers)
+	)
+
+	// record adds a single read to the stats.
+	record := func(n int64, t0 time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		stats.bytes += n
+		stats.latencies = append(stats.latencies, float64(time.Since(t0).Microseconds())/1000)
+	}
+
+	for r := 0; r < *readers; r++ {
+		r := r
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			var err error
+
+			if workload == workloadSequential {
+				err = readSequential(ctx, files, r, record)
+			} else {
+				err = readRandom(ctx, files, r, record)
+			}
+
+			if err != nil {
+				errs <- err
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	return &stats, <-errs
+}
+
+// readSequential reads every --readers-th file starting with the reader's index to the end,
+// each file counting as a single read.
+func readSequential(ctx context.Context, files []mountedFile, reader int, record func(n int64, t0 time.Time)) error {
+	for i := reader; i < len(files); i += *readers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		t0 := time.Now()
+
+		f, err := os.Open(files[i].path)
+		if err != nil {
+			return err
+		}
+
+		n, err := io.Copy(io.Discard, f)
+		f.Close()
+
+		if err != nil {
+			return err
+		}
+
+		record(n, t0)
+	}
+
+	return nil
+}
+
+// readRandom performs the reader's share of --random-reads reads of --block-size at random offsets.
+func readRandom(ctx context.Context, files []mountedFile, reader int, record func(n int64, t0 time.Time)) error {
+	rnd := rand.New(rand.NewSource(*seed + int64(reader)))
+	buf := make([]byte, *blockSize)
+
+	for i := reader; i < *randomReads; i += *readers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		mf := files[rnd.Intn(len(files))]
+
+		var off int64
+		if mf.size > int64(*blockSize) {
+			off = rnd.Int63n(mf.size - int64(*blockSize))
+		}
+
+		t0 := time.Now()
+
+		f, err := os.Open(mf.path)
+		if err != nil {
+			return err
+		}
+
+		n, err := f.ReadAt(buf, off)
+		f.Close()
+
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		record(int64(n), t0)
+	}
+
+	return nil
+}
+
+// listFiles returns non-empty regular files under dir.
+func listFiles(dir string) ([]mountedFile, error) {
+	var files []mountedFile
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if fi.Size() > 0 {
+			files = append(files, mountedFile{p, fi.Size()})
+		}
+
+		return nil
+	})
+
+	return files, err
+}
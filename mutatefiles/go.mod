@@ -0,0 +1,3 @@
+module mutatefiles
+
+go 1.18
@@ -0,0 +1,166 @@
+// Command mutatefiles applies churn to an existing directory tree, which unlike 'makemanyfiles --mutate'
+// doesn't need to be generated by makemanyfiles, so that real-world datasets can be used in
+// incremental snapshot scenarios.
+//
+// Usage: mutatefiles --dir=<path> [--edit-pct=N] [--truncate-pct=N] [--rename-pct=N] [--chmod-pct=N] [--seed=N]
+//
+// Each file is subject to at most one operation chosen by a hash of --seed and its relative path,
+// so the same parameters applied to the same tree always make the same changes:
+//
+//   - edit overwrites a range of the file with pseudo-random data,
+//   - truncate shortens the file,
+//   - rename renames it within its directory,
+//   - chmod toggles group and other permission bits.
+//
+// Operations can be written to a file with --record and applied again with --replay, which
+// reproduces them exactly on another copy of the tree.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+var (
+	dir         = flag.String("dir", "", "Directory tree to mutate")
+	seed        = flag.Int64("seed", 1, "Seed selecting files and changes, use different values for successive mutations")
+	editPct     = flag.Float64("edit-pct", 0, "Percentage of files to edit in place")
+	truncatePct = flag.Float64("truncate-pct", 0, "Percentage of files to truncate")
+	renamePct   = flag.Float64("rename-pct", 0, "Percentage of files to rename")
+	chmodPct    = flag.Float64("chmod-pct", 0, "Percentage of files to change permissions of")
+	maxEditSize = flag.Int64("max-edit-size", 64<<10, "Maximum number of bytes overwritten by an edit")
+	record      = flag.String("record", "", "Write applied operations as JSON lines to this file")
+	replay      = flag.String("replay", "", "Apply operations recorded with --record instead of choosing them")
+	dryRun      = flag.Bool("dry-run", false, "Only print operations which would be applied")
+)
+
+func main() {
+	flag.Parse()
+
+	if *dir == "" {
+		log.Fatal("missing --dir")
+	}
+
+	if *editPct < 0 || *truncatePct < 0 || *renamePct < 0 || *chmodPct < 0 || *editPct+*truncatePct+*renamePct+*chmodPct > 100 {
+		log.Fatal("percentages of operations must be non-negative and add up to at most 100")
+	}
+
+	t0 := time.Now()
+
+	var (
+		ops []operation
+		err error
+	)
+
+	if *replay != "" {
+		ops, err = readOperations(*replay)
+	} else {
+		ops, err = chooseOperations()
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *record != "" {
+		if err := writeOperations(*record, ops); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	counts := map[string]int{}
+
+	for _, op := range ops {
+		if *dryRun {
+			fmt.Println(op)
+			continue
+		}
+
+		if err := op.apply(*dir); err != nil {
+			log.Fatalf("error applying %v: %v", op, err)
+		}
+
+		counts[op.Op]++
+	}
+
+	log.Printf("mutated %v: edited %v, truncated %v, renamed %v files and changed permissions of %v in %v",
+		*dir, counts[opEdit], counts[opTruncate], counts[opRename], counts[opChmod], time.Since(t0))
+}
+
+// chooseOperations walks the tree in lexical order and chooses operations applied to its files.
+func chooseOperations() ([]operation, error) {
+	var ops []operation
+
+	err := filepath.WalkDir(*dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(*dir, p)
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if op, ok := chooseOperation(filepath.ToSlash(rel), info.Size(), info.Mode().Perm()); ok {
+			ops = append(ops, op)
+		}
+
+		return nil
+	})
+
+	return ops, err
+}
+
+// chooseOperation chooses the operation applied to the file with the given relative path.
+func chooseOperation(rel string, size int64, perm fs.FileMode) (operation, bool) {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%v\x00%v", *seed, rel)))
+	v := 100 * float64(binary.BigEndian.Uint64(h[0:8])>>11) / (1 << 53)
+	r := binary.BigEndian.Uint64(h[8:16])
+
+	op := operation{Path: rel}
+
+	switch {
+	case v < *editPct:
+		op.Op = opEdit
+		op.Seed = int64(binary.BigEndian.Uint64(h[16:24]) >> 1)
+
+		op.Length = *maxEditSize
+		if size < op.Length {
+			op.Length = size
+		}
+
+		if op.Length == 0 {
+			return op, false
+		}
+
+		op.Offset = int64(r % uint64(size-op.Length+1))
+
+	case v < *editPct+*truncatePct:
+		op.Op = opTruncate
+		op.Length = int64(r % uint64(size+1))
+
+	case v < *editPct+*truncatePct+*renamePct:
+		op.Op = opRename
+		op.NewPath = fmt.Sprintf("%v.renamed-%x", rel, h[24:28])
+
+	case v < *editPct+*truncatePct+*renamePct+*chmodPct:
+		op.Op = opChmod
+		op.Mode = uint32(perm ^ 0o054)
+
+	default:
+		return op, false
+	}
+
+	return op, true
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+)
+
+const (
+	opEdit     = "edit"
+	opTruncate = "truncate"
+	opRename   = "rename"
+	opChmod    = "chmod"
+)
+
+// operation is a single change of a file, as recorded by --record.
+type operation struct {
+	Op      string `json:"op"`
+	Path    string `json:"path"`              // slash-separated path relative to --dir
+	Offset  int64  `json:"offset,omitempty"`  // edit
+	Length  int64  `json:"length,omitempty"`  // edit, new size for truncate
+	Seed    int64  `json:"seed,omitempty"`    // edit, seed of data written
+	NewPath string `json:"newPath,omitempty"` // rename
+	Mode    uint32 `json:"mode,omitempty"`    // chmod
+}
+
+func (op operation) String() string {
+	switch op.Op {
+	case opEdit:
+		return fmt.Sprintf("edit %v [%v,%v)", op.Path, op.Offset, op.Offset+op.Length)
+	case opTruncate:
+		return fmt.Sprintf("truncate %v to %v", op.Path, op.Length)
+	case opRename:
+		return fmt.Sprintf("rename %v to %v", op.Path, op.NewPath)
+	case opChmod:
+		return fmt.Sprintf("chmod %v to %o", op.Path, op.Mode)
+	default:
+		return fmt.Sprintf("%v %v", op.Op, op.Path)
+	}
+}
+
+func (op operation) apply(root string) error {
+	fname := filepath.Join(root, filepath.FromSlash(op.Path))
+
+	switch op.Op {
+	case opEdit:
+		f, err := os.OpenFile(fname, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		if _, err := f.Seek(op.Offset, io.SeekStart); err != nil {
+			return err
+		}
+
+		if _, err := io.CopyN(f, rand.New(rand.NewSource(op.Seed)), op.Length); err != nil {
+			return err
+		}
+
+		return f.Close()
+
+	case opTruncate:
+		return os.Truncate(fname, op.Length)
+
+	case opRename:
+		return os.Rename(fname, filepath.Join(root, filepath.FromSlash(op.NewPath)))
+
+	case opChmod:
+		return os.Chmod(fname, os.FileMode(op.Mode))
+
+	default:
+		return fmt.Errorf("unknown operation %q", op.Op)
+	}
+}
+
+func readOperations(fname string) ([]operation, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var ops []operation
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+
+	for {
+		var op operation
+
+		if err := dec.Decode(&op); err != nil {
+			if err == io.EOF {
+				return ops, nil
+			}
+
+			return nil, fmt.Errorf("invalid operation in %v: %w", fname, err)
+		}
+
+		ops = append(ops, op)
+	}
+}
+
+func writeOperations(fname string, ops []operation) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+
+	for _, op := range ops {
+		if err := enc.Encode(op); err != nil {
+			return err
+		}
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// backend is an in-process WebDAV server which delays each request, standing in for cloud storage.
+type backend struct {
+	server *httptest.Server
+	dir    string
+
+	latency time.Duration
+	jitter  time.Duration
+
+	mu       sync.Mutex
+	rnd      *rand.Rand
+	handler  *webdav.Handler
+	requests int64
+}
+
+func startBackend(dir string, latency, jitter time.Duration) *backend {
+	b := &backend{
+		dir:     dir,
+		latency: latency,
+		jitter:  jitter,
+		rnd:     rand.New(rand.NewSource(1)),
+	}
+
+	b.server = httptest.NewServer(http.HandlerFunc(b.serveHTTP))
+
+	return b
+}
+
+func (b *backend) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&b.requests, 1)
+
+	b.mu.Lock()
+	h := b.handler
+	d := b.latency
+	if b.jitter > 0 {
+		d += time.Duration(b.rnd.Int63n(int64(b.jitter)))
+	}
+	b.mu.Unlock()
+
+	time.Sleep(d)
+
+	h.ServeHTTP(w, r)
+}
+
+// reset removes all data of the backend and returns its URL.
+func (b *backend) reset() (string, error) {
+	if err := os.RemoveAll(b.dir); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(b.dir, 0o755); err != nil {
+		return "", err
+	}
+
+	b.mu.Lock()
+	b.handler = &webdav.Handler{
+		FileSystem: webdav.Dir(b.dir),
+		LockSystem: webdav.NewMemLS(),
+	}
+	b.mu.Unlock()
+
+	return b.server.URL, nil
+}
+
+// requestCount returns the number of requests served so far.
+func (b *backend) requestCount() int64 {
+	return atomic.LoadInt64(&b.requests)
+}
+
+// Close stops the server.
+func (b *backend) Close() {
+	b.server.Close()
+}
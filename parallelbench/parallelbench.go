@@ -0,0 +1,252 @@
+// Command parallelbench measures how snapshot throughput scales with upload parallelism against
+// a backend with latency, so that changes to kopia upload queueing which move or lower the optimal
+// parallelism are caught automatically.
+//
+// Usage: parallelbench --dataset=<dir> [--parallel=1,2,4,8,16,32] [--file-reads=,8] [--latency=50ms]
+//
+// The repository is stored in an in-process WebDAV server which delays each request by --latency
+// (with --latency-jitter), which makes upload parallelism matter like it does for cloud storage.
+// Each combination of --parallel (passed to 'kopia snapshot create') and --file-reads (global
+// policy --max-parallel-file-reads, an empty element uses the kopia default) is measured --repeat
+// times, each time into a new repository.
+//
+// Measurements are written to --output (stdout by default):
+//
+//	parallelism_scaling   - each combination, with average duration, throughput, number of requests
+//	                        served by the backend and memory and CPU usage of the snapshot
+//	parallelism_optimum   - for each --file-reads value the --parallel value with the highest
+//	                        throughput and the speedup of that value over the lowest --parallel
+//
+// Measurements are tagged with the latency and --run-tags.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+const repoPassword = "dummy"
+
+var (
+	kopiaExe         = flag.String("kopia-exe", os.ExpandEnv("$HOME/go/bin/kopia"), "Path to kopia")
+	dataset          = flag.String("dataset", "", "Directory to snapshot")
+	parallelValues   = flag.String("parallel", "1,2,4,8,16,32", "Comma-separated list of --parallel values in increasing order")
+	fileReadsValues  = flag.String("file-reads", "", "Comma-separated list of --max-parallel-file-reads policy values, empty element for kopia default")
+	latency          = flag.Duration("latency", 50*time.Millisecond, "Latency added to each request of the backend")
+	latencyJitter    = flag.Duration("latency-jitter", 0, "Maximum random jitter added to --latency")
+	repeat           = flag.Int("repeat", 3, "Number of measured snapshots of each combination")
+	workDir          = flag.String("work-dir", "", "Directory for repositories and config files (default: new temporary directory)")
+	samplingInterval = flag.Duration("sampling-interval", 100*time.Millisecond, "Interval between samples of the measured process")
+	keep             = flag.Bool("keep", false, "Keep the work directory")
+	outputFile       = flag.String("output", "", "File to append measurements to (default: stdout)")
+	runTags          = flag.String("run-tags", "", "Comma-separated list of tags to attach to measurements")
+)
+
+// setting is a single measured combination.
+type setting struct {
+	parallel  int
+	fileReads string
+}
+
+func (s setting) fileReadsTag() bench.Tag {
+	v := s.fileReads
+	if v == "" {
+		v = "default"
+	}
+
+	return bench.Tag{Key: "file_reads", Value: v}
+}
+
+// measurement is the result of measuring a setting.
+type measurement struct {
+	setting    setting
+	throughput float64
+}
+
+func main() {
+	flag.Parse()
+
+	if *dataset == "" {
+		log.Fatal("missing --dataset")
+	}
+
+	if *repeat <= 0 {
+		log.Fatal("--repeat must be positive")
+	}
+
+	var parallel []int
+
+	for _, s := range strings.Split(*parallelValues, ",") {
+		p, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || p <= 0 {
+			log.Fatalf("invalid --parallel value %q", s)
+		}
+
+		if len(parallel) > 0 && p <= parallel[len(parallel)-1] {
+			log.Fatal("--parallel values must be in increasing order")
+		}
+
+		parallel = append(parallel, p)
+	}
+
+	tags, err := bench.ParseTags(*runTags)
+	if err != nil {
+		log.Fatalf("invalid --run-tags: %v", err)
+	}
+
+	tags = append([]bench.Tag{{Key: "latency", Value: latency.String()}}, tags...)
+
+	if err := run(context.Background(), parallel, strings.Split(*fileReadsValues, ","), tags); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, parallel []int, fileReads []string, tags []bench.Tag) error {
+	dir, cleanup, err := bench.WorkDir(*workDir, "parallelbench", *keep)
+	if err != nil {
+		return err
+	}
+
+	defer cleanup()
+
+	datasetSize, _, err := bench.DirSize(*dataset)
+	if err != nil {
+		return err
+	}
+
+	backend := startBackend(filepath.Join(dir, "backend"), *latency, *latencyJitter)
+	defer backend.Close()
+
+	out, err := bench.OpenOutput(*outputFile)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	sink := bench.NewLineProtocolSink(out)
+
+	for _, fr := range fileReads {
+		var results []measurement
+
+		for _, p := range parallel {
+			st := setting{parallel: p, fileReads: fr}
+
+			log.Printf("parallel %v, file reads %v", p, st.fileReadsTag().Value)
+
+			pt, m, err := measure(ctx, filepath.Join(dir, "client"), backend, st, datasetSize)
+			if err != nil {
+				return err
+			}
+
+			pt.Tags = append(pt.Tags, tags...)
+
+			if err := sink.Write(pt); err != nil {
+				return err
+			}
+
+			results = append(results, m)
+		}
+
+		if err := sink.Write(optimum(results, tags)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// measure snapshots the dataset --repeat times with the setting, each time into a new repository.
+func measure(ctx context.Context, dir string, backend *backend, st setting, datasetSize int64) (bench.Point, measurement, error) {
+	var (
+		results  []*bench.Result
+		requests int64
+	)
+
+	for i := 0; i < *repeat; i++ {
+		if err := os.RemoveAll(dir); err != nil {
+			return bench.Point{}, measurement{}, err
+		}
+
+		url, err := backend.reset()
+		if err != nil {
+			return bench.Point{}, measurement{}, err
+		}
+
+		k := bench.Kopia{Exe: *kopiaExe, ConfigFile: filepath.Join(dir, "kopia.config"), Password: repoPassword}
+
+		if _, err := k.Run(ctx, "repository", "create", "webdav", "--url="+url); err != nil {
+			return bench.Point{}, measurement{}, err
+		}
+
+		if st.fileReads != "" {
+			if _, err := k.Run(ctx, "policy", "set", "--global", "--max-parallel-file-reads="+st.fileReads); err != nil {
+				return bench.Point{}, measurement{}, err
+			}
+		}
+
+		before := backend.requestCount()
+
+		c := k.Command(ctx, "snapshot", "create", *dataset, "--no-progress", "--no-auto-maintenance", "--parallel="+strconv.Itoa(st.parallel))
+		c.Stderr = os.Stderr
+
+		r := &bench.CommandRunner{Interval: *samplingInterval}
+
+		res, err := r.Run(ctx, c)
+		if err != nil {
+			return bench.Point{}, measurement{}, fmt.Errorf("snapshot failed: %w", err)
+		}
+
+		results = append(results, res)
+		requests += backend.requestCount() - before
+	}
+
+	s := bench.Summarize(results)
+	throughput := float64(datasetSize) / s.AvgDuration
+
+	log.Printf("  %.1fs, %.1f MiB/s, cpu %.0f%%", s.AvgDuration, throughput/(1<<20), s.AvgCPU)
+
+	return bench.Point{
+		Measurement: "parallelism_scaling",
+		Tags:        []bench.Tag{{Key: "parallel", Value: strconv.Itoa(st.parallel)}, st.fileReadsTag()},
+		Fields: append(s.ProcessFields(),
+			bench.Field{Key: "throughput_bytes_per_sec", Value: throughput},
+			bench.Field{Key: "requests", Value: float64(requests) / float64(len(results))},
+			bench.Field{Key: "cpu_seconds_per_gib", Value: s.AvgCPU / 100 * s.AvgDuration / (float64(datasetSize) / (1 << 30))}),
+		Time: time.Now(),
+	}, measurement{setting: st, throughput: throughput}, nil
+}
+
+// optimum returns the point describing the setting with the highest throughput of results,
+// which are measured with increasing parallelism.
+func optimum(results []measurement, tags []bench.Tag) bench.Point {
+	best := results[0]
+
+	for _, m := range results[1:] {
+		if m.throughput > best.throughput {
+			best = m
+		}
+	}
+
+	log.Printf("optimal parallelism %v (%.1f MiB/s)", best.setting.parallel, best.throughput/(1<<20))
+
+	return bench.Point{
+		Measurement: "parallelism_optimum",
+		Tags:        append([]bench.Tag{best.setting.fileReadsTag()}, tags...),
+		Fields: []bench.Field{
+			{Key: "parallel", Value: best.setting.parallel},
+			{Key: "throughput_bytes_per_sec", Value: best.throughput},
+			{Key: "speedup", Value: bench.Fixed{Value: best.throughput / results[0].throughput, Digits: 2}},
+		},
+		Time: time.Now(),
+	}
+}
@@ -0,0 +1,240 @@
+// Command restoresweep measures restore throughput of kopia as a function of restore parallelism
+// and cache state, by restoring the same snapshot repeatedly with each combination of --parallel
+// values and --cache states.
+//
+// Usage: restoresweep (--config-file=<file> --snapshot=<id> | --dataset=<dir>) [--parallel=1,2,4,8] [--cache=cold,warm]
+//
+// Either an existing repository connected using --config-file and a --snapshot (root object ID)
+// in it is restored, or --dataset is first snapshotted into a new filesystem repository in the work
+// directory.
+//
+// Cache states:
+//
+//	cold - kopia's cache is cleared before each restore, with --drop-page-cache also the kernel
+//	       page cache (Linux and macOS, requires root)
+//	warm - an unmeasured restore precedes the measured ones
+//
+// Each combination is measured --repeat times. Measurements are written to --output (stdout by
+// default) as 'restore_sweep' lines tagged with parallelism, cache state and --run-tags, with
+// average duration, throughput and memory and CPU usage of the restore process.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+const (
+	repoPassword = "dummy"
+
+	cacheCold = "cold"
+	cacheWarm = "warm"
+)
+
+var (
+	kopiaExe         = flag.String("kopia-exe", os.ExpandEnv("$HOME/go/bin/kopia"), "Path to kopia")
+	configFile       = flag.String("config-file", "", "Config file of a connected repository containing --snapshot")
+	snapshotID       = flag.String("snapshot", "", "Root object ID of the snapshot to restore")
+	dataset          = flag.String("dataset", "", "Directory to snapshot into a new repository and restore")
+	parallelValues   = flag.String("parallel", "1,2,4,8,16", "Comma-separated list of --parallel values")
+	cacheStates      = flag.String("cache", cacheCold+","+cacheWarm, "Comma-separated list of cache states")
+	repeat           = flag.Int("repeat", 3, "Number of measured restores of each combination")
+	dropPageCache    = flag.Bool("drop-page-cache", false, "Drop kernel page cache before cold restores")
+	workDir          = flag.String("work-dir", "", "Directory for restored files and the created repository (default: new temporary directory)")
+	samplingInterval = flag.Duration("sampling-interval", 100*time.Millisecond, "Interval between samples of the measured process")
+	keep             = flag.Bool("keep", false, "Keep the work directory")
+	outputFile       = flag.String("output", "", "File to append measurements to (default: stdout)")
+	runTags          = flag.String("run-tags", "", "Comma-separated list of tags to attach to measurements")
+)
+
+func main() {
+	flag.Parse()
+
+	if (*dataset == "") == (*snapshotID == "" || *configFile == "") {
+		log.Fatal("either --config-file and --snapshot or --dataset is required")
+	}
+
+	if *repeat <= 0 {
+		log.Fatal("--repeat must be positive")
+	}
+
+	tags, err := bench.ParseTags(*runTags)
+	if err != nil {
+		log.Fatalf("invalid --run-tags: %v", err)
+	}
+
+	var parallel []int
+
+	for _, s := range strings.Split(*parallelValues, ",") {
+		p, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || p <= 0 {
+			log.Fatalf("invalid --parallel value %q", s)
+		}
+
+		parallel = append(parallel, p)
+	}
+
+	caches := strings.Split(*cacheStates, ",")
+
+	for _, c := range caches {
+		if c != cacheCold && c != cacheWarm {
+			log.Fatalf("unsupported cache state %q", c)
+		}
+	}
+
+	if err := run(context.Background(), parallel, caches, tags); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, parallel []int, caches []string, tags []bench.Tag) error {
+	dir, cleanup, err := bench.WorkDir(*workDir, "restoresweep", *keep)
+	if err != nil {
+		return err
+	}
+
+	defer cleanup()
+
+	sw := &sweep{
+		kopia:    bench.Kopia{Exe: *kopiaExe, ConfigFile: *configFile, Password: repoPassword},
+		snapshot: *snapshotID,
+		target:   filepath.Join(dir, "restored"),
+	}
+
+	if *dataset != "" {
+		log.Printf("snapshotting %v", *dataset)
+
+		k, roots, err := bench.SnapshotDatasets(ctx, *kopiaExe, dir, repoPassword, *dataset)
+		if err != nil {
+			return err
+		}
+
+		sw.kopia, sw.snapshot = k, roots[0]
+	}
+
+	out, err := bench.OpenOutput(*outputFile)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	sink := bench.NewLineProtocolSink(out)
+
+	for _, cache := range caches {
+		for _, p := range parallel {
+			pt, err := sw.measure(ctx, p, cache, tags)
+			if err != nil {
+				return err
+			}
+
+			if err := sink.Write(pt); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// sweep restores a snapshot into the target directory.
+type sweep struct {
+	kopia    bench.Kopia
+	snapshot string
+	target   string
+}
+
+// measure restores the snapshot --repeat times with the given parallelism and cache state.
+func (sw *sweep) measure(ctx context.Context, parallel int, cache string, tags []bench.Tag) (bench.Point, error) {
+	var (
+		results       []*bench.Result
+		restoredBytes int64
+	)
+
+	if cache == cacheWarm {
+		if _, err := sw.restore(ctx, parallel); err != nil {
+			return bench.Point{}, err
+		}
+	}
+
+	for i := 0; i < *repeat; i++ {
+		if cache == cacheCold {
+			if err := sw.clearCaches(ctx); err != nil {
+				return bench.Point{}, err
+			}
+		}
+
+		res, err := sw.restore(ctx, parallel)
+		if err != nil {
+			return bench.Point{}, err
+		}
+
+		if restoredBytes == 0 {
+			if restoredBytes, _, err = bench.DirSize(sw.target); err != nil {
+				return bench.Point{}, err
+			}
+		}
+
+		results = append(results, res)
+	}
+
+	s := bench.Summarize(results)
+	throughput := float64(restoredBytes) / s.AvgDuration
+
+	log.Printf("parallel=%v cache=%v: %.1fs, %.1f MiB/s", parallel, cache, s.AvgDuration, throughput/(1<<20))
+
+	return bench.Point{
+		Measurement: "restore_sweep",
+		Tags: append([]bench.Tag{
+			{Key: "parallel", Value: strconv.Itoa(parallel)},
+			{Key: "cache", Value: cache},
+		}, tags...),
+		Fields: append(s.ProcessFields(),
+			bench.Field{Key: "throughput_bytes_per_sec", Value: throughput},
+			bench.Field{Key: "restored_bytes", Value: restoredBytes}),
+		Time: time.Now(),
+	}, nil
+}
+
+// restore measures a restore into an empty target directory.
+func (sw *sweep) restore(ctx context.Context, parallel int) (*bench.Result, error) {
+	if err := os.RemoveAll(sw.target); err != nil {
+		return nil, err
+	}
+
+	c := sw.kopia.Command(ctx, "restore", sw.snapshot, sw.target, "--parallel="+strconv.Itoa(parallel))
+	c.Stderr = os.Stderr
+
+	r := &bench.CommandRunner{Interval: *samplingInterval}
+
+	res, err := r.Run(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("restore failed: %w", err)
+	}
+
+	return res, nil
+}
+
+// clearCaches clears kopia's cache and optionally the kernel page cache.
+func (sw *sweep) clearCaches(ctx context.Context) error {
+	if _, err := sw.kopia.Run(ctx, "cache", "clear"); err != nil {
+		return err
+	}
+
+	if *dropPageCache {
+		if err := bench.DropPageCache(); err != nil {
+			return fmt.Errorf("unable to drop page cache: %w", err)
+		}
+	}
+
+	return nil
+}
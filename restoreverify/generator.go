@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+// failedVerificationRE matches summary printed by 'makemanyfiles --verify' when files don't match.
+var failedVerificationRE = regexp.MustCompile(`(\d+) of \d+ files in .* failed verification`)
+
+// verifyGenerator runs 'makemanyfiles --verify' on the restored tree and returns the number of
+// files which failed verification.
+func verifyGenerator(dir string, flags []string) (int, error) {
+	var stderr bytes.Buffer
+
+	c := exec.Command(*makeManyFilesExe, append(append([]string(nil), flags...), "--verify", "--quiet", "--output-dir="+dir)...)
+	c.Stdout = os.Stderr
+	c.Stderr = io.MultiWriter(os.Stderr, &stderr)
+
+	err := c.Run()
+	if err == nil {
+		return 0, nil
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, fmt.Errorf("error running makemanyfiles: %w", err)
+	}
+
+	m := failedVerificationRE.FindSubmatch(stderr.Bytes())
+	if m == nil {
+		return 0, fmt.Errorf("makemanyfiles failed: %w", err)
+	}
+
+	return strconv.Atoi(string(m[1]))
+}
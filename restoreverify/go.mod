@@ -0,0 +1,3 @@
+module restoreverify
+
+go 1.18
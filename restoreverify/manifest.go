@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// datasetManifest is the subset of manifest written by 'makemanyfiles --manifest' used for verification.
+type datasetManifest struct {
+	Parameters  map[string]string `json:"parameters"`
+	FileCount   int               `json:"fileCount"`
+	TotalBytes  int64             `json:"totalBytes"`
+	ContentHash string            `json:"contentHash"`
+}
+
+// nonDatasetFlags are makemanyfiles flags which select output or mode rather than the dataset,
+// so they are not passed when verifying.
+var nonDatasetFlags = map[string]bool{
+	"output-dir": true, "output-targets": true, "output-format": true, "output-file": true,
+	"config": true, "stats": true, "verify": true, "delete": true, "churn": true, "mutate": true,
+	"append": true, "resume": true, "resume-verify": true, "quiet": true, "progress-interval": true,
+	"parallel": true, "fsync": true, "direct": true, "max-write-mbps": true,
+}
+
+func readManifest(fname string) (*datasetManifest, error) {
+	b, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest: %w", err)
+	}
+
+	m := &datasetManifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %v: %w", fname, err)
+	}
+
+	return m, nil
+}
+
+// derivedFlag returns true if the value of the flag recorded in the manifest was derived from other
+// flags, so passing it back would conflict with them: --num-files is computed from --total-size and
+// values of --profile are recorded as the flags it sets.
+func (m *datasetManifest) derivedFlag(name string) bool {
+	switch name {
+	case "num-files":
+		return m.Parameters["total-size"] != ""
+	case "profile":
+		return true
+	default:
+		return false
+	}
+}
+
+// generatorFlags returns makemanyfiles flags which regenerate the dataset described by the manifest.
+func (m *datasetManifest) generatorFlags() []string {
+	var flags []string
+
+	for name, v := range m.Parameters {
+		if !nonDatasetFlags[name] && !m.derivedFlag(name) {
+			flags = append(flags, fmt.Sprintf("--%v=%v", name, v))
+		}
+	}
+
+	sort.Strings(flags)
+
+	return flags
+}
+
+// verifyManifest compares number, total size and content hash of files under dir with the manifest,
+// computing the content hash the same way as makemanyfiles. The manifest doesn't describe individual
+// files, so the number of mismatched files is a lower bound.
+func verifyManifest(dir string, m *datasetManifest) (verifyResult, error) {
+	var res verifyResult
+
+	manifestContents, err := os.ReadFile(*manifestFile)
+	if err != nil {
+		return res, err
+	}
+
+	var paths []string
+
+	if err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.Type().IsRegular() {
+			paths = append(paths, p)
+		}
+
+		return nil
+	}); err != nil {
+		return res, err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		hash     [sha256.Size]byte
+	)
+
+	for w := 0; w < *parallel; w++ {
+		wg.Add(1)
+
+		go func(w int) {
+			defer wg.Done()
+
+			for i := w; i < len(paths); i += *parallel {
+				rel, size, sum, err := hashFile(dir, paths[i], manifestContents)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+
+				if err == nil && rel != "" {
+					res.files++
+					res.bytes += size
+
+					for j := range hash {
+						hash[j] ^= sum[j]
+					}
+				}
+				mu.Unlock()
+			}
+		}(w)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return res, firstErr
+	}
+
+	if res.files != m.FileCount || res.bytes != m.TotalBytes {
+		log.Printf("restored %v files (%v bytes), manifest has %v files (%v bytes)", res.files, res.bytes, m.FileCount, m.TotalBytes)
+	}
+
+	if hex.EncodeToString(hash[:]) != m.ContentHash {
+		log.Printf("content hash of restored files doesn't match manifest")
+
+		res.mismatchedFiles = res.files - m.FileCount
+		if res.mismatchedFiles < 0 {
+			res.mismatchedFiles = -res.mismatchedFiles
+		}
+
+		if res.mismatchedFiles == 0 {
+			res.mismatchedFiles = 1
+		}
+	}
+
+	return res, nil
+}
+
+// hashFile returns the relative path, size and hash of (path, content hash) of the file as computed
+// by makemanyfiles. A copy of the manifest at the top of the tree is skipped with empty path, since
+// makemanyfiles doesn't include it when writing the manifest to the output directory.
+func hashFile(dir, fname string, manifestContents []byte) (string, int64, []byte, error) {
+	rel, err := filepath.Rel(dir, fname)
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	rel = path.Clean(filepath.ToSlash(rel))
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+
+	var buf bytes.Buffer
+
+	w := io.Writer(h)
+
+	isManifestCopy := rel == filepath.Base(*manifestFile)
+	if isManifestCopy {
+		w = io.MultiWriter(h, &buf)
+	}
+
+	n, err := io.Copy(w, f)
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	if isManifestCopy && bytes.Equal(buf.Bytes(), manifestContents) {
+		return "", 0, nil, nil
+	}
+
+	eh := sha256.New()
+	io.WriteString(eh, rel)
+	eh.Write([]byte{0})
+	eh.Write(h.Sum(nil))
+
+	return rel, n, eh.Sum(nil), nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testdata/dataset and its manifest were written by:
+//
+//	makemanyfiles --output-dir=testdata/dataset --num-files=6 --file-length=64 --shard1=2 --manifest=testdata/dataset/manifest.json
+const testDatasetDir = "testdata/dataset"
+
+func TestVerifyManifest(t *testing.T) {
+	cases := []struct {
+		name           string
+		mutate         func(t *testing.T, dir string)
+		wantFiles      int
+		wantBytes      int64
+		wantMismatched int
+	}{
+		{
+			name:      "unchanged",
+			mutate:    func(t *testing.T, dir string) {},
+			wantFiles: 6,
+			wantBytes: 384,
+		},
+		{
+			name: "manifest copy removed",
+			mutate: func(t *testing.T, dir string) {
+				removeFile(t, filepath.Join(dir, "manifest.json"))
+			},
+			wantFiles: 6,
+			wantBytes: 384,
+		},
+		{
+			name: "corrupted file",
+			mutate: func(t *testing.T, dir string) {
+				fname := filepath.Join(dir, "5f", "466d7afa48b619c7045d54b15d8d48f47e401335078e9267f5e1d942e09ca5")
+
+				b, err := os.ReadFile(fname)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				b[10] ^= 1
+
+				if err := os.WriteFile(fname, b, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantFiles:      6,
+			wantBytes:      384,
+			wantMismatched: 1,
+		},
+		{
+			name: "missing file",
+			mutate: func(t *testing.T, dir string) {
+				removeFile(t, filepath.Join(dir, "99", "de02da7f6c27f934fe3cf67d151bac7196348e3fbfbb610f385709bbc94166"))
+			},
+			wantFiles:      5,
+			wantBytes:      320,
+			wantMismatched: 1,
+		},
+		{
+			name: "renamed file",
+			mutate: func(t *testing.T, dir string) {
+				old := filepath.Join(dir, "d6", "dfa7cf6edb1bf7217e986d65bf430fa60c913af06104e534911c829e710f1a")
+				if err := os.Rename(old, filepath.Join(dir, "d6", "renamed")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantFiles:      6,
+			wantBytes:      384,
+			wantMismatched: 1,
+		},
+		{
+			name: "extra file",
+			mutate: func(t *testing.T, dir string) {
+				if err := os.WriteFile(filepath.Join(dir, "extra"), []byte("extra"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantFiles:      7,
+			wantBytes:      389,
+			wantMismatched: 1,
+		},
+	}
+
+	saved := *manifestFile
+	defer func() { *manifestFile = saved }()
+
+	*manifestFile = filepath.Join(testDatasetDir, "manifest.json")
+
+	m, err := readManifest(*manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := copyTree(t, testDatasetDir)
+			tc.mutate(t, dir)
+
+			res, err := verifyManifest(dir, m)
+			if err != nil {
+				t.Fatalf("verifyManifest() failed: %v", err)
+			}
+
+			want := verifyResult{files: tc.wantFiles, bytes: tc.wantBytes, mismatchedFiles: tc.wantMismatched}
+			if res != want {
+				t.Errorf("verifyManifest() = %+v, want %+v", res, want)
+			}
+		})
+	}
+}
+
+func TestGeneratorFlags(t *testing.T) {
+	cases := []struct {
+		name   string
+		params map[string]string
+		want   []string
+	}{
+		{
+			name:   "output and mode flags are dropped",
+			params: map[string]string{"num-files": "6", "output-dir": "/tmp/x", "verify": "false", "parallel": "4", "seed": "1"},
+			want:   []string{"--num-files=6", "--seed=1"},
+		},
+		{
+			name:   "num-files derived from total-size is dropped",
+			params: map[string]string{"num-files": "16", "total-size": "64K", "file-length": "4096"},
+			want:   []string{"--file-length=4096", "--total-size=64K"},
+		},
+		{
+			name:   "num-files without total-size is kept",
+			params: map[string]string{"num-files": "16", "total-size": "", "file-length": "4096"},
+			want:   []string{"--file-length=4096", "--num-files=16", "--total-size="},
+		},
+		{
+			name:   "profile is dropped",
+			params: map[string]string{"num-files": "5", "profile": "photos", "file-length": "3000000"},
+			want:   []string{"--file-length=3000000", "--num-files=5"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &datasetManifest{Parameters: tc.params}
+
+			if got := m.generatorFlags(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("generatorFlags() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGeneratorFlagsOfManifest(t *testing.T) {
+	m, err := readManifest(filepath.Join(testDatasetDir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags := map[string]bool{}
+	for _, f := range m.generatorFlags() {
+		flags[f] = true
+	}
+
+	for _, f := range []string{"--num-files=6", "--file-length=64", "--shard1=2", "--seed=123"} {
+		if !flags[f] {
+			t.Errorf("generatorFlags() doesn't include %v", f)
+		}
+	}
+
+	for _, f := range []string{"--output-dir=testdata/dataset", "--parallel=4", "--quiet=false"} {
+		if flags[f] {
+			t.Errorf("generatorFlags() includes %v", f)
+		}
+	}
+}
+
+// testdata/totalsize-manifest.json was written by:
+//
+//	makemanyfiles --output-dir=/tmp/tsds --total-size=64K --file-length=4096 --manifest=testdata/totalsize-manifest.json
+func TestGeneratorFlagsOfTotalSizeManifest(t *testing.T) {
+	m, err := readManifest(filepath.Join("testdata", "totalsize-manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags := map[string]bool{}
+	for _, f := range m.generatorFlags() {
+		flags[f] = true
+	}
+
+	for _, f := range []string{"--total-size=64K", "--file-length=4096"} {
+		if !flags[f] {
+			t.Errorf("generatorFlags() doesn't include %v", f)
+		}
+	}
+
+	for _, f := range []string{"--num-files=16", "--profile="} {
+		if flags[f] {
+			t.Errorf("generatorFlags() includes %v, which conflicts with --total-size", f)
+		}
+	}
+}
+
+// copyTree copies files under dir to a temporary directory.
+func copyTree(t *testing.T, dir string) string {
+	t.Helper()
+
+	dst := t.TempDir()
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0o755)
+		}
+
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(filepath.Join(dst, rel), b, 0o644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dst
+}
+
+func removeFile(t *testing.T, fname string) {
+	t.Helper()
+
+	if err := os.Remove(fname); err != nil {
+		t.Fatal(err)
+	}
+}
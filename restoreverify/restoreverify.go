@@ -0,0 +1,186 @@
+// Command restoreverify restores a kopia snapshot to a scratch directory and verifies contents
+// of restored files, emitting InfluxDB-formatted measurements of restore and verification
+// duration and the number of mismatches.
+//
+// Usage: restoreverify --snapshot=<id> [--manifest=<file>] [--makemanyfiles-exe=<path> --dataset-flags=<flags>]
+//
+// With --manifest pointing at a manifest written by 'makemanyfiles --manifest', the number and total
+// size of restored files and the content hash of the restored tree are compared with the manifest.
+//
+// With --makemanyfiles-exe each restored file is verified against the deterministic generator by
+// running 'makemanyfiles --verify' with --dataset-flags, which must be the flags the dataset was
+// generated with (without --output-dir). When --manifest is also given and --dataset-flags is not,
+// the flags are taken from the manifest.
+//
+// Measurements are written to --output (stdout by default) as a single 'restore_verify' line,
+// the command fails after writing it if verification found any mismatches. Restored files are
+// removed unless --keep is given or the command fails, so that mismatches can be investigated.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var (
+	kopiaExe         = flag.String("kopia-exe", os.ExpandEnv("$HOME/go/bin/kopia"), "Path to kopia")
+	snapshotID       = flag.String("snapshot", "", "ID of the snapshot (or snapshot root object) to restore")
+	restoreDir       = flag.String("restore-dir", "", "Directory to restore to, must not exist (default: new temporary directory)")
+	keep             = flag.Bool("keep", false, "Keep restored files")
+	manifestFile     = flag.String("manifest", "", "Manifest written by 'makemanyfiles --manifest' to verify restored tree against")
+	makeManyFilesExe = flag.String("makemanyfiles-exe", "", "Path to makemanyfiles used to verify contents of each file")
+	datasetFlags     = flag.String("dataset-flags", "", "Space-separated makemanyfiles flags the dataset was generated with")
+	parallel         = flag.Int("parallel", 8, "Number of files hashed in parallel")
+	outputFile       = flag.String("output", "", "File to append measurements to (default: stdout)")
+	runTags          = flag.String("run-tags", "", "Comma-separated list of tags to attach to measurements")
+)
+
+// verifyResult is the outcome of one verification method.
+type verifyResult struct {
+	files           int
+	bytes           int64
+	mismatchedFiles int
+}
+
+func main() {
+	flag.Parse()
+
+	if *snapshotID == "" {
+		log.Fatal("missing --snapshot")
+	}
+
+	if *manifestFile == "" && *makeManyFilesExe == "" {
+		log.Fatal("at least one of --manifest or --makemanyfiles-exe is required")
+	}
+
+	dir := *restoreDir
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "restoreverify")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// kopia restores into a new directory.
+		dir = tmp + "/restored"
+
+		defer os.RemoveAll(tmp)
+	}
+
+	if !*keep {
+		defer os.RemoveAll(dir)
+	}
+
+	t0 := time.Now()
+
+	if err := restore(dir); err != nil {
+		log.Fatal(err)
+	}
+
+	restoreDuration := time.Since(t0)
+	log.Printf("restored %v to %v in %v", *snapshotID, dir, restoreDuration)
+
+	t1 := time.Now()
+
+	res, err := verify(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	verifyDuration := time.Since(t1)
+	log.Printf("verified %v files (%v bytes) in %v, %v mismatched", res.files, res.bytes, verifyDuration, res.mismatchedFiles)
+
+	if err := writeMeasurement(restoreDuration, verifyDuration, res); err != nil {
+		log.Fatal(err)
+	}
+
+	if res.mismatchedFiles > 0 {
+		log.Fatalf("verification of %v failed", *snapshotID)
+	}
+}
+
+func restore(dir string) error {
+	c := exec.Command(*kopiaExe, "snapshot", "restore", *snapshotID, dir)
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("error restoring snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// verify verifies restored files using all requested methods.
+func verify(dir string) (verifyResult, error) {
+	var (
+		res verifyResult
+		m   *datasetManifest
+		err error
+	)
+
+	if *manifestFile != "" {
+		if m, err = readManifest(*manifestFile); err != nil {
+			return res, err
+		}
+
+		if res, err = verifyManifest(dir, m); err != nil {
+			return res, err
+		}
+	}
+
+	if *makeManyFilesExe != "" {
+		flags := strings.Fields(*datasetFlags)
+		if len(flags) == 0 && m != nil {
+			flags = m.generatorFlags()
+		}
+
+		failed, err := verifyGenerator(dir, flags)
+		if err != nil {
+			return res, err
+		}
+
+		if failed > res.mismatchedFiles {
+			res.mismatchedFiles = failed
+		}
+	}
+
+	return res, nil
+}
+
+func writeMeasurement(restoreDuration, verifyDuration time.Duration, res verifyResult) error {
+	var w io.Writer = os.Stdout
+
+	if *outputFile != "" {
+		f, err := os.OpenFile(*outputFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		w = f
+	}
+
+	tags := "snapshot=" + *snapshotID
+	if *runTags != "" {
+		tags += "," + *runTags
+	}
+
+	_, err := fmt.Fprintf(w, "restore_verify,%v restore_duration=%.1f,verify_duration=%.1f,num_files=%v,total_bytes=%v,mismatched_files=%v %v\n",
+		tags,
+		restoreDuration.Seconds(),
+		verifyDuration.Seconds(),
+		res.files,
+		res.bytes,
+		res.mismatchedFiles,
+		time.Now().UnixNano(),
+	)
+
+	return err
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// builder builds kopia from refs of a git repository, caching binaries by commit.
+//
+// The repository is cloned to <dir>/src and binaries are written to <dir>/bin/<commit>/kopia.
+type builder struct {
+	repo string
+	dir  string
+	keep int // number of most recently used binaries kept, zero keeps all
+}
+
+// build returns the path to the kopia binary built from the ref.
+func (b *builder) build(ctx context.Context, ref string) (string, error) {
+	src := filepath.Join(b.dir, "src")
+
+	if _, err := os.Stat(filepath.Join(src, ".git")); os.IsNotExist(err) {
+		log.Printf("cloning %v", b.repo)
+
+		if err := b.git(ctx, "", "clone", "--quiet", b.repo, src); err != nil {
+			return "", err
+		}
+	} else if err := b.git(ctx, src, "fetch", "--quiet", "--tags", "--force", "origin"); err != nil {
+		return "", err
+	}
+
+	commit, err := b.resolve(ctx, src, ref)
+	if err != nil {
+		return "", err
+	}
+
+	exe := filepath.Join(b.dir, "bin", commit, "kopia")
+
+	if _, err := os.Stat(exe); err == nil {
+		log.Printf("using cached build of %v (%v)", ref, commit)
+
+		// mark the build as recently used.
+		return exe, b.touchAndPrune(exe)
+	}
+
+	log.Printf("building %v (%v)", ref, commit)
+
+	if err := b.git(ctx, src, "checkout", "--quiet", "--force", "--detach", commit); err != nil {
+		return "", err
+	}
+
+	c := exec.CommandContext(ctx, *goExe, "build", "-o", exe, ".")
+	c.Dir = src
+
+	if out, err := c.CombinedOutput(); err != nil {
+		return "", errors.Wrapf(err, "go build failed: %s", out)
+	}
+
+	return exe, b.touchAndPrune(exe)
+}
+
+// resolve returns the commit of the ref, branches are resolved to their remote-tracking
+// branch so that fetched changes are picked up.
+func (b *builder) resolve(ctx context.Context, src, ref string) (string, error) {
+	for _, r := range []string{"origin/" + ref, ref} {
+		c := exec.CommandContext(ctx, "git", "rev-parse", "--verify", "--quiet", r+"^{commit}")
+		c.Dir = src
+
+		if out, err := c.Output(); err == nil {
+			return strings.TrimSpace(string(out)), nil
+		}
+	}
+
+	return "", errors.Errorf("unknown ref %q", ref)
+}
+
+func (b *builder) git(ctx context.Context, dir string, args ...string) error {
+	c := exec.CommandContext(ctx, "git", args...)
+	c.Dir = dir
+
+	out, err := c.CombinedOutput()
+
+	return errors.Wrapf(err, "git %v failed: %s", args[0], out)
+}
+
+// touchAndPrune updates modification time of the binary and removes least recently used
+// binaries beyond the number of kept builds.
+func (b *builder) touchAndPrune(exe string) error {
+	now := time.Now()
+
+	if err := os.Chtimes(exe, now, now); err != nil {
+		return err
+	}
+
+	if b.keep <= 0 {
+		return nil
+	}
+
+	dirs, err := filepath.Glob(filepath.Join(b.dir, "bin", "*"))
+	if err != nil {
+		return err
+	}
+
+	type build struct {
+		dir     string
+		modTime int64
+	}
+
+	var builds []build
+
+	for _, d := range dirs {
+		st, err := os.Stat(filepath.Join(d, "kopia"))
+		if err != nil {
+			// incomplete build.
+			builds = append(builds, build{d, 0})
+			continue
+		}
+
+		builds = append(builds, build{d, st.ModTime().UnixNano()})
+	}
+
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].modTime > builds[j].modTime
+	})
+
+	for i := b.keep; i < len(builds); i++ {
+		log.Printf("removing old build %v", builds[i].dir)
+
+		if err := os.RemoveAll(builds[i].dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
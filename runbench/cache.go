@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"runbench/pkg/bench"
+)
+
+// With --cache-states=cold,warm each scenario is measured in both cache states. Before each cold
+// run kopia's cache is cleared and the page cache of the host dropped (which requires root), warm
+// runs follow the usual unmeasured warmup run.
+var cacheStates = flag.String("cache-states", "", "Comma-separated list of cache states to measure each scenario in (cold, warm), results are tagged with 'cache'")
+
+const (
+	cacheCold = "cold"
+	cacheWarm = "warm"
+)
+
+// parseCacheStates returns the states of --cache-states, or a single empty state when not measuring
+// cache states explicitly.
+func parseCacheStates() ([]string, error) {
+	if *cacheStates == "" {
+		return []string{""}, nil
+	}
+
+	states := strings.Split(*cacheStates, ",")
+
+	for _, s := range states {
+		if s != cacheCold && s != cacheWarm {
+			return nil, errors.Errorf("unsupported cache state %q", s)
+		}
+	}
+
+	return states, nil
+}
+
+func cacheTags(cache string) []bench.Tag {
+	if cache == "" {
+		return nil
+	}
+
+	return []bench.Tag{{Key: "cache", Value: cache}}
+}
+
+// clearCaches clears the cache of the kopia repository used by the measured command and drops
+// the page cache of the host. Only the page cache is dropped for generic commands.
+func (ss *scenarioState) clearCaches(ctx context.Context, exe string, args []string) error {
+	if ss.generic {
+		return errors.Wrap(bench.DropPageCache(), "unable to drop page cache")
+	}
+
+	clearArgs := []string{"cache", "clear"}
+
+	for i, a := range args {
+		if strings.HasPrefix(a, "--config-file=") {
+			clearArgs = append(clearArgs, a)
+		} else if a == "--config-file" && i+1 < len(args) {
+			clearArgs = append(clearArgs, a, args[i+1])
+		}
+	}
+
+	c := exec.CommandContext(ctx, exe, clearArgs...)
+	c.Env = kopiaEnv(exe)
+
+	if out, err := c.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "unable to clear kopia cache: %s", out)
+	}
+
+	return errors.Wrap(bench.DropPageCache(), "unable to drop page cache")
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/google/shlex"
+	"github.com/pkg/errors"
+
+	"runbench/pkg/bench"
+)
+
+var (
+	sourceChurn      = flag.String("churn", "", "Mutate the source while the measured command is running using 'makemanyfiles --churn' with these arguments, e.g. '--output-dir=/data/src --num-files=100000 --churn-rate=50 --change-pct=100'")
+	makeManyFilesExe = flag.String("makemanyfiles-exe", "makemanyfiles", "Path to makemanyfiles used for --churn")
+)
+
+// marker that can be put in a script to override --churn for the scenario, for example:
+//
+//	# CHURN: --output-dir=$DATASET_DIR --num-files=100000 --churn-rate=50 --change-pct=100
+//
+// Operations of the churn and its CPU and memory usage are emitted as source_churn_summary.
+const churnMarker = `# CHURN:`
+
+// churnStats describes mutations of the source made during a single run and resources used
+// to make them.
+type churnStats struct {
+	ops    int64
+	avgCPU float64
+	maxRAM float64
+}
+
+// churner runs 'makemanyfiles --churn' while the measured command is running, so that the command
+// observes a live-changing source. The churning process is sampled like the measured one and its
+// operations are counted from the summary it logs when interrupted.
+type churner struct {
+	args []string
+
+	cmd     *exec.Cmd
+	done    chan error
+	monitor *bench.Monitor
+	stderr  bytes.Buffer
+}
+
+var churnSummaryRegexp = regexp.MustCompile(`changed (\d+), added (\d+) and deleted (\d+) files`)
+
+func newChurner(spec string) (*churner, error) {
+	args, err := shlex.Split(os.Expand(spec, lookupScenarioEnv))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid churn arguments")
+	}
+
+	return &churner{args: append([]string{"--churn"}, args...)}, nil
+}
+
+// start starts mutating the source.
+func (c *churner) start(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+
+	c.stderr.Reset()
+	c.cmd = exec.CommandContext(ctx, *makeManyFilesExe, c.args...)
+	c.cmd.Stderr = io.MultiWriter(os.Stderr, &c.stderr)
+
+	if err := c.cmd.Start(); err != nil {
+		return errors.Wrap(err, "unable to start churn")
+	}
+
+	c.done = make(chan error, 1)
+
+	go func() {
+		c.done <- c.cmd.Wait()
+	}()
+
+	ps, err := bench.NewProcessSampler(ctx, c.cmd.Process.Pid)
+	if err != nil {
+		c.cmd.Process.Kill()
+		<-c.done
+
+		return err
+	}
+
+	c.monitor = bench.StartMonitor(ctx, *samplingInterval, ps)
+
+	return nil
+}
+
+// stop interrupts the churning process and returns the statistics of the run.
+func (c *churner) stop() (churnStats, error) {
+	if c == nil {
+		return churnStats{}, nil
+	}
+
+	res := c.monitor.Stop()
+
+	// the process may have already exited after its --churn-duration.
+	_ = c.cmd.Process.Signal(os.Interrupt)
+
+	select {
+	case err := <-c.done:
+		if err != nil {
+			return churnStats{}, errors.Wrapf(err, "churn failed: %s", c.stderr.Bytes())
+		}
+
+	case <-time.After(time.Minute):
+		c.cmd.Process.Kill()
+		<-c.done
+
+		return churnStats{}, errors.New("churn did not stop")
+	}
+
+	st := churnStats{}
+
+	if m := churnSummaryRegexp.FindSubmatch(c.stderr.Bytes()); m != nil {
+		for _, v := range m[1:] {
+			n, _ := strconv.ParseInt(string(v), 10, 64)
+			st.ops += n
+		}
+	}
+
+	if len(res.Samples) > 0 {
+		s := bench.Summarize([]*bench.Result{res})
+		st.avgCPU, st.maxRAM = s.AvgCPU, s.MaxRAM
+	}
+
+	return st, nil
+}
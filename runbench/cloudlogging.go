@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/compute/metadata"
+	"cloud.google.com/go/logging"
+	"github.com/pkg/errors"
+)
+
+// When running on GCE, logs are also sent to Cloud Logging as structured entries labeled
+// with scenario, revision and run index. When running on EC2 with --cloudwatch-log-group,
+// the same entries are sent to CloudWatch Logs.
+var disableCloudLogging = flag.Bool("disable-cloud-logging", false, "Disable sending logs to Cloud Logging when running in the cloud")
+
+// structuredLogWriter receives lines written to the global logger, echoes them to stderr
+// and forwards each one as a structured entry labeled with the current scenario, revision and run index.
+type structuredLogWriter struct {
+	mu     sync.Mutex
+	labels map[string]string
+	muted  bool
+
+	send  func(severity logging.Severity, labels map[string]string, msg string)
+	flush func()
+}
+
+var cloudLog *structuredLogWriter
+
+func (w *structuredLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	muted := w.muted
+	labels := w.copyLabelsLocked()
+	w.mu.Unlock()
+
+	if !muted {
+		msg := strings.TrimSuffix(string(p), "\n")
+		w.send(logSeverity(msg), labels, msg)
+	}
+
+	return os.Stderr.Write(p)
+}
+
+// logTimestampRegexp matches the date and time written by the global logger with the default flags.
+var logTimestampRegexp = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} `)
+
+// logSeverity returns the severity of a line written to the global logger, which has no levels,
+// so failures are recognized by their wording, e.g. "unable to ..." or "scenario ... failed: ...".
+func logSeverity(msg string) logging.Severity {
+	msg = strings.ToLower(logTimestampRegexp.ReplaceAllString(msg, ""))
+
+	hasPrefix := func(prefixes ...string) bool {
+		for _, p := range prefixes {
+			if strings.HasPrefix(msg, p) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	switch {
+	case hasPrefix("fatal", "panic"):
+		return logging.Critical
+	case hasPrefix("error", "failed", "unable to"), strings.Contains(msg, " failed: "), strings.Contains(msg, ": unable to "):
+		return logging.Error
+	case hasPrefix("warning"):
+		return logging.Warning
+	default:
+		return logging.Info
+	}
+}
+
+func (w *structuredLogWriter) copyLabelsLocked() map[string]string {
+	res := map[string]string{}
+	for k, v := range w.labels {
+		res[k] = v
+	}
+
+	return res
+}
+
+// logFatal sends the error synchronously with critical severity, subsequent lines are only written to stderr.
+func (w *structuredLogWriter) logFatal(err error) {
+	w.mu.Lock()
+	labels := w.copyLabelsLocked()
+	w.muted = true
+	w.mu.Unlock()
+
+	w.send(logging.Critical, labels, err.Error())
+	w.flush()
+}
+
+// setLogLabel attaches a label to all subsequent structured log entries, empty value removes the label.
+func setLogLabel(key, value string) {
+	if cloudLog == nil {
+		return
+	}
+
+	cloudLog.mu.Lock()
+	defer cloudLog.mu.Unlock()
+
+	if value == "" {
+		delete(cloudLog.labels, key)
+	} else {
+		cloudLog.labels[key] = value
+	}
+}
+
+// setupCloudLogging redirects the global logger to Cloud Logging when running on GCE or to
+// CloudWatch Logs when running on EC2 and returns a function that flushes and closes the client.
+func setupCloudLogging(ctx context.Context) (func(), error) {
+	if *disableCloudLogging {
+		return func() {}, nil
+	}
+
+	if metadata.OnGCE() {
+		return setupGCPLogging(ctx)
+	}
+
+	return setupCloudWatchLogging(ctx)
+}
+
+func installStructuredLogWriter(
+	send func(severity logging.Severity, labels map[string]string, msg string),
+	flush func(),
+) {
+	cloudLog = &structuredLogWriter{
+		labels: map[string]string{},
+		send:   send,
+		flush:  flush,
+	}
+
+	log.SetOutput(cloudLog)
+}
+
+func setupGCPLogging(ctx context.Context) (func(), error) {
+	projectID, err := metadata.ProjectID()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to determine project ID")
+	}
+
+	client, err := logging.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create logging client")
+	}
+
+	logger := client.Logger("runbench")
+
+	installStructuredLogWriter(
+		func(severity logging.Severity, labels map[string]string, msg string) {
+			logger.Log(logging.Entry{
+				Severity: severity,
+				Labels:   labels,
+				Payload:  msg,
+			})
+		},
+		func() {
+			_ = logger.Flush()
+		},
+	)
+
+	return func() {
+		log.SetOutput(os.Stderr)
+		client.Close()
+	}, nil
+}
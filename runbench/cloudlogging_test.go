@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestLogSeverity(t *testing.T) {
+	cases := []struct {
+		line string
+		want logging.Severity
+	}{
+		{"2022/07/01 10:00:00 Running scenario snapshot-linux-parallel-1", logging.Info},
+		{"Running scenario snapshot-linux-parallel-1", logging.Info},
+		{"2022/07/01 10:00:00 scenario snapshot.sh failed: exit status 1", logging.Error},
+		{"2022/07/01 10:00:00 FAILED snapshot.sh: exit status 1", logging.Error},
+		{"unable to build v0.11.0: exit status 2", logging.Error},
+		{"2022/07/01 10:00:00 network shaping: unable to connect to 127.0.0.1:9000: connection refused", logging.Error},
+		{"2022/07/01 10:00:00 failed with memory limit 256M: signal: killed", logging.Error},
+		{"fatal error: out of memory", logging.Critical},
+		{"warning: clock skew detected", logging.Warning},
+		{"2022/07/01 10:00:00 no heap profile of run-1.log: no such file", logging.Info},
+	}
+
+	for _, tc := range cases {
+		if got := logSeverity(tc.line); got != tc.want {
+			t.Errorf("logSeverity(%q) = %v, want %v", tc.line, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/pkg/errors"
+)
+
+var cloudWatchLogGroup = flag.String("cloudwatch-log-group", "", "CloudWatch Logs group to send logs to when running on EC2")
+
+const (
+	cloudWatchFlushInterval = 5 * time.Second
+	imdsProbeTimeout        = 2 * time.Second
+
+	// limits of a single PutLogEvents call, the size of an event is its message plus 26 bytes.
+	cloudWatchMaxBatchEvents = 10000
+	cloudWatchMaxBatchBytes  = 1 << 20
+	cloudWatchEventOverhead  = 26
+
+	// cloudWatchMaxPending is the maximum number of buffered events, oldest events are dropped
+	// when CloudWatch is unreachable for long enough to exceed it.
+	cloudWatchMaxPending = 100000
+)
+
+// cloudWatchBatcher buffers log events and periodically uploads them to a single log stream.
+type cloudWatchBatcher struct {
+	client *cloudwatchlogs.Client
+	group  string
+	stream string
+
+	mu      sync.Mutex
+	pending []types.InputLogEvent
+	dropped int
+
+	// sendMu serializes uploads, which must pass the sequence token returned by the previous one.
+	sendMu        sync.Mutex
+	sequenceToken *string
+}
+
+func (b *cloudWatchBatcher) add(severity logging.Severity, labels map[string]string, msg string) {
+	// CloudWatch has no notion of labels, so each event is a JSON document which can be filtered with
+	// metric filters and Logs Insights queries, for example: { $.labels.scenario = "snapshot-linux-parallel-1" }
+	payload, _ := json.Marshal(struct {
+		Severity string            `json:"severity"`
+		Labels   map[string]string `json:"labels,omitempty"`
+		Message  string            `json:"message"`
+	}{severity.String(), labels, msg})
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending = append(b.pending, types.InputLogEvent{
+		Message:   aws.String(string(payload)),
+		Timestamp: aws.Int64(time.Now().UnixMilli()),
+	})
+	b.trimLocked()
+}
+
+// trimLocked drops the oldest pending events above cloudWatchMaxPending.
+func (b *cloudWatchBatcher) trimLocked() {
+	if n := len(b.pending) - cloudWatchMaxPending; n > 0 {
+		b.pending = append([]types.InputLogEvent(nil), b.pending[n:]...)
+		b.dropped += n
+	}
+}
+
+// flush uploads pending events in batches within the limits of PutLogEvents. Events which
+// failed to upload are put back to be retried by the next flush.
+func (b *cloudWatchBatcher) flush() {
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+
+	b.mu.Lock()
+	events, dropped := b.pending, b.dropped
+	b.pending, b.dropped = nil, 0
+	b.mu.Unlock()
+
+	// can't use the global logger here since it would recurse into the batcher.
+	if dropped > 0 {
+		fmt.Fprintf(os.Stderr, "dropped %v log events which couldn't be sent to CloudWatch\n", dropped)
+	}
+
+	for len(events) > 0 {
+		n := cloudWatchBatchLen(events)
+
+		out, err := b.client.PutLogEvents(context.Background(), &cloudwatchlogs.PutLogEventsInput{
+			LogGroupName:  aws.String(b.group),
+			LogStreamName: aws.String(b.stream),
+			LogEvents:     events[0:n],
+			SequenceToken: b.sequenceToken,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to send logs to CloudWatch: %v\n", err)
+
+			b.mu.Lock()
+			b.pending = append(events, b.pending...)
+			b.trimLocked()
+			b.mu.Unlock()
+
+			return
+		}
+
+		b.sequenceToken = out.NextSequenceToken
+		events = events[n:]
+	}
+}
+
+// cloudWatchBatchLen returns the number of leading events which fit in a single PutLogEvents call,
+// at least one.
+func cloudWatchBatchLen(events []types.InputLogEvent) int {
+	size := 0
+
+	for i, e := range events {
+		size += len(aws.ToString(e.Message)) + cloudWatchEventOverhead
+
+		if i > 0 && (i == cloudWatchMaxBatchEvents || size > cloudWatchMaxBatchBytes) {
+			return i
+		}
+	}
+
+	return len(events)
+}
+
+// ec2InstanceID returns the ID of EC2 instance we're running on or empty string if not running on EC2.
+func ec2InstanceID(ctx context.Context, cfg aws.Config) string {
+	ctx, cancel := context.WithTimeout(ctx, imdsProbeTimeout)
+	defer cancel()
+
+	out, err := imds.NewFromConfig(cfg).GetMetadata(ctx, &imds.GetMetadataInput{Path: "instance-id"})
+	if err != nil {
+		return ""
+	}
+	defer out.Content.Close()
+
+	b, err := io.ReadAll(out.Content)
+	if err != nil {
+		return ""
+	}
+
+	return string(b)
+}
+
+func setupCloudWatchLogging(ctx context.Context) (func(), error) {
+	if *cloudWatchLogGroup == "" {
+		return func() {}, nil
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithEC2IMDSRegion())
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load AWS config")
+	}
+
+	instanceID := ec2InstanceID(ctx, cfg)
+	if instanceID == "" {
+		return func() {}, nil
+	}
+
+	b := &cloudWatchBatcher{
+		client: cloudwatchlogs.NewFromConfig(cfg),
+		group:  *cloudWatchLogGroup,
+		stream: fmt.Sprintf("runbench-%v-%v", instanceID, time.Now().UTC().Format("20060102-150405")),
+	}
+
+	if _, err := b.client.CreateLogStream(ctx, &cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String(b.group),
+		LogStreamName: aws.String(b.stream),
+	}); err != nil {
+		return nil, errors.Wrapf(err, "unable to create log stream %q in %q", b.stream, b.group)
+	}
+
+	done := make(chan struct{})
+	flushed := make(chan struct{})
+
+	go func() {
+		defer close(flushed)
+
+		t := time.NewTicker(cloudWatchFlushInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				b.flush()
+
+			case <-done:
+				b.flush()
+				return
+			}
+		}
+	}()
+
+	installStructuredLogWriter(b.add, b.flush)
+
+	return func() {
+		log.SetOutput(os.Stderr)
+		close(done)
+		<-flushed
+	}, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+func TestCloudWatchBatchLen(t *testing.T) {
+	events := func(n, size int) []types.InputLogEvent {
+		var res []types.InputLogEvent
+
+		for i := 0; i < n; i++ {
+			res = append(res, types.InputLogEvent{Message: aws.String(strings.Repeat("x", size))})
+		}
+
+		return res
+	}
+
+	cases := []struct {
+		name   string
+		events []types.InputLogEvent
+		want   int
+	}{
+		{"small", events(3, 100), 3},
+		{"event limit", events(cloudWatchMaxBatchEvents+5, 10), cloudWatchMaxBatchEvents},
+		{"size limit", events(10, 200<<10), 5},
+		{"exactly size limit", events(4, 256<<10-cloudWatchEventOverhead), 4},
+		{"oversized first event", events(2, 2<<20), 1},
+	}
+
+	for _, tc := range cases {
+		if got := cloudWatchBatchLen(tc.events); got != tc.want {
+			t.Errorf("%v: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCloudWatchBatcherDropsOldestPending(t *testing.T) {
+	b := &cloudWatchBatcher{}
+
+	for i := 0; i < cloudWatchMaxPending+3; i++ {
+		b.add(0, nil, "msg")
+	}
+
+	if len(b.pending) != cloudWatchMaxPending || b.dropped != 3 {
+		t.Errorf("got %v pending, %v dropped, want %v pending, 3 dropped", len(b.pending), b.dropped, cloudWatchMaxPending)
+	}
+}
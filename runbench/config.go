@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// benchConfig is the contents of the file passed via --config.
+//
+// Keys of both sections are flag names (without leading dashes), for example:
+//
+//	flags:
+//	  kopia-exe: /usr/local/bin/kopia
+//	  min-repeat: 5
+//	scenarios:
+//	  snapshot-linux-parallel-1:
+//	    min-duration: 10m
+//
+// Values in 'flags' provide defaults for flags not passed on the command line, values in
+// 'scenarios' are applied only while running the scenario with a matching name.
+type benchConfig struct {
+	Flags     map[string]string            `yaml:"flags"`
+	Scenarios map[string]map[string]string `yaml:"scenarios"`
+}
+
+var configFile = flag.String("config", "", "Path to YAML config file providing flag defaults and per-scenario overrides")
+
+var (
+	config benchConfig
+
+	// flags explicitly passed on the command line, these always take precedence over config file.
+	explicitFlags = map[string]bool{}
+)
+
+// loadConfig records flags passed on the command line and applies the config file.
+func loadConfig() error {
+	flag.Visit(func(f *flag.Flag) {
+		explicitFlags[f.Name] = true
+	})
+
+	return readConfig()
+}
+
+// readConfig reads the config file and applies its flag defaults.
+func readConfig() error {
+	if *configFile == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(*configFile)
+	if err != nil {
+		return errors.Wrap(err, "unable to read config file")
+	}
+
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return errors.Wrapf(err, "unable to parse config file %q", *configFile)
+	}
+
+	for scen, overrides := range config.Scenarios {
+		for name := range overrides {
+			if flag.Lookup(name) == nil {
+				return errors.Errorf("unknown flag %q in config for scenario %q", name, scen)
+			}
+		}
+	}
+
+	_, err = applyFlagValues(config.Flags)
+
+	return errors.Wrap(err, "invalid flags in config file")
+}
+
+// applyScenarioConfig applies per-scenario overrides from the config file and returns
+// a function that restores previous values.
+func applyScenarioConfig(scen string) (restore func(), err error) {
+	previous, err := applyFlagValues(config.Scenarios[scen])
+
+	// flag.Set would make restored flags explicit, which prevents reloading them from the config file.
+	restore = func() {
+		for name, v := range previous {
+			_ = flag.Lookup(name).Value.Set(v)
+		}
+	}
+
+	if err != nil {
+		restore()
+		return nil, errors.Wrapf(err, "invalid config for scenario %q", scen)
+	}
+
+	return restore, nil
+}
+
+// applyFlagValues sets the provided flag values, skipping flags that were explicitly passed
+// on the command line and returns previous values of flags that were changed.
+func applyFlagValues(values map[string]string) (map[string]string, error) {
+	previous := map[string]string{}
+
+	for name, v := range values {
+		f := flag.Lookup(name)
+		if f == nil {
+			return previous, errors.Errorf("unknown flag %q", name)
+		}
+
+		if name == "config" {
+			return previous, errors.Errorf("flag %q cannot be set in config file", name)
+		}
+
+		if explicitFlags[name] {
+			continue
+		}
+
+		old := f.Value.String()
+
+		if err := f.Value.Set(v); err != nil {
+			return previous, errors.Wrapf(err, "invalid value for flag %q", name)
+		}
+
+		previous[name] = old
+	}
+
+	return previous, nil
+}
+
+// reloadConfig re-reads the config file, flags which were set by the previous version of the file
+// are reset to their defaults first, so removing a key from the file takes effect.
+func reloadConfig() error {
+	for name := range config.Flags {
+		if f := flag.Lookup(name); f != nil && !explicitFlags[name] {
+			_ = f.Value.Set(f.DefValue)
+		}
+	}
+
+	config = benchConfig{}
+
+	return readConfig()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cronSchedule is a parsed cron expression with five fields: minute, hour, day of month, month
+// and day of week (0 is Sunday). Each field is '*', a number, a range 'a-b' or a comma-separated
+// list of those, optionally followed by a step '/n'.
+type cronSchedule struct {
+	fields [5][]bool
+
+	// whether day of month and day of week were restricted, if both are the day matches
+	// when either of them does, like in cron(8).
+	domRestricted bool
+	dowRestricted bool
+}
+
+var cronFieldRanges = [5]struct{ min, max int }{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 6},  // day of week
+}
+
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	if a, ok := cronAliases[expr]; ok {
+		expr = a
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFieldRanges) {
+		return nil, errors.Errorf("invalid schedule %q, expected 5 fields", expr)
+	}
+
+	cs := &cronSchedule{
+		domRestricted: parts[2] != "*",
+		dowRestricted: parts[4] != "*",
+	}
+
+	for i, p := range parts {
+		f, err := parseCronField(p, cronFieldRanges[i].min, cronFieldRanges[i].max)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid schedule %q", expr)
+		}
+
+		cs.fields[i] = f
+	}
+
+	if cs.next(time.Now()).IsZero() {
+		return nil, errors.Errorf("schedule %q never fires", expr)
+	}
+
+	return cs, nil
+}
+
+func parseCronField(s string, min, max int) ([]bool, error) {
+	res := make([]bool, max+1)
+
+	for _, item := range strings.Split(s, ",") {
+		rng, step := item, 1
+
+		if r, st, ok := strings.Cut(item, "/"); ok {
+			n, err := strconv.Atoi(st)
+			if err != nil || n <= 0 {
+				return nil, errors.Errorf("invalid step in %q", item)
+			}
+
+			rng, step = r, n
+		}
+
+		lo, hi := min, max
+
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+
+			v, err := strconv.Atoi(a)
+			if err != nil {
+				return nil, errors.Errorf("invalid value %q", item)
+			}
+
+			lo, hi = v, v
+
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return nil, errors.Errorf("invalid range %q", item)
+				}
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return nil, errors.Errorf("%q out of range %v-%v", item, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			res[v] = true
+		}
+	}
+
+	return res, nil
+}
+
+// next returns the first minute after t matching the schedule, or zero time if there's none.
+func (cs *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// every schedule matches at least once in 4 years (e.g. on February 29th).
+	for limit := t.AddDate(4, 0, 1); t.Before(limit); t = t.Add(time.Minute) {
+		if cs.matches(t) {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (cs *cronSchedule) matches(t time.Time) bool {
+	if !cs.fields[0][t.Minute()] || !cs.fields[1][t.Hour()] || !cs.fields[3][int(t.Month())] {
+		return false
+	}
+
+	dom, dow := cs.fields[2][t.Day()], cs.fields[4][int(t.Weekday())]
+
+	if cs.domRestricted && cs.dowRestricted {
+		return dom || dow
+	}
+
+	return dom && dow
+}
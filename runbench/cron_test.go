@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	base := time.Date(2022, 7, 14, 10, 30, 15, 0, time.UTC)
+
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2022, 7, 14, 10, 45, 0, 0, time.UTC)},
+		{"@daily", time.Date(2022, 7, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 3 * * 1-5", time.Date(2022, 7, 15, 3, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range cases {
+		cs, err := parseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tc.expr, err)
+		}
+
+		if got := cs.next(base); !got.Equal(tc.want) {
+			t.Errorf("next(%q) = %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestParseCronNeverFires(t *testing.T) {
+	for _, expr := range []string{"0 0 31 2 *", "0 0 30 2 *", "0 0 31 4,6,9,11 *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded", expr)
+		}
+	}
+}
+
+func TestScheduleAfterNoRun(t *testing.T) {
+	cs, err := parseCron("0 0 1 1 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no month matches anymore.
+	cs.fields[3] = make([]bool, len(cs.fields[3]))
+
+	j := &suiteJob{Name: "yearly", schedule: cs}
+
+	if err := j.scheduleAfter(time.Now()); err == nil {
+		t.Errorf("scheduled at %v", j.nextRun)
+	}
+
+	sc := &suiteConfig{Jobs: []suiteJob{*j}}
+
+	if err := sc.schedule(time.Now()); err == nil {
+		t.Errorf("scheduled at %v", sc.Jobs[0].nextRun)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"runbench/pkg/bench"
+)
+
+// marker that can be put in a script with several steps to also measure them as a single logical
+// run, for example a snapshot followed by maintenance. The combined run is emitted without the
+// 'step' tag: durations, repository growth and heap counters are summed and peak RSS is the
+// maximum across all steps.
+const cumulativeMarker = `# CUMULATIVE`
+
+// withCumulativeRuns prepends the combination of all steps of each run to results of the steps,
+// which is emitted without the 'step' tag like the result of a scenario with a single step.
+func (ss *scenarioState) withCumulativeRuns(steps []stepRuns) []stepRuns {
+	if !ss.cumulative || len(steps) < 2 {
+		return steps
+	}
+
+	total := stepRuns{}
+
+	for i := range steps[0].runs {
+		var rrs []*runResult
+
+		for _, sr := range steps {
+			rrs = append(rrs, sr.runs[i])
+		}
+
+		total.runs = append(total.runs, combineRuns(rrs))
+	}
+
+	return append([]stepRuns{total}, steps...)
+}
+
+// combineRuns returns the result of steps of a single run measured as one command: durations,
+// repository growth and counters are summed and samples of all steps are kept, so that peak RSS
+// is the maximum across steps. Repository size and Prometheus metrics
+// (including the ones of metrics endpoints) are the ones after the last step.
+func combineRuns(rrs []*runResult) *runResult {
+	last := rrs[len(rrs)-1]
+
+	res := &bench.Result{Prometheus: last.Prometheus, Endpoints: last.Endpoints}
+	c := &runResult{
+		Result:        res,
+		run:           rrs[0].run,
+		seed:          rrs[0].seed,
+		prepares:      rrs[0].prepares,
+		repoSizeBytes: last.repoSizeBytes,
+		numRepoFiles:  last.numRepoFiles,
+		blobTypes:     last.blobTypes,
+	}
+
+	for _, rr := range rrs {
+		res.Duration += rr.Duration
+		res.Samples = append(res.Samples, rr.Samples...)
+		res.SelfCPUSeconds += rr.SelfCPUSeconds
+		res.SamplingTime += rr.SamplingTime
+		res.SamplingErrors += rr.SamplingErrors
+
+		if rr.SelfRAM > res.SelfRAM {
+			res.SelfRAM = rr.SelfRAM
+		}
+
+		c.repoGrowthBytes += rr.repoGrowthBytes
+		c.repoGrowthBlobs += rr.repoGrowthBlobs
+		c.go_memstats_alloc_bytes_delta += rr.go_memstats_alloc_bytes_delta
+		c.go_memstats_mallocs_delta += rr.go_memstats_mallocs_delta
+		c.go_memstats_alloc_bytes_total += rr.go_memstats_alloc_bytes_total
+		c.go_memstats_mallocs_total += rr.go_memstats_mallocs_total
+
+		c.faults.errors += rr.faults.errors
+		c.faults.timeouts += rr.faults.timeouts
+		c.faults.slow += rr.faults.slow
+
+		c.churn.ops += rr.churn.ops
+		c.churn.avgCPU += rr.churn.avgCPU / float64(len(rrs))
+
+		if rr.churn.maxRAM > c.churn.maxRAM {
+			c.churn.maxRAM = rr.churn.maxRAM
+		}
+	}
+
+	return c
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"runbench/pkg/bench"
+)
+
+var datasetManifest = flag.String("dataset-manifest", "", "Manifest written by 'makemanyfiles --manifest' for the dataset used by the scenario, its content hash is added as 'dataset' tag")
+
+// datasetTags returns a tag identifying the dataset described by --dataset-manifest, if any.
+func datasetTags() ([]bench.Tag, error) {
+	if *datasetManifest == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(*datasetManifest)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read dataset manifest")
+	}
+
+	var m struct {
+		ContentHash string `json:"contentHash"`
+	}
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, errors.Wrap(err, "invalid dataset manifest")
+	}
+
+	if len(m.ContentHash) < 16 {
+		return nil, errors.Errorf("dataset manifest %v has no content hash", *datasetManifest)
+	}
+
+	return []bench.Tag{{Key: "dataset", Value: m.ContentHash[0:16]}}, nil
+}
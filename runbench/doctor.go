@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"runbench/pkg/bench"
+)
+
+// doctorCommand is the argument which runs the environment self-test instead of scenarios.
+const doctorCommand = "doctor"
+
+// doctorMetricsTimeout is how long the self-test waits for kopia to serve metrics.
+const doctorMetricsTimeout = 10 * time.Second
+
+// doctorCheck is the outcome of a single check of the environment, skipped when err and
+// detail are both empty.
+type doctorCheck struct {
+	name   string
+	detail string
+	err    error
+}
+
+// runDoctor checks that the environment is able to run benchmarks with current flags and prints
+// a checklist, returning an error if any check failed.
+func runDoctor(ctx context.Context, w io.Writer) error {
+	var checks []doctorCheck
+
+	add := func(name, detail string, err error) {
+		checks = append(checks, doctorCheck{name, detail, err})
+	}
+
+	version, err := kopiaVersion(ctx)
+	add("kopia executable", version, err)
+
+	kopiaOK := err == nil
+
+	if kopiaOK {
+		err = parseBuildInfo()
+		if err == nil && gitRevision == "" {
+			err = errors.Errorf("%v has no VCS build info, build it with 'go build' from a git checkout", *kopiaExe)
+		}
+
+		add("VCS build info", fmt.Sprintf("revision %v (%v) modified:%v", gitRevision, gitTime, gitModified), err)
+
+		metricsDetail, samplersDetail, metricsErr, samplersErr := checkKopiaSampling(ctx)
+		add("metrics endpoint", metricsDetail, metricsErr)
+		add("process sampling", samplersDetail, samplersErr)
+	} else {
+		add("VCS build info", "", nil)
+		add("metrics endpoint", "", nil)
+		add("process sampling", "", nil)
+	}
+
+	add("output directory", *outputDir, checkWritableDir("output-dir", *outputDir))
+
+	if *repoPath != "" {
+		add("repository directory", *repoPath, checkWritableDir("repo-path", filepath.Dir(*repoPath)))
+	}
+
+	if *parquetDir != "" {
+		add("parquet directory", *parquetDir, checkWritableDir("parquet-dir", *parquetDir))
+	}
+
+	var failed int
+
+	for _, c := range checks {
+		switch {
+		case c.err != nil:
+			failed++
+
+			fmt.Fprintf(w, "FAIL  %v: %v\n", c.name, c.err)
+		case c.detail == "":
+			fmt.Fprintf(w, "SKIP  %v\n", c.name)
+		default:
+			fmt.Fprintf(w, "PASS  %v: %v\n", c.name, c.detail)
+		}
+	}
+
+	if failed > 0 {
+		return errors.Errorf("%v of %v checks failed", failed, len(checks))
+	}
+
+	return nil
+}
+
+// kopiaVersion runs kopia --version and returns the first line of its output.
+func kopiaVersion(ctx context.Context) (string, error) {
+	if err := checkExecutable("kopia-exe", *kopiaExe); err != nil {
+		return "", err
+	}
+
+	c := exec.CommandContext(ctx, *kopiaExe, "--version")
+	c.Env = kopiaEnv(*kopiaExe)
+
+	out, err := c.CombinedOutput()
+	if err != nil {
+		return "", errors.Errorf("%v --version failed: %v %s", *kopiaExe, err, bytes.TrimSpace(out))
+	}
+
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+
+	return line, nil
+}
+
+// checkKopiaSampling runs a long kopia benchmark which doesn't need a repository, scrapes its
+// metrics endpoint and samples it with --samplers, killing it afterwards. It returns details and
+// errors of both checks.
+func checkKopiaSampling(ctx context.Context) (metricsDetail, samplersDetail string, metricsErr, samplersErr error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", "", errors.Wrap(err, "unable to find free port"), nil
+	}
+
+	addr := l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var out bytes.Buffer
+
+	c := exec.CommandContext(ctx, *kopiaExe, "--metrics-listen-addr="+addr, "benchmark", "crypto", "--repeat=1000000")
+	c.Env = kopiaEnv(*kopiaExe)
+	c.Stdout = &out
+	c.Stderr = &out
+
+	if err := c.Start(); err != nil {
+		err = errors.Wrap(err, "unable to start kopia")
+		return "", "", err, err
+	}
+
+	exited := make(chan struct{})
+
+	go func() {
+		_ = c.Wait()
+
+		close(exited)
+	}()
+
+	defer func() {
+		cancel()
+		<-exited
+	}()
+
+	metricsURL := "http://" + addr + "/metrics"
+	metricsDetail, metricsErr = scrapeKopiaMetrics(ctx, metricsURL, exited)
+	samplersDetail, samplersErr = sampleProcess(ctx, bench.SamplerTarget{PID: c.Process.Pid, MetricsURL: metricsURL})
+
+	select {
+	case <-exited:
+		err := errors.Errorf("kopia exited during the check: %s", bytes.TrimSpace(out.Bytes()))
+		return "", "", err, err
+	default:
+	}
+
+	return metricsDetail, samplersDetail, metricsErr, samplersErr
+}
+
+// scrapeKopiaMetrics waits for kopia to serve metrics at the URL and returns the number of counters.
+func scrapeKopiaMetrics(ctx context.Context, metricsURL string, exited <-chan struct{}) (string, error) {
+	deadline := time.Now().Add(doctorMetricsTimeout)
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, metricsURL, nil)
+		if err != nil {
+			return "", err
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			b, rerr := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if rerr != nil {
+				return "", errors.Wrap(rerr, "unable to read metrics")
+			}
+
+			counters := bench.ParsePrometheusCounters(b)
+			if len(counters) == 0 {
+				return "", errors.Errorf("%v serves no Prometheus counters", metricsURL)
+			}
+
+			return fmt.Sprintf("%v counters at %v", len(counters), metricsURL), nil
+		}
+
+		if time.Now().After(deadline) {
+			return "", errors.Errorf("kopia doesn't serve metrics at %v: %v", metricsURL, err)
+		}
+
+		select {
+		case <-exited:
+			// reported by checkKopiaSampling along with the output.
+			return "", nil
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// sampleProcess samples the target with --samplers other than prometheus, which is checked by
+// scrapeKopiaMetrics. Samplers may need a previous sample (e.g. rates), so a few are taken.
+func sampleProcess(ctx context.Context, t bench.SamplerTarget) (string, error) {
+	names, err := parseSamplers()
+	if err != nil {
+		return "", err
+	}
+
+	names = withoutSampler(names, "prometheus")
+	if len(names) == 0 {
+		return "no samplers besides prometheus", nil
+	}
+
+	samplers, err := bench.NewSamplers(ctx, names, t)
+	if err != nil {
+		return "", err
+	}
+
+	var s bench.Sample
+
+	for i := 0; i < 3; i++ {
+		time.Sleep(*samplingInterval)
+
+		s = bench.Sample{Time: time.Now()}
+
+		for j, sm := range samplers {
+			if err := sm.Sample(ctx, &s); err != nil {
+				return "", errors.Wrapf(err, "%v sampler failed", names[j])
+			}
+		}
+	}
+
+	return fmt.Sprintf("%v: ram %.1f MiB, cpu %.0f%%, %v other values", strings.Join(names, ","), s.RAM, s.CPU, len(s.Values)), nil
+}
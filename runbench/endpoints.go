@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/shlex"
+	"github.com/pkg/errors"
+)
+
+// marker that can be put in a script to scrape Prometheus metrics of other processes than the
+// measured command along with it, e.g. the server of a client/server scenario, for example:
+//
+//	# METRICS_ENDPOINT: server=http://127.0.0.1:6667/metrics
+//
+// Captured metrics and histograms of endpoints are emitted with the 'endpoint' tag set to the
+// declared name.
+const metricsEndpointMarker = `# METRICS_ENDPOINT:`
+
+// parseMetricsEndpoints parses 'name=url' declarations of metricsEndpointMarker, with variables
+// in URLs expanded.
+func parseMetricsEndpoints(specs []string) (map[string]string, error) {
+	res := map[string]string{}
+
+	for _, spec := range specs {
+		items, err := shlex.Split(spec)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid METRICS_ENDPOINT declaration")
+		}
+
+		for _, item := range items {
+			name, u, ok := strings.Cut(item, "=")
+			if !ok || !envNameRegexp.MatchString(name) {
+				return nil, errors.Errorf("invalid METRICS_ENDPOINT %q, expected name=url", item)
+			}
+
+			if _, dup := res[name]; dup {
+				return nil, errors.Errorf("duplicate METRICS_ENDPOINT %q", name)
+			}
+
+			u = os.Expand(u, lookupScenarioEnv)
+
+			if pu, err := url.Parse(u); err != nil || pu.Host == "" {
+				return nil, errors.Errorf("invalid URL %q of METRICS_ENDPOINT %q", u, name)
+			}
+
+			res[name] = u
+		}
+	}
+
+	return res, nil
+}
+
+// sortedEndpointNames returns names of metrics endpoints of the scenario.
+func (ss *scenarioState) sortedEndpointNames() []string {
+	var names []string
+
+	for name := range ss.endpoints {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/google/shlex"
+	"github.com/pkg/errors"
+)
+
+var (
+	inheritEnv = flag.Bool("inherit-env", false, "Run kopia with the full environment of runbench instead of only the allowed and declared variables")
+	envAllow   = flag.String("env-allow", "", "Comma-separated list of additional variables of runbench environment passed to kopia")
+)
+
+// marker that can be put in a script to declare variables of the environment of kopia, which by
+// default doesn't inherit runbench environment, for example:
+//
+//	# ENV: KOPIA_PASSWORD=dummy AWS_ACCESS_KEY_ID AWS_SECRET_ACCESS_KEY
+//
+// NAME=value sets the variable, NAME passes its value from runbench environment.
+//
+// Kopia (the measured command as well as kopia invocations of runbench) otherwise only gets PATH,
+// HOME, USER, temporary, XDG config and cache directories and variables listed in --env-allow, so
+// that proxies, locales or KOPIA_* variables of the host can't affect results. --inherit-env restores the full
+// environment. Prepare scripts always inherit runbench environment.
+const envMarker = `# ENV:`
+
+// baseEnvAllow are variables of runbench environment always passed to kopia, which are needed
+// to find executables, home and temporary directories on all platforms, as well as config and
+// cache directories kopia uses on Unix (via os.UserConfigDir and os.UserCacheDir).
+var baseEnvAllow = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "TMPDIR", "XDG_CONFIG_HOME", "XDG_CACHE_HOME",
+	"TMP", "TEMP", "SYSTEMROOT", "USERPROFILE", "APPDATA", "LOCALAPPDATA",
+}
+
+// scenarioEnv holds variables exported by runbench to the currently running scenario,
+// for example addresses of servers started on its behalf.
+var scenarioEnv = map[string]string{}
+
+// scenarioEnvAllow holds variables of runbench environment the currently running scenario
+// declared using '# ENV: NAME'.
+var scenarioEnvAllow = map[string]bool{}
+
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func setScenarioEnv(key, value string) {
+	scenarioEnv[key] = value
+}
+
+func resetScenarioEnv() {
+	scenarioEnv = map[string]string{}
+	scenarioEnvAllow = map[string]bool{}
+}
+
+func lookupScenarioEnv(key string) string {
+	if v, ok := scenarioEnv[key]; ok {
+		return v
+	}
+
+	return os.Getenv(key)
+}
+
+// declareScenarioEnv applies the '# ENV:' marker of a scenario: NAME=value sets the variable
+// (with variables in the value expanded), NAME passes it from runbench environment.
+func declareScenarioEnv(spec string) error {
+	items, err := shlex.Split(spec)
+	if err != nil {
+		return errors.Wrap(err, "invalid ENV declaration")
+	}
+
+	for _, item := range items {
+		k, v, hasValue := strings.Cut(item, "=")
+		if !envNameRegexp.MatchString(k) {
+			return errors.Errorf("invalid variable name %q in ENV declaration", k)
+		}
+
+		if hasValue {
+			setScenarioEnv(k, os.Expand(v, lookupScenarioEnv))
+		} else {
+			scenarioEnvAllow[envKey(k)] = true
+		}
+	}
+
+	return nil
+}
+
+// commandEnv returns the environment for scenario scripts, which inherit the environment of runbench.
+func commandEnv(exe string) []string {
+	return append(append([]string(nil), os.Environ()...), runbenchEnv(exe)...)
+}
+
+// kopiaEnv returns the environment for measured commands and other kopia invocations, which
+// only includes allowed and declared variables unless --inherit-env is passed, so that stray
+// proxies, locales or KOPIA_* variables of the host can't affect results.
+func kopiaEnv(exe string) []string {
+	if *inheritEnv {
+		return commandEnv(exe)
+	}
+
+	allowed := map[string]bool{}
+
+	for _, k := range baseEnvAllow {
+		allowed[envKey(k)] = true
+	}
+
+	for _, k := range strings.Split(*envAllow, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			allowed[envKey(k)] = true
+		}
+	}
+
+	for k := range scenarioEnvAllow {
+		allowed[k] = true
+	}
+
+	var env []string
+
+	for _, kv := range os.Environ() {
+		if k, _, _ := strings.Cut(kv, "="); allowed[envKey(k)] {
+			env = append(env, kv)
+		}
+	}
+
+	return append(env, runbenchEnv(exe)...)
+}
+
+// runbenchEnv returns variables set by runbench and the current scenario.
+func runbenchEnv(exe string) []string {
+	env := []string{
+		"KOPIA_EXE=" + exe,
+		"REPO_PATH=" + *repoPath,
+	}
+
+	for k, v := range scenarioEnv {
+		env = append(env, k+"="+v)
+	}
+
+	return env
+}
+
+// envNames returns sorted names of variables of the environment.
+func envNames(env []string) []string {
+	var names []string
+
+	for _, kv := range env {
+		k, _, _ := strings.Cut(kv, "=")
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// envKey returns the name of a variable as compared by the platform.
+func envKey(k string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToUpper(k)
+	}
+
+	return k
+}
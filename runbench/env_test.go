@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKopiaEnv(t *testing.T) {
+	t.Setenv("HOME", "/home/bench")
+	t.Setenv("XDG_CONFIG_HOME", "/home/bench/.config")
+	t.Setenv("XDG_CACHE_HOME", "/home/bench/.cache")
+	t.Setenv("KOPIA_PASSWORD", "host")
+	t.Setenv("HTTPS_PROXY", "http://proxy")
+	t.Setenv("BENCH_EXTRA", "1")
+	t.Setenv("BENCH_DECLARED", "2")
+
+	defer resetScenarioEnv()
+
+	*envAllow = "BENCH_EXTRA"
+	defer func() { *envAllow = "" }()
+
+	if err := declareScenarioEnv("BENCH_DECLARED BENCH_SET=3"); err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{}
+
+	for _, kv := range kopiaEnv("/bin/kopia") {
+		k, v, _ := strings.Cut(kv, "=")
+		env[k] = v
+	}
+
+	for k, want := range map[string]string{
+		"HOME":            "/home/bench",
+		"XDG_CONFIG_HOME": "/home/bench/.config",
+		"XDG_CACHE_HOME":  "/home/bench/.cache",
+		"BENCH_EXTRA":     "1",
+		"BENCH_DECLARED":  "2",
+		"BENCH_SET":       "3",
+		"KOPIA_EXE":       "/bin/kopia",
+	} {
+		if got, ok := env[k]; !ok || got != want {
+			t.Errorf("%v = %q (%v), want %q", k, got, ok, want)
+		}
+	}
+
+	for _, k := range []string{"KOPIA_PASSWORD", "HTTPS_PROXY"} {
+		if _, ok := env[k]; ok {
+			t.Errorf("%v passed to kopia", k)
+		}
+	}
+}
+
+func TestEnvNames(t *testing.T) {
+	got := envNames([]string{"XDG_CACHE_HOME=/c", "HOME=/h", "EMPTY="})
+
+	if want := []string{"EMPTY", "HOME", "XDG_CACHE_HOME"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"flag"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var faultInjection = flag.String("fault-injection", "", "Inject faults into HTTP requests to repository server, e.g. 'target=http://127.0.0.1:9000 error-rate=0.01 timeout-rate=0.001 slow-rate=0.05 slow-delay=2s'")
+
+// marker that can be put in a script to override --fault-injection for the scenario, for example:
+//
+//	# FAULT_INJECTION: target=http://$S3_ENDPOINT error-rate=0.01 slow-rate=0.05 slow-delay=2s
+//
+// The proxy is exported as $FAULTY_ADDR, injected faults are emitted as fault_injection_summary.
+const faultInjectionMarker = `# FAULT_INJECTION:`
+
+// faultCounts are numbers of faults injected during a single run.
+type faultCounts struct {
+	errors   int64
+	timeouts int64
+	slow     int64
+}
+
+// faultInjectionProxy is a reverse proxy for HTTP-based storage backends (S3, GCS, WebDAV, ...)
+// which fails a fraction of requests with an error status, by never responding or by responding late.
+// Faults are only injected while the measured command is running and use a fixed seed so that
+// runs are comparable.
+type faultInjectionProxy struct {
+	server *httptest.Server
+	target *url.URL
+
+	errorRate   float64
+	errorStatus int
+	timeoutRate float64
+	timeoutHold time.Duration
+	slowRate    float64
+	slowDelay   time.Duration
+
+	active int32
+
+	seed int64
+	mu   sync.Mutex
+	rnd  *rand.Rand
+
+	counts faultCounts
+}
+
+func parseRate(key, value string) (float64, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || v < 0 || v > 1 {
+		return 0, errors.Errorf("invalid %v %q, must be between 0 and 1", key, value)
+	}
+
+	return v, nil
+}
+
+func startFaultInjectionProxy(spec string) (*faultInjectionProxy, error) {
+	p := &faultInjectionProxy{
+		errorStatus: http.StatusInternalServerError,
+		timeoutHold: 5 * time.Minute,
+		slowDelay:   time.Second,
+		seed:        1,
+	}
+
+	for _, kv := range strings.Fields(spec) {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, errors.Errorf("invalid fault injection parameter %q", kv)
+		}
+
+		var err error
+
+		switch key {
+		case "target":
+			p.target, err = url.Parse(value)
+		case "error-rate":
+			p.errorRate, err = parseRate(key, value)
+		case "error-status":
+			p.errorStatus, err = strconv.Atoi(value)
+		case "timeout-rate":
+			p.timeoutRate, err = parseRate(key, value)
+		case "timeout-hold":
+			p.timeoutHold, err = time.ParseDuration(value)
+		case "slow-rate":
+			p.slowRate, err = parseRate(key, value)
+		case "slow-delay":
+			p.slowDelay, err = time.ParseDuration(value)
+		case "seed":
+			p.seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			err = errors.Errorf("unknown fault injection parameter %q", key)
+		}
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid fault injection %q", spec)
+		}
+	}
+
+	if p.target == nil || p.target.Host == "" {
+		return nil, errors.Errorf("missing target URL in fault injection %q", spec)
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(p.target)
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.serve(proxy, w, r)
+	}))
+
+	return p, nil
+}
+
+const (
+	faultNone = iota
+	faultError
+	faultTimeout
+	faultSlow
+)
+
+func (p *faultInjectionProxy) pickFault() int {
+	if atomic.LoadInt32(&p.active) == 0 {
+		return faultNone
+	}
+
+	p.mu.Lock()
+	v := p.rnd.Float64()
+	p.mu.Unlock()
+
+	switch {
+	case v < p.errorRate:
+		atomic.AddInt64(&p.counts.errors, 1)
+		return faultError
+
+	case v < p.errorRate+p.timeoutRate:
+		atomic.AddInt64(&p.counts.timeouts, 1)
+		return faultTimeout
+
+	case v < p.errorRate+p.timeoutRate+p.slowRate:
+		atomic.AddInt64(&p.counts.slow, 1)
+		return faultSlow
+
+	default:
+		return faultNone
+	}
+}
+
+func (p *faultInjectionProxy) serve(proxy http.Handler, w http.ResponseWriter, r *http.Request) {
+	switch p.pickFault() {
+	case faultError:
+		http.Error(w, "injected fault", p.errorStatus)
+		return
+
+	case faultTimeout:
+		// hold the request until the client gives up, then drop the connection without responding.
+		select {
+		case <-r.Context().Done():
+		case <-time.After(p.timeoutHold):
+		}
+
+		panic(http.ErrAbortHandler)
+
+	case faultSlow:
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(p.slowDelay):
+		}
+	}
+
+	proxy.ServeHTTP(w, r)
+}
+
+// Addr returns host:port the scenario should connect to instead of the target.
+func (p *faultInjectionProxy) Addr() string {
+	return p.server.Listener.Addr().String()
+}
+
+// setActive enables or disables fault injection, enabling resets fault counters and the random
+// sequence, so that each run sees the same sequence of faults.
+func (p *faultInjectionProxy) setActive(active bool) {
+	if p == nil {
+		return
+	}
+
+	if active {
+		p.mu.Lock()
+		p.rnd = rand.New(rand.NewSource(p.seed))
+		p.mu.Unlock()
+
+		atomic.StoreInt64(&p.counts.errors, 0)
+		atomic.StoreInt64(&p.counts.timeouts, 0)
+		atomic.StoreInt64(&p.counts.slow, 0)
+		atomic.StoreInt32(&p.active, 1)
+	} else {
+		atomic.StoreInt32(&p.active, 0)
+	}
+}
+
+// injectedFaults returns the number of faults injected since the proxy was last activated.
+func (p *faultInjectionProxy) injectedFaults() faultCounts {
+	if p == nil {
+		return faultCounts{}
+	}
+
+	return faultCounts{
+		errors:   atomic.LoadInt64(&p.counts.errors),
+		timeouts: atomic.LoadInt64(&p.counts.timeouts),
+		slow:     atomic.LoadInt64(&p.counts.slow),
+	}
+}
+
+func (p *faultInjectionProxy) Close() {
+	p.server.Close()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// With --heap-profile-top=N kopia also writes a heap profile when each measured run of both
+// executables exits (run-N.pprof/mem.pprof next to its captured output), see compareHeapProfiles.
+var heapProfileTop = flag.Int("heap-profile-top", 0, "With --compare-to-exe, profile heap allocations of both executables and report the top N functions by difference of allocated bytes from the baseline, 0 to disable")
+
+// heapProfileRate is the rate of sampling of heap allocations of profiled runs, which is the
+// default of the Go runtime so that profiling doesn't change what is measured.
+const heapProfileRate = 512 << 10
+
+// heapProfileArgs returns kopia flags writing the heap profile of the run captured in logFile
+// when it exits.
+func heapProfileArgs(logFile string) []string {
+	return []string{
+		"--profile-dir=" + heapProfileDir(logFile),
+		"--profile-memory=" + strconv.Itoa(heapProfileRate),
+	}
+}
+
+// heapProfileDir returns the directory of the heap profile of the run captured in logFile.
+func heapProfileDir(logFile string) string {
+	return strings.TrimSuffix(logFile, ".log") + ".pprof"
+}
+
+// heapProfileFile returns the heap profile written by the run captured in logFile, empty if
+// kopia didn't write it.
+func heapProfileFile(logFile string) string {
+	f := filepath.Join(heapProfileDir(logFile), "mem.pprof")
+
+	if _, err := os.Stat(f); err != nil {
+		log.Printf("no heap profile of %v: %v", logFile, err)
+		return ""
+	}
+
+	return f
+}
+
+// compareHeapProfiles writes allocations of the current executable which differ the most from
+// the baseline, as reported by 'go tool pprof -diff_base'. Profiles are written when kopia exits,
+// so the last run measured for both executables is compared, which used the same preparation.
+func compareHeapProfiles(ctx context.Context, f io.Writer, rrs, baseline []*runResult) error {
+	n := len(rrs)
+	if len(baseline) < n {
+		n = len(baseline)
+	}
+
+	if n == 0 || rrs[n-1].heapProfile == "" || baseline[n-1].heapProfile == "" {
+		return nil
+	}
+
+	c := exec.CommandContext(ctx, *goExe, "tool", "pprof",
+		"-top",
+		"-nodecount="+strconv.Itoa(*heapProfileTop),
+		"-sample_index=alloc_space",
+		"-diff_base="+baseline[n-1].heapProfile,
+		rrs[n-1].heapProfile)
+
+	o, err := c.Output()
+	if err != nil {
+		return errors.Wrap(err, "unable to diff heap profiles")
+	}
+
+	fmt.Fprintf(f, "HEAP DIFF run:%v current:%v baseline:%v\n", rrs[n-1].run, rrs[n-1].heapProfile, baseline[n-1].heapProfile)
+
+	for _, l := range strings.Split(strings.TrimRight(string(o), "\n"), "\n") {
+		fmt.Fprintf(f, "  %v\n", l)
+	}
+
+	return nil
+}
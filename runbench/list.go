@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"runbench/pkg/bench"
+)
+
+var listScenarios = flag.Bool("list", false, "Instead of running scenarios (or the jobs of --suite), list them with their measured commands and the duration of their last run found in --output-dir")
+
+// scenarioHistory describes the last output file of a scenario.
+type scenarioHistory struct {
+	fname      string
+	duration   float64 // average duration of all steps of a run, in seconds
+	prepare    float64 // average preparation time, in seconds
+	runs       int     // number of measured runs, excluding warmup
+	hasPrepare bool
+}
+
+// listedScenarioFiles returns scenario files given as arguments, or the ones of all jobs of --suite.
+func listedScenarioFiles(args []string) ([]string, error) {
+	if *suiteFile == "" {
+		return args, nil
+	}
+
+	sc, err := loadSuite()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		files []string
+		seen  = map[string]bool{}
+	)
+
+	for _, job := range sc.Jobs {
+		for _, pattern := range job.Scenarios {
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid scenario pattern %q of job %q", pattern, job.Name)
+			}
+
+			for _, m := range matches {
+				if !seen[m] {
+					seen[m] = true
+					files = append(files, m)
+				}
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// listScenarioFiles prints the scenarios with their measured commands, markers and the duration of
+// their last run, along with the time running them again is estimated to take with current flags.
+func listScenarioFiles(w io.Writer, scenFiles []string) error {
+	var total time.Duration
+
+	for _, scenFile := range scenFiles {
+		d, err := listScenario(w, scenFile)
+		if err != nil {
+			return err
+		}
+
+		total += d
+	}
+
+	if total > 0 {
+		fmt.Fprintf(w, "estimated total: %v (scenarios with history only)\n", total.Round(time.Second))
+	}
+
+	return nil
+}
+
+// listScenario prints a single scenario and returns the estimated time to run it, 0 if unknown.
+func listScenario(w io.Writer, scenFile string) (time.Duration, error) {
+	scen := strings.TrimSuffix(filepath.Base(scenFile), ".sh")
+
+	restoreConfig, err := applyScenarioConfig(scen)
+	if err != nil {
+		return 0, err
+	}
+
+	defer restoreConfig()
+
+	fmt.Fprintf(w, "%v (%v)\n", scen, scenFile)
+
+	si, err := parseScenario(scenFile)
+	if err != nil {
+		fmt.Fprintf(w, "  invalid: %v\n", err)
+		return 0, nil
+	}
+
+	for _, st := range si.steps {
+		if st.name != "" {
+			fmt.Fprintf(w, "  step %v: %v\n", st.name, st.commandLine)
+		} else {
+			fmt.Fprintf(w, "  command: %v\n", st.commandLine)
+		}
+	}
+
+	if m := si.markers(); len(m) > 0 {
+		fmt.Fprintf(w, "  markers: %v\n", strings.Join(m, ", "))
+	}
+
+	h, err := lastScenarioHistory(scen)
+	if err != nil {
+		return 0, err
+	}
+
+	if h == nil {
+		fmt.Fprintf(w, "  last run: none in %v\n", filepath.Join(*outputDir, scen))
+		return 0, nil
+	}
+
+	fmt.Fprintf(w, "  last run: %v, %v runs of %.1fs", filepath.Base(h.fname), h.runs, h.duration)
+
+	if h.hasPrepare {
+		fmt.Fprintf(w, ", prepared in %.1fs", h.prepare)
+	}
+
+	fmt.Fprintln(w)
+
+	est, runs := estimateScenarioDuration(h, si.singlePrepare)
+	fmt.Fprintf(w, "  estimated: %v (%v runs including warmup)\n", est.Round(time.Second), runs)
+
+	return est, nil
+}
+
+// markers returns descriptions of markers of the scenario which affect how it is measured.
+func (si *scenarioInfo) markers() []string {
+	var m []string
+
+	for _, v := range []struct {
+		set  bool
+		desc string
+	}{
+		{si.singlePrepare, "single prepare"},
+		{si.cumulative, "cumulative"},
+		{si.generic, "generic command"},
+		{si.minio, "minio"},
+		{si.sftp, "sftp"},
+		{si.webDAV, "webdav"},
+		{si.faultInjection != "", "fault injection " + si.faultInjection},
+		{si.repoSizeSource != "", "repo size from " + si.repoSizeSource},
+		{si.networkShaping != "", "network shaping " + si.networkShaping},
+		{si.netns != "", "netns " + si.netns},
+		{si.networkSource != "", "network source " + si.networkSource},
+		{si.churn != "", "churn " + si.churn},
+		{len(si.env) > 0, "env " + strings.Join(si.env, " ")},
+		{len(si.endpoints) > 0, "metrics endpoints " + strings.Join(si.endpoints, " ")},
+	} {
+		if v.set {
+			m = append(m, v.desc)
+		}
+	}
+
+	return m
+}
+
+// lastScenarioHistory reads the most recent output file of the scenario, returns nil if there
+// is none. Durations are the ones without memory limit in the first cache state of the file.
+func lastScenarioHistory(scen string) (*scenarioHistory, error) {
+	files, err := filepath.Glob(filepath.Join(*outputDir, scen, "*.line"))
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+
+	// output files are named after git time of the measured revision.
+	sort.Strings(files)
+
+	h := &scenarioHistory{fname: files[len(files)-1]}
+
+	f, err := os.Open(h.fname)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var (
+		cache          *string
+		whole, stepSum float64
+		hasWhole       bool
+		prepares       []float64
+		runs           = map[string]bool{}
+	)
+
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+
+	for s.Scan() {
+		p, err := bench.ParseLine(s.Text())
+		if err != nil || p.Tags["memory_limit"] != "" {
+			continue
+		}
+
+		if cache == nil {
+			c := p.Tags["cache"]
+			cache = &c
+		}
+
+		if p.Tags["cache"] != *cache {
+			continue
+		}
+
+		switch p.Measurement {
+		case "process_summary":
+			if p.Tags["step"] == "" {
+				whole, hasWhole = p.Fields["duration"], true
+			} else {
+				stepSum += p.Fields["duration"]
+			}
+
+		case "repo_growth":
+			runs[p.Tags["run"]] = true
+
+		case "prepare":
+			prepares = append(prepares, p.Fields["prepare_duration"])
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrapf(err, "unable to read %v", h.fname)
+	}
+
+	// scenarios with steps only have a result without step tag when they are cumulative.
+	h.duration = stepSum
+	if hasWhole {
+		h.duration = whole
+	}
+
+	h.runs = len(runs)
+
+	if len(prepares) > 0 {
+		var sum float64
+
+		for _, v := range prepares {
+			sum += v
+		}
+
+		h.prepare, h.hasPrepare = sum/float64(len(prepares)), true
+	}
+
+	return h, nil
+}
+
+// estimateScenarioDuration estimates how long the scenario takes with the current --min-duration,
+// --min-repeat, --cache-states and --memory-limits, assuming each run takes as long as in history.
+// It returns the estimate and the number of runs of each cache state and memory limit.
+func estimateScenarioDuration(h *scenarioHistory, singlePrepare bool) (time.Duration, int) {
+	runs := *minRepeat
+
+	if h.duration > 0 {
+		if n := int(math.Ceil(minDuration.Seconds() / h.duration)); n > runs {
+			runs = n
+		}
+	}
+
+	perState := float64(runs) * h.duration
+
+	if singlePrepare {
+		perState += h.prepare
+	} else {
+		perState += float64(runs) * h.prepare
+	}
+
+	states := 1
+
+	if c, err := parseCacheStates(); err == nil {
+		states = len(c)
+	}
+
+	if l, err := parseMemoryLimits(); err == nil {
+		states *= len(l)
+	}
+
+	if *compareExe != "" {
+		states *= 2
+	}
+
+	return time.Duration(perState * float64(states) * float64(time.Second)), runs
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"runbench/pkg/bench"
+)
+
+// With --memory-limits (Linux only, cgroup v2) each scenario is also measured with the measured
+// command limited to each amount of memory, from the largest, until it fails. Measurements are
+// tagged with 'memory_limit' and memory_limit_summary reports for each limit whether the command
+// succeeded, how often it was OOM-killed and its slowdown against the unlimited run.
+var memoryLimits = flag.String("memory-limits", "", "Comma-separated list of memory limits (e.g. 1GiB,512MiB) to measure each scenario with after the unlimited run (Linux only, requires root)")
+
+// limitedRuns are runs of steps of a scenario in a single cache state and with a single memory
+// limit, which unlike unlimited runs may fail.
+type limitedRuns struct {
+	cache    string
+	limit    string
+	steps    []stepRuns
+	err      error
+	oomKills int64
+}
+
+// parseMemoryLimits returns an empty (unlimited) limit followed by --memory-limits sorted from
+// the largest, so that the sweep can stop at the first failing limit.
+func parseMemoryLimits() ([]string, error) {
+	limits := []string{""}
+
+	if *memoryLimits == "" {
+		return limits, nil
+	}
+
+	var prev int64
+
+	for _, l := range strings.Split(*memoryLimits, ",") {
+		v, err := parseMemorySize(l)
+		if err != nil {
+			return nil, err
+		}
+
+		if prev != 0 && v >= prev {
+			return nil, errors.Errorf("--memory-limits must be in decreasing order")
+		}
+
+		prev = v
+		limits = append(limits, l)
+	}
+
+	return limits, nil
+}
+
+// parseMemorySize parses sizes such as 512MiB, 2GiB or 1048576 and returns bytes.
+func parseMemorySize(s string) (int64, error) {
+	mult := int64(1)
+	num := s
+
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{
+		{"GiB", 1 << 30},
+		{"MiB", 1 << 20},
+		{"KiB", 1 << 10},
+	} {
+		if strings.HasSuffix(s, u.suffix) {
+			num, mult = strings.TrimSuffix(s, u.suffix), u.mult
+			break
+		}
+	}
+
+	v, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || v <= 0 {
+		return 0, errors.Errorf("invalid memory size %q", s)
+	}
+
+	return v * mult, nil
+}
+
+func memoryLimitTags(limit string) []bench.Tag {
+	if limit == "" {
+		return nil
+	}
+
+	return []bench.Tag{{Key: "memory_limit", Value: limit}}
+}
+
+// wrap makes the command move itself into the cgroup before executing, so that it is limited
+// from the start and keeps its process ID.
+func (l *cgroupLimit) wrap(c *exec.Cmd) {
+	if l == nil {
+		return
+	}
+
+	c.Args = append([]string{"sh", "-c", `echo $$ > "$0" && exec "$@"`, l.procsFile(), c.Path}, c.Args[1:]...)
+	c.Path = "/bin/sh"
+}
+
+// logMemoryLimits writes memory_limit_summary for each limit, with slowdown of each step relative
+// to the unlimited runs of the same cache state.
+func (ss *scenarioState) logMemoryLimits(sink bench.Sink, results []*limitedRuns) error {
+	tags, err := scenarioTags(ss.name)
+	if err != nil {
+		return err
+	}
+
+	// unlimited durations by cache state and step.
+	unlimited := map[string][]float64{}
+
+	for _, lr := range results {
+		if lr.limit == "" {
+			for _, sr := range lr.steps {
+				unlimited[lr.cache] = append(unlimited[lr.cache], summarizeSamples(sr.runs).AvgDuration)
+			}
+		}
+	}
+
+	var points []bench.Point
+
+	for _, lr := range results {
+		if lr.limit == "" {
+			continue
+		}
+
+		limitBytes, _ := parseMemorySize(lr.limit)
+
+		// the unlimited runs of the first cache state succeeded, so they describe all steps.
+		for i, sr := range results[0].steps {
+			fields := []bench.Field{
+				{Key: "limit_bytes", Value: limitBytes},
+				{Key: "succeeded", Value: boolToInt(lr.err == nil)},
+				{Key: "oom_kills", Value: lr.oomKills},
+			}
+
+			if lr.err == nil {
+				d := summarizeSamples(lr.steps[i].runs).AvgDuration
+
+				fields = append(fields,
+					bench.Field{Key: "duration", Value: bench.Fixed{Value: d, Digits: 1}},
+					bench.Field{Key: "slowdown", Value: bench.Fixed{Value: d / unlimited[lr.cache][i], Digits: 2}})
+			}
+
+			points = append(points, bench.Point{
+				Measurement: "memory_limit_summary",
+				Tags:        append(append(append(append(append([]bench.Tag(nil), tags...), cacheTags(lr.cache)...), memoryLimitTags(lr.limit)...), stepTags(sr.step)...), ss.netShapingTags()...),
+				Fields:      fields,
+				Time:        gitTime,
+			})
+		}
+	}
+
+	return sink.Write(points...)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
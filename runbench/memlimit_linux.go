@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupLimit is a cgroup v2 limiting memory and disabling swap of processes in it.
+type cgroupLimit struct {
+	dir string
+}
+
+var cgroupCounter int
+
+// newMemoryLimiter creates a cgroup with the given limit, or returns nil for an empty limit.
+func newMemoryLimiter(limit string) (*cgroupLimit, error) {
+	if limit == "" {
+		return nil, nil
+	}
+
+	v, err := parseMemorySize(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	cgroupCounter++
+
+	l := &cgroupLimit{dir: filepath.Join(cgroupRoot, fmt.Sprintf("runbench-%v-%v", os.Getpid(), cgroupCounter))}
+
+	if err := os.Mkdir(l.dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "unable to create cgroup")
+	}
+
+	for file, value := range map[string]string{
+		"memory.max":      strconv.FormatInt(v, 10),
+		"memory.swap.max": "0",
+	} {
+		if err := os.WriteFile(filepath.Join(l.dir, file), []byte(value), 0o644); err != nil {
+			l.Close()
+			return nil, errors.Wrapf(err, "unable to set %v", file)
+		}
+	}
+
+	return l, nil
+}
+
+func (l *cgroupLimit) procsFile() string {
+	return filepath.Join(l.dir, "cgroup.procs")
+}
+
+// oomKills returns the number of processes killed in the cgroup because of the limit.
+func (l *cgroupLimit) oomKills() int64 {
+	if l == nil {
+		return 0
+	}
+
+	b, err := os.ReadFile(filepath.Join(l.dir, "memory.events"))
+	if err != nil {
+		return 0
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		if v, ok := strings.CutPrefix(s.Text(), "oom_kill "); ok {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			return n
+		}
+	}
+
+	return 0
+}
+
+// Close removes the cgroup, which is empty after the measured command exits.
+func (l *cgroupLimit) Close() {
+	if l == nil {
+		return
+	}
+
+	if err := os.Remove(l.dir); err != nil {
+		log.Printf("unable to remove cgroup: %v", err)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "github.com/pkg/errors"
+
+type cgroupLimit struct{}
+
+func newMemoryLimiter(limit string) (*cgroupLimit, error) {
+	if limit == "" {
+		return nil, nil
+	}
+
+	return nil, errors.New("memory limits are only supported on Linux")
+}
+
+func (l *cgroupLimit) procsFile() string { return "" }
+
+func (l *cgroupLimit) oomKills() int64 { return 0 }
+
+func (l *cgroupLimit) Close() {}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"runbench/pkg/bench"
+)
+
+// Metrics listed in --capture-metrics are emitted as prometheus_metric tagged with the metric name,
+// with the last scraped value averaged over runs. Labeled series are summed, or with
+// --capture-metrics=<name>:<label> grouped by values of the label, one point per value.
+// Histograms and summaries (e.g. of blob operation latency) listed in --capture-histograms are
+// emitted as prometheus_histogram for each run with the number of observations, their mean and
+// p50, p90, p95 and p99 (of summaries only those they provide).
+var (
+	captureMetrics    = flag.String("capture-metrics", "", "Comma-separated list of Prometheus metrics of the measured command to emit as prometheus_metric, summed over all series or with ':label1+label2' over series with the same values of these labels")
+	captureHistograms = flag.String("capture-histograms", "", "Comma-separated list of Prometheus histograms or summaries of the measured command to emit quantiles of as prometheus_histogram for each run, optionally with ':label1+label2' like --capture-metrics")
+)
+
+// quantiles of captured histograms emitted as fields.
+var histogramQuantiles = []struct {
+	field string
+	q     float64
+}{
+	{"p50", 0.5},
+	{"p90", 0.9},
+	{"p95", 0.95},
+	{"p99", 0.99},
+}
+
+// capturedMetric is a metric of --capture-metrics.
+type capturedMetric struct {
+	name string
+	by   []string
+}
+
+func parseCaptureMetrics() ([]capturedMetric, error) {
+	return parseCapturedMetrics(*captureMetrics, "--capture-metrics")
+}
+
+func parseCaptureHistograms() ([]capturedMetric, error) {
+	return parseCapturedMetrics(*captureHistograms, "--capture-histograms")
+}
+
+func parseCapturedMetrics(list, flagName string) ([]capturedMetric, error) {
+	if list == "" {
+		return nil, nil
+	}
+
+	var res []capturedMetric
+
+	for _, s := range strings.Split(list, ",") {
+		name, by, hasLabels := strings.Cut(strings.TrimSpace(s), ":")
+		if name == "" || (hasLabels && by == "") {
+			return nil, errors.Errorf("invalid %v element %q", flagName, s)
+		}
+
+		m := capturedMetric{name: name}
+		if hasLabels {
+			m.by = strings.Split(by, "+")
+		}
+
+		res = append(res, m)
+	}
+
+	return res, nil
+}
+
+// metricsSource is the measured command or a metrics endpoint declared by the scenario, whose
+// captured metrics are tagged with the name of the endpoint.
+type metricsSource struct {
+	tags    []bench.Tag
+	scrapes func(rr *runResult) *bench.PrometheusScrapes
+}
+
+// metricsSources returns the measured command followed by the given metrics endpoints.
+func metricsSources(endpoints []string) []metricsSource {
+	sources := []metricsSource{{
+		scrapes: func(rr *runResult) *bench.PrometheusScrapes { return &rr.Prometheus },
+	}}
+
+	for _, name := range endpoints {
+		name := name
+
+		sources = append(sources, metricsSource{
+			tags:    []bench.Tag{{Key: "endpoint", Value: name}},
+			scrapes: func(rr *runResult) *bench.PrometheusScrapes { return rr.EndpointScrapes(name) },
+		})
+	}
+
+	return sources
+}
+
+// capturedMetricPoints returns averages of the last scraped values of captured metrics over the runs,
+// tagged with the metric name, the labels it's grouped by and the endpoint it was scraped from.
+func (ss *scenarioState) capturedMetricPoints(tags []bench.Tag, rrs []*runResult) ([]bench.Point, error) {
+	metrics, err := parseCaptureMetrics()
+	if err != nil {
+		return nil, err
+	}
+
+	var points []bench.Point
+
+	for _, src := range metricsSources(ss.sortedEndpointNames()) {
+		for _, m := range metrics {
+			totals := map[string]float64{}
+			series := map[string]bench.PrometheusSeries{}
+
+			var keys []string
+
+			for _, rr := range rrs {
+				for _, s := range bench.SumBy(src.scrapes(rr).LastSeries(), m.name, m.by...) {
+					k := s.Key()
+					if _, ok := series[k]; !ok {
+						series[k] = s
+						keys = append(keys, k)
+					}
+
+					totals[k] += s.Value
+				}
+			}
+
+			for _, k := range keys {
+				pt := append(append(append([]bench.Tag(nil), tags...), src.tags...), bench.Tag{Key: "metric", Value: m.name})
+
+				for _, l := range m.by {
+					pt = append(pt, bench.Tag{Key: l, Value: series[k].Labels[l]})
+				}
+
+				points = append(points, bench.Point{
+					Measurement: "prometheus_metric",
+					Tags:        pt,
+					Fields:      []bench.Field{{Key: "value", Value: totals[k] / float64(len(rrs))}},
+					Time:        gitTime,
+				})
+			}
+		}
+	}
+
+	return points, nil
+}
+
+// capturedHistogramPoints returns the number of observations, mean and quantiles of captured
+// histograms and summaries scraped last in each run, tagged with the run, the metric name,
+// the labels it's grouped by and the endpoint it was scraped from.
+func (ss *scenarioState) capturedHistogramPoints(tags []bench.Tag, rrs []*runResult) ([]bench.Point, error) {
+	histograms, err := parseCaptureHistograms()
+	if err != nil {
+		return nil, err
+	}
+
+	var points []bench.Point
+
+	for _, src := range metricsSources(ss.sortedEndpointNames()) {
+		for _, rr := range rrs {
+			dists := src.scrapes(rr).LastDistributions()
+
+			for _, m := range histograms {
+				for _, d := range bench.MergeDistributionsBy(dists, m.name, m.by...) {
+					pt := append(append(append([]bench.Tag(nil), tags...), src.tags...),
+						bench.Tag{Key: "run", Value: strconv.Itoa(rr.run)},
+						bench.Tag{Key: "metric", Value: m.name})
+
+					for _, l := range m.by {
+						pt = append(pt, bench.Tag{Key: l, Value: d.Labels[l]})
+					}
+
+					fields := []bench.Field{
+						{Key: "count", Value: d.Count},
+						{Key: "mean", Value: d.Mean()},
+					}
+
+					// summaries only have the quantiles they are configured with.
+					for _, hq := range histogramQuantiles {
+						if v := d.Quantile(hq.q); !math.IsNaN(v) {
+							fields = append(fields, bench.Field{Key: hq.field, Value: v})
+						}
+					}
+
+					points = append(points, bench.Point{
+						Measurement: "prometheus_histogram",
+						Tags:        pt,
+						Fields:      fields,
+						Time:        gitTime,
+					})
+				}
+			}
+		}
+	}
+
+	return points, nil
+}
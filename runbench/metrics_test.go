@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+func TestParseCapturedMetrics(t *testing.T) {
+	cases := []struct {
+		list    string
+		want    []capturedMetric
+		wantErr bool
+	}{
+		{list: "", want: nil},
+		{list: "kopia_blob_upload_bytes_total", want: []capturedMetric{{name: "kopia_blob_upload_bytes_total"}}},
+		{list: "a, b:x+y", want: []capturedMetric{{name: "a"}, {name: "b", by: []string{"x", "y"}}}},
+		{list: "a:", wantErr: true},
+		{list: ":x", wantErr: true},
+		{list: "a,,b", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		got, err := parseCapturedMetrics(tc.list, "--capture-metrics")
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: unexpected error %v", tc.list, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %v, want %v", tc.list, got, tc.want)
+		}
+	}
+}
+
+// testScrape returns a scrape of labeled kopia counters with the given value of go_goroutines.
+func testScrape(goroutines string) []byte {
+	return []byte(`# TYPE kopia_blob_upload_bytes_total counter
+kopia_blob_upload_bytes_total{blob_type="p",storage_type="filesystem"} 1000
+kopia_blob_upload_bytes_total{blob_type="q",storage_type="filesystem"} 100
+kopia_blob_upload_bytes_total{blob_type="p",storage_type="s3"} 10
+# TYPE go_goroutines gauge
+go_goroutines ` + goroutines + "\n")
+}
+
+func TestCapturedMetricPoints(t *testing.T) {
+	defer func(s string, tm time.Time) {
+		*captureMetrics, gitTime = s, tm
+	}(*captureMetrics, gitTime)
+
+	*captureMetrics = "kopia_blob_upload_bytes_total:storage_type,go_goroutines,no_such_metric"
+	gitTime = time.Unix(100, 0)
+
+	ss := &scenarioState{endpoints: map[string]string{"server": "http://127.0.0.1:6667/metrics"}}
+
+	var rrs []*runResult
+
+	for _, g := range []string{"40", "44"} {
+		rr := &runResult{Result: &bench.Result{Endpoints: map[string]*bench.PrometheusScrapes{"server": {}}}}
+		rr.Prometheus.Add(testScrape(g))
+		rr.Endpoints["server"].Add(testScrape("10"))
+		rrs = append(rrs, rr)
+	}
+
+	points, err := ss.capturedMetricPoints([]bench.Tag{{Key: "scenario", Value: "s"}}, rrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, p := range points {
+		got = append(got, bench.FormatLine(p))
+	}
+
+	want := []string{
+		"prometheus_metric,scenario=s,metric=kopia_blob_upload_bytes_total,storage_type=filesystem value=1100 100000000000",
+		"prometheus_metric,scenario=s,metric=kopia_blob_upload_bytes_total,storage_type=s3 value=10 100000000000",
+		"prometheus_metric,scenario=s,metric=go_goroutines value=42 100000000000",
+		"prometheus_metric,scenario=s,endpoint=server,metric=kopia_blob_upload_bytes_total,storage_type=filesystem value=1100 100000000000",
+		"prometheus_metric,scenario=s,endpoint=server,metric=kopia_blob_upload_bytes_total,storage_type=s3 value=10 100000000000",
+		"prometheus_metric,scenario=s,endpoint=server,metric=go_goroutines value=10 100000000000",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got:\n%v\nwant:\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pkg/errors"
+)
+
+// marker that can be put in a script to have runbench start an ephemeral MinIO server for the
+// scenario (see startMinio). Its address can also be referenced in NETWORK_SHAPING (e.g.
+// target=$S3_ENDPOINT) and --repo-url. The bucket is emptied before each preparation, so that
+// repositories of previous runs don't pile up. Such scenarios should declare
+// '# REPO_SIZE_SOURCE: blob-stats', since --repo-path isn't where the repository is stored.
+const minioMarker = `# MINIO`
+
+var minioExe = flag.String("minio-exe", "minio", "Path to MinIO server executable used by scenarios with '# MINIO'")
+
+const (
+	minioBucket       = "kopia-benchmark"
+	minioAccessKey    = "runbench"
+	minioSecretKey    = "runbench-secret"
+	minioRegion       = "us-east-1"
+	minioStartTimeout = 30 * time.Second
+)
+
+// minioServer is an ephemeral MinIO instance serving a single bucket from a temporary directory.
+type minioServer struct {
+	cmd     *exec.Cmd
+	dataDir string
+	addr    string
+}
+
+// freeLocalAddr returns a localhost address with a port that is currently not in use.
+func freeLocalAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", errors.Wrap(err, "unable to find free port")
+	}
+
+	defer l.Close()
+
+	return l.Addr().String(), nil
+}
+
+// startMinio launches MinIO, waits for it to become healthy, creates the benchmark bucket
+// and exports S3_ENDPOINT, S3_BUCKET, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY to the scenario.
+func startMinio(ctx context.Context) (*minioServer, error) {
+	addr, err := freeLocalAddr()
+	if err != nil {
+		return nil, err
+	}
+
+	consoleAddr, err := freeLocalAddr()
+	if err != nil {
+		return nil, err
+	}
+
+	dataDir, err := os.MkdirTemp("", "runbench-minio")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create MinIO data directory")
+	}
+
+	m := &minioServer{dataDir: dataDir, addr: addr}
+
+	m.cmd = exec.Command(*minioExe, "server", "--quiet", "--address", addr, "--console-address", consoleAddr, dataDir)
+	m.cmd.Env = append(append([]string(nil), os.Environ()...),
+		"MINIO_ROOT_USER="+minioAccessKey,
+		"MINIO_ROOT_PASSWORD="+minioSecretKey,
+	)
+	m.cmd.Stdout = os.Stderr
+	m.cmd.Stderr = os.Stderr
+
+	if err := m.cmd.Start(); err != nil {
+		os.RemoveAll(dataDir)
+		return nil, errors.Wrap(err, "unable to start MinIO")
+	}
+
+	if err := m.waitHealthy(ctx); err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	setScenarioEnv("S3_ENDPOINT", addr)
+	setScenarioEnv("S3_BUCKET", minioBucket)
+	setScenarioEnv("AWS_ACCESS_KEY_ID", minioAccessKey)
+	setScenarioEnv("AWS_SECRET_ACCESS_KEY", minioSecretKey)
+
+	cli, err := newS3Client(ctx)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	if _, err := cli.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(minioBucket)}); err != nil {
+		m.Close()
+		return nil, errors.Wrap(err, "unable to create bucket")
+	}
+
+	return m, nil
+}
+
+func (m *minioServer) waitHealthy(ctx context.Context) error {
+	deadline := time.Now().Add(minioStartTimeout)
+
+	for time.Now().Before(deadline) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+m.addr+"/minio/health/live", http.NoBody)
+		if err != nil {
+			return errors.Wrap(err, "unable to create request")
+		}
+
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return errors.Errorf("MinIO did not become healthy within %v", minioStartTimeout)
+}
+
+// reset implements storageServer by deleting all objects in the bucket.
+func (m *minioServer) reset(ctx context.Context) error {
+	cli, err := newS3Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	p := s3.NewListObjectsV2Paginator(cli, &s3.ListObjectsV2Input{Bucket: aws.String(minioBucket)})
+
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return errors.Wrap(err, "error listing objects")
+		}
+
+		if len(page.Contents) == 0 {
+			continue
+		}
+
+		var ids []types.ObjectIdentifier
+
+		for _, o := range page.Contents {
+			ids = append(ids, types.ObjectIdentifier{Key: o.Key})
+		}
+
+		// pages have at most 1000 objects, which is the limit of DeleteObjects.
+		if _, err := cli.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(minioBucket),
+			Delete: &types.Delete{Objects: ids, Quiet: true},
+		}); err != nil {
+			return errors.Wrap(err, "error deleting objects")
+		}
+	}
+
+	return nil
+}
+
+// Close stops MinIO and removes its data.
+func (m *minioServer) Close() {
+	_ = m.cmd.Process.Kill()
+	_ = m.cmd.Wait()
+
+	os.RemoveAll(m.dataDir)
+}
+
+// newS3Client returns S3 client for the MinIO server started for the scenario, if any,
+// otherwise using default AWS configuration.
+func newS3Client(ctx context.Context) (*s3.Client, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+
+	endpoint := scenarioEnv["S3_ENDPOINT"]
+	if endpoint != "" {
+		opts = append(opts,
+			awsconfig.WithRegion(minioRegion),
+			awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
+				scenarioEnv["AWS_ACCESS_KEY_ID"], scenarioEnv["AWS_SECRET_ACCESS_KEY"], "")))
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load AWS config")
+	}
+
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.EndpointResolver = s3.EndpointResolverFromURL("http://" + endpoint)
+			o.UsePathStyle = true
+		}
+	}), nil
+}
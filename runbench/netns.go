@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var netnsShaping = flag.String("netns", "", "Run the measured command in its own network namespace with shaped link to the host (Linux only, requires root), e.g. 'latency=50ms bandwidth=20Mbit loss=0.1'")
+
+// marker that can be put in a script to override --netns for the scenario, for example:
+//
+//	# NETNS: latency=50ms bandwidth=20Mbit loss=0.1
+//
+// On Linux the measured command then runs in its own network namespace connected to the host by
+// a shaped veth link, which doesn't affect any other traffic of the host but requires root.
+// Servers on the host are reachable from the namespace at $NETNS_HOST_ADDR.
+const netnsMarker = `# NETNS:`
+
+// addresses of both ends of the veth link between the host and the namespace.
+const (
+	netnsHostAddr = "10.199.0.1"
+	netnsAddr     = "10.199.0.2"
+	netnsPrefix   = "/30"
+)
+
+// netnsSandbox is a network namespace connected to the host by a veth pair whose both ends are
+// shaped using netem, so that only traffic of the measured command is affected and host-wide
+// qdiscs are left untouched. The measured command can reach servers on the host (which must
+// listen on $NETNS_HOST_ADDR or all interfaces) but not the internet.
+type netnsSandbox struct {
+	name    string
+	hostDev string
+	nsDev   string
+
+	latency   time.Duration
+	jitter    time.Duration
+	bandwidth float64 // bytes per second
+	loss      float64 // percent
+}
+
+func parseNetnsSpec(spec string) (*netnsSandbox, error) {
+	s := &netnsSandbox{}
+
+	for _, kv := range strings.Fields(spec) {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, errors.Errorf("invalid network namespace parameter %q", kv)
+		}
+
+		var err error
+
+		switch key {
+		case "latency":
+			s.latency, err = time.ParseDuration(value)
+		case "jitter":
+			s.jitter, err = time.ParseDuration(value)
+		case "bandwidth":
+			s.bandwidth, err = parseBandwidth(value)
+		case "loss":
+			s.loss, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		default:
+			return nil, errors.Errorf("unknown network namespace parameter %q", key)
+		}
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %v", key)
+		}
+	}
+
+	return s, nil
+}
+
+// netemArgs returns the netem parameters of one direction of the link.
+func (s *netnsSandbox) netemArgs() []string {
+	args := []string{"netem"}
+
+	if s.latency > 0 {
+		args = append(args, "delay", fmt.Sprintf("%vus", s.latency.Microseconds()))
+
+		if s.jitter > 0 {
+			args = append(args, fmt.Sprintf("%vus", s.jitter.Microseconds()))
+		}
+	}
+
+	if s.loss > 0 {
+		args = append(args, "loss", strconv.FormatFloat(s.loss, 'f', -1, 64)+"%")
+	}
+
+	if s.bandwidth > 0 {
+		args = append(args, "rate", fmt.Sprintf("%.0fbit", s.bandwidth*8))
+	}
+
+	return args
+}
+
+// command returns a command running exe in the namespace, or directly if there's no sandbox.
+// 'ip netns exec' execs the command in its own process, so the process can be sampled as usual.
+func (s *netnsSandbox) command(ctx context.Context, exe string, args ...string) *exec.Cmd {
+	if s == nil {
+		return exec.CommandContext(ctx, exe, args...)
+	}
+
+	return exec.CommandContext(ctx, "ip", append([]string{"netns", "exec", s.name, exe}, args...)...)
+}
+
+// listenHost returns the address on the host to listen on for connections from the measured command.
+func (s *netnsSandbox) listenHost() string {
+	if s == nil {
+		return "127.0.0.1"
+	}
+
+	return netnsHostAddr
+}
+
+// metricsHost returns the address of the measured command as seen from runbench.
+func (s *netnsSandbox) metricsHost() string {
+	if s == nil {
+		return "localhost"
+	}
+
+	return netnsAddr
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// startNetnsSandbox creates the namespace and the shaped veth link to it.
+func startNetnsSandbox(spec string) (*netnsSandbox, error) {
+	s, err := parseNetnsSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	pid := strconv.Itoa(os.Getpid())
+
+	s.name = "runbench-" + pid
+	s.hostDev = "rbh" + pid
+	s.nsDev = "rbn" + pid
+
+	steps := [][]string{
+		{"netns", "add", s.name},
+		{"link", "add", s.hostDev, "type", "veth", "peer", "name", s.nsDev},
+		{"link", "set", s.nsDev, "netns", s.name},
+		{"addr", "add", netnsHostAddr + netnsPrefix, "dev", s.hostDev},
+		{"link", "set", s.hostDev, "up"},
+		{"-n", s.name, "addr", "add", netnsAddr + netnsPrefix, "dev", s.nsDev},
+		{"-n", s.name, "link", "set", s.nsDev, "up"},
+		{"-n", s.name, "link", "set", "lo", "up"},
+		{"-n", s.name, "route", "add", "default", "via", netnsHostAddr},
+	}
+
+	for _, args := range steps {
+		if err := runNetworkTool("ip", args...); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	// shaping egress of both ends shapes both directions of the link.
+	for _, args := range [][]string{
+		append([]string{"qdisc", "add", "dev", s.hostDev, "root"}, s.netemArgs()...),
+		append([]string{"-n", s.name, "qdisc", "add", "dev", s.nsDev, "root"}, s.netemArgs()...),
+	} {
+		if err := runNetworkTool("tc", args...); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Close deletes the namespace, which also deletes the veth pair and its qdiscs.
+func (s *netnsSandbox) Close() {
+	if err := runNetworkTool("ip", "netns", "delete", s.name); err != nil {
+		log.Printf("unable to delete network namespace: %v", err)
+	}
+}
+
+func runNetworkTool(exe string, args ...string) error {
+	out, err := exec.Command(exe, args...).CombinedOutput()
+
+	return errors.Wrapf(err, "%v %v failed: %s", exe, strings.Join(args, " "), strings.TrimSpace(string(out)))
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "github.com/pkg/errors"
+
+func startNetnsSandbox(spec string) (*netnsSandbox, error) {
+	return nil, errors.New("network namespaces are only supported on Linux")
+}
+
+func (s *netnsSandbox) Close() {}
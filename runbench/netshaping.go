@@ -0,0 +1,296 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"runbench/pkg/bench"
+)
+
+var networkShaping = flag.String("network-shaping", "", "Shape connections to repository server, e.g. 'target=127.0.0.1:9000 latency=50ms bandwidth=20Mbit'")
+
+// marker that can be put in a script to override --network-shaping for the scenario, for example:
+//
+//	# NETWORK_SHAPING: target=127.0.0.1:9000 latency=50ms bandwidth=20Mbit
+//
+// runbench then starts a proxy which shapes connections to the target while the measured command
+// is running, exported as $SHAPED_ADDR. Measurements are tagged with net_shaping set to the
+// parameters other than the target.
+const networkShapingMarker = `# NETWORK_SHAPING:`
+
+const shapingChunkSize = 32 << 10
+
+// shapingProxy is a TCP proxy which adds one-way latency and limits bandwidth of connections
+// to the target server in each direction. Scenarios point kopia at $SHAPED_ADDR instead of the
+// server address, shaping only applies while the measured command is running so that
+// preparation is not slowed down.
+type shapingProxy struct {
+	listener net.Listener
+	target   string
+	latency  time.Duration
+	active   int32
+
+	// shaping are the parameters other than the target, which vary between runs of the scenario.
+	shaping string
+
+	upload   *bandwidthLimiter
+	download *bandwidthLimiter
+
+	wg sync.WaitGroup
+}
+
+// bandwidthLimiter serializes transfers in one direction over a link of a fixed capacity.
+type bandwidthLimiter struct {
+	bytesPerSecond float64
+
+	mu       sync.Mutex
+	nextFree time.Time
+}
+
+func (l *bandwidthLimiter) wait(n int) {
+	if l.bytesPerSecond <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	start := time.Now()
+	if l.nextFree.After(start) {
+		start = l.nextFree
+	}
+
+	l.nextFree = start.Add(time.Duration(float64(n) / l.bytesPerSecond * float64(time.Second)))
+	until := l.nextFree
+	l.mu.Unlock()
+
+	time.Sleep(time.Until(until))
+}
+
+// parseBandwidth parses values such as 20Mbit, 512kbit or 1Gbit and returns bytes per second.
+func parseBandwidth(s string) (float64, error) {
+	lower := strings.ToLower(s)
+
+	multiplier := 1.0
+
+	for _, u := range []struct {
+		suffix string
+		mult   float64
+	}{
+		{"gbit", 1e9},
+		{"mbit", 1e6},
+		{"kbit", 1e3},
+		{"bit", 1},
+	} {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower = strings.TrimSuffix(lower, u.suffix)
+			multiplier = u.mult
+
+			break
+		}
+	}
+
+	v, err := strconv.ParseFloat(lower, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid bandwidth %q", s)
+	}
+
+	return v * multiplier / 8, nil
+}
+
+func startShapingProxy(spec string) (*shapingProxy, error) {
+	p := &shapingProxy{
+		upload:   &bandwidthLimiter{},
+		download: &bandwidthLimiter{},
+	}
+
+	var shaping []string
+
+	for _, kv := range strings.Fields(spec) {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, errors.Errorf("invalid network shaping parameter %q", kv)
+		}
+
+		if key != "target" {
+			shaping = append(shaping, kv)
+		}
+
+		switch key {
+		case "target":
+			p.target = value
+
+		case "latency":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid latency")
+			}
+
+			p.latency = d
+
+		case "bandwidth":
+			bps, err := parseBandwidth(value)
+			if err != nil {
+				return nil, err
+			}
+
+			p.upload.bytesPerSecond = bps
+			p.download.bytesPerSecond = bps
+
+		default:
+			return nil, errors.Errorf("unknown network shaping parameter %q", key)
+		}
+	}
+
+	if p.target == "" {
+		return nil, errors.Errorf("missing target in network shaping %q", spec)
+	}
+
+	p.shaping = strings.Join(shaping, " ")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to listen")
+	}
+
+	p.listener = l
+
+	p.wg.Add(1)
+
+	go p.acceptLoop()
+
+	return p, nil
+}
+
+// Addr returns the address the scenario should connect to instead of the target.
+func (p *shapingProxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// netShapingTags returns the tag recording network shaping of the scenario, without the
+// target whose address is usually ephemeral.
+func (ss *scenarioState) netShapingTags() []bench.Tag {
+	if ss.networkShaper == nil || ss.networkShaper.shaping == "" {
+		return nil
+	}
+
+	return []bench.Tag{{Key: "net_shaping", Value: ss.networkShaper.shaping}}
+}
+
+func (p *shapingProxy) setActive(active bool) {
+	if p == nil {
+		return
+	}
+
+	v := int32(0)
+	if active {
+		v = 1
+	}
+
+	atomic.StoreInt32(&p.active, v)
+}
+
+func (p *shapingProxy) isActive() bool {
+	return atomic.LoadInt32(&p.active) == 1
+}
+
+// Close stops accepting new connections, existing ones are closed by their owners.
+func (p *shapingProxy) Close() {
+	p.listener.Close()
+	p.wg.Wait()
+}
+
+func (p *shapingProxy) acceptLoop() {
+	defer p.wg.Done()
+
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go p.handle(conn)
+	}
+}
+
+func (p *shapingProxy) handle(client net.Conn) {
+	defer client.Close()
+
+	server, err := net.Dial("tcp", p.target)
+	if err != nil {
+		log.Printf("network shaping: unable to connect to %v: %v", p.target, err)
+		return
+	}
+
+	defer server.Close()
+
+	done := make(chan struct{}, 2)
+
+	go func() {
+		p.pipe(server, client, p.upload)
+		done <- struct{}{}
+	}()
+
+	go func() {
+		p.pipe(client, server, p.download)
+		done <- struct{}{}
+	}()
+
+	// when either side is done, closing both connections unblocks the other direction.
+	<-done
+}
+
+type delayedChunk struct {
+	data    []byte
+	readyAt time.Time
+}
+
+// pipe copies data from src to dst, delaying each chunk by the configured latency
+// without serializing the delays, so that throughput is limited by bandwidth only.
+func (p *shapingProxy) pipe(dst io.Writer, src io.Reader, limiter *bandwidthLimiter) {
+	chunks := make(chan delayedChunk, 256)
+
+	go func() {
+		defer close(chunks)
+
+		for {
+			buf := make([]byte, shapingChunkSize)
+
+			n, err := src.Read(buf)
+			if n > 0 {
+				c := delayedChunk{data: buf[0:n], readyAt: time.Now()}
+				if p.isActive() {
+					c.readyAt = c.readyAt.Add(p.latency)
+				}
+
+				chunks <- c
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for c := range chunks {
+		time.Sleep(time.Until(c.readyAt))
+
+		if p.isActive() {
+			limiter.wait(len(c.data))
+		}
+
+		if _, err := dst.Write(c.data); err != nil {
+			// drain remaining chunks so that the reader goroutine can exit.
+			for range chunks {
+			}
+
+			return
+		}
+	}
+}
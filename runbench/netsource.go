@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var networkSource = flag.String("network-source", "", "Export a source directory over a local NFS or SMB server and mount it (Linux only, requires root), e.g. 'protocol=nfs dir=/data/linux'")
+
+// marker that can be put in a script to override --network-source for the scenario, for example:
+//
+//	# NETWORK_SOURCE: protocol=nfs dir=$HOME/backup-sources/linux
+//
+// On Linux the directory is then exported using the kernel NFS server (protocol=nfs,
+// nfs-kernel-server must be running) or a dedicated smbd (protocol=smb) and mounted read-only at
+// $NETWORK_SOURCE_DIR, which the measured command should snapshot instead of the directory.
+// This requires root.
+const networkSourceMarker = `# NETWORK_SOURCE:`
+
+const (
+	networkSourceNFS = "nfs"
+	networkSourceSMB = "smb"
+)
+
+// networkShare is a source directory exported by a local network filesystem server and mounted
+// back, so that the measured command reads the source through the network filesystem client.
+// The share is read-only and its mount point is exported to the scenario as $NETWORK_SOURCE_DIR.
+type networkShare struct {
+	protocol     string
+	dir          string
+	mountOptions string
+
+	mountPoint string
+	cleanup    []func() error
+}
+
+func parseNetworkSourceSpec(spec string) (*networkShare, error) {
+	s := &networkShare{}
+
+	for _, kv := range strings.Fields(spec) {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, errors.Errorf("invalid network source parameter %q", kv)
+		}
+
+		switch key {
+		case "protocol":
+			s.protocol = value
+		case "dir":
+			s.dir = value
+		case "mount-options":
+			s.mountOptions = value
+		default:
+			return nil, errors.Errorf("unknown network source parameter %q", key)
+		}
+	}
+
+	if s.protocol != networkSourceNFS && s.protocol != networkSourceSMB {
+		return nil, errors.Errorf("unsupported network source protocol %q", s.protocol)
+	}
+
+	if s.dir == "" {
+		return nil, errors.Errorf("missing dir in network source %q", spec)
+	}
+
+	return s, nil
+}
+
+// Close unmounts the share and stops exporting it, in reverse order of setup.
+func (s *networkShare) Close() {
+	for i := len(s.cleanup) - 1; i >= 0; i-- {
+		if err := s.cleanup[i](); err != nil {
+			log.Printf("unable to clean up network source: %v", err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// port of the SMB server started for the share, not conflicting with a system-wide server.
+const smbPort = "4455"
+
+// startNetworkShare exports the directory and mounts it on a temporary mount point.
+func startNetworkShare(spec string) (*networkShare, error) {
+	s, err := parseNetworkSourceSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.dir, err = filepath.Abs(s.dir); err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.MkdirTemp("", "runbench-share")
+	if err != nil {
+		return nil, err
+	}
+
+	s.cleanup = append(s.cleanup, func() error { return os.RemoveAll(tmp) })
+	s.mountPoint = filepath.Join(tmp, "mnt")
+
+	if err := os.Mkdir(s.mountPoint, 0o755); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if s.protocol == networkSourceNFS {
+		err = s.startNFS()
+	} else {
+		err = s.startSMB(tmp)
+	}
+
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// startNFS exports the directory using the kernel NFS server, which must be running.
+func (s *networkShare) startNFS() error {
+	export := "127.0.0.1:" + s.dir
+
+	if err := runShareTool("exportfs", "-o", "ro,no_root_squash,insecure,no_subtree_check,fsid="+fmt.Sprint(os.Getpid()), export); err != nil {
+		return err
+	}
+
+	s.cleanup = append(s.cleanup, func() error { return runShareTool("exportfs", "-u", export) })
+
+	return s.mount("nfs", export, "ro")
+}
+
+// startSMB starts smbd with a config sharing only the directory to guests.
+func (s *networkShare) startSMB(tmp string) error {
+	state := filepath.Join(tmp, "smb")
+	if err := os.Mkdir(state, 0o700); err != nil {
+		return err
+	}
+
+	conf := filepath.Join(state, "smb.conf")
+
+	if err := os.WriteFile(conf, []byte(strings.Join([]string{
+		"[global]",
+		"server role = standalone server",
+		"map to guest = Bad User",
+		"smb ports = " + smbPort,
+		"interfaces = lo",
+		"bind interfaces only = yes",
+		"pid directory = " + state,
+		"lock directory = " + state,
+		"state directory = " + state,
+		"cache directory = " + state,
+		"private dir = " + state,
+		"log file = " + filepath.Join(state, "log.%m"),
+		"[source]",
+		"path = " + s.dir,
+		"read only = yes",
+		"guest ok = yes",
+		"force user = root",
+		"",
+	}, "\n")), 0o600); err != nil {
+		return err
+	}
+
+	c := exec.Command("smbd", "--foreground", "--no-process-group", "--configfile="+conf)
+	if err := c.Start(); err != nil {
+		return errors.Wrap(err, "unable to start smbd")
+	}
+
+	s.cleanup = append(s.cleanup, func() error {
+		c.Process.Signal(os.Interrupt)
+		return c.Wait()
+	})
+
+	// wait for smbd to start listening.
+	var err error
+
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(200 * time.Millisecond) {
+		if err = s.mount("cifs", "//127.0.0.1/source", "ro,guest,vers=3.0,port="+smbPort); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (s *networkShare) mount(fsType, source, options string) error {
+	if s.mountOptions != "" {
+		options += "," + s.mountOptions
+	}
+
+	if err := runShareTool("mount", "-t", fsType, "-o", options, source, s.mountPoint); err != nil {
+		return err
+	}
+
+	s.cleanup = append(s.cleanup, func() error { return runShareTool("umount", s.mountPoint) })
+
+	return nil
+}
+
+func runShareTool(exe string, args ...string) error {
+	out, err := exec.Command(exe, args...).CombinedOutput()
+
+	return errors.Wrapf(err, "%v %v failed: %s", exe, strings.Join(args, " "), strings.TrimSpace(string(out)))
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "github.com/pkg/errors"
+
+func startNetworkShare(spec string) (*networkShare, error) {
+	return nil, errors.New("network sources are only supported on Linux")
+}
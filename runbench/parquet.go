@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"runbench/pkg/bench"
+)
+
+var parquetDir = flag.String("parquet-dir", "", "Also write all samples of measured runs to Parquet files in this directory, partitioned as scenario=<scenario>/revision=<revision>/<time>.parquet")
+
+// parquetSample is a row of the Parquet file of a scenario, scenario and revision are columns
+// of the partitioning directories.
+type parquetSample struct {
+	GitTime     int64              `parquet:"name=git_time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Time        int64              `parquet:"name=time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Cache       string             `parquet:"name=cache, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MemoryLimit string             `parquet:"name=memory_limit, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Step        string             `parquet:"name=step, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Run         int32              `parquet:"name=run, type=INT32"`
+	RAM         float64            `parquet:"name=ram_rss, type=DOUBLE"`
+	CPU         float64            `parquet:"name=cpu_percent, type=DOUBLE"`
+	Values      map[string]float64 `parquet:"name=values, type=MAP, convertedtype=MAP, keytype=BYTE_ARRAY, keyconvertedtype=UTF8, valuetype=DOUBLE"`
+}
+
+// parquetFile returns the Parquet file of the scenario for the current kopia revision.
+func parquetFile(scen string) string {
+	return filepath.Join(*parquetDir, "scenario="+scen, "revision="+gitRevision, gitTime.UTC().Format("2006-01-02_150405")+".parquet")
+}
+
+// parquetSamples writes samples of measured runs of a scenario to its Parquet file, which only
+// appears once complete.
+type parquetSamples struct {
+	fname string
+	f     *os.File
+	pw    *writer.ParquetWriter
+}
+
+// openParquetSamples returns the writer of samples of the scenario, or nil without --parquet-dir.
+func openParquetSamples(scen string) (*parquetSamples, error) {
+	if *parquetDir == "" {
+		return nil, nil
+	}
+
+	fname := parquetFile(scen)
+
+	if err := os.MkdirAll(filepath.Dir(fname), 0o700); err != nil {
+		return nil, errors.Wrap(err, "unable to create parquet directory")
+	}
+
+	f, err := os.Create(fname + ".tmp")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create parquet file")
+	}
+
+	pw, err := writer.NewParquetWriterFromWriter(f, new(parquetSample), 1)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return nil, errors.Wrap(err, "unable to create parquet writer")
+	}
+
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	return &parquetSamples{fname: fname, f: f, pw: pw}, nil
+}
+
+// write adds all samples of the run in the given states, which are empty when not measured.
+func (p *parquetSamples) write(res *bench.Result, run int, cache, limit, step string) error {
+	if p == nil {
+		return nil
+	}
+
+	for _, s := range res.Samples {
+		if err := p.pw.Write(parquetSample{
+			GitTime:     gitTime.UnixMilli(),
+			Time:        s.Time.UnixMilli(),
+			Cache:       cache,
+			MemoryLimit: limit,
+			Step:        step,
+			Run:         int32(run),
+			RAM:         s.RAM,
+			CPU:         s.CPU,
+			Values:      s.Values,
+		}); err != nil {
+			return errors.Wrap(err, "unable to write parquet sample")
+		}
+	}
+
+	return nil
+}
+
+// Close completes the Parquet file, it is removed if incomplete.
+func (p *parquetSamples) Close() error {
+	if p == nil {
+		return nil
+	}
+
+	err := p.pw.WriteStop()
+	if cerr := p.f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(p.f.Name())
+
+		return errors.Wrap(err, "unable to write parquet file")
+	}
+
+	return errors.Wrap(os.Rename(p.f.Name(), p.fname), "unable to write parquet file")
+}
+
+// writeParquetSamples writes samples of all successful runs of the scenario with --parquet-dir.
+// Combined runs of cumulative scenarios are skipped, their samples are the ones of the steps.
+func writeParquetSamples(scen string, results []*limitedRuns) error {
+	p, err := openParquetSamples(scen)
+	if p == nil {
+		return err
+	}
+
+	for _, lr := range results {
+		if lr.err != nil {
+			continue
+		}
+
+		for _, sr := range lr.steps {
+			if sr.step == "" && len(lr.steps) > 1 {
+				continue
+			}
+
+			for _, rr := range sr.runs {
+				if err := p.write(rr.Result, rr.run, lr.cache, lr.limit, sr.step); err != nil {
+					p.Close()
+
+					return err
+				}
+			}
+		}
+	}
+
+	return p.Close()
+}
@@ -0,0 +1,307 @@
+// Package bench implements measurement machinery of runbench which can be embedded in other
+// benchmark harnesses: running a command while sampling its CPU and memory usage and
+// Prometheus metrics, summarizing samples of repeated runs and emitting the results in
+// InfluxDB line protocol.
+//
+// A typical harness runs the measured command several times using a Runner, summarizes the
+// results and writes points describing them to a Sink:
+//
+//	r := &bench.CommandRunner{Interval: 100 * time.Millisecond, MetricsURL: "http://localhost:6666/metrics"}
+//	res, err := r.Run(ctx, exec.CommandContext(ctx, kopiaExe, "--metrics-listen-addr=:6666", "snapshot", "create", dir))
+//	...
+//	s := bench.Summarize(results)
+//	err = bench.NewLineProtocolSink(os.Stdout).Write(bench.Point{
+//		Measurement: "process_summary",
+//		Tags:        tags,
+//		Fields:      []bench.Field{{Key: "duration", Value: bench.Fixed{Value: s.AvgDuration, Digits: 1}}},
+//		Time:        gitTime,
+//	})
+//
+// Samples are taken by Samplers: CPU and memory of the process and Prometheus metrics by default,
+// other registered samplers (such as "disk-io" and "system-load", or ones the harness registers
+// using RegisterSampler) are selected by name in CommandRunner.Samplers.
+//
+// Harnesses which prepare their own repositories run unmeasured kopia commands using Kopia and
+// open --output using OpenOutput.
+package bench
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// Runner runs a command to completion and measures it.
+type Runner interface {
+	Run(ctx context.Context, c *exec.Cmd) (*Result, error)
+}
+
+// Result is the measurement of a single run of a command.
+type Result struct {
+	Duration time.Duration
+	Samples  []*Sample
+
+	// overhead of the measuring process itself during the run
+	SelfCPUSeconds float64
+	SelfRAM        float64 // MiB
+	SamplingTime   time.Duration
+
+	// SamplingErrors is the number of samples dropped because a sampler failed while the process
+	// was running.
+	SamplingErrors int
+
+	// Prometheus summarizes metrics scraped with the samples, which don't keep them.
+	Prometheus PrometheusScrapes
+
+	// Endpoints summarize metrics scraped from CommandRunner.MetricsEndpoints by endpoint name.
+	Endpoints map[string]*PrometheusScrapes
+}
+
+// addSample appends the sample, moving its Prometheus metrics into r.Prometheus so that long
+// runs don't keep all scrapes in memory.
+func (r *Result) addSample(s *Sample) {
+	if len(s.PrometheusMetrics) > 0 {
+		r.Prometheus.Add(s.PrometheusMetrics)
+		s.PrometheusMetrics = nil
+	}
+
+	for name, m := range s.EndpointMetrics {
+		if len(m) == 0 {
+			continue
+		}
+
+		if r.Endpoints == nil {
+			r.Endpoints = map[string]*PrometheusScrapes{}
+		}
+
+		if r.Endpoints[name] == nil {
+			r.Endpoints[name] = &PrometheusScrapes{}
+		}
+
+		r.Endpoints[name].Add(m)
+	}
+
+	s.EndpointMetrics = nil
+
+	r.Samples = append(r.Samples, s)
+}
+
+// LastCounter returns the last positive value of the Prometheus metric scraped during the run,
+// or zero if it was never scraped. For cumulative counters use CounterDelta.
+func (r *Result) LastCounter(name string) float64 {
+	if cs := r.Prometheus.counters[name]; cs != nil {
+		return cs.lastPositive
+	}
+
+	return 0
+}
+
+// CounterDelta returns how much the cumulative Prometheus counter increased between its first and
+// last scrape during the run, or zero if it was scraped fewer than two times. A decrease between
+// consecutive scrapes is treated as a restart of the process, after which the counter starts from
+// zero, so that values of several processes are added up instead of the last one being returned.
+func (r *Result) CounterDelta(name string) float64 {
+	if cs := r.Prometheus.counters[name]; cs != nil {
+		return cs.delta
+	}
+
+	return 0
+}
+
+// LastMetrics returns all series of the last Prometheus scrape during the run.
+func (r *Result) LastMetrics() []PrometheusSeries {
+	return r.Prometheus.LastSeries()
+}
+
+// LastDistributions returns all histograms and summaries of the last Prometheus scrape during the run.
+func (r *Result) LastDistributions() []PrometheusDistribution {
+	return r.Prometheus.LastDistributions()
+}
+
+// EndpointScrapes returns metrics scraped from the named endpoint of CommandRunner.MetricsEndpoints
+// during the run, which are empty if it was never scraped successfully.
+func (r *Result) EndpointScrapes(name string) *PrometheusScrapes {
+	if p := r.Endpoints[name]; p != nil {
+		return p
+	}
+
+	return &PrometheusScrapes{}
+}
+
+// CommandRunner is a Runner which samples the started process every Interval until it exits.
+type CommandRunner struct {
+	Interval time.Duration
+
+	// TimeOffset is added to the time of each sample.
+	TimeOffset time.Duration
+
+	// MetricsURL is the Prometheus endpoint of the command scraped with each sample, if not empty.
+	MetricsURL string
+
+	// MetricsEndpoints are Prometheus endpoints of other processes (e.g. the server the command
+	// talks to) by name, also scraped with each sample into Result.Endpoints.
+	MetricsEndpoints map[string]string
+
+	// Samplers are names of registered samplers (see RegisterSampler) taking each sample,
+	// by default "process" and, with MetricsURL, "prometheus".
+	Samplers []string
+
+	// RawSamples, if not nil, receives each sample including its full Prometheus scrape as
+	// a line of JSON before the scrape is discarded.
+	RawSamples io.Writer
+}
+
+// Run implements Runner. The result is returned along with the error of a failed command.
+// Cancellation of ctx kills the command and returns the error of ctx without a result.
+func (r *CommandRunner) Run(ctx context.Context, c *exec.Cmd) (*Result, error) {
+	t0 := time.Now()
+
+	if err := c.Start(); err != nil {
+		return nil, errors.Wrap(err, "unable to start")
+	}
+
+	var (
+		dur    time.Duration
+		runErr error
+		done   = make(chan struct{})
+	)
+
+	go func() {
+		runErr = c.Wait()
+		dur = time.Since(t0)
+		close(done)
+	}()
+
+	// abort kills the command which can't be measured, so that it doesn't outlive the run.
+	abort := func(err error) (*Result, error) {
+		_ = c.Process.Kill()
+		<-done
+
+		return nil, err
+	}
+
+	samplers, err := r.samplers(ctx, c.Process.Pid)
+	if err != nil {
+		return abort(err)
+	}
+
+	self, err := process.NewProcessWithContext(ctx, int32(os.Getpid()))
+	if err != nil {
+		return abort(errors.Wrap(err, "unable to attach to self"))
+	}
+
+	selfTimes0, err := self.TimesWithContext(ctx)
+	if err != nil {
+		return abort(errors.Wrap(err, "unable to get own CPU times"))
+	}
+
+	var (
+		res    = &Result{}
+		rawErr error
+	)
+
+	// sampling ends when Wait returns, sampling errors are transient unless the process exits
+	// before the next sample, in which case the failed sample is not counted.
+	for exited := false; !exited; {
+		tSample := time.Now()
+
+		s := &Sample{
+			Time: time.Now().Add(r.TimeOffset),
+		}
+
+		sampleErr := takeSample(ctx, s, samplers)
+		if sampleErr == nil {
+			if r.RawSamples != nil && rawErr == nil {
+				rawErr = WriteRawSample(r.RawSamples, s)
+			}
+
+			res.addSample(s)
+		}
+
+		res.SamplingTime += time.Since(tSample)
+
+		select {
+		case <-done:
+			exited = true
+
+		case <-ctx.Done():
+			// commands not started using exec.CommandContext are killed too.
+			_ = c.Process.Kill()
+			<-done
+
+			exited = true
+
+		case <-time.After(r.Interval):
+			if sampleErr != nil {
+				res.SamplingErrors++
+			}
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	selfTimes1, err := self.TimesWithContext(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get own CPU times")
+	}
+
+	selfMem, err := self.MemoryInfoWithContext(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get own memory info")
+	}
+
+	if len(res.Samples) == 0 {
+		return nil, errors.Errorf("no samples")
+	}
+
+	res.Duration = dur
+	res.SelfCPUSeconds = (selfTimes1.User + selfTimes1.System) - (selfTimes0.User + selfTimes0.System)
+	res.SelfRAM = float64(selfMem.RSS) / (1 << 20)
+
+	if runErr == nil && rawErr != nil {
+		runErr = errors.Wrap(rawErr, "unable to write raw samples")
+	}
+
+	return res, runErr
+}
+
+// takeSample fills in the sample using all samplers and returns the first error.
+func takeSample(ctx context.Context, s *Sample, samplers []Sampler) error {
+	for _, sm := range samplers {
+		if err := sm.Sample(ctx, s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *CommandRunner) samplers(ctx context.Context, pid int) ([]Sampler, error) {
+	names := r.Samplers
+
+	if names == nil {
+		names = []string{"process"}
+
+		if r.MetricsURL != "" {
+			names = append(names, "prometheus")
+		}
+	}
+
+	samplers, err := NewSamplers(ctx, names, SamplerTarget{PID: pid, MetricsURL: r.MetricsURL})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, u := range r.MetricsEndpoints {
+		samplers = append(samplers, &PrometheusEndpointSampler{Name: name, URL: u})
+	}
+
+	return samplers, nil
+}
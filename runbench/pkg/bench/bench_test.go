@@ -0,0 +1,348 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newCounterServer returns a Prometheus endpoint whose test_ops_total counter is incremented
+// by each scrape.
+func newCounterServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var scrapes int64
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&scrapes, 1)
+		fmt.Fprintf(w, "# TYPE test_ops_total counter\ntest_ops_total %v\n", 10*n)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestCommandRunnerRun(t *testing.T) {
+	s := newCounterServer(t)
+
+	var raw bytes.Buffer
+
+	r := &CommandRunner{
+		Interval:         50 * time.Millisecond,
+		MetricsURL:       s.URL,
+		MetricsEndpoints: map[string]string{"server": s.URL},
+		RawSamples:       &raw,
+	}
+
+	res, err := r.Run(context.Background(), exec.Command("sleep", "0.5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Duration < 500*time.Millisecond {
+		t.Errorf("duration %v is shorter than the command", res.Duration)
+	}
+
+	if len(res.Samples) < 3 {
+		t.Fatalf("got %v samples, want at least 3", len(res.Samples))
+	}
+
+	for _, s := range res.Samples {
+		if s.RAM <= 0 {
+			t.Errorf("sample at %v has no RSS", s.Time)
+		}
+
+		if s.PrometheusMetrics != nil || s.EndpointMetrics != nil {
+			t.Errorf("sample at %v keeps its scrape", s.Time)
+		}
+	}
+
+	if got := res.CounterDelta("test_ops_total"); got <= 0 {
+		t.Errorf("CounterDelta = %v, want positive", got)
+	}
+
+	if got := len(res.EndpointScrapes("server").LastSeries()); got != 1 {
+		t.Errorf("got %v series scraped from endpoint, want 1", got)
+	}
+
+	if got := bytes.Count(raw.Bytes(), []byte("\n")); got != len(res.Samples) {
+		t.Errorf("got %v raw samples, want %v", got, len(res.Samples))
+	}
+}
+
+func TestCommandRunnerRunFailedCommand(t *testing.T) {
+	r := &CommandRunner{Interval: 50 * time.Millisecond}
+
+	res, err := r.Run(context.Background(), exec.Command("sh", "-c", "sleep 0.2; exit 3"))
+
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) || ee.ExitCode() != 3 {
+		t.Fatalf("got error %v, want exit status 3", err)
+	}
+
+	if res == nil || len(res.Samples) == 0 {
+		t.Errorf("got result %v, want samples of the failed command", res)
+	}
+}
+
+func TestCommandRunnerRunKillsCommandWithoutSamplers(t *testing.T) {
+	r := &CommandRunner{Interval: 50 * time.Millisecond, Samplers: []string{"no-such-sampler"}}
+	c := exec.Command("sleep", "30")
+
+	t0 := time.Now()
+
+	if _, err := r.Run(context.Background(), c); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	if c.ProcessState == nil {
+		t.Fatal("command was not waited for")
+	}
+
+	if d := time.Since(t0); d > 10*time.Second {
+		t.Errorf("command was not killed, Run took %v", d)
+	}
+}
+
+func TestCommandRunnerRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	r := &CommandRunner{Interval: 50 * time.Millisecond}
+
+	res, err := r.Run(ctx, exec.Command("sleep", "30"))
+	if !errors.Is(err, context.DeadlineExceeded) || res != nil {
+		t.Errorf("got %v, %v, want no result and deadline exceeded", res, err)
+	}
+}
+
+func TestPrometheusSamplerTimeout(t *testing.T) {
+	defer func(d time.Duration) { scrapeTimeout = d }(scrapeTimeout)
+
+	scrapeTimeout = 100 * time.Millisecond
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer s.Close()
+
+	samplers := []Sampler{
+		&PrometheusSampler{URL: s.URL},
+		&PrometheusEndpointSampler{Name: "server", URL: s.URL},
+	}
+
+	for _, sm := range samplers {
+		t0 := time.Now()
+
+		smp := &Sample{}
+		if err := sm.Sample(context.Background(), smp); err != nil {
+			t.Fatal(err)
+		}
+
+		if d := time.Since(t0); d > 5*time.Second {
+			t.Errorf("%T didn't time out, scraping took %v", sm, d)
+		}
+
+		if smp.PrometheusMetrics != nil || smp.EndpointMetrics != nil {
+			t.Errorf("%T returned metrics of a failed scrape", sm)
+		}
+	}
+}
+
+func TestPrometheusSamplerDiscardsTruncatedScrape(t *testing.T) {
+	defer func(d time.Duration) { scrapeTimeout = d }(scrapeTimeout)
+
+	scrapeTimeout = 200 * time.Millisecond
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "go_goroutines 4")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer s.Close()
+
+	smp := &Sample{}
+	if err := (&PrometheusSampler{URL: s.URL}).Sample(context.Background(), smp); err != nil {
+		t.Fatal(err)
+	}
+
+	if smp.PrometheusMetrics != nil {
+		t.Errorf("got truncated scrape %q", smp.PrometheusMetrics)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	results := []*Result{
+		{
+			Duration:       2 * time.Second,
+			SelfCPUSeconds: 0.2,
+			SelfRAM:        30,
+			SamplingTime:   100 * time.Millisecond,
+			Samples: []*Sample{
+				{CPU: 100, RAM: 10, Values: map[string]float64{"v": 1}},
+				{CPU: 200, RAM: 30},
+			},
+		},
+		{
+			Duration:       4 * time.Second,
+			SelfCPUSeconds: 0.8,
+			SelfRAM:        20,
+			SamplingTime:   400 * time.Millisecond,
+			SamplingErrors: 2,
+			Samples: []*Sample{
+				{CPU: 300, RAM: 20, Values: map[string]float64{"v": 5}},
+			},
+		},
+	}
+
+	got := Summarize(results)
+
+	want := Summary{
+		AvgCPU:              200,
+		MaxCPU:              300,
+		AvgRAM:              20,
+		MaxRAM:              30,
+		AvgDuration:         3,
+		AvgSelfCPU:          15,
+		MaxSelfRAM:          30,
+		AvgSamplingOverhead: 7.5,
+		SamplingErrors:      2,
+		Values:              map[string]ValueSummary{"v": {Avg: 3, Max: 5}},
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{5, 1, 4, 2, 3}
+
+	for _, tc := range []struct {
+		p    float64
+		want float64
+	}{
+		{0, 1},
+		{20, 1},
+		{50, 3},
+		{90, 5},
+		{100, 5},
+	} {
+		if got := Percentile(values, tc.p); got != tc.want {
+			t.Errorf("Percentile(%v) = %v, want %v", tc.p, got, tc.want)
+		}
+	}
+
+	if got := Percentile(nil, 50); !math.IsNaN(got) {
+		t.Errorf("Percentile of no values = %v, want NaN", got)
+	}
+}
+
+var testPoint = Point{
+	Measurement: "process summary",
+	Tags:        []Tag{{"scenario", "snap,shot"}, {"run_tags", "a=b c"}},
+	Fields: []Field{
+		{"duration", Fixed{Value: 1.25, Digits: 1}},
+		{"repo_size", int64(1234)},
+		{"path", `C:\tmp "x"`},
+	},
+	Time: time.Unix(1, 5),
+}
+
+func TestLineProtocolSink(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := NewLineProtocolSink(&buf).Write(testPoint, testPoint); err != nil {
+		t.Fatal(err)
+	}
+
+	line := `process\ summary,scenario=snap\,shot,run_tags=a\=b\ c duration=1.2,repo_size=1234,path="C:\\tmp \"x\"" 1000000005` + "\n"
+
+	if got := buf.String(); got != line+line {
+		t.Errorf("got %q, want %q", got, line+line)
+	}
+}
+
+func TestParseLineRoundTrip(t *testing.T) {
+	p, err := ParseLine(FormatLine(testPoint))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := ParsedPoint{
+		Measurement: "process summary",
+		Tags:        map[string]string{"scenario": "snap,shot", "run_tags": "a=b c"},
+		Fields:      map[string]float64{"duration": 1.2, "repo_size": 1234},
+		Timestamp:   1000000005,
+	}
+
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+}
+
+func TestParseLineEscapedSeparators(t *testing.T) {
+	in := Point{
+		Measurement: "m,1 x",
+		Tags:        []Tag{{"k ey", "a,b=c d"}, {"x=y", ""}},
+		Fields:      []Field{{"f,1", 2.5}, {"note", `quoted "a b,c" \`}, {"n=2", int64(-7)}},
+		Time:        time.Unix(0, 42),
+	}
+
+	p, err := ParseLine(FormatLine(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := ParsedPoint{
+		Measurement: "m,1 x",
+		Tags:        map[string]string{"k ey": "a,b=c d", "x=y": ""},
+		Fields:      map[string]float64{"f,1": 2.5, "n=2": -7},
+		Timestamp:   42,
+	}
+
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+}
+
+func TestParseLineInvalid(t *testing.T) {
+	for _, line := range []string{
+		"measurement",
+		"m,tag f=1 1",
+		"m f 1",
+		"m f=1 abc",
+	} {
+		if _, err := ParseLine(line); err == nil {
+			t.Errorf("ParseLine(%q) succeeded", line)
+		}
+	}
+}
+
+func TestJSONSink(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := NewJSONSink(&buf).Write(testPoint); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"measurement":"process summary","tags":{"run_tags":"a=b c","scenario":"snap,shot"},` +
+		`"fields":{"duration":1.2,"path":"C:\\tmp \"x\"","repo_size":1234},"time":"` +
+		time.Unix(1, 5).Format(time.RFC3339Nano) + `"}` + "\n"
+
+	if got := buf.String(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
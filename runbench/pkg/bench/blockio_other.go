@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package bench
+
+import "os"
+
+// BlockIO is not supported on this platform.
+func BlockIO(ps *os.ProcessState) (read, written int64, ok bool) {
+	return 0, 0, false
+}
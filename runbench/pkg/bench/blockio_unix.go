@@ -0,0 +1,20 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package bench
+
+import (
+	"os"
+	"syscall"
+)
+
+// BlockIO returns the number of bytes read from and written to block devices by the exited process.
+func BlockIO(ps *os.ProcessState) (read, written int64, ok bool) {
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0, 0, false
+	}
+
+	// rusage reports blocks of 512 bytes.
+	return int64(ru.Inblock) * 512, int64(ru.Oublock) * 512, true
+}
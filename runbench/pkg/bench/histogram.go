@@ -0,0 +1,226 @@
+package bench
+
+import (
+	"bytes"
+	"math"
+	"sort"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// PrometheusDistribution is a single series of a Prometheus histogram or summary.
+type PrometheusDistribution struct {
+	Name   string
+	Labels map[string]string
+	Count  float64
+	Sum    float64
+
+	// Buckets of a histogram with cumulative counts, sorted by upper bound.
+	Buckets []HistogramBucket
+
+	// Quantiles of a summary.
+	Quantiles map[float64]float64
+}
+
+// HistogramBucket is the number of observations less than or equal to UpperBound.
+type HistogramBucket struct {
+	UpperBound float64
+	Count      float64
+}
+
+// Mean returns the average observed value.
+func (d PrometheusDistribution) Mean() float64 {
+	if d.Count == 0 {
+		return 0
+	}
+
+	return d.Sum / d.Count
+}
+
+// Quantile returns the q-quantile (0..1) of the distribution. Quantiles of histograms are
+// interpolated linearly within buckets like histogram_quantile() of PromQL, summaries only
+// provide the quantiles they were configured with and NaN is returned for others.
+func (d PrometheusDistribution) Quantile(q float64) float64 {
+	if d.Buckets == nil {
+		if v, ok := d.Quantiles[q]; ok {
+			return v
+		}
+
+		return math.NaN()
+	}
+
+	// like in PromQL, quantiles can't be estimated without finite buckets.
+	if len(d.Buckets) < 2 || d.Count == 0 {
+		return math.NaN()
+	}
+
+	rank := q * d.Count
+
+	var lowerBound, lowerCount float64
+
+	for _, b := range d.Buckets {
+		if b.Count >= rank {
+			if math.IsInf(b.UpperBound, 1) {
+				// observations above the highest finite bound are reported as that bound.
+				return lowerBound
+			}
+
+			if b.Count == lowerCount {
+				return b.UpperBound
+			}
+
+			return lowerBound + (b.UpperBound-lowerBound)*(rank-lowerCount)/(b.Count-lowerCount)
+		}
+
+		lowerBound, lowerCount = b.UpperBound, b.Count
+	}
+
+	return lowerBound
+}
+
+// ParsePrometheusDistributions returns all series of histograms and summaries in Prometheus text format.
+func ParsePrometheusDistributions(b []byte) ([]PrometheusDistribution, error) {
+	var p expfmt.TextParser
+
+	families, err := p.TextToMetricFamilies(bytes.NewReader(b))
+
+	var res []PrometheusDistribution
+
+	for name, mf := range families {
+		for _, m := range mf.GetMetric() {
+			d := PrometheusDistribution{Name: name, Labels: map[string]string{}}
+
+			for _, lp := range m.GetLabel() {
+				d.Labels[lp.GetName()] = lp.GetValue()
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				d.Count, d.Sum = float64(h.GetSampleCount()), h.GetSampleSum()
+				d.Buckets = []HistogramBucket{}
+
+				for _, b := range h.GetBucket() {
+					d.Buckets = append(d.Buckets, HistogramBucket{b.GetUpperBound(), float64(b.GetCumulativeCount())})
+				}
+
+				sort.Slice(d.Buckets, func(i, j int) bool {
+					return d.Buckets[i].UpperBound < d.Buckets[j].UpperBound
+				})
+
+				// the +Inf bucket may be left out, since it's equal to the count.
+				if n := len(d.Buckets); n == 0 || !math.IsInf(d.Buckets[n-1].UpperBound, 1) {
+					d.Buckets = append(d.Buckets, HistogramBucket{math.Inf(1), d.Count})
+				}
+
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				d.Count, d.Sum = float64(s.GetSampleCount()), s.GetSampleSum()
+				d.Quantiles = map[float64]float64{}
+
+				for _, q := range s.GetQuantile() {
+					d.Quantiles[q.GetQuantile()] = q.GetValue()
+				}
+
+			default:
+				continue
+			}
+
+			res = append(res, d)
+		}
+	}
+
+	return res, err
+}
+
+// MergeDistributionsBy merges series of the histogram or summary grouped by the given labels, which
+// are the only labels of the returned series. Buckets of histograms are added up, summaries can't be
+// merged and only their counts and sums are, so groups of more than one summary have no quantiles.
+func MergeDistributionsBy(dists []PrometheusDistribution, name string, labels ...string) []PrometheusDistribution {
+	groups := map[string]*PrometheusDistribution{}
+	merged := map[string]int{}
+
+	var keys []string
+
+	for _, d := range dists {
+		if d.Name != name {
+			continue
+		}
+
+		g := PrometheusSeries{Name: name, Labels: map[string]string{}}
+		for _, l := range labels {
+			g.Labels[l] = d.Labels[l]
+		}
+
+		key := g.Key()
+		merged[key]++
+
+		acc := groups[key]
+		if acc == nil {
+			groups[key] = &PrometheusDistribution{
+				Name:      name,
+				Labels:    g.Labels,
+				Count:     d.Count,
+				Sum:       d.Sum,
+				Buckets:   append([]HistogramBucket(nil), d.Buckets...),
+				Quantiles: d.Quantiles,
+			}
+			keys = append(keys, key)
+
+			continue
+		}
+
+		acc.Count += d.Count
+		acc.Sum += d.Sum
+		acc.Quantiles = nil
+		acc.Buckets = addBuckets(acc.Buckets, d.Buckets)
+	}
+
+	sort.Strings(keys)
+
+	var res []PrometheusDistribution
+
+	for _, k := range keys {
+		res = append(res, *groups[k])
+	}
+
+	return res
+}
+
+// addBuckets adds cumulative counts of histograms, whose bounds are normally identical.
+func addBuckets(a, b []HistogramBucket) []HistogramBucket {
+	if a == nil || b == nil {
+		return nil
+	}
+
+	countAt := func(buckets []HistogramBucket, bound float64) float64 {
+		var c float64
+
+		for _, bk := range buckets {
+			if bk.UpperBound > bound {
+				break
+			}
+
+			c = bk.Count
+		}
+
+		return c
+	}
+
+	bounds := map[float64]bool{}
+	for _, bk := range append(append([]HistogramBucket(nil), a...), b...) {
+		bounds[bk.UpperBound] = true
+	}
+
+	res := []HistogramBucket{}
+	for bound := range bounds {
+		res = append(res, HistogramBucket{bound, countAt(a, bound) + countAt(b, bound)})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].UpperBound < res[j].UpperBound
+	})
+
+	return res
+}
@@ -0,0 +1,179 @@
+package bench
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestQuantile(t *testing.T) {
+	inf := math.Inf(1)
+	nan := math.NaN()
+
+	cases := []struct {
+		name string
+		dist PrometheusDistribution
+		want [4]float64 // p50, p90, p95, p99
+	}{
+		{
+			name: "interpolated within buckets",
+			dist: PrometheusDistribution{Count: 10, Buckets: []HistogramBucket{{10, 2}, {100, 8}, {1000, 10}, {inf, 10}}},
+			want: [4]float64{55, 550, 775, 955},
+		},
+		{
+			name: "first bucket interpolated from zero",
+			dist: PrometheusDistribution{Count: 10, Buckets: []HistogramBucket{{10, 10}, {inf, 10}}},
+			want: [4]float64{5, 9, 9.5, 9.9},
+		},
+		{
+			name: "empty buckets",
+			dist: PrometheusDistribution{Count: 4, Buckets: []HistogramBucket{{1, 0}, {2, 0}, {4, 4}, {8, 4}, {inf, 4}}},
+			want: [4]float64{3, 3.8, 3.9, 3.98},
+		},
+		{
+			name: "above highest finite bound",
+			dist: PrometheusDistribution{Count: 10, Buckets: []HistogramBucket{{10, 0}, {100, 0}, {1000, 5}, {inf, 10}}},
+			want: [4]float64{1000, 1000, 1000, 1000},
+		},
+		{
+			name: "only +Inf bucket",
+			dist: PrometheusDistribution{Count: 5, Buckets: []HistogramBucket{{inf, 5}}},
+			want: [4]float64{nan, nan, nan, nan},
+		},
+		{
+			name: "no observations",
+			dist: PrometheusDistribution{Buckets: []HistogramBucket{{10, 0}, {inf, 0}}},
+			want: [4]float64{nan, nan, nan, nan},
+		},
+		{
+			name: "summary quantiles passed through",
+			dist: PrometheusDistribution{Count: 20, Quantiles: map[float64]float64{0.5: 2e-05, 0.99: 0.0005}},
+			want: [4]float64{2e-05, nan, nan, 0.0005},
+		},
+		{
+			name: "summary without quantiles",
+			dist: PrometheusDistribution{Count: 20, Quantiles: map[float64]float64{}},
+			want: [4]float64{nan, nan, nan, nan},
+		},
+	}
+
+	for _, tc := range cases {
+		for i, q := range []float64{0.5, 0.9, 0.95, 0.99} {
+			got, want := tc.dist.Quantile(q), tc.want[i]
+
+			if math.IsNaN(want) != math.IsNaN(got) || (!math.IsNaN(want) && math.Abs(got-want) > 1e-9) {
+				t.Errorf("%v: Quantile(%v) = %v, want %v", tc.name, q, got, want)
+			}
+		}
+	}
+}
+
+func TestParsePrometheusDistributions(t *testing.T) {
+	dists, err := ParsePrometheusDistributions(readTestMetrics(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]PrometheusDistribution{}
+	for _, d := range dists {
+		got[PrometheusSeries{Name: d.Name, Labels: d.Labels}.Key()] = d
+	}
+
+	inf := math.Inf(1)
+
+	want := map[string]PrometheusDistribution{
+		`kopia_blob_download_duration_ms{method="get"}`: {
+			Name:    "kopia_blob_download_duration_ms",
+			Labels:  map[string]string{"method": "get"},
+			Count:   10,
+			Sum:     450,
+			Buckets: []HistogramBucket{{10, 2}, {100, 8}, {1000, 10}, {inf, 10}},
+		},
+		`kopia_blob_download_duration_ms{method="list"}`: {
+			Name:    "kopia_blob_download_duration_ms",
+			Labels:  map[string]string{"method": "list"},
+			Count:   10,
+			Sum:     12000,
+			Buckets: []HistogramBucket{{10, 0}, {100, 0}, {1000, 5}, {inf, 10}},
+		},
+		`go_gc_duration_seconds`: {
+			Name:      "go_gc_duration_seconds",
+			Labels:    map[string]string{},
+			Count:     20,
+			Sum:       0.01,
+			Quantiles: map[float64]float64{0: 1e-05, 0.5: 2e-05, 0.99: 0.0005, 1: 0.001},
+		},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParsePrometheusDistributionsImplicitInf(t *testing.T) {
+	dists, err := ParsePrometheusDistributions([]byte(`# TYPE h histogram
+h_bucket{le="1"} 3
+h_sum 10
+h_count 5
+# TYPE inf_only histogram
+inf_only_bucket{le="+Inf"} 5
+inf_only_sum 10
+inf_only_count 5
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inf := math.Inf(1)
+
+	for _, d := range dists {
+		want := map[string][]HistogramBucket{
+			"h":        {{1, 3}, {inf, 5}},
+			"inf_only": {{inf, 5}},
+		}[d.Name]
+
+		if !reflect.DeepEqual(d.Buckets, want) {
+			t.Errorf("%v: got buckets %v, want %v", d.Name, d.Buckets, want)
+		}
+	}
+
+	if len(dists) != 2 {
+		t.Errorf("got %v distributions, want 2", len(dists))
+	}
+}
+
+func TestMergeDistributionsBy(t *testing.T) {
+	dists, err := ParsePrometheusDistributions(readTestMetrics(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged := MergeDistributionsBy(dists, "kopia_blob_download_duration_ms")
+	if len(merged) != 1 {
+		t.Fatalf("got %v, want a single distribution", merged)
+	}
+
+	m := merged[0]
+
+	wantBuckets := []HistogramBucket{{10, 2}, {100, 8}, {1000, 15}, {math.Inf(1), 20}}
+	if m.Count != 20 || m.Sum != 12450 || !reflect.DeepEqual(m.Buckets, wantBuckets) {
+		t.Errorf("got %+v, want count 20, sum 12450 and buckets %v", m, wantBuckets)
+	}
+
+	if got, want := m.Quantile(0.5), 100+900*2/7.0; math.Abs(got-want) > 1e-9 {
+		t.Errorf("merged p50 = %v, want %v", got, want)
+	}
+
+	if got := MergeDistributionsBy(dists, "kopia_blob_download_duration_ms", "method"); len(got) != 2 || got[0].Labels["method"] != "get" {
+		t.Errorf("got %v, want a distribution per method", got)
+	}
+
+	summaries := MergeDistributionsBy(append(dists, dists...), "go_gc_duration_seconds")
+	if len(summaries) != 1 || summaries[0].Count != 40 || summaries[0].Quantiles != nil {
+		t.Errorf("got %+v, want merged summary without quantiles", summaries)
+	}
+
+	if q := summaries[0].Quantile(0.5); !math.IsNaN(q) {
+		t.Errorf("merged summary p50 = %v, want NaN", q)
+	}
+}
@@ -0,0 +1,170 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Kopia runs kopia commands of a harness with its config file and repository password.
+type Kopia struct {
+	Exe        string
+	ConfigFile string
+	Password   string
+}
+
+// Command returns a kopia command which doesn't check for updates.
+func (k Kopia) Command(ctx context.Context, args ...string) *exec.Cmd {
+	c := exec.CommandContext(ctx, k.Exe, append([]string{"--config-file=" + k.ConfigFile}, args...)...)
+	c.Env = append(os.Environ(), "KOPIA_PASSWORD="+k.Password, "KOPIA_CHECK_FOR_UPDATES=false")
+
+	return c
+}
+
+// Run runs a kopia command which is not measured and returns its output, errors include
+// the standard error of kopia.
+func (k Kopia) Run(ctx context.Context, args ...string) ([]byte, error) {
+	out, err := k.Command(ctx, args...).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return nil, errors.Wrapf(err, "kopia %v failed: %s", strings.Join(args, " "), strings.TrimSpace(string(ee.Stderr)))
+		}
+
+		return nil, errors.Wrapf(err, "kopia %v failed", strings.Join(args, " "))
+	}
+
+	return out, nil
+}
+
+// CreateRepository creates a filesystem repository in repoDir and connects to it, args are
+// passed to 'kopia repository create'.
+func (k Kopia) CreateRepository(ctx context.Context, repoDir string, args ...string) error {
+	_, err := k.Run(ctx, append([]string{"repository", "create", "filesystem", "--path=" + repoDir}, args...)...)
+
+	return err
+}
+
+// KeepSnapshots sets the global retention policy to keep the latest n snapshots of each source,
+// so that a history of at most n snapshots built by a harness is not expired by kopia.
+func (k Kopia) KeepSnapshots(ctx context.Context, n int) error {
+	_, err := k.Run(ctx, "policy", "set", "--global", "--keep-latest="+strconv.Itoa(n))
+
+	return err
+}
+
+// Snapshot is a snapshot listed by 'kopia snapshot list --json'.
+type Snapshot struct {
+	ID        string `json:"id"`
+	RootEntry struct {
+		Obj string `json:"obj"`
+	} `json:"rootEntry"`
+}
+
+// Snapshots returns the snapshots of the source, oldest first.
+func (k Kopia) Snapshots(ctx context.Context, source string) ([]Snapshot, error) {
+	out, err := k.Run(ctx, "snapshot", "list", source, "--json")
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []Snapshot
+
+	if err := json.Unmarshal(out, &snapshots); err != nil {
+		return nil, errors.Wrap(err, "unable to parse snapshot list")
+	}
+
+	return snapshots, nil
+}
+
+// LatestRoot returns the root object ID of the latest snapshot of the source, or an empty
+// string if the source has no snapshots.
+func (k Kopia) LatestRoot(ctx context.Context, source string) (string, error) {
+	snapshots, err := k.Snapshots(ctx, source)
+	if err != nil {
+		return "", err
+	}
+
+	if len(snapshots) == 0 {
+		return "", nil
+	}
+
+	return snapshots[len(snapshots)-1].RootEntry.Obj, nil
+}
+
+// SnapshotDatasets creates a filesystem repository in dir, snapshots the datasets into it and
+// returns the connected kopia and the root object IDs of the snapshots.
+func SnapshotDatasets(ctx context.Context, exe, dir, password string, datasets ...string) (Kopia, []string, error) {
+	k := Kopia{Exe: exe, ConfigFile: filepath.Join(dir, "kopia.config"), Password: password}
+
+	if err := k.CreateRepository(ctx, filepath.Join(dir, "repo")); err != nil {
+		return k, nil, err
+	}
+
+	var roots []string
+
+	for _, ds := range datasets {
+		if _, err := k.Run(ctx, "snapshot", "create", ds, "--no-progress"); err != nil {
+			return k, nil, err
+		}
+
+		root, err := k.LatestRoot(ctx, ds)
+		if err != nil {
+			return k, nil, err
+		}
+
+		if root == "" {
+			return k, nil, errors.Errorf("no snapshot of %v found", ds)
+		}
+
+		roots = append(roots, root)
+	}
+
+	return k, roots, nil
+}
+
+// WorkDir returns dir, or a new temporary directory named after the harness if dir is empty, and
+// a function which removes it unless it's kept.
+func WorkDir(dir, harness string, keep bool) (string, func(), error) {
+	if dir == "" {
+		d, err := os.MkdirTemp("", harness)
+		if err != nil {
+			return "", nil, err
+		}
+
+		dir = d
+	}
+
+	if keep {
+		return dir, func() {}, nil
+	}
+
+	return dir, func() { os.RemoveAll(dir) }, nil
+}
+
+// DirSize returns the total size and number of regular files under dir.
+func DirSize(dir string) (size int64, count int, err error) {
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		size += fi.Size()
+		count++
+
+		return nil
+	})
+
+	return size, count, err
+}
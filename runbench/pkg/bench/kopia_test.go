@@ -0,0 +1,50 @@
+package bench
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkDir(t *testing.T) {
+	given := filepath.Join(t.TempDir(), "work")
+	if err := os.Mkdir(given, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		dir      string
+		keep     bool
+		wantKept bool
+	}{
+		{dir: "", keep: false, wantKept: false},
+		{dir: "", keep: true, wantKept: true},
+		{dir: given, keep: true, wantKept: true},
+		{dir: given, keep: false, wantKept: false},
+	}
+
+	for _, tc := range cases {
+		dir, cleanup, err := WorkDir(tc.dir, "benchtest", tc.keep)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tc.dir != "" && dir != tc.dir {
+			t.Errorf("got %v, want %v", dir, tc.dir)
+		}
+
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			t.Errorf("%v is not a directory: %v", dir, err)
+		}
+
+		cleanup()
+
+		if _, err := os.Stat(dir); (err == nil) != tc.wantKept {
+			t.Errorf("%v kept: %v, want %v", dir, err == nil, tc.wantKept)
+		}
+
+		if tc.dir == "" {
+			os.RemoveAll(dir)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// MountLoopback creates a filesystem image of the given size and type and mounts it on mountPoint,
+// which requires root, and returns a function which unmounts it.
+func MountLoopback(ctx context.Context, image, mountPoint, fsType string, size int64) (unmount func() error, err error) {
+	f, err := os.Create(image)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(mountPoint, 0o755); err != nil {
+		return nil, err
+	}
+
+	if err := runTool(ctx, "mkfs", "-t", fsType, "-q", image); err != nil {
+		return nil, err
+	}
+
+	if err := runTool(ctx, "mount", "-o", "loop", image, mountPoint); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		return runTool(context.Background(), "umount", mountPoint)
+	}, nil
+}
+
+// UsedPercent returns the percentage of used blocks of the filesystem containing dir.
+func UsedPercent(dir string) (float64, error) {
+	var st syscall.Statfs_t
+
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+
+	return 100 * float64(st.Blocks-st.Bfree) / float64(st.Blocks), nil
+}
+
+func runTool(ctx context.Context, exe string, args ...string) error {
+	if out, err := exec.CommandContext(ctx, exe, args...).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "%v failed: %s", exe, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package bench
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// MountLoopback is only supported on Linux.
+func MountLoopback(ctx context.Context, image, mountPoint, fsType string, size int64) (unmount func() error, err error) {
+	return nil, errors.New("loopback filesystems are only supported on Linux")
+}
+
+// UsedPercent is only supported on Linux.
+func UsedPercent(dir string) (float64, error) {
+	return 0, errors.New("filesystem usage is only supported on Linux")
+}
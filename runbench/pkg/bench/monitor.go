@@ -0,0 +1,66 @@
+package bench
+
+import (
+	"context"
+	"time"
+)
+
+// Monitor samples a process which is not started by a Runner (e.g. a long-running server)
+// in the background until stopped.
+//
+// Since it can't wait for the process, the process is considered gone once sampling fails
+// maxConsecutiveErrors times in a row, these failures are not counted as SamplingErrors.
+type Monitor struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	result *Result
+}
+
+const maxConsecutiveErrors = 10
+
+// StartMonitor starts sampling every interval using the given samplers.
+func StartMonitor(ctx context.Context, interval time.Duration, samplers ...Sampler) *Monitor {
+	ctx, cancel := context.WithCancel(ctx)
+
+	m := &Monitor{cancel: cancel, done: make(chan struct{}), result: &Result{}}
+
+	go func() {
+		defer close(m.done)
+
+		t0 := time.Now()
+		defer func() { m.result.Duration = time.Since(t0) }()
+
+		consecutiveErrors := 0
+
+		for consecutiveErrors < maxConsecutiveErrors {
+			tSample := time.Now()
+			s := &Sample{Time: tSample}
+
+			if err := takeSample(ctx, s, samplers); err != nil {
+				consecutiveErrors++
+			} else {
+				m.result.addSample(s)
+				m.result.SamplingErrors += consecutiveErrors
+				consecutiveErrors = 0
+			}
+
+			m.result.SamplingTime += time.Since(tSample)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+
+	return m
+}
+
+// Stop stops sampling and returns samples taken so far, with the duration of monitoring.
+func (m *Monitor) Stop() *Result {
+	m.cancel()
+	<-m.done
+
+	return m.result
+}
@@ -0,0 +1,8 @@
+package bench
+
+import "os/exec"
+
+// DropPageCache flushes the unified buffer cache using purge(8).
+func DropPageCache() error {
+	return exec.Command("purge").Run()
+}
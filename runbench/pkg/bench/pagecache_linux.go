@@ -0,0 +1,13 @@
+package bench
+
+import (
+	"os"
+	"syscall"
+)
+
+// DropPageCache writes dirty pages and drops clean page, dentry and inode caches.
+func DropPageCache() error {
+	syscall.Sync()
+
+	return os.WriteFile("/proc/sys/vm/drop_caches", []byte("3\n"), 0o200)
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package bench
+
+import "github.com/pkg/errors"
+
+// DropPageCache is not supported on this platform.
+func DropPageCache() error {
+	return errors.New("dropping page cache is not supported on this platform")
+}
@@ -0,0 +1,86 @@
+package bench
+
+import (
+	"context"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"github.com/shirou/gopsutil/v3/process"
+	"golang.org/x/sys/unix"
+)
+
+// constants of proc_pidinfo(), see <sys/proc_info.h>.
+const (
+	procInfoCallPIDInfo = 2
+	procPIDTaskAllInfo  = 2
+)
+
+// procTaskAllInfo is struct proc_taskallinfo of <sys/proc_info.h>.
+type procTaskAllInfo struct {
+	bsd  procBSDInfo
+	task procTaskInfo
+}
+
+// procBSDInfo is struct proc_bsdinfo, with unused fields left out.
+type procBSDInfo struct {
+	_         [12]uint32
+	comm      [16]byte
+	name      [32]byte
+	_         [5]uint32
+	nice      int32
+	startSec  uint64
+	startUsec uint64
+}
+
+// procTaskInfo is struct proc_taskinfo, with unused fields left out.
+type procTaskInfo struct {
+	virtualSize   uint64
+	residentSize  uint64
+	totalUser     uint64 // in mach absolute time units
+	totalSystem   uint64
+	threadsUser   uint64
+	threadsSystem uint64
+	_             [12]int32
+}
+
+// machTicksPerSecond is the frequency of mach absolute time, which is 1GHz on Intel,
+// but not on Apple Silicon.
+var machTicksPerSecond = func() float64 {
+	f, err := unix.SysctlUint64("hw.tbfrequency")
+	if err != nil || f == 0 {
+		return 1e9
+	}
+
+	return float64(f)
+}()
+
+// sampleProcess returns the average CPU usage since the process started and its resident memory
+// in bytes. It calls proc_pidinfo() directly, since gopsutil spawns 'ps' without cgo and doesn't
+// convert CPU times from mach absolute time on Apple Silicon.
+func sampleProcess(ctx context.Context, proc *process.Process) (cpuPercent float64, rss uint64, err error) {
+	var ti procTaskAllInfo
+
+	n, _, errno := unix.Syscall6(unix.SYS_PROC_INFO,
+		procInfoCallPIDInfo,
+		uintptr(proc.Pid),
+		procPIDTaskAllInfo,
+		0,
+		uintptr(unsafe.Pointer(&ti)),
+		unsafe.Sizeof(ti))
+	if errno != 0 {
+		return 0, 0, errors.Wrap(errno, "proc_pidinfo")
+	}
+
+	if n != unsafe.Sizeof(ti) {
+		return 0, 0, errors.Errorf("proc_pidinfo returned %v bytes, expected %v", n, unsafe.Sizeof(ti))
+	}
+
+	started := time.Unix(int64(ti.bsd.startSec), int64(ti.bsd.startUsec)*int64(time.Microsecond/time.Nanosecond))
+
+	if elapsed := time.Since(started).Seconds(); elapsed > 0 {
+		cpuPercent = 100 * float64(ti.task.totalUser+ti.task.totalSystem) / machTicksPerSecond / elapsed
+	}
+
+	return cpuPercent, ti.task.residentSize, nil
+}
@@ -0,0 +1,26 @@
+//go:build !darwin
+// +build !darwin
+
+package bench
+
+import (
+	"context"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// sampleProcess returns the average CPU usage since the process started and its resident memory
+// in bytes.
+func sampleProcess(ctx context.Context, proc *process.Process) (cpuPercent float64, rss uint64, err error) {
+	mi, err := proc.MemoryInfoWithContext(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	cpuPercent, err = proc.CPUPercentWithContext(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return cpuPercent, mi.RSS, nil
+}
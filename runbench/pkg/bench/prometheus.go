@@ -0,0 +1,198 @@
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// PrometheusSeries is a single value of a counter, gauge or untyped metric.
+type PrometheusSeries struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// Key returns the series in PromQL notation, e.g. name{label="value"}, with labels sorted.
+func (s PrometheusSeries) Key() string {
+	if len(s.Labels) == 0 {
+		return s.Name
+	}
+
+	var labels []string
+
+	for k, v := range s.Labels {
+		labels = append(labels, fmt.Sprintf("%v=%q", k, v))
+	}
+
+	sort.Strings(labels)
+
+	return s.Name + "{" + strings.Join(labels, ",") + "}"
+}
+
+// ParsePrometheusSeries returns all series of counters, gauges and untyped metrics in Prometheus
+// text format. Malformed input ends parsing, series parsed so far are returned along with the error.
+func ParsePrometheusSeries(b []byte) ([]PrometheusSeries, error) {
+	var p expfmt.TextParser
+
+	families, err := p.TextToMetricFamilies(bytes.NewReader(b))
+
+	var res []PrometheusSeries
+
+	for name, mf := range families {
+		for _, m := range mf.GetMetric() {
+			var v float64
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				v = m.GetCounter().GetValue()
+			case dto.MetricType_GAUGE:
+				v = m.GetGauge().GetValue()
+			case dto.MetricType_UNTYPED:
+				v = m.GetUntyped().GetValue()
+			default:
+				continue
+			}
+
+			labels := map[string]string{}
+			for _, lp := range m.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+
+			res = append(res, PrometheusSeries{Name: name, Labels: labels, Value: v})
+		}
+	}
+
+	return res, err
+}
+
+// SumBy sums values of series of the metric grouped by the given labels, which are the only
+// labels of the returned series. Without labels all series of the metric are summed.
+func SumBy(series []PrometheusSeries, name string, labels ...string) []PrometheusSeries {
+	groups := map[string]*PrometheusSeries{}
+
+	var keys []string
+
+	for _, s := range series {
+		if s.Name != name {
+			continue
+		}
+
+		g := PrometheusSeries{Name: name, Labels: map[string]string{}}
+		for _, l := range labels {
+			g.Labels[l] = s.Labels[l]
+		}
+
+		key := g.Key()
+
+		if groups[key] == nil {
+			groups[key] = &g
+			keys = append(keys, key)
+		}
+
+		groups[key].Value += s.Value
+	}
+
+	sort.Strings(keys)
+
+	var res []PrometheusSeries
+
+	for _, k := range keys {
+		res = append(res, *groups[k])
+	}
+
+	return res
+}
+
+// ParsePrometheusCounters returns values of counters, gauges and untyped metrics in Prometheus
+// text format by name, summing series with different labels. Each labeled series is also
+// returned by its key (see PrometheusSeries.Key).
+func ParsePrometheusCounters(b []byte) map[string]float64 {
+	res := map[string]float64{}
+
+	// partially parsed metrics are still useful.
+	series, _ := ParsePrometheusSeries(b)
+
+	for _, s := range series {
+		res[s.Name] += s.Value
+
+		if len(s.Labels) > 0 {
+			res[s.Key()] = s.Value
+		}
+	}
+
+	return res
+}
+
+// PrometheusScrapes accumulates Prometheus scrapes of a run in memory which doesn't grow with
+// its length: each value returned by ParsePrometheusCounters is tracked by its last positive
+// value and increase (see Result.CounterDelta) and only the last scrape is kept in full.
+type PrometheusScrapes struct {
+	Last  []byte // last scrape in text format
+	Count int    // number of scrapes
+
+	counters map[string]*counterState
+}
+
+type counterState struct {
+	prev         float64
+	delta        float64
+	lastPositive float64
+}
+
+// LastSeries returns all series of the last scrape.
+func (p *PrometheusScrapes) LastSeries() []PrometheusSeries {
+	if len(p.Last) == 0 {
+		return nil
+	}
+
+	series, _ := ParsePrometheusSeries(p.Last)
+
+	return series
+}
+
+// LastDistributions returns all histograms and summaries of the last scrape.
+func (p *PrometheusScrapes) LastDistributions() []PrometheusDistribution {
+	if len(p.Last) == 0 {
+		return nil
+	}
+
+	dists, _ := ParsePrometheusDistributions(p.Last)
+
+	return dists
+}
+
+// Add parses the scrape and updates tracked values.
+func (p *PrometheusScrapes) Add(b []byte) {
+	if p.counters == nil {
+		p.counters = map[string]*counterState{}
+	}
+
+	for name, v := range ParsePrometheusCounters(b) {
+		cs := p.counters[name]
+
+		switch {
+		case cs == nil:
+			cs = &counterState{}
+			p.counters[name] = cs
+		case v >= cs.prev:
+			cs.delta += v - cs.prev
+		default:
+			// restarted process, the counter starts from zero.
+			cs.delta += v
+		}
+
+		cs.prev = v
+
+		if v > 0 {
+			cs.lastPositive = v
+		}
+	}
+
+	p.Last = b
+	p.Count++
+}
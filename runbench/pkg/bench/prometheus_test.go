@@ -0,0 +1,151 @@
+package bench
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+// testdata/metrics.txt is a scrape in Prometheus exposition format with labeled counters,
+// a gauge, untyped metrics, an escaped label value, a histogram and a summary.
+func readTestMetrics(t *testing.T) []byte {
+	t.Helper()
+
+	b, err := os.ReadFile("testdata/metrics.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestParsePrometheusSeries(t *testing.T) {
+	series, err := ParsePrometheusSeries(readTestMetrics(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]float64{}
+	for _, s := range series {
+		got[s.Key()] = s.Value
+	}
+
+	// histograms and summaries are not series.
+	want := map[string]float64{
+		`go_goroutines`:                 42,
+		`go_memstats_alloc_bytes_total`: 12345000,
+		`kopia_blob_upload_bytes_total{blob_type="p",storage_type="filesystem"}`: 1048576,
+		`kopia_blob_upload_bytes_total{blob_type="q",storage_type="filesystem"}`: 4096,
+		`kopia_blob_upload_bytes_total{blob_type="p",storage_type="s3"}`:         2048,
+		`kopia_cache_hit_bytes{cache="contents"}`:                                100,
+		`kopia_cache_hit_bytes{cache="metadata"}`:                                50,
+		`kopia_content_write_errors_total{path="a \"quoted\" \\path\nx"}`:        3,
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParsePrometheusSeriesMalformed(t *testing.T) {
+	series, err := ParsePrometheusSeries([]byte("go_goroutines 42\nnot a metric line\ngo_threads 7\n"))
+	if err == nil {
+		t.Fatal("malformed input was parsed")
+	}
+
+	got := map[string]float64{}
+	for _, s := range series {
+		got[s.Key()] = s.Value
+	}
+
+	// the parser may also return the incomplete metric of the malformed line.
+	if got["go_goroutines"] != 42 {
+		t.Errorf("got %v, want the series before the malformed line", series)
+	}
+
+	if _, ok := got["go_threads"]; ok {
+		t.Errorf("got %v, want no series after the malformed line", series)
+	}
+}
+
+func TestParsePrometheusCounters(t *testing.T) {
+	got := ParsePrometheusCounters(readTestMetrics(t))
+
+	// values by name are summed over labels, labeled series are also returned by their key.
+	for k, want := range map[string]float64{
+		`go_goroutines`:                 42,
+		`kopia_blob_upload_bytes_total`: 1054720,
+		`kopia_blob_upload_bytes_total{blob_type="p",storage_type="s3"}`: 2048,
+		`kopia_cache_hit_bytes`:                   150,
+		`kopia_cache_hit_bytes{cache="metadata"}`: 50,
+	} {
+		if got[k] != want {
+			t.Errorf("%v = %v, want %v", k, got[k], want)
+		}
+	}
+
+	if len(got) != 11 {
+		t.Errorf("got %v values, want 11: %v", len(got), got)
+	}
+}
+
+func TestSumBy(t *testing.T) {
+	series, err := ParsePrometheusSeries(readTestMetrics(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		labels []string
+		want   []PrometheusSeries
+	}{
+		{nil, []PrometheusSeries{
+			{Name: "kopia_blob_upload_bytes_total", Labels: map[string]string{}, Value: 1054720},
+		}},
+		{[]string{"storage_type"}, []PrometheusSeries{
+			{Name: "kopia_blob_upload_bytes_total", Labels: map[string]string{"storage_type": "filesystem"}, Value: 1052672},
+			{Name: "kopia_blob_upload_bytes_total", Labels: map[string]string{"storage_type": "s3"}, Value: 2048},
+		}},
+		{[]string{"blob_type", "no_such_label"}, []PrometheusSeries{
+			{Name: "kopia_blob_upload_bytes_total", Labels: map[string]string{"blob_type": "p", "no_such_label": ""}, Value: 1050624},
+			{Name: "kopia_blob_upload_bytes_total", Labels: map[string]string{"blob_type": "q", "no_such_label": ""}, Value: 4096},
+		}},
+	}
+
+	for _, tc := range cases {
+		if got := SumBy(series, "kopia_blob_upload_bytes_total", tc.labels...); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("SumBy(%v) = %v, want %v", tc.labels, got, tc.want)
+		}
+	}
+
+	if got := SumBy(series, "no_such_metric"); got != nil {
+		t.Errorf("got %v for missing metric", got)
+	}
+}
+
+func TestPrometheusScrapesCounterReset(t *testing.T) {
+	var r Result
+
+	// the measured process restarts after the second scrape, its endpoint is down for one scrape
+	// and the counter starts from zero again.
+	for _, v := range []string{"10", "30", "", "0", "5", "25"} {
+		b := []byte("# TYPE go_memstats_alloc_bytes_total counter\n")
+		if v != "" {
+			b = append(b, "go_memstats_alloc_bytes_total "+v+"\n"...)
+		}
+
+		r.Prometheus.Add(b)
+	}
+
+	if got, want := r.CounterDelta("go_memstats_alloc_bytes_total"), 20.0+0+5+20; got != want {
+		t.Errorf("CounterDelta = %v, want %v", got, want)
+	}
+
+	if got := r.LastCounter("go_memstats_alloc_bytes_total"); got != 25 {
+		t.Errorf("LastCounter = %v, want 25", got)
+	}
+
+	if got := r.Prometheus.Count; got != 6 {
+		t.Errorf("got %v scrapes, want 6", got)
+	}
+}
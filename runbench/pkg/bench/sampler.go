@@ -0,0 +1,325 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// Sample is a single observation of the measured process.
+type Sample struct {
+	Time time.Time
+	RAM  float64 // MiB
+	CPU  float64 // percent of one core
+
+	// PrometheusMetrics is the scrape of PrometheusSampler, which is moved into Result.Prometheus
+	// once the sample is added to the result.
+	PrometheusMetrics []byte
+
+	// EndpointMetrics are scrapes of additional endpoints by PrometheusEndpointSampler keyed by
+	// endpoint name, which are moved into Result.Endpoints like PrometheusMetrics.
+	EndpointMetrics map[string][]byte
+
+	// Values are measurements of samplers other than ProcessSampler and PrometheusSampler,
+	// keyed by field name. Summarize averages them over samples where they are present.
+	Values map[string]float64
+}
+
+// rawSample is the JSON representation of a sample written by WriteRawSample.
+type rawSample struct {
+	Time       time.Time          `json:"time"`
+	RAM        float64            `json:"ram"`
+	CPU        float64            `json:"cpu"`
+	Values     map[string]float64 `json:"values,omitempty"`
+	Prometheus string             `json:"prometheus,omitempty"`
+	Endpoints  map[string]string  `json:"endpoints,omitempty"`
+}
+
+// WriteRawSample writes the sample including its Prometheus scrape as a line of JSON.
+func WriteRawSample(w io.Writer, s *Sample) error {
+	rs := rawSample{
+		Time:       s.Time,
+		RAM:        s.RAM,
+		CPU:        s.CPU,
+		Values:     s.Values,
+		Prometheus: string(s.PrometheusMetrics),
+	}
+
+	for name, m := range s.EndpointMetrics {
+		if rs.Endpoints == nil {
+			rs.Endpoints = map[string]string{}
+		}
+
+		rs.Endpoints[name] = string(m)
+	}
+
+	b, err := json.Marshal(rs)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(b, '\n'))
+
+	return err
+}
+
+func (s *Sample) setValue(key string, v float64) {
+	if s.Values == nil {
+		s.Values = map[string]float64{}
+	}
+
+	s.Values[key] = v
+}
+
+// Sampler fills in the fields of a sample it's responsible for. An error drops the sample,
+// which may be transient, sampling ends only when the process exits.
+type Sampler interface {
+	Sample(ctx context.Context, s *Sample) error
+}
+
+// SamplerTarget describes the measured process to samplers created by NewSamplers.
+type SamplerTarget struct {
+	PID        int
+	MetricsURL string
+}
+
+// SamplerFactory creates a sampler of the target.
+type SamplerFactory func(ctx context.Context, t SamplerTarget) (Sampler, error)
+
+var samplerFactories = map[string]SamplerFactory{
+	"process": func(ctx context.Context, t SamplerTarget) (Sampler, error) {
+		return NewProcessSampler(ctx, t.PID)
+	},
+	"prometheus": func(ctx context.Context, t SamplerTarget) (Sampler, error) {
+		if t.MetricsURL == "" {
+			return nil, errors.New("prometheus sampler requires metrics URL")
+		}
+
+		return &PrometheusSampler{URL: t.MetricsURL}, nil
+	},
+	"disk-io": func(ctx context.Context, t SamplerTarget) (Sampler, error) {
+		return NewDiskIOSampler(ctx, t.PID)
+	},
+	"system-load": func(ctx context.Context, t SamplerTarget) (Sampler, error) {
+		return &SystemLoadSampler{}, nil
+	},
+}
+
+// RegisterSampler makes a sampler available to NewSamplers under the given name. It's meant to be
+// called from init functions of harnesses, registering an existing name replaces the sampler.
+func RegisterSampler(name string, f SamplerFactory) {
+	samplerFactories[name] = f
+}
+
+// SamplerNames returns sorted names of registered samplers.
+func SamplerNames() []string {
+	var names []string
+
+	for n := range samplerFactories {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// NewSamplers creates registered samplers of the target with the given names.
+func NewSamplers(ctx context.Context, names []string, t SamplerTarget) ([]Sampler, error) {
+	var samplers []Sampler
+
+	for _, n := range names {
+		f := samplerFactories[n]
+		if f == nil {
+			return nil, errors.Errorf("unknown sampler %q, available: %v", n, SamplerNames())
+		}
+
+		sm, err := f(ctx, t)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to create %v sampler", n)
+		}
+
+		samplers = append(samplers, sm)
+	}
+
+	return samplers, nil
+}
+
+// ProcessSampler samples resident memory and CPU usage of a process.
+type ProcessSampler struct {
+	proc *process.Process
+}
+
+// NewProcessSampler returns a sampler of the process with the given PID.
+func NewProcessSampler(ctx context.Context, pid int) (*ProcessSampler, error) {
+	proc, err := process.NewProcessWithContext(ctx, int32(pid))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to attach to process")
+	}
+
+	return &ProcessSampler{proc}, nil
+}
+
+// Sample implements Sampler.
+func (p *ProcessSampler) Sample(ctx context.Context, s *Sample) error {
+	cpuPercent, rss, err := sampleProcess(ctx, p.proc)
+	if err != nil {
+		return err
+	}
+
+	s.CPU = cpuPercent
+	s.RAM = float64(rss) / (1 << 20)
+
+	return nil
+}
+
+// scrapeTimeout limits scrapes of Prometheus samplers whose Client has no Timeout, so that
+// an unresponsive endpoint doesn't stall sampling.
+var scrapeTimeout = 5 * time.Second
+
+// scrape returns the body of the response to a request of a Prometheus endpoint.
+func scrape(client *http.Client, req *http.Request) ([]byte, error) {
+	if client.Timeout == 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), scrapeTimeout)
+		defer cancel()
+
+		req = req.WithContext(ctx)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+// PrometheusSampler scrapes Prometheus metrics in text format from URL. Scraping failures
+// (e.g. before the process starts listening) leave the metrics of the sample empty.
+// Scrapes time out after 5 seconds unless Client has a Timeout.
+type PrometheusSampler struct {
+	URL    string
+	Client http.Client
+}
+
+// Sample implements Sampler.
+func (p *PrometheusSampler) Sample(ctx context.Context, s *Sample) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	// a scrape cut short by the timeout could end with a truncated value, so it's discarded.
+	if b, err := scrape(&p.Client, req); err == nil {
+		s.PrometheusMetrics = b
+	}
+
+	return nil
+}
+
+// PrometheusEndpointSampler scrapes Prometheus metrics of another process than the measured one,
+// such as the server of a client/server benchmark, into the sample's EndpointMetrics under Name.
+// Like with PrometheusSampler, scraping failures leave the metrics of the sample empty and scrapes
+// time out unless Client has a Timeout.
+type PrometheusEndpointSampler struct {
+	Name   string
+	URL    string
+	Client http.Client
+}
+
+// Sample implements Sampler.
+func (p *PrometheusEndpointSampler) Sample(ctx context.Context, s *Sample) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	b, err := scrape(&p.Client, req)
+	if err != nil {
+		return nil
+	}
+
+	if s.EndpointMetrics == nil {
+		s.EndpointMetrics = map[string][]byte{}
+	}
+
+	s.EndpointMetrics[p.Name] = b
+
+	return nil
+}
+
+// DiskIOSampler samples the rate at which a process reads from and writes to storage, in bytes
+// per second since the previous sample, as disk_read_bytes_per_sec and disk_write_bytes_per_sec.
+// The first sample only records the baseline.
+type DiskIOSampler struct {
+	proc *process.Process
+
+	prevTime time.Time
+	prev     *process.IOCountersStat
+}
+
+// NewDiskIOSampler returns a sampler of I/O of the process with the given PID. It fails on
+// platforms where I/O counters of processes are not available.
+func NewDiskIOSampler(ctx context.Context, pid int) (*DiskIOSampler, error) {
+	proc, err := process.NewProcessWithContext(ctx, int32(pid))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to attach to process")
+	}
+
+	if _, err := proc.IOCountersWithContext(ctx); err != nil {
+		return nil, errors.Wrap(err, "unable to get I/O counters")
+	}
+
+	return &DiskIOSampler{proc: proc}, nil
+}
+
+// Sample implements Sampler.
+func (d *DiskIOSampler) Sample(ctx context.Context, s *Sample) error {
+	c, err := d.proc.IOCountersWithContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if d.prev != nil {
+		if dt := s.Time.Sub(d.prevTime).Seconds(); dt > 0 {
+			s.setValue("disk_read_bytes_per_sec", float64(c.ReadBytes-d.prev.ReadBytes)/dt)
+			s.setValue("disk_write_bytes_per_sec", float64(c.WriteBytes-d.prev.WriteBytes)/dt)
+		}
+	}
+
+	d.prev, d.prevTime = c, s.Time
+
+	return nil
+}
+
+// SystemLoadSampler samples the load of the whole host as load1 and mem_used_percent, which
+// shows interference of other processes with the measurement.
+type SystemLoadSampler struct{}
+
+// Sample implements Sampler.
+func (SystemLoadSampler) Sample(ctx context.Context, s *Sample) error {
+	avg, err := load.AvgWithContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	vm, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.setValue("load1", avg.Load1)
+	s.setValue("mem_used_percent", vm.UsedPercent)
+
+	return nil
+}
@@ -0,0 +1,477 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Point is a single measurement.
+type Point struct {
+	Measurement string
+	Tags        []Tag
+	Fields      []Field
+	Time        time.Time
+}
+
+// Tag is a key-value pair identifying the series of a point.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Field is a measured value, numbers are written using their default format (%v) and strings
+// are quoted.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Fixed is a field value written with a fixed number of decimal digits.
+type Fixed struct {
+	Value  float64
+	Digits int
+}
+
+func (f Fixed) String() string {
+	return strconv.FormatFloat(f.Value, 'f', f.Digits, 64)
+}
+
+// Sink receives measurements.
+type Sink interface {
+	Write(points ...Point) error
+}
+
+// LineProtocolSink writes points in InfluxDB line protocol.
+type LineProtocolSink struct {
+	w io.Writer
+}
+
+// NewLineProtocolSink returns a sink writing to w.
+func NewLineProtocolSink(w io.Writer) *LineProtocolSink {
+	return &LineProtocolSink{w}
+}
+
+// Write implements Sink.
+func (s *LineProtocolSink) Write(points ...Point) error {
+	for _, p := range points {
+		if _, err := io.WriteString(s.w, FormatLine(p)+"\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SinkCloser is a sink which must be closed once all points are written.
+type SinkCloser interface {
+	Sink
+	io.Closer
+}
+
+// MultiSink writes points to all of its sinks.
+type MultiSink []Sink
+
+// Write implements Sink. Points are written to all sinks even if some of them fail,
+// the first error is returned.
+func (m MultiSink) Write(points ...Point) error {
+	var firstErr error
+
+	for _, s := range m {
+		if err := s.Write(points...); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Close closes all sinks which implement io.Closer and returns the first error.
+func (m MultiSink) Close() error {
+	var firstErr error
+
+	for _, s := range m {
+		if c, ok := s.(io.Closer); ok {
+			if err := c.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// OpenSink opens a sink described by spec:
+//
+//	stdout          - line protocol written to stdout
+//	line:<file>     - line protocol appended to the file
+//	json:<file>     - JSON objects appended to the file, one per line
+//	influx:<url>    - InfluxDB v2 write API at the URL, with org and bucket taken from query
+//	                  parameters or $INFLUX_ORG and $INFLUX_BUCKET and token from $INFLUX_TOKEN
+func OpenSink(spec string) (SinkCloser, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+
+	switch kind {
+	case "stdout":
+		return closingSink{NewLineProtocolSink(os.Stdout), nopCloser{}}, nil
+
+	case "line", "json":
+		if arg == "" {
+			return nil, errors.Errorf("sink %q requires file name", spec)
+		}
+
+		f, err := OpenOutput(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if kind == "json" {
+			return closingSink{NewJSONSink(f), f}, nil
+		}
+
+		return closingSink{NewLineProtocolSink(f), f}, nil
+
+	case "influx":
+		return NewInfluxSink(arg)
+
+	default:
+		return nil, errors.Errorf("unknown sink %q, expected stdout, line:<file>, json:<file> or influx:<url>", spec)
+	}
+}
+
+type closingSink struct {
+	Sink
+	io.Closer
+}
+
+// JSONSink writes each point as a JSON object on its own line, with fields and tags as objects
+// and time in RFC 3339 format.
+type JSONSink struct {
+	enc *json.Encoder
+}
+
+// NewJSONSink returns a sink writing to w.
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{json.NewEncoder(w)}
+}
+
+type jsonPoint struct {
+	Measurement string                 `json:"measurement"`
+	Tags        map[string]string      `json:"tags,omitempty"`
+	Fields      map[string]interface{} `json:"fields"`
+	Time        time.Time              `json:"time"`
+}
+
+// Write implements Sink.
+func (s *JSONSink) Write(points ...Point) error {
+	for _, p := range points {
+		jp := jsonPoint{
+			Measurement: p.Measurement,
+			Fields:      map[string]interface{}{},
+			Time:        p.Time,
+		}
+
+		for _, t := range p.Tags {
+			if jp.Tags == nil {
+				jp.Tags = map[string]string{}
+			}
+
+			jp.Tags[t.Key] = t.Value
+		}
+
+		for _, f := range p.Fields {
+			switch v := f.Value.(type) {
+			case Fixed:
+				jp.Fields[f.Key] = json.Number(v.String())
+			case string, bool:
+				jp.Fields[f.Key] = v
+			default:
+				jp.Fields[f.Key] = json.Number(fmt.Sprint(v))
+			}
+		}
+
+		if err := s.enc.Encode(jp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// InfluxSink writes points directly to the InfluxDB v2 write API, each Write in a single request.
+type InfluxSink struct {
+	URL    string
+	Org    string
+	Bucket string
+	Token  string
+	Client http.Client
+}
+
+// NewInfluxSink returns a sink writing to InfluxDB at the URL, see OpenSink.
+func NewInfluxSink(u string) (*InfluxSink, error) {
+	pu, err := url.Parse(u)
+	if err != nil || pu.Host == "" {
+		return nil, errors.Errorf("invalid InfluxDB URL %q", u)
+	}
+
+	s := &InfluxSink{
+		Org:    pu.Query().Get("org"),
+		Bucket: pu.Query().Get("bucket"),
+		Token:  os.Getenv("INFLUX_TOKEN"),
+		Client: http.Client{Timeout: time.Minute},
+	}
+
+	if s.Org == "" {
+		s.Org = os.Getenv("INFLUX_ORG")
+	}
+
+	if s.Bucket == "" {
+		s.Bucket = os.Getenv("INFLUX_BUCKET")
+	}
+
+	if s.Org == "" || s.Bucket == "" {
+		return nil, errors.Errorf("InfluxDB org and bucket must be provided for %q", u)
+	}
+
+	pu.RawQuery = ""
+	s.URL = strings.TrimSuffix(pu.String(), "/")
+
+	return s, nil
+}
+
+// Write implements Sink.
+func (s *InfluxSink) Write(points ...Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+
+	if err := NewLineProtocolSink(&body).Write(points...); err != nil {
+		return err
+	}
+
+	q := url.Values{
+		"org":       {s.Org},
+		"bucket":    {s.Bucket},
+		"precision": {"ns"},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.URL+"/api/v2/write?"+q.Encode(), &body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Token "+s.Token)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "unable to write to InfluxDB")
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return errors.Errorf("write to InfluxDB failed with %v: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// Close implements io.Closer.
+func (s *InfluxSink) Close() error {
+	s.Client.CloseIdleConnections()
+	return nil
+}
+
+// OpenOutput opens the file measurements of a harness are appended to, or returns stdout
+// if fname is empty.
+func OpenOutput(fname string) (io.WriteCloser, error) {
+	if fname == "" {
+		return nopCloser{os.Stdout}, nil
+	}
+
+	f, err := os.OpenFile(fname, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open output")
+	}
+
+	return f, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+var tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// FormatLine returns the point in InfluxDB line protocol.
+func FormatLine(p Point) string {
+	var sb strings.Builder
+
+	sb.WriteString(strings.NewReplacer(",", `\,`, " ", `\ `).Replace(p.Measurement))
+
+	for _, t := range p.Tags {
+		fmt.Fprintf(&sb, ",%v=%v", tagEscaper.Replace(t.Key), tagEscaper.Replace(t.Value))
+	}
+
+	for i, f := range p.Fields {
+		sep := ","
+		if i == 0 {
+			sep = " "
+		}
+
+		fmt.Fprintf(&sb, "%v%v=%v", sep, tagEscaper.Replace(f.Key), formatFieldValue(f.Value))
+	}
+
+	fmt.Fprintf(&sb, " %v", p.Time.UnixNano())
+
+	return sb.String()
+}
+
+var stringFieldEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+
+func formatFieldValue(v interface{}) interface{} {
+	if s, ok := v.(string); ok {
+		return `"` + stringFieldEscaper.Replace(s) + `"`
+	}
+
+	return v
+}
+
+// ParsedPoint is a single line of InfluxDB line protocol read back, as returned by ParseLine.
+type ParsedPoint struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]float64 // non-numeric fields are ignored
+	Timestamp   int64
+}
+
+// ParseLine parses a line of InfluxDB line protocol, as written by FormatLine.
+func ParseLine(line string) (ParsedPoint, error) {
+	p := ParsedPoint{Tags: map[string]string{}, Fields: map[string]float64{}}
+
+	parts := splitUnescaped(line, ' ')
+	if len(parts) < 2 {
+		return p, errors.New("missing fields")
+	}
+
+	series := splitUnescaped(parts[0], ',')
+	p.Measurement = unescape(series[0])
+
+	for _, t := range series[1:] {
+		k, v, ok := cutUnescaped(t, '=')
+		if !ok {
+			return p, errors.Errorf("invalid tag %v", t)
+		}
+
+		p.Tags[unescape(k)] = unescape(v)
+	}
+
+	for _, f := range splitUnescaped(parts[1], ',') {
+		k, v, ok := cutUnescaped(f, '=')
+		if !ok {
+			return p, errors.Errorf("invalid field %v", f)
+		}
+
+		if fv, err := strconv.ParseFloat(strings.TrimSuffix(v, "i"), 64); err == nil {
+			p.Fields[unescape(k)] = fv
+		}
+	}
+
+	if len(parts) > 2 {
+		ts, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return p, errors.Errorf("invalid timestamp %v", parts[2])
+		}
+
+		p.Timestamp = ts
+	}
+
+	return p, nil
+}
+
+// splitUnescaped splits s at separators not preceded by backslash or within double quotes.
+func splitUnescaped(s string, sep byte) []string {
+	var (
+		parts  []string
+		quoted bool
+		start  int
+	)
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// cutUnescaped slices s around the first separator not preceded by backslash or within double
+// quotes.
+func cutUnescaped(s string, sep byte) (before, after string, found bool) {
+	parts := splitUnescaped(s, sep)
+	if len(parts) < 2 {
+		return s, "", false
+	}
+
+	return parts[0], s[len(parts[0])+1:], true
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var sb strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+
+		sb.WriteByte(s[i])
+	}
+
+	return sb.String()
+}
+
+// ParseTags parses comma-separated list of key=value tags.
+func ParseTags(s string) ([]Tag, error) {
+	var tags []Tag
+
+	if s == "" {
+		return nil, nil
+	}
+
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return nil, errors.Errorf("invalid tag %q, expected key=value", kv)
+		}
+
+		tags = append(tags, Tag{k, v})
+	}
+
+	return tags, nil
+}
@@ -0,0 +1,59 @@
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+)
+
+// ChurningSource is a directory of random files which changes between snapshots of a history
+// built by a harness, so that older snapshots reference contents newer ones don't.
+type ChurningSource struct {
+	Dir       string
+	FileSize  int
+	NewFiles  int     // number of files added by each Churn
+	DeletePct float64 // percentage of existing files removed by each Churn
+	Rand      *rand.Rand
+
+	files []string
+}
+
+// Churn removes some of the existing files and adds new ones in a directory of the generation.
+func (s *ChurningSource) Churn(generation int) error {
+	var kept []string
+
+	for _, f := range s.files {
+		if s.Rand.Float64()*100 < s.DeletePct {
+			if err := os.Remove(f); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		kept = append(kept, f)
+	}
+
+	s.files = kept
+
+	dir := filepath.Join(s.Dir, fmt.Sprintf("gen-%05d", generation))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	buf := make([]byte, s.FileSize)
+
+	for j := 0; j < s.NewFiles; j++ {
+		s.Rand.Read(buf)
+
+		fname := filepath.Join(dir, fmt.Sprintf("file-%05d", j))
+		if err := os.WriteFile(fname, buf, 0o644); err != nil {
+			return err
+		}
+
+		s.files = append(s.files, fname)
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package bench
+
+import (
+	"math"
+	"sort"
+)
+
+// Summary summarizes results of repeated runs of a command.
+type Summary struct {
+	AvgCPU float64 // percent of one core
+	MaxCPU float64
+	AvgRAM float64 // MiB
+	MaxRAM float64
+
+	AvgDuration float64 // seconds
+
+	AvgSelfCPU          float64 // percent of one core
+	MaxSelfRAM          float64
+	AvgSamplingOverhead float64 // percent of wall time spent sampling
+	SamplingErrors      int     // total number of dropped samples
+
+	// Values summarizes Sample.Values by key.
+	Values map[string]ValueSummary
+}
+
+// ValueSummary is the average and maximum of a value over samples where it's present.
+type ValueSummary struct {
+	Avg float64
+	Max float64
+}
+
+// Summarize averages durations and samples of all results.
+func Summarize(results []*Result) Summary {
+	var (
+		totalCPU      float64
+		totalRAM      float64
+		totalDuration float64
+		maxCPU        float64
+		maxRAM        float64
+		cnt           int
+
+		totalSelfCPU          float64
+		totalSamplingOverhead float64
+		maxSelfRAM            float64
+		samplingErrors        int
+
+		valueTotals = map[string]float64{}
+		valueCounts = map[string]int{}
+		values      = map[string]ValueSummary{}
+	)
+
+	for _, r := range results {
+		totalDuration += r.Duration.Seconds()
+
+		if d := r.Duration.Seconds(); d > 0 {
+			totalSelfCPU += 100 * r.SelfCPUSeconds / d
+			totalSamplingOverhead += 100 * r.SamplingTime.Seconds() / d
+		}
+
+		samplingErrors += r.SamplingErrors
+
+		if r.SelfRAM > maxSelfRAM {
+			maxSelfRAM = r.SelfRAM
+		}
+
+		for _, s := range r.Samples {
+			totalCPU += s.CPU
+			totalRAM += s.RAM
+
+			if s.CPU > maxCPU {
+				maxCPU = s.CPU
+			}
+
+			if s.RAM > maxRAM {
+				maxRAM = s.RAM
+			}
+
+			cnt++
+
+			for k, v := range s.Values {
+				vs, ok := values[k]
+				if !ok || v > vs.Max {
+					vs.Max = v
+				}
+
+				values[k] = vs
+				valueTotals[k] += v
+				valueCounts[k]++
+			}
+		}
+	}
+
+	n := float64(len(results))
+
+	for k, vs := range values {
+		vs.Avg = valueTotals[k] / float64(valueCounts[k])
+		values[k] = vs
+	}
+
+	return Summary{
+		AvgCPU: totalCPU / float64(cnt),
+		MaxCPU: maxCPU,
+		AvgRAM: totalRAM / float64(cnt),
+		MaxRAM: maxRAM,
+
+		AvgDuration: totalDuration / n,
+
+		AvgSelfCPU:          totalSelfCPU / n,
+		MaxSelfRAM:          maxSelfRAM,
+		AvgSamplingOverhead: totalSamplingOverhead / n,
+		SamplingErrors:      samplingErrors,
+
+		Values: values,
+	}
+}
+
+// ProcessFields returns the average duration and memory and CPU usage of the measured process,
+// the fields which harnesses emit for every measured command.
+func (s Summary) ProcessFields() []Field {
+	return []Field{
+		{Key: "duration", Value: Fixed{Value: s.AvgDuration, Digits: 1}},
+		{Key: "avg_ram_rss", Value: s.AvgRAM},
+		{Key: "max_ram_rss", Value: s.MaxRAM},
+		{Key: "avg_cpu_percent", Value: s.AvgCPU},
+	}
+}
+
+// Mean returns the arithmetic mean of values.
+func Mean(values []float64) float64 {
+	var sum float64
+
+	for _, v := range values {
+		sum += v
+	}
+
+	return sum / float64(len(values))
+}
+
+// Percentile returns the nearest-rank percentile of values, which don't need to be sorted,
+// or NaN if there are none.
+func Percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"runtime"
+
+	"runbench/pkg/bench"
+)
+
+// platformTags returns tags identifying the host platform, so that results from different
+// operating systems and CPU architectures end up in separate series. On macOS RSS and CPU usage
+// are sampled with proc_pidinfo(), which doesn't require cgo.
+func platformTags() []bench.Tag {
+	tags := []bench.Tag{
+		{Key: "os", Value: runtime.GOOS},
+		{Key: "arch", Value: runtime.GOARCH},
+	}
+
+	if cpu := hostCPUKind(); cpu != "" {
+		tags = append(tags, bench.Tag{Key: "cpu", Value: cpu})
+	}
+
+	return tags
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// hostCPUKind distinguishes Apple Silicon from Intel Macs, including x86-64 binaries
+// running under Rosetta 2, whose measurements are not comparable with native ones.
+func hostCPUKind() string {
+	if translated, err := unix.SysctlUint32("sysctl.proc_translated"); err == nil && translated == 1 {
+		return "apple-silicon-rosetta"
+	}
+
+	brand, err := unix.Sysctl("machdep.cpu.brand_string")
+	if err != nil {
+		return ""
+	}
+
+	if strings.HasPrefix(brand, "Apple") {
+		return "apple-silicon"
+	}
+
+	return "intel"
+}
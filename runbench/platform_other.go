@@ -0,0 +1,8 @@
+//go:build !darwin
+// +build !darwin
+
+package main
+
+func hostCPUKind() string {
+	return ""
+}
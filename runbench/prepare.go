@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"runbench/pkg/bench"
+)
+
+// Output of the scenario script preparing each run is captured in prepare-N.log next to the output
+// of measured runs and its duration is emitted as prepare (prepare_duration). Preparation
+// taking longer than --prepare-timeout is killed and fails the scenario.
+var prepareTimeout = flag.Duration("prepare-timeout", 0, "Fail scenarios whose preparation (running the scenario script) takes longer than this, 0 for no limit")
+
+// prepareOutputTail is the amount of output of a failed prepare script included in the error.
+const prepareOutputTail = 4 << 10
+
+// prepareResult describes preparation of a run.
+type prepareResult struct {
+	run      int
+	seed     int64
+	duration time.Duration
+}
+
+// runPrepare runs the scenario script, streaming its output to logFile (up to --kopia-log-max-size)
+// unless empty. Errors include the end of the output and the path of the log.
+func runPrepare(ctx context.Context, scenarioFile, logFile string, run int, seed int64) (prepareResult, error) {
+	tail := &tailBuffer{max: prepareOutputTail}
+
+	var out io.Writer = tail
+
+	if logFile != "" {
+		rl, err := openRunLog(logFile)
+		if err != nil {
+			return prepareResult{}, err
+		}
+
+		defer rl.Close()
+
+		out = io.MultiWriter(rl, tail)
+	}
+
+	pctx := ctx
+
+	if *prepareTimeout > 0 {
+		var cancel context.CancelFunc
+
+		pctx, cancel = context.WithTimeout(ctx, *prepareTimeout)
+		defer cancel()
+	}
+
+	c := exec.Command(scenarioFile)
+	c.Env = commandEnv(*kopiaExe)
+	c.Stdout = out
+	c.Stderr = out
+
+	t0 := time.Now()
+	err := runProcessGroup(pctx, c)
+	pr := prepareResult{run: run, seed: seed, duration: time.Since(t0)}
+
+	switch {
+	case err == nil:
+		return pr, nil
+
+	case ctx.Err() != nil:
+		return pr, ctx.Err()
+
+	case pctx.Err() != nil:
+		err = errors.Errorf("timed out after %v (--prepare-timeout)", *prepareTimeout)
+	}
+
+	if logFile != "" {
+		err = errors.Wrapf(err, "see %v", logFile)
+	}
+
+	return pr, errors.Wrapf(err, "failed after %v with %s", pr.duration.Round(time.Millisecond), tail.bytes())
+}
+
+// preparePoint describes preparation of a run.
+func preparePoint(tags []bench.Tag, pr prepareResult) bench.Point {
+	return bench.Point{
+		Measurement: "prepare",
+		Tags:        tags,
+		Fields: []bench.Field{
+			{Key: "prepare_duration", Value: bench.Fixed{Value: pr.duration.Seconds(), Digits: 1}},
+		},
+		Time: gitTime,
+	}
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+
+	if len(t.buf) > t.max {
+		t.buf = append([]byte(nil), t.buf[len(t.buf)-t.max:]...)
+	}
+
+	return len(p), nil
+}
+
+func (t *tailBuffer) bytes() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]byte(nil), t.buf...)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+)
+
+func runProcessGroup(ctx context.Context, c *exec.Cmd) error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+
+	case <-ctx.Done():
+		_ = c.Process.Kill()
+		<-done
+
+		return ctx.Err()
+	}
+}
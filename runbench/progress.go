@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+var progressUpdateInterval = flag.Duration("progress-update-interval", 0, "Pass --progress-update-interval to kopia, so that progress of measured runs is sampled more or less often than kopia's default (0 to not pass it)")
+
+// progressLineRegexp matches the progress line kopia prints while snapshotting, for example:
+//
+//	/ 2 hashing, 1523 hashed (1.2 GB), 310 cached (45.6 MB), uploaded 1.1 GB, estimated 3.4 GB (35.3%) 1m12s left
+var progressLineRegexp = regexp.MustCompile(`(\d+) hashing, (\d+) hashed \(([\d.]+ ?[KMGTP]?i?B)\), (\d+) cached \(([\d.]+ ?[KMGTP]?i?B)\), uploaded ([\d.]+ ?[KMGTP]?i?B)`)
+
+// progressLineMaxLength is the length after which an unterminated line of output is discarded
+// instead of being buffered further.
+const progressLineMaxLength = 4 << 10
+
+// progressSample is a single progress update of the measured command.
+type progressSample struct {
+	time          time.Time
+	elapsed       time.Duration
+	hashingFiles  int64
+	hashedFiles   int64
+	hashedBytes   int64
+	cachedFiles   int64
+	cachedBytes   int64
+	uploadedBytes int64
+}
+
+// progressParser collects progress updates from standard error of kopia, which rewrites the
+// progress line by starting each update with a carriage return.
+type progressParser struct {
+	mu         sync.Mutex
+	start      time.Time
+	timeOffset time.Duration
+	line       []byte
+	samples    []progressSample
+}
+
+func newProgressParser(timeOffset time.Duration) *progressParser {
+	return &progressParser{start: time.Now(), timeOffset: timeOffset}
+}
+
+// Write implements io.Writer, it never fails so that the measured command isn't affected.
+func (p *progressParser) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := len(b)
+
+	for len(b) > 0 {
+		i := bytes.IndexAny(b, "\r\n")
+		if i < 0 {
+			if len(p.line)+len(b) <= progressLineMaxLength {
+				p.line = append(p.line, b...)
+			}
+
+			break
+		}
+
+		if len(p.line)+i <= progressLineMaxLength {
+			p.parseLine(append(p.line, b[:i]...))
+		}
+
+		p.line = p.line[:0]
+		b = b[i+1:]
+	}
+
+	return n, nil
+}
+
+// finish parses the last line of output if it wasn't terminated and returns all progress samples.
+func (p *progressParser) finish() []progressSample {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.parseLine(p.line)
+	p.line = nil
+
+	return p.samples
+}
+
+func (p *progressParser) parseLine(line []byte) {
+	m := progressLineRegexp.FindSubmatch(line)
+	if m == nil {
+		return
+	}
+
+	now := time.Now()
+	ps := progressSample{time: now.Add(p.timeOffset), elapsed: now.Sub(p.start)}
+
+	for _, v := range []struct {
+		s      []byte
+		target *int64
+		parse  func(string) (int64, bool)
+	}{
+		{m[1], &ps.hashingFiles, parseProgressCount},
+		{m[2], &ps.hashedFiles, parseProgressCount},
+		{m[3], &ps.hashedBytes, parseProgressBytes},
+		{m[4], &ps.cachedFiles, parseProgressCount},
+		{m[5], &ps.cachedBytes, parseProgressBytes},
+		{m[6], &ps.uploadedBytes, parseProgressBytes},
+	} {
+		var ok bool
+
+		if *v.target, ok = v.parse(string(v.s)); !ok {
+			return
+		}
+	}
+
+	p.samples = append(p.samples, ps)
+}
+
+func parseProgressCount(s string) (int64, bool) {
+	v, err := strconv.ParseInt(s, 10, 64)
+
+	return v, err == nil
+}
+
+// progressByteUnits are multipliers of units of sizes printed by kopia, in base 10 by default
+// and in base 2 with KOPIA_BYTES_STRING_BASE_2.
+var progressByteUnits = map[string]float64{
+	"B":   1,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"PB":  1e15,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+	"PiB": 1 << 50,
+}
+
+// parseProgressBytes parses sizes such as '1.2 GB' or '512 B', which are rounded by kopia.
+func parseProgressBytes(s string) (int64, bool) {
+	i := strings.LastIndexAny(s, "0123456789.") + 1
+
+	mult, ok := progressByteUnits[strings.TrimSpace(s[i:])]
+	if !ok {
+		return 0, false
+	}
+
+	v, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return int64(v * mult), true
+}
+
+// progressPoints returns a progress point for each progress update of the run, timed when the
+// update was printed, so that throughput can be charted over the course of the run.
+func progressPoints(tags []bench.Tag, rr *runResult) []bench.Point {
+	var points []bench.Point
+
+	for _, ps := range rr.progress {
+		points = append(points, bench.Point{
+			Measurement: "progress",
+			Tags:        tags,
+			Fields: []bench.Field{
+				{Key: "elapsed", Value: bench.Fixed{Value: ps.elapsed.Seconds(), Digits: 1}},
+				{Key: "hashing_files", Value: ps.hashingFiles},
+				{Key: "hashed_files", Value: ps.hashedFiles},
+				{Key: "hashed_bytes", Value: ps.hashedBytes},
+				{Key: "cached_files", Value: ps.cachedFiles},
+				{Key: "cached_bytes", Value: ps.cachedBytes},
+				{Key: "uploaded_bytes", Value: ps.uploadedBytes},
+			},
+			Time: ps.time,
+		})
+	}
+
+	return points
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+)
+
+const repoSizeFromObjectStore = "object-store"
+
+var repoURL = flag.String("repo-url", "", "Object store location of the repository used with --repo-size-source=object-store: s3://bucket/prefix, gs://bucket/prefix or azure://account/container/prefix")
+
+// summarizeObjectStore summarizes objects under the prefix given by --repo-url.
+func summarizeObjectStore(ctx context.Context) (*repoSummary, error) {
+	u, err := url.Parse(os.Expand(*repoURL, lookupScenarioEnv))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid --repo-url")
+	}
+
+	prefix := strings.TrimPrefix(u.Path, "/")
+
+	switch u.Scheme {
+	case "s3":
+		return summarizeS3(ctx, u.Host, prefix)
+
+	case "gs":
+		return summarizeGCS(ctx, u.Host, prefix)
+
+	case "azure":
+		container, prefix, _ := strings.Cut(prefix, "/")
+		return summarizeAzure(ctx, u.Host, container, prefix)
+
+	default:
+		return nil, errors.Errorf("unsupported --repo-url scheme %q", u.Scheme)
+	}
+}
+
+// objectBlobType returns the type of blob stored in an object with the given name.
+func objectBlobType(name, prefix string) string {
+	return blobType(strings.TrimPrefix(strings.TrimPrefix(name, prefix), "/"))
+}
+
+func summarizeS3(ctx context.Context, bucket, prefix string) (*repoSummary, error) {
+	cli, err := newS3Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := newRepoSummary()
+
+	p := s3.NewListObjectsV2Paginator(cli, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "error listing S3 objects")
+		}
+
+		for _, o := range page.Contents {
+			rs.add(objectBlobType(aws.ToString(o.Key), prefix), o.Size)
+		}
+	}
+
+	return rs, nil
+}
+
+func summarizeGCS(ctx context.Context, bucket, prefix string) (*repoSummary, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create GCS client")
+	}
+
+	defer client.Close()
+
+	rs := newRepoSummary()
+
+	it := client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+
+	for {
+		o, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+
+		if err != nil {
+			return nil, errors.Wrap(err, "error listing GCS objects")
+		}
+
+		rs.add(objectBlobType(o.Name, prefix), o.Size)
+	}
+
+	return rs, nil
+}
+
+// summarizeAzure lists blobs using the shared key from AZURE_STORAGE_KEY if set,
+// otherwise using default Azure credentials.
+func summarizeAzure(ctx context.Context, account, container, prefix string) (*repoSummary, error) {
+	containerURL := fmt.Sprintf("https://%v.blob.core.windows.net/%v", account, container)
+
+	var (
+		cc  *azblob.ContainerClient
+		err error
+	)
+
+	if key := os.Getenv("AZURE_STORAGE_KEY"); key != "" {
+		cred, cerr := azblob.NewSharedKeyCredential(account, key)
+		if cerr != nil {
+			return nil, errors.Wrap(cerr, "invalid Azure shared key")
+		}
+
+		cc, err = azblob.NewContainerClientWithSharedKey(containerURL, cred, nil)
+	} else {
+		cred, cerr := azidentity.NewDefaultAzureCredential(nil)
+		if cerr != nil {
+			return nil, errors.Wrap(cerr, "unable to get Azure credentials")
+		}
+
+		cc, err = azblob.NewContainerClient(containerURL, cred, nil)
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create Azure container client")
+	}
+
+	rs := newRepoSummary()
+
+	pager := cc.ListBlobsFlat(&azblob.ContainerListBlobsFlatOptions{Prefix: &prefix})
+
+	for pager.NextPage(ctx) {
+		for _, b := range pager.PageResponse().Segment.BlobItems {
+			var size int64
+
+			if b.Properties != nil && b.Properties.ContentLength != nil {
+				size = *b.Properties.ContentLength
+			}
+
+			rs.add(objectBlobType(*b.Name, prefix), size)
+		}
+	}
+
+	if err := pager.Err(); err != nil {
+		return nil, errors.Wrap(err, "error listing Azure blobs")
+	}
+
+	return rs, nil
+}
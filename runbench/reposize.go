@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	repoSizeFromDir       = "dir"
+	repoSizeFromBlobStats = "blob-stats"
+)
+
+var repoSizeParallel = flag.Int("repo-size-parallel", 16, "Number of directories to walk concurrently when computing repository size from --repo-path")
+
+var repoSizeExclude = flag.String("repo-size-exclude", "", "Comma-separated glob patterns of paths under --repo-path that are not repository data (e.g. 'cache,logs/*'), matched against relative path and base name")
+
+// Repository size is computed by walking --repo-path or, with --repo-size-source=blob-stats,
+// using 'kopia blob stats', which also works for non-filesystem repositories. Repositories in
+// S3, GCS or Azure can also be summarized by listing objects under --repo-url. Sizes are also
+// broken down by blob type (blob ID prefix) and emitted as repo_composition_summary. Files under
+// --repo-path which are not repository data (caches, logs) can be excluded with --repo-size-exclude.
+var repoSizeSource = flag.String("repo-size-source", repoSizeFromDir, "How to compute repository size: 'dir' (walk --repo-path), 'blob-stats' (run 'kopia blob stats --raw') or 'object-store' (list objects under --repo-url)")
+
+// marker that can be put in a script to override --repo-size-source for the scenario, e.g. for
+// repositories stored on servers started by runbench:
+//
+//	# REPO_SIZE_SOURCE: blob-stats
+const repoSizeSourceMarker = `# REPO_SIZE_SOURCE:`
+
+// knownBlobPrefixes are blob ID prefixes for which 'blob-stats' source computes per-type totals,
+// each prefix is also the type of blobs it matches in all sources (see blobType).
+var knownBlobPrefixes = []string{"p", "q", "n", "x", "s", "_", "kopia"}
+
+// blobTypeSummary is the number and total size of blobs of a single type.
+type blobTypeSummary struct {
+	count int
+	size  int64
+}
+
+// repoSummary describes the contents of the repository at the end of a run.
+type repoSummary struct {
+	numBlobs  int
+	totalSize int64
+
+	// byType breaks blobs down by blob ID prefix (p - data, q - metadata, x and n - indexes,
+	// s - sessions, _ - logs, kopia - format and configuration blobs)
+	byType map[string]blobTypeSummary
+}
+
+func newRepoSummary() *repoSummary {
+	return &repoSummary{byType: map[string]blobTypeSummary{}}
+}
+
+func (rs *repoSummary) add(blobType string, size int64) {
+	rs.numBlobs++
+	rs.totalSize += size
+
+	t := rs.byType[blobType]
+	t.count++
+	t.size += size
+	rs.byType[blobType] = t
+}
+
+func (rs *repoSummary) merge(other *repoSummary) {
+	rs.numBlobs += other.numBlobs
+	rs.totalSize += other.totalSize
+
+	for typ, o := range other.byType {
+		t := rs.byType[typ]
+		t.count += o.count
+		t.size += o.size
+		rs.byType[typ] = t
+	}
+}
+
+// blobType returns the type of blob given its ID, a prefix of blob IDs or the name of the
+// top-level shard directory it is stored in, so that all --repo-size-source modes break the
+// repository down the same way: the longest of knownBlobPrefixes it starts with or its first
+// character.
+func blobType(name string) string {
+	if name == "" {
+		return "kopia"
+	}
+
+	typ := name[0:1]
+
+	for _, prefix := range knownBlobPrefixes {
+		if strings.HasPrefix(name, prefix) && len(prefix) > len(typ) {
+			typ = prefix
+		}
+	}
+
+	return typ
+}
+
+// summarizeRepository returns the number of blobs and total size of the repository
+// the measured command has been operating on.
+func summarizeRepository(ctx context.Context, exe string, args []string) (*repoSummary, error) {
+	switch *repoSizeSource {
+	case repoSizeFromDir:
+		rs := newRepoSummary()
+
+		if *repoPath != "" {
+			if err := summarizeDir(*repoPath, rs); err != nil {
+				return nil, err
+			}
+		}
+
+		return rs, nil
+
+	case repoSizeFromBlobStats:
+		return blobStats(ctx, exe, args)
+
+	case repoSizeFromObjectStore:
+		return summarizeObjectStore(ctx)
+
+	default:
+		return nil, errors.Errorf("unsupported --repo-size-source %q", *repoSizeSource)
+	}
+}
+
+// summarizeDir adds all files under the repository directory to the summary, walking top-level
+// entries (shard directories) concurrently using --repo-size-parallel workers.
+func summarizeDir(dir string, rs *repoSummary) error {
+	for _, pattern := range strings.Split(*repoSizeExclude, ",") {
+		if _, err := path.Match(strings.TrimSpace(pattern), ""); err != nil {
+			return errors.Wrapf(err, "invalid --repo-size-exclude pattern %q", pattern)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "error reading dir")
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		work     = make(chan fs.DirEntry)
+	)
+
+	workers := *repoSizeParallel
+	if workers < 1 {
+		workers = 1
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			local := newRepoSummary()
+
+			for e := range work {
+				if err := summarizeEntry(dir, e, local); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+
+			mu.Lock()
+			rs.merge(local)
+			mu.Unlock()
+		}()
+	}
+
+	for _, e := range entries {
+		work <- e
+	}
+
+	close(work)
+	wg.Wait()
+
+	return firstErr
+}
+
+// summarizeEntry adds all files under the given top-level entry of the repository directory,
+// which all have the same blob type.
+func summarizeEntry(dir string, e fs.DirEntry, rs *repoSummary) error {
+	typ := blobType(e.Name())
+
+	return filepath.WalkDir(filepath.Join(dir, e.Name()), func(entryPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return errors.Wrap(err, "error walking dir")
+		}
+
+		if rel, _ := filepath.Rel(dir, entryPath); isExcludedFromRepoSize(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return errors.Wrap(err, "error getting info")
+		}
+
+		rs.add(typ, info.Size())
+
+		return nil
+	})
+}
+
+// isExcludedFromRepoSize returns true if the path relative to the repository directory
+// or its base name matches any of --repo-size-exclude patterns.
+func isExcludedFromRepoSize(rel string) bool {
+	if *repoSizeExclude == "" {
+		return false
+	}
+
+	rel = filepath.ToSlash(rel)
+
+	for _, pattern := range strings.Split(*repoSizeExclude, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// blobStats runs 'kopia blob stats --raw' using the same config file as the measured command,
+// once for the entire repository and once for each of knownBlobPrefixes.
+func blobStats(ctx context.Context, exe string, args []string) (*repoSummary, error) {
+	var statsArgs []string
+
+	for _, a := range args {
+		if strings.HasPrefix(a, "--config-file=") {
+			statsArgs = append(statsArgs, a)
+		}
+	}
+
+	statsArgs = append(statsArgs, "blob", "stats", "--raw")
+
+	run := func(extraArgs ...string) (int, int64, error) {
+		c := exec.CommandContext(ctx, exe, append(append([]string(nil), statsArgs...), extraArgs...)...)
+		c.Env = kopiaEnv(exe)
+
+		out, err := c.Output()
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "unable to run blob stats")
+		}
+
+		return parseBlobStats(out)
+	}
+
+	return summarizeBlobStats(func(prefix string) (int, int64, error) {
+		if prefix == "" {
+			return run()
+		}
+
+		return run("--prefix=" + prefix)
+	})
+}
+
+// summarizeBlobStats summarizes the repository using stats returning the number and total size
+// of blobs with the given prefix, or all blobs when empty.
+func summarizeBlobStats(stats func(prefix string) (int, int64, error)) (*repoSummary, error) {
+	rs := newRepoSummary()
+
+	var err error
+
+	if rs.numBlobs, rs.totalSize, err = stats(""); err != nil {
+		return nil, err
+	}
+
+	for _, prefix := range knownBlobPrefixes {
+		cnt, size, err := stats(prefix)
+		if err != nil {
+			return nil, errors.Wrapf(err, "prefix %q", prefix)
+		}
+
+		if cnt > 0 {
+			rs.byType[blobType(prefix)] = blobTypeSummary{cnt, size}
+		}
+	}
+
+	return rs, nil
+}
+
+// parseBlobStats parses the 'Count:' and 'Total:' lines of 'kopia blob stats --raw' output.
+func parseBlobStats(out []byte) (int, int64, error) {
+	var (
+		numBlobs  int
+		totalSize int64
+		found     int
+	)
+
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(s.Text()), ":")
+		if !ok {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "Count":
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return 0, 0, errors.Wrapf(err, "invalid blob count %q", value)
+			}
+
+			numBlobs = v
+			found++
+
+		case "Total":
+			v, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return 0, 0, errors.Wrapf(err, "invalid total size %q", value)
+			}
+
+			totalSize = v
+			found++
+		}
+	}
+
+	if found != 2 {
+		return 0, 0, errors.Errorf("unexpected blob stats output: %s", out)
+	}
+
+	return numBlobs, totalSize, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testBlobs are blob IDs of a small repository with their sizes.
+var testBlobs = []struct {
+	id   string
+	size int
+}{
+	{"kopia.repository", 10},
+	{"kopia.blobcfg", 20},
+	{"kopia.maintenance", 30},
+	{"p0123456789abcdef", 1000},
+	{"p1123456789abcdef", 2000},
+	{"q0123456789abcdef", 300},
+	{"xn0_0123456789abcdef", 40},
+	{"s0123456789abcdef", 5},
+	{"_log_20220101_0123456789abcdef", 60},
+}
+
+func TestBlobTypeConsistentAcrossSources(t *testing.T) {
+	want := map[string]blobTypeSummary{
+		"kopia": {3, 60},
+		"p":     {2, 3000},
+		"q":     {1, 300},
+		"x":     {1, 40},
+		"s":     {1, 5},
+		"_":     {1, 60},
+	}
+
+	sources := []struct {
+		name      string
+		summarize func(t *testing.T) *repoSummary
+	}{
+		{repoSizeFromDir, summarizeTestDir},
+		{repoSizeFromBlobStats, summarizeTestBlobStats},
+		{repoSizeFromObjectStore, summarizeTestObjects},
+	}
+
+	for _, src := range sources {
+		t.Run(src.name, func(t *testing.T) {
+			rs := src.summarize(t)
+
+			if rs.numBlobs != len(testBlobs) || rs.totalSize != 3465 {
+				t.Errorf("got %v blobs of %v bytes, want %v blobs of 3465 bytes", rs.numBlobs, rs.totalSize, len(testBlobs))
+			}
+
+			if !reflect.DeepEqual(rs.byType, want) {
+				t.Errorf("byType = %v, want %v", rs.byType, want)
+			}
+		})
+	}
+}
+
+// summarizeTestDir stores test blobs like the filesystem storage, sharded by the first
+// characters of the ID except for kopia.* blobs, and walks the directory.
+func summarizeTestDir(t *testing.T) *repoSummary {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for _, b := range testBlobs {
+		fname := filepath.Join(dir, b.id+".f")
+		if !strings.HasPrefix(b.id, "kopia.") {
+			fname = filepath.Join(dir, b.id[:3], b.id[3:]+".f")
+		}
+
+		if err := os.MkdirAll(filepath.Dir(fname), 0o700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(fname, make([]byte, b.size), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rs := newRepoSummary()
+
+	if err := summarizeDir(dir, rs); err != nil {
+		t.Fatal(err)
+	}
+
+	return rs
+}
+
+// summarizeTestBlobStats summarizes test blobs like 'kopia blob stats --prefix'.
+func summarizeTestBlobStats(t *testing.T) *repoSummary {
+	t.Helper()
+
+	rs, err := summarizeBlobStats(func(prefix string) (int, int64, error) {
+		var (
+			cnt  int
+			size int64
+		)
+
+		for _, b := range testBlobs {
+			if strings.HasPrefix(b.id, prefix) {
+				cnt++
+				size += int64(b.size)
+			}
+		}
+
+		return cnt, size, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs
+}
+
+// summarizeTestObjects summarizes test blobs stored as objects under a prefix.
+func summarizeTestObjects(t *testing.T) *repoSummary {
+	t.Helper()
+
+	rs := newRepoSummary()
+
+	for _, b := range testBlobs {
+		rs.add(objectBlobType("repo/"+b.id, "repo"), int64(b.size))
+	}
+
+	return rs
+}
@@ -7,6 +7,9 @@
 //
 // Usage: runbench [--flags] scenario1.sh ... scenarioN.sh
 //
+// Flag defaults and per-scenario overrides can also be provided in a YAML file passed
+// via --config (see benchConfig).
+//
 // Each scenario file is a simple bash script that prepares the test, it must contain exactly
 // one line starting with:
 //
@@ -15,12 +18,36 @@
 // This prefix prevents the command from running as part of bash script and allows the tool
 // to parse it and run separately with metric collection.
 //
+// Alternatively a scenario can contain several named steps measured one after another in each
+// run, such as a snapshot followed by its restore, each emitted with the 'step' tag:
+//
+//	[ -z "COLLECT_METRICS:snapshot" ] && $KOPIA_EXE snapshot create ...
+//	[ -z "COLLECT_METRICS:restore" ] && $KOPIA_EXE snapshot restore ...
+//
+// The whole script runs as the preparation of each run, so commands between steps are not
+// executed between them.
+//
+// Scenarios can also contain markers (comments such as '# MINIO' or '# NETWORK_SHAPING: ...') which
+// start servers for the scenario, shape its network or change how it's measured. Markers and flags
+// are documented next to the code implementing them.
+//
 // The tool relies on build information embedded in each Kopia binary (which relies on Go 1.18 or later)
 //
+// SIGINT or SIGTERM (or reaching --deadline) cancels the running scenario, killing its prepare
+// script, measured command and helper processes and stopping sampling.
+//
+// A failing scenario doesn't prevent the remaining ones from running, failed scenarios are listed
+// at the end and runbench exits with non-zero status.
+//
 // For each scenario the tool generates one output file:
 // <outputDir>/<scenario>/<gitTime>-<gitHash>.line
 //
-// This can be imported into InfluxDB using `influx write --file=<path>`
+// This can be imported into InfluxDB using the influximport tool, which skips files imported
+// previously, or for a single file using `influx write --file=<path>`.
+//
+// Sampling of the measured process, summarization of samples and line protocol output are
+// implemented by package runbench/pkg/bench, which other benchmark harnesses can import
+// (using a replace directive pointing at this directory).
 package main
 
 import (
@@ -31,19 +58,24 @@ import (
 	"fmt"
 	"io"
 	stdlog "log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/google/shlex"
 	"github.com/pkg/errors"
-	"github.com/shirou/gopsutil/v3/process"
+
+	"runbench/pkg/bench"
 )
 
 var log = stdlog.Default()
@@ -53,20 +85,34 @@ var log = stdlog.Default()
 // command to collect metrics for.
 const collectMetricsMarker = `[ -z "COLLECT_METRICS" ] && `
 
+// named variant of collectMetricsMarker which can prefix several lines, each measured as a step:
+//
+//	[ -z "COLLECT_METRICS:restore" ] && $KOPIA_EXE snapshot restore ...
+var collectStepMarkerRegexp = regexp.MustCompile(`^\[ -z "COLLECT_METRICS:([A-Za-z0-9_-]+)" \] && (.*)$`)
+
 // marker that can be put in a script to indicate that the benchmark can share single preparation phase.
 const singlePrepareMarker = `# SINGLE_PREPARE`
 
+// marker that can be put in a script whose measured command isn't kopia (e.g. tar, rsync or restic),
+// which is then run without kopia metrics flags and not scraped for Prometheus metrics, and only
+// the page cache is dropped in the cold cache state. Repository size is the size of --repo-path
+// (or --repo-url) the tool writes to.
+const genericCommandMarker = `# GENERIC_COMMAND`
+
 var (
 	kopiaExe    = flag.String("kopia-exe", os.ExpandEnv("$HOME/go/bin/kopia"), "Path to kopia")
 	compareExe  = flag.String("compare-to-exe", "", "Path to executable to compare against")
 	runTags     = flag.String("run-tags", "", "Comma-separated list of tags to attach to measurements")
-	repoPath    = flag.String("repo-path", "/tmp/kopia-test-repo", "Path to repository directory")
-	outputDir   = flag.String("output-dir", "/tmp/kopia-benchmark-outputs", "Output directory")
+	repoPath    = flag.String("repo-path", filepath.Join(os.TempDir(), "kopia-test-repo"), "Path to repository directory")
+	outputDir   = flag.String("output-dir", filepath.Join(os.TempDir(), "kopia-benchmark-outputs"), "Output directory")
 	timestamp   = flag.Int64("timestamp", 0, "Override benchmark timestamp")
 	force       = flag.Bool("force", false, "Force run even if output already exists")
 	minDuration = flag.Duration("min-duration", 2*time.Minute, "Repeat scenarios until they run for a given minum time")
 	minRepeat   = flag.Int("min-repeat", 3, "Repeat scenarios a given minum number of times")
 	goExe       = flag.String("go-exe", "go", "Path to go executable")
+
+	deadline         = flag.Duration("deadline", 0, "Cancel scenarios which are still running after this time, remaining scenarios fail (not used with --service and --suite)")
+	samplingInterval = flag.Duration("sampling-interval", 100*time.Millisecond, "Interval between samples of the measured process")
 )
 
 var (
@@ -75,272 +121,324 @@ var (
 	gitModified bool
 )
 
-type sample struct {
-	ts                time.Time
-	ram               float64 // MiB
-	cpu               float64
-	prometheusMetrics []byte
-}
-
 type runResult struct {
-	duration time.Duration
+	*bench.Result
 
 	repoSizeBytes int64
 	numRepoFiles  int
-
-	// prometheus metrics
+	blobTypes     map[string]blobTypeSummary
+
+	// repository growth caused by the run, emitted as repo_growth. With single preparation phase
+	// each run adds to the repository left by the previous one, so this is the incremental growth
+	// of each successive snapshot.
+	run             int
+	seed            int64
+	repoGrowthBytes int64
+	repoGrowthBlobs int
+
+	// increase of prometheus counters between the first and last scrape of the run, emitted as
+	// *_delta fields, which doesn't depend on the value the counter started at and survives
+	// restarts of the measured process
+	go_memstats_alloc_bytes_delta float64
+	go_memstats_mallocs_delta     float64
+
+	// last scraped values of the same counters, which are still emitted under the names used
+	// before the deltas (avg_heap_objects and avg_heap_bytes of process_heap_summary, heap_bytes
+	// of soak_run) until dashboards move to them
 	go_memstats_alloc_bytes_total float64
 	go_memstats_mallocs_total     float64
 
-	samples []*sample
+	// faults injected by fault injection proxy
+	faults faultCounts
+
+	// mutations of the source made by --churn
+	churn churnStats
+
+	// preparations since the previous result of the first step, including the one of the
+	// discarded warmup run
+	prepares []prepareResult
+
+	// progress updates printed by the measured command
+	progress []progressSample
+
+	// heap profile written by the measured command when it exited, with --heap-profile-top
+	heapProfile string
+
+	// captured output of the measured command
+	logFile      string
+	logSize      int64
+	logTruncated bool
 }
 
-func summarizeDir(dir string, numFiles *int, totalSize *int64) error {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return errors.Wrap(err, "error reading dir")
-	}
+// runKopia runs the measured command, capturing its output in logFile unless empty.
+func (ss *scenarioState) runKopia(ctx context.Context, timeOffset time.Duration, logFile, exe string, args ...string) (*runResult, error) {
+	var (
+		cmdArgs    = args
+		metricsURL string
+	)
 
-	for _, e := range entries {
-		if e.IsDir() {
-			if err := summarizeDir(filepath.Join(dir, e.Name()), numFiles, totalSize); err != nil {
-				return err
-			}
+	if !ss.generic {
+		s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log.Printf("received %v %v %v", r.Method, r.RequestURI, r.ContentLength)
 
-			continue
-		}
+			//_, _ = io.Copy(os.Stderr, r.Body)
+		}))
 
-		info, err := e.Info()
+		// the push server must be reachable from the network namespace of the measured command.
+		l, err := net.Listen("tcp", ss.netSandbox.listenHost()+":0")
 		if err != nil {
-			return errors.Wrap(err, "error getting info")
+			return nil, errors.Wrap(err, "unable to listen")
 		}
 
-		*totalSize += info.Size()
-		*numFiles++
-	}
-
-	return nil
-}
+		s.Listener.Close()
+		s.Listener = l
+		s.Start()
 
-func parsePrometheusCounters(b []byte) map[string]float64 {
-	res := map[string]float64{}
+		defer s.Close()
 
-	s := bufio.NewScanner(bytes.NewReader(b))
-	for s.Scan() {
-		l := s.Text()
+		cmdArgs = append([]string{
+			"--metrics-listen-addr=:6666",
+			"--metrics-push-addr=" + s.URL,
+			"--metrics-push-format=text",
+		}, args...)
 
-		if strings.HasPrefix(l, "#") {
-			continue
+		if *progressUpdateInterval > 0 {
+			cmdArgs = append([]string{"--progress-update-interval=" + progressUpdateInterval.String()}, cmdArgs...)
 		}
 
-		parts := strings.Split(l, " ")
-		if len(parts) != 2 {
-			continue
+		if ss.heapProfiling && logFile != "" {
+			cmdArgs = append(heapProfileArgs(logFile), cmdArgs...)
 		}
+		metricsURL = "http://" + ss.netSandbox.metricsHost() + ":6666/metrics"
+	}
 
-		name := parts[0]
-		value, err := strconv.ParseFloat(parts[1], 64)
+	c := ss.netSandbox.command(ctx, exe, cmdArgs...)
+	c.Env = kopiaEnv(exe)
+
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+
+	var (
+		rl  *runLog
+		err error
+	)
+
+	if logFile != "" {
+		rl, err = openRunLog(logFile)
 		if err != nil {
-			continue
+			return nil, err
 		}
 
-		res[name] = value
+		defer rl.Close()
+
+		c.Stdout = rl.writer(os.Stdout)
+		c.Stderr = rl.writer(os.Stderr)
 	}
 
-	return res
-}
+	// progress is only printed by kopia.
+	var progress *progressParser
 
-func runCommandAndSample(ctx context.Context, c *exec.Cmd, timeOffset time.Duration) (*runResult, error) {
-	t0 := time.Now()
-
-	err := c.Start()
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to start")
+	if !ss.generic {
+		progress = newProgressParser(timeOffset)
+		c.Stderr = io.MultiWriter(c.Stderr, progress)
 	}
 
-	var (
-		dur    time.Duration
-		runErr error
-		wg     sync.WaitGroup
-	)
-
-	wg.Add(1)
-	go func() {
-		runErr = c.Wait()
-		dur = time.Since(t0)
-		wg.Done()
-	}()
+	ss.memoryLimiter.wrap(c)
 
-	proc, err := process.NewProcessWithContext(ctx, int32(c.Process.Pid))
+	samplers, err := parseSamplers()
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to attach to process")
+		return nil, err
 	}
 
-	var samples []*sample
-
-	for {
-		s := &sample{
-			ts: time.Now().Add(timeOffset),
-		}
+	if ss.generic {
+		samplers = withoutSampler(samplers, "prometheus")
+	}
 
-		mi, err := proc.MemoryInfoWithContext(ctx)
-		if err != nil {
-			break
-		}
+	r := &bench.CommandRunner{
+		Interval:         *samplingInterval,
+		TimeOffset:       timeOffset,
+		MetricsURL:       metricsURL,
+		MetricsEndpoints: ss.endpoints,
+		Samplers:         samplers,
+	}
 
-		cpuPercent, err := proc.CPUPercentWithContext(ctx)
+	if *rawSamples && logFile != "" {
+		f, err := os.Create(rawSamplesFile(logFile))
 		if err != nil {
-			break
+			return nil, errors.Wrap(err, "unable to create raw samples file")
 		}
 
-		s.cpu = cpuPercent
-		s.ram = float64(mi.RSS) / (1 << 20)
-
-		resp, err := http.Get("http://localhost:6666/metrics")
-		if err == nil {
-			s.prometheusMetrics, _ = io.ReadAll(resp.Body)
-			resp.Body.Close()
-		}
+		defer f.Close()
 
-		samples = append(samples, s)
+		bw := bufio.NewWriter(f)
+		defer bw.Flush()
 
-		time.Sleep(100 * time.Millisecond)
+		r.RawSamples = bw
 	}
 
-	wg.Wait()
-
-	if len(samples) == 0 {
-		return nil, errors.Errorf("no samples")
+	res, runErr := r.Run(ctx, c)
+	if runErr != nil && rl != nil {
+		runErr = errors.Wrapf(runErr, "see %v", logFile)
 	}
 
-	var numFiles int
-	var totalSize int64
+	if res == nil {
+		return nil, runErr
+	}
 
-	if *repoPath != "" {
-		if err := summarizeDir(*repoPath, &numFiles, &totalSize); err != nil {
-			return nil, errors.Wrap(err, "error summarizing repository")
-		}
+	rs, err := summarizeRepository(ctx, exe, args)
+	if err != nil {
+		return nil, errors.Wrap(err, "error summarizing repository")
 	}
 
 	rr := &runResult{
-		samples:       samples,
-		duration:      dur,
-		numRepoFiles:  numFiles,
-		repoSizeBytes: totalSize,
+		Result:        res,
+		numRepoFiles:  rs.numBlobs,
+		repoSizeBytes: rs.totalSize,
+		blobTypes:     rs.byType,
+
+		go_memstats_alloc_bytes_delta: res.CounterDelta("go_memstats_alloc_bytes_total"),
+		go_memstats_mallocs_delta:     res.CounterDelta("go_memstats_mallocs_total"),
+		go_memstats_alloc_bytes_total: res.LastCounter("go_memstats_alloc_bytes_total"),
+		go_memstats_mallocs_total:     res.LastCounter("go_memstats_mallocs_total"),
 	}
 
-	for _, s := range samples {
-		counters := parsePrometheusCounters(s.prometheusMetrics)
+	if rl != nil {
+		rr.logFile, rr.logSize, rr.logTruncated = logFile, rl.written, rl.truncated
+	}
 
-		if v := counters["go_memstats_alloc_bytes_total"]; v > 0 {
-			rr.go_memstats_alloc_bytes_total = v
-		}
+	if progress != nil {
+		rr.progress = progress.finish()
+	}
 
-		if v := counters["go_memstats_mallocs_total"]; v > 0 {
-			rr.go_memstats_mallocs_total = v
-		}
+	if ss.heapProfiling && logFile != "" && runErr == nil {
+		rr.heapProfile = heapProfileFile(logFile)
 	}
 
 	return rr, runErr
 }
 
-func runKopia(ctx context.Context, timeOffset time.Duration, exe string, args ...string) (*runResult, error) {
-	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("received %v %v %v", r.Method, r.RequestURI, r.ContentLength)
-
-		//_, _ = io.Copy(os.Stderr, r.Body)
-	}))
-
-	c := exec.CommandContext(ctx, exe, append([]string{
-		"--metrics-listen-addr=:6666",
-		"--metrics-push-addr=" + s.URL,
-		"--metrics-push-format=text",
-	}, args...)...)
-	c.Env = append(append([]string(nil), os.Environ()...),
-		"KOPIA_EXE="+exe,
-		"REPO_PATH="+*repoPath,
-	)
-
-	c.Stdout = os.Stdout
-	c.Stderr = os.Stderr
-
-	return runCommandAndSample(ctx, c, timeOffset)
-}
-
-func runPrepare(ctx context.Context, scenarioFile string) error {
-	c := exec.Command(scenarioFile)
-	c.Env = append(append([]string(nil), os.Environ()...),
-		"KOPIA_EXE="+*kopiaExe,
-		"REPO_PATH="+*repoPath,
-	)
-
-	out, err := c.CombinedOutput()
-
-	return errors.Wrapf(err, "failed with %s", out)
+type runSummary struct {
+	bench.Summary
+
+	avgRepoSize         float64
+	avgFileCount        float64
+	avgRepoGrowth       float64
+	avgBlobGrowth       float64
+	avgHeapObjectsDelta float64
+	avgHeapBytesDelta   float64
+	avgHeapObjects      float64 // deprecated
+	avgHeapBytes        float64 // deprecated
+
+	avgInjectedErrors   float64
+	avgInjectedTimeouts float64
+	avgInjectedSlow     float64
+
+	avgChurnOps float64
+	avgChurnCPU float64
+	maxChurnRAM float64
+
+	avgBlobTypes map[string]blobTypeAverage
 }
 
-type runSummary struct {
-	avgCPU float64
-	maxCPU float64
-	avgRAM float64
-	maxRAM float64
-
-	avgRepoSize    float64
-	avgFileCount   float64
-	avgDuration    float64
-	avgHeapObjects float64
-	avgHeapBytes   float64
+// blobTypeAverage is the average number and total size of blobs of a single type.
+type blobTypeAverage struct {
+	count float64
+	size  float64
 }
 
 func summarizeSamples(rrs []*runResult) runSummary {
 	var (
-		totalCPU         float64
-		totalRAM         float64
-		totalDuration    float64
-		totalFiles       float64
-		totalRepoSize    float64
-		totalHeapObjects float64
-		totalHeapBytes   float64
-		maxCPU           float64
-		maxRAM           float64
-		cnt              int
+		totalFiles            float64
+		totalRepoSize         float64
+		totalRepoGrowth       float64
+		totalBlobGrowth       float64
+		totalHeapObjects      float64
+		totalHeapBytes        float64
+		totalHeapObjectsTotal float64
+		totalHeapBytesTotal   float64
+
+		totalFaults faultCounts
+
+		totalChurnOps float64
+		totalChurnCPU float64
+		maxChurnRAM   float64
+
+		totalBlobTypes = map[string]blobTypeAverage{}
+		results        []*bench.Result
 	)
 
 	for _, rr := range rrs {
-		totalDuration += rr.duration.Seconds()
+		results = append(results, rr.Result)
+
 		totalFiles += float64(rr.numRepoFiles)
 		totalRepoSize += float64(rr.repoSizeBytes)
-		totalHeapObjects += float64(rr.go_memstats_mallocs_total)
-		totalHeapBytes += float64(rr.go_memstats_alloc_bytes_total)
-
-		for _, s := range rr.samples {
-			totalCPU += s.cpu
-			totalRAM += float64(s.ram)
-
-			if s.cpu > maxCPU {
-				maxCPU = s.cpu
-			}
+		totalRepoGrowth += float64(rr.repoGrowthBytes)
+		totalBlobGrowth += float64(rr.repoGrowthBlobs)
+		totalHeapObjects += rr.go_memstats_mallocs_delta
+		totalHeapBytes += rr.go_memstats_alloc_bytes_delta
+		totalHeapObjectsTotal += rr.go_memstats_mallocs_total
+		totalHeapBytesTotal += rr.go_memstats_alloc_bytes_total
+
+		totalFaults.errors += rr.faults.errors
+		totalFaults.timeouts += rr.faults.timeouts
+		totalFaults.slow += rr.faults.slow
+
+		totalChurnOps += float64(rr.churn.ops)
+		totalChurnCPU += rr.churn.avgCPU
+
+		if rr.churn.maxRAM > maxChurnRAM {
+			maxChurnRAM = rr.churn.maxRAM
+		}
 
-			if s.ram > maxRAM {
-				maxRAM = s.ram
-			}
+		for typ, bt := range rr.blobTypes {
+			t := totalBlobTypes[typ]
+			t.count += float64(bt.count)
+			t.size += float64(bt.size)
+			totalBlobTypes[typ] = t
+		}
+	}
 
-			cnt++
+	for typ, t := range totalBlobTypes {
+		totalBlobTypes[typ] = blobTypeAverage{
+			count: t.count / float64(len(rrs)),
+			size:  t.size / float64(len(rrs)),
 		}
 	}
 
 	return runSummary{
-		avgCPU: totalCPU / float64(cnt),
-		maxCPU: maxCPU,
-		avgRAM: totalRAM / float64(cnt),
-		maxRAM: maxRAM,
+		Summary: bench.Summarize(results),
+
+		avgRepoSize:         totalRepoSize / float64(len(rrs)),
+		avgFileCount:        totalFiles / float64(len(rrs)),
+		avgRepoGrowth:       totalRepoGrowth / float64(len(rrs)),
+		avgBlobGrowth:       totalBlobGrowth / float64(len(rrs)),
+		avgHeapObjectsDelta: totalHeapObjects / float64(len(rrs)),
+		avgHeapBytesDelta:   totalHeapBytes / float64(len(rrs)),
+		avgHeapObjects:      totalHeapObjectsTotal / float64(len(rrs)),
+		avgHeapBytes:        totalHeapBytesTotal / float64(len(rrs)),
+
+		avgInjectedErrors:   float64(totalFaults.errors) / float64(len(rrs)),
+		avgInjectedTimeouts: float64(totalFaults.timeouts) / float64(len(rrs)),
+		avgInjectedSlow:     float64(totalFaults.slow) / float64(len(rrs)),
+
+		avgChurnOps: totalChurnOps / float64(len(rrs)),
+		avgChurnCPU: totalChurnCPU / float64(len(rrs)),
+		maxChurnRAM: maxChurnRAM,
+
+		avgBlobTypes: totalBlobTypes,
+	}
+}
 
-		avgRepoSize:    totalRepoSize / float64(len(rrs)),
-		avgFileCount:   totalFiles / float64(len(rrs)),
-		avgDuration:    totalDuration / float64(len(rrs)),
-		avgHeapObjects: totalHeapObjects / float64(len(rrs)),
-		avgHeapBytes:   totalHeapBytes / float64(len(rrs)),
+func sortedBlobTypes(m map[string]blobTypeAverage) []string {
+	var res []string
+
+	for typ := range m {
+		res = append(res, typ)
 	}
+
+	sort.Strings(res)
+
+	return res
 }
 
 func compareValues(current, baseline float64) string {
@@ -362,111 +460,351 @@ func compareSamples(f io.Writer, rrs, baseline []*runResult) {
 	summ := summarizeSamples(rrs)
 	summ2 := summarizeSamples(baseline)
 
-	fmt.Fprintf(f, "DIFF duration:%v\n", compareValues(summ.avgDuration, summ2.avgDuration))
+	fmt.Fprintf(f, "DIFF duration:%v\n", compareValues(summ.AvgDuration, summ2.AvgDuration))
 	fmt.Fprintf(f, "DIFF repo_size:%v\n", compareValues(summ.avgRepoSize, summ2.avgRepoSize))
 	fmt.Fprintf(f, "DIFF num_files:%v\n", compareValues(summ.avgFileCount, summ2.avgFileCount))
+	fmt.Fprintf(f, "DIFF repo_growth:%v\n", compareValues(summ.avgRepoGrowth, summ2.avgRepoGrowth))
+
+	for _, typ := range sortedBlobTypes(summ.avgBlobTypes) {
+		fmt.Fprintf(f, "DIFF repo_size[%v]:%v\n", typ, compareValues(summ.avgBlobTypes[typ].size, summ2.avgBlobTypes[typ].size))
+	}
 
-	fmt.Fprintf(f, "DIFF avg_heap_objects:%v\n", compareValues(summ.avgHeapObjects, summ2.avgHeapObjects))
-	fmt.Fprintf(f, "DIFF avg_heap_bytes:%v\n", compareValues(summ.avgHeapBytes, summ2.avgHeapBytes))
+	fmt.Fprintf(f, "DIFF avg_heap_objects_delta:%v\n", compareValues(summ.avgHeapObjectsDelta, summ2.avgHeapObjectsDelta))
+	fmt.Fprintf(f, "DIFF avg_heap_bytes_delta:%v\n", compareValues(summ.avgHeapBytesDelta, summ2.avgHeapBytesDelta))
 
-	fmt.Fprintf(f, "DIFF avg_ram:%v\n", compareValues(summ.avgRAM, summ2.avgRAM))
-	fmt.Fprintf(f, "DIFF max_ram:%v\n", compareValues(summ.maxRAM, summ2.maxRAM))
+	fmt.Fprintf(f, "DIFF avg_ram:%v\n", compareValues(summ.AvgRAM, summ2.AvgRAM))
+	fmt.Fprintf(f, "DIFF max_ram:%v\n", compareValues(summ.MaxRAM, summ2.MaxRAM))
 
-	fmt.Fprintf(f, "DIFF avg_cpu:%v\n", compareValues(summ.avgCPU, summ2.avgCPU))
-	fmt.Fprintf(f, "DIFF max_cpu:%v\n", compareValues(summ.maxCPU, summ2.maxCPU))
+	fmt.Fprintf(f, "DIFF avg_cpu:%v\n", compareValues(summ.AvgCPU, summ2.AvgCPU))
+	fmt.Fprintf(f, "DIFF max_cpu:%v\n", compareValues(summ.MaxCPU, summ2.MaxCPU))
 }
 
-func logSamples(f io.Writer, scen string, rrs []*runResult) {
+// scenarioTags returns tags attached to all measurements of the scenario.
+func scenarioTags(scen string) ([]bench.Tag, error) {
+	dsTags, err := datasetTags()
+	if err != nil {
+		return nil, err
+	}
+
+	extraTags, err := bench.ParseTags(*runTags)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid --run-tags")
+	}
+
+	return append(append(append([]bench.Tag{
+		{Key: "rev", Value: gitRevision},
+		{Key: "mod", Value: strconv.FormatBool(gitModified)},
+		{Key: "gitTime", Value: strconv.FormatInt(gitTime.Unix(), 10)},
+		{Key: "scenario", Value: scen},
+	}, platformTags()...), dsTags...), extraTags...), nil
+}
+
+func (ss *scenarioState) logSamples(sink bench.Sink, rrs []*runResult, stateTags ...bench.Tag) error {
 	summ := summarizeSamples(rrs)
 
-	// log.Printf("dur: %v CPU avg:%.1f max:%.1f RAM avg:%.1f max:%.1f", rr.duration, totalCPU/float64(len(rr.samples)), maxCPU, float64(totalRAM)/((1<<20)*float64(len(rr.samples))), float64(maxRAM)/float64((1<<20)))
+	tags, err := scenarioTags(ss.name)
+	if err != nil {
+		return err
+	}
 
-	tags := strings.Join([]string{
-		fmt.Sprintf("rev=%v", gitRevision),
-		fmt.Sprintf("mod=%v", gitModified),
-		fmt.Sprintf("gitTime=%v", gitTime.Unix()),
-		fmt.Sprintf("scenario=%v", scen),
-	}, ",")
+	tags = append(tags, stateTags...)
 
-	if *runTags != "" {
-		tags += "," + *runTags
+	withTag := func(key string, value interface{}) []bench.Tag {
+		return append(append([]bench.Tag(nil), tags...), bench.Tag{Key: key, Value: fmt.Sprint(value)})
 	}
 
-	fmt.Fprintf(f, "process_summary,%v duration=%.1f,repo_size=%v,num_files=%v %v\n",
-		tags,
-		summ.avgDuration,
-		summ.avgRepoSize,
-		summ.avgFileCount,
-		gitTime.UnixNano(),
-	)
+	point := func(measurement string, tags []bench.Tag, fields ...bench.Field) bench.Point {
+		return bench.Point{Measurement: measurement, Tags: tags, Fields: fields, Time: gitTime}
+	}
 
-	fmt.Fprintf(f, "process_heap_summary,%v avg_heap_objects=%v,avg_heap_bytes=%v %v\n",
-		tags,
-		summ.avgHeapObjects,
-		summ.avgHeapBytes,
-		gitTime.UnixNano(),
-	)
-	fmt.Fprintf(f, "process_ram_summary,%v avg_ram_rss=%v,max_ram_rss=%v %v\n",
-		tags,
-		summ.avgRAM,
-		summ.maxRAM,
-		gitTime.UnixNano(),
-	)
+	points := []bench.Point{
+		point("process_summary", tags,
+			bench.Field{Key: "duration", Value: bench.Fixed{Value: summ.AvgDuration, Digits: 1}},
+			bench.Field{Key: "repo_size", Value: summ.avgRepoSize},
+			bench.Field{Key: "num_files", Value: summ.avgFileCount}),
+		point("process_ram_summary", tags,
+			bench.Field{Key: "avg_ram_rss", Value: summ.AvgRAM},
+			bench.Field{Key: "max_ram_rss", Value: summ.MaxRAM}),
+		point("process_cpu_summary", tags,
+			bench.Field{Key: "avg_cpu_percent", Value: summ.AvgCPU},
+			bench.Field{Key: "max_cpu_percent", Value: summ.MaxCPU}),
+		point("runbench_overhead_summary", tags,
+			bench.Field{Key: "avg_cpu_percent", Value: summ.AvgSelfCPU},
+			bench.Field{Key: "max_ram_rss", Value: summ.MaxSelfRAM},
+			bench.Field{Key: "avg_sampling_percent", Value: summ.AvgSamplingOverhead},
+			bench.Field{Key: "sampling_errors", Value: summ.SamplingErrors}),
+		point("repo_growth_summary", tags,
+			bench.Field{Key: "avg_size_delta", Value: summ.avgRepoGrowth},
+			bench.Field{Key: "avg_num_blobs_delta", Value: summ.avgBlobGrowth}),
+	}
 
-	fmt.Fprintf(f, "process_cpu_summary,%v avg_cpu_percent=%v,max_cpu_percent=%v %v\n",
-		tags,
-		summ.avgCPU,
-		summ.maxCPU,
-		gitTime.UnixNano(),
-	)
+	// heap counters are only scraped from kopia.
+	if !ss.generic {
+		points = append(points, point("process_heap_summary", tags,
+			bench.Field{Key: "avg_heap_objects_delta", Value: summ.avgHeapObjectsDelta},
+			bench.Field{Key: "avg_heap_bytes_delta", Value: summ.avgHeapBytesDelta},
+			bench.Field{Key: "avg_heap_objects", Value: summ.avgHeapObjects},
+			bench.Field{Key: "avg_heap_bytes", Value: summ.avgHeapBytes}))
+	}
+
+	withRun := func(run int, seed int64) []bench.Tag {
+		return append(withTag("run", run), seedTags(seed)...)
+	}
+
+	for _, rr := range rrs {
+		points = append(points, point("repo_growth", withRun(rr.run, rr.seed),
+			bench.Field{Key: "size_delta", Value: rr.repoGrowthBytes},
+			bench.Field{Key: "num_blobs_delta", Value: rr.repoGrowthBlobs}))
+
+		if rr.logFile != "" {
+			points = append(points, runLogPoint(withRun(rr.run, rr.seed), rr))
+		}
+
+		for _, pr := range rr.prepares {
+			points = append(points, preparePoint(withRun(pr.run, pr.seed), pr))
+		}
+
+		points = append(points, progressPoints(withRun(rr.run, rr.seed), rr)...)
+	}
+
+	for _, typ := range sortedBlobTypes(summ.avgBlobTypes) {
+		points = append(points, point("repo_composition_summary", withTag("blob_type", typ),
+			bench.Field{Key: "num_blobs", Value: summ.avgBlobTypes[typ].count},
+			bench.Field{Key: "size", Value: summ.avgBlobTypes[typ].size}))
+	}
+
+	if ss.faultInjector != nil {
+		points = append(points, point("fault_injection_summary", tags,
+			bench.Field{Key: "avg_errors", Value: summ.avgInjectedErrors},
+			bench.Field{Key: "avg_timeouts", Value: summ.avgInjectedTimeouts},
+			bench.Field{Key: "avg_slow", Value: summ.avgInjectedSlow}))
+	}
+
+	if fields := samplerValueFields(summ.Summary); len(fields) > 0 {
+		points = append(points, point("sampler_summary", tags, fields...))
+	}
+
+	if ss.sourceChurner != nil {
+		points = append(points, point("source_churn_summary", tags,
+			bench.Field{Key: "avg_ops", Value: summ.avgChurnOps},
+			bench.Field{Key: "avg_cpu_percent", Value: summ.avgChurnCPU},
+			bench.Field{Key: "max_ram_rss", Value: summ.maxChurnRAM}))
+	}
+
+	metricPoints, err := ss.capturedMetricPoints(tags, rrs)
+	if err != nil {
+		return err
+	}
+
+	histogramPoints, err := ss.capturedHistogramPoints(tags, rrs)
+	if err != nil {
+		return err
+	}
+
+	return sink.Write(append(append(points, metricPoints...), histogramPoints...)...)
+}
+
+// scenarioInfo describes a parsed scenario script.
+type scenarioInfo struct {
+	steps          []scenarioStep
+	singlePrepare  bool
+	networkShaping string
+	netns          string
+	churn          string
+	networkSource  string
+	faultInjection string
+	repoSizeSource string
+	env            []string
+	endpoints      []string
+	generic        bool
+	cumulative     bool
+	minio          bool
+	sftp           bool
+	webDAV         bool
+}
+
+// scenarioStep is a measured command line of a scenario, unnamed unless the scenario has
+// several steps.
+type scenarioStep struct {
+	name        string
+	commandLine string // measured command line before variable expansion
 }
 
-func parseScenario(fname string) (string, []string, bool, error) {
+func parseScenario(fname string) (*scenarioInfo, error) {
 	f, err := os.Open(fname)
 	if err != nil {
-		return "", nil, false, err
+		return nil, err
 	}
 	defer f.Close()
 
-	var lines []string
-	singlePrepare := false
+	var (
+		lines []string
+		steps []scenarioStep
+	)
+
+	si := &scenarioInfo{}
+	stepNames := map[string]bool{}
 
 	s := bufio.NewScanner(f)
 	for s.Scan() {
 		if strings.HasPrefix(s.Text(), collectMetricsMarker) {
 			lines = append(lines, strings.TrimPrefix(s.Text(), collectMetricsMarker))
 		}
+		if m := collectStepMarkerRegexp.FindStringSubmatch(s.Text()); m != nil {
+			if stepNames[m[1]] {
+				return nil, errors.Errorf("duplicate step %q in %q", m[1], fname)
+			}
+
+			stepNames[m[1]] = true
+			steps = append(steps, scenarioStep{name: m[1], commandLine: m[2]})
+		}
 		if strings.HasPrefix(s.Text(), singlePrepareMarker) {
-			singlePrepare = true
+			si.singlePrepare = true
+		}
+		if strings.HasPrefix(s.Text(), cumulativeMarker) {
+			si.cumulative = true
+		}
+		if strings.HasPrefix(s.Text(), genericCommandMarker) {
+			si.generic = true
+		}
+		if strings.HasPrefix(s.Text(), minioMarker) {
+			si.minio = true
+		}
+		if strings.HasPrefix(s.Text(), sftpMarker) {
+			si.sftp = true
+		}
+		if strings.HasPrefix(s.Text(), webDAVMarker) {
+			si.webDAV = true
+		}
+		if strings.HasPrefix(s.Text(), faultInjectionMarker) {
+			si.faultInjection = strings.TrimSpace(strings.TrimPrefix(s.Text(), faultInjectionMarker))
+		}
+		if strings.HasPrefix(s.Text(), repoSizeSourceMarker) {
+			si.repoSizeSource = strings.TrimSpace(strings.TrimPrefix(s.Text(), repoSizeSourceMarker))
+		}
+		if strings.HasPrefix(s.Text(), networkSourceMarker) {
+			si.networkSource = strings.TrimSpace(strings.TrimPrefix(s.Text(), networkSourceMarker))
+		}
+		if strings.HasPrefix(s.Text(), metricsEndpointMarker) {
+			si.endpoints = append(si.endpoints, strings.TrimSpace(strings.TrimPrefix(s.Text(), metricsEndpointMarker)))
+		}
+		if strings.HasPrefix(s.Text(), envMarker) {
+			si.env = append(si.env, strings.TrimSpace(strings.TrimPrefix(s.Text(), envMarker)))
+		}
+		if strings.HasPrefix(s.Text(), churnMarker) {
+			si.churn = strings.TrimSpace(strings.TrimPrefix(s.Text(), churnMarker))
+		}
+		if strings.HasPrefix(s.Text(), netnsMarker) {
+			si.netns = strings.TrimSpace(strings.TrimPrefix(s.Text(), netnsMarker))
+		}
+		if strings.HasPrefix(s.Text(), networkShapingMarker) {
+			si.networkShaping = strings.TrimSpace(strings.TrimPrefix(s.Text(), networkShapingMarker))
+		}
+	}
+
+	if si.cumulative && len(steps) < 2 {
+		return nil, errors.Errorf("%q must have several named steps to be measured as '%v'", fname, cumulativeMarker)
+	}
+
+	if len(steps) > 0 {
+		if len(lines) != 0 {
+			return nil, errors.Errorf("%q can't have both named steps and unnamed measured line", fname)
 		}
+
+		si.steps = steps
+
+		return si, nil
 	}
 
 	if len(lines) != 1 {
-		return "", nil, false, errors.Errorf("expected %q to have exactly one line, got %v", fname, len(lines))
+		return nil, errors.Errorf("expected %q to have exactly one line, got %v", fname, len(lines))
+	}
+
+	si.steps = []scenarioStep{{commandLine: lines[0]}}
+
+	return si, nil
+}
+
+// measuredStep is a step of a scenario ready to run.
+type measuredStep struct {
+	name string
+	exe  string
+	args []string
+}
+
+// measuredSteps returns the steps with commands expanded.
+func (si *scenarioInfo) measuredSteps() ([]measuredStep, error) {
+	var steps []measuredStep
+
+	for _, st := range si.steps {
+		exe, args, err := st.command()
+		if err != nil {
+			return nil, err
+		}
+
+		steps = append(steps, measuredStep{name: st.name, exe: exe, args: args})
+	}
+
+	return steps, nil
+}
+
+// withExe returns the steps measuring a different executable, e.g. the baseline of --compare-to-exe.
+func withExe(steps []measuredStep, exe string) []measuredStep {
+	res := append([]measuredStep(nil), steps...)
+
+	for i := range res {
+		res[i].exe = exe
 	}
 
-	expanded := strings.ReplaceAll(lines[0], "$KOPIA_EXE", *kopiaExe)
+	return res
+}
+
+func stepTags(step string) []bench.Tag {
+	if step == "" {
+		return nil
+	}
+
+	return []bench.Tag{{Key: "step", Value: step}}
+}
+
+// stepRuns are measured runs of a single step of a scenario.
+type stepRuns struct {
+	step string
+	runs []*runResult
+}
+
+// command returns the measured command with variables expanded, including the ones
+// exported to the scenario by runbench, except for $RUN_SEED expanded when each run starts.
+func (st scenarioStep) command() (string, []string, error) {
+	expanded := strings.ReplaceAll(st.commandLine, "$KOPIA_EXE", *kopiaExe)
 	expanded = strings.ReplaceAll(expanded, "$REPO_PATH", *repoPath)
-	expanded = os.ExpandEnv(expanded)
+	expanded = os.Expand(expanded, lookupCommandEnv)
 
 	parts, err := shlex.Split(expanded)
 	if err != nil {
-		return "", nil, false, errors.Wrap(err, "unable to split")
+		return "", nil, errors.Wrap(err, "unable to split")
 	}
 
-	return parts[0], parts[1:], singlePrepare, nil
+	return parts[0], parts[1:], nil
 }
 
 func failOnError(err error) {
 	if err != nil {
+		if cloudLog != nil {
+			cloudLog.logFatal(err)
+		}
+
 		log.Fatal(err)
 	}
 }
 
-func parseBuildInfo() {
+func parseBuildInfo() error {
 	c := exec.Command(*goExe, "version", "-m", *kopiaExe)
 	o, err := c.Output()
-	failOnError(errors.Wrap(err, "unable to run go version"))
+	if err != nil {
+		return errors.Wrap(err, "unable to run go version")
+	}
+
 	s := bufio.NewScanner(bytes.NewReader(o))
 	for s.Scan() {
 		fields := strings.Fields(s.Text())
@@ -486,7 +824,10 @@ func parseBuildInfo() {
 		switch key {
 		case "vcs.time":
 			t, err := time.Parse(time.RFC3339, val)
-			failOnError(err)
+			if err != nil {
+				return errors.Wrap(err, "invalid vcs.time")
+			}
+
 			gitTime = t
 		case "vcs.revision":
 			gitRevision = val
@@ -498,88 +839,455 @@ func parseBuildInfo() {
 	if *timestamp != 0 {
 		gitTime = time.Unix(*timestamp, 0)
 	}
+
+	return nil
 }
 
-func runMultiple(ctx context.Context, scenFile string, timeOffset time.Duration, exe string, args []string, singlePrepare bool) []*runResult {
+func (ss *scenarioState) runMultiple(ctx context.Context, steps []measuredStep, cache string) ([]stepRuns, error) {
 	var (
-		runs          []*runResult
+		results       = make([]stepRuns, len(steps))
 		totalDuration time.Duration
 		totalCount    int
+		before        *repoSummary
+		prepares      []prepareResult
 	)
 
+	for i, st := range steps {
+		results[i].step = st.name
+	}
+
+	defer setLogLabel("run", "")
+
 	for totalDuration < *minDuration || totalCount < *minRepeat {
-		log.Printf("Run #%v (%v), total duration %v", totalCount+1, exe, totalDuration)
-		if totalCount == 0 || !singlePrepare {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		seed := newRunSeed(totalCount + 1)
+		setRunSeed(seed)
+
+		setLogLabel("run", strconv.Itoa(totalCount+1))
+		log.Printf("Run #%v (%v), total duration %v, seed %v", totalCount+1, steps[0].exe, totalDuration, seed)
+		if totalCount == 0 || !ss.singlePrepare {
 			log.Printf("  preparing...")
-			failOnError(runPrepare(ctx, scenFile))
+
+			if err := ss.resetStorageServers(ctx); err != nil {
+				return nil, err
+			}
+
+			var logFile string
+			if ss.runLogDir != "" {
+				logFile = filepath.Join(ss.runLogDir, fmt.Sprintf("prepare-%v.log", totalCount+1))
+			}
+
+			pr, err := runPrepare(ctx, ss.file, logFile, totalCount+1, seed)
+			if err != nil {
+				return nil, errors.Wrap(err, "prepare failed")
+			}
+
+			log.Printf("  prepared in %v", pr.duration.Round(time.Millisecond))
+
+			prepares = append(prepares, pr)
+
+			before, err = summarizeRepository(ctx, steps[0].exe, withRunSeed(steps[0].args, seed))
+			if err != nil {
+				return nil, errors.Wrap(err, "error summarizing prepared repository")
+			}
 		}
 
-		log.Printf("  running...")
-		t0 := time.Now()
-		rr, err := runKopia(ctx, timeOffset, exe, args...)
-		failOnError(err)
+		// steps run one after another in the same prepared repository.
+		for i, st := range steps {
+			rr, elapsed, err := ss.runStep(ctx, st, cache, totalCount+1, seed, before)
+			if err != nil {
+				return nil, err
+			}
 
-		if totalCount > 0 {
-			// discard first result as a warmup
-			runs = append(runs, rr)
+			before = &repoSummary{numBlobs: rr.numRepoFiles, totalSize: rr.repoSizeBytes}
+
+			if totalCount > 0 {
+				// discard first result as a warmup
+				results[i].runs = append(results[i].runs, rr)
+
+				if i == 0 {
+					rr.prepares, prepares = prepares, nil
+				}
+			}
+
+			totalDuration += elapsed
 		}
 
-		totalDuration += time.Since(t0)
+		pingWatchdog()
+
 		totalCount++
-		log.Printf("  completed in %v dir size: %v allocated bytes %v allocated objects: %v", rr.duration, rr.repoSizeBytes, int64(rr.go_memstats_alloc_bytes_total), int64(rr.go_memstats_mallocs_total))
 	}
 
-	return runs
+	return ss.withCumulativeRuns(results), nil
 }
 
-func main() {
-	flag.Parse()
+// runStep measures a single step of the given run, with repository growth relative to before.
+// It returns the result along with the time it took including starting and stopping the churn.
+func (ss *scenarioState) runStep(ctx context.Context, st measuredStep, cache string, run int, seed int64, before *repoSummary) (*runResult, time.Duration, error) {
+	args := withRunSeed(st.args, seed)
+
+	if cache == cacheCold {
+		log.Printf("  clearing caches...")
+
+		if err := ss.clearCaches(ctx, st.exe, args); err != nil {
+			return nil, 0, err
+		}
+	}
 
-	ctx := context.Background()
+	logName := fmt.Sprintf("run-%v.log", run)
 
-	parseBuildInfo()
+	if st.name != "" {
+		log.Printf("  running step %v...", st.name)
 
-	for _, scenFile := range flag.Args() {
-		scen := strings.TrimSuffix(filepath.Base(scenFile), ".sh")
+		logName = fmt.Sprintf("run-%v-%v.log", run, st.name)
+	} else {
+		log.Printf("  running...")
+	}
 
-		outputFile := filepath.Join(*outputDir, scen, gitTime.UTC().Format("2006-01-02_150405")+"-"+gitRevision+".line")
+	t0 := time.Now()
 
-		log.Printf("Running benchmark:")
-		log.Printf("   scenario %q", scenFile)
-		log.Printf("   executable %q", *kopiaExe)
-		log.Printf("   revision %q (%v) modified:%v", gitRevision, gitTime, gitModified)
-		log.Printf("   output file %q", outputFile)
+	if err := ss.sourceChurner.start(ctx); err != nil {
+		return nil, 0, err
+	}
 
-		if _, err := os.Stat(outputFile); err == nil && !*force && *compareExe == "" {
-			log.Println("output already exists and --force not passed")
-			continue
+	ss.networkShaper.setActive(true)
+	ss.faultInjector.setActive(true)
+	var logFile string
+	if ss.runLogDir != "" {
+		logFile = filepath.Join(ss.runLogDir, logName)
+	}
+
+	rr, err := ss.runKopia(ctx, ss.timeOffset, logFile, st.exe, args...)
+	churn, churnErr := ss.sourceChurner.stop()
+	ss.faultInjector.setActive(false)
+	ss.networkShaper.setActive(false)
+
+	// the measured command and churn are killed on cancellation.
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if churnErr != nil {
+		return nil, 0, churnErr
+	}
+
+	if err != nil {
+		if st.name != "" {
+			return nil, 0, errors.Wrapf(err, "measured command of step %v failed", st.name)
 		}
 
-		exe, args, singlePrepare, err := parseScenario(scenFile)
-		failOnError(err)
+		return nil, 0, errors.Wrap(err, "measured command failed")
+	}
+
+	rr.churn = churn
+
+	rr.faults = ss.faultInjector.injectedFaults()
+	rr.run = run
+	rr.seed = seed
+	rr.repoGrowthBytes = rr.repoSizeBytes - before.totalSize
+	rr.repoGrowthBlobs = rr.numRepoFiles - before.numBlobs
+
+	log.Printf("  completed in %v dir size: %v allocated bytes %v allocated objects: %v", rr.Duration, rr.repoSizeBytes, int64(rr.go_memstats_alloc_bytes_delta), int64(rr.go_memstats_mallocs_delta))
+	log.Printf("  repository grew by %v bytes in %v blobs", rr.repoGrowthBytes, rr.repoGrowthBlobs)
+	log.Printf("  runbench overhead: cpu %.2fs ram %.1f MiB sampling %v (%v errors)", rr.SelfCPUSeconds, rr.SelfRAM, rr.SamplingTime, rr.SamplingErrors)
+
+	return rr, time.Since(t0), nil
+}
+
+// scenarioOutputFile returns the output file of the scenario for the current kopia revision.
+func scenarioOutputFile(scen string) string {
+	return filepath.Join(*outputDir, scen, gitTime.UTC().Format("2006-01-02_150405")+"-"+gitRevision+".line")
+}
+
+func runScenario(ctx context.Context, scenFile string) error {
+	scen := strings.TrimSuffix(filepath.Base(scenFile), ".sh")
+
+	restoreConfig, err := applyScenarioConfig(scen)
+	if err != nil {
+		return err
+	}
+
+	defer restoreConfig()
+
+	setLogLabel("scenario", scen)
+	defer setLogLabel("scenario", "")
+
+	outputFile := scenarioOutputFile(scen)
+
+	log.Printf("Running benchmark:")
+	log.Printf("   scenario %q", scenFile)
+	log.Printf("   executable %q", *kopiaExe)
+	log.Printf("   revision %q (%v) modified:%v", gitRevision, gitTime, gitModified)
+	log.Printf("   output file %q", outputFile)
+
+	if *soakDuration > 0 && (*compareExe != "" || *cacheStates != "" || *memoryLimits != "") {
+		return errors.New("--soak can't be combined with --compare-to-exe, --cache-states or --memory-limits")
+	}
+
+	if _, err := os.Stat(outputFile); err == nil && !*force && *compareExe == "" {
+		log.Println("output already exists and --force not passed")
+		return nil
+	}
+
+	si, err := parseScenario(scenFile)
+	if err != nil {
+		return err
+	}
+
+	defer resetScenarioEnv()
+
+	if si.repoSizeSource != "" {
+		previous := *repoSizeSource
+		*repoSizeSource = si.repoSizeSource
+
+		defer func() { *repoSizeSource = previous }()
+	}
+
+	ss := &scenarioState{
+		name:       scen,
+		file:       scenFile,
+		outputFile: outputFile,
 
 		// compute offset such that now + offset == gitTime
 		// so that runs for a given time are clustered around it.
-		timeOffset := time.Until(gitTime)
+		timeOffset: time.Until(gitTime),
+	}
+
+	defer ss.close()
+
+	if err := ss.start(ctx, si); err != nil {
+		return err
+	}
+
+	steps, err := si.measuredSteps()
+	if err != nil {
+		return err
+	}
+
+	states, err := parseCacheStates()
+	if err != nil {
+		return err
+	}
+
+	if *soakDuration > 0 {
+		if len(steps) > 1 {
+			return errors.New("--soak can't be used with scenarios with multiple steps")
+		}
+
+		return ss.runSoak(ctx, steps[0].exe, steps[0].args)
+	}
+
+	if *compareExe != "" {
+		ss.heapProfiling = *heapProfileTop > 0 && !ss.generic
 
-		runs := runMultiple(ctx, scenFile, timeOffset, exe, args, singlePrepare)
-		if *compareExe != "" {
-			comparedResult := runMultiple(ctx, scenFile, timeOffset, *compareExe, args, singlePrepare)
+		for _, cache := range states {
+			ss.runLogDir = filepath.Join(scenarioLogDir(outputFile), runLogName("current", cache))
+
+			runs, err := ss.runMultiple(ctx, steps, cache)
+			if err != nil {
+				return err
+			}
+
+			ss.runLogDir = filepath.Join(scenarioLogDir(outputFile), runLogName("baseline", cache))
+
+			comparedResult, err := ss.runMultiple(ctx, withExe(steps, *compareExe), cache)
+			if err != nil {
+				return err
+			}
+
+			if cache != "" {
+				fmt.Fprintf(os.Stdout, "CACHE %v\n", cache)
+			}
 
-			compareSamples(os.Stdout, runs, comparedResult)
+			for i, sr := range runs {
+				if sr.step != "" {
+					fmt.Fprintf(os.Stdout, "STEP %v\n", sr.step)
+				}
 
+				compareSamples(os.Stdout, sr.runs, comparedResult[i].runs)
+
+				if err := compareHeapProfiles(ctx, os.Stdout, sr.runs, comparedResult[i].runs); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	limits, err := parseMemoryLimits()
+	if err != nil {
+		return err
+	}
+
+	var results []*limitedRuns
+
+	for _, cache := range states {
+		for _, limit := range limits {
+			ss.memoryLimiter, err = newMemoryLimiter(limit)
+			if err != nil {
+				return err
+			}
+
+			ss.runLogDir = filepath.Join(scenarioLogDir(outputFile), runLogName(cache, limit))
+
+			stepResults, err := ss.runMultiple(ctx, steps, cache)
+			lr := &limitedRuns{cache: cache, limit: limit, steps: stepResults, err: err, oomKills: ss.memoryLimiter.oomKills()}
+
+			ss.memoryLimiter.Close()
+			ss.memoryLimiter = nil
+
+			if err != nil && limit == "" {
+				return err
+			}
+
+			results = append(results, lr)
+
+			if err != nil {
+				log.Printf("failed with memory limit %v: %v", limit, err)
+				break
+			}
+		}
+	}
+
+	var f io.Writer = os.Stdout
+
+	if outputFile != "" {
+		if err := os.MkdirAll(filepath.Dir(outputFile), 0700); err != nil {
+			return errors.Wrap(err, "unable to create output directory")
+		}
+
+		of, err := os.Create(outputFile)
+		if err != nil {
+			return errors.Wrap(err, "unable to create output file")
+		}
+
+		defer of.Close()
+
+		f = of
+	}
+
+	sink := outputSink(f)
+
+	for _, lr := range results {
+		if lr.err != nil {
 			continue
 		}
 
-		if outputFile != "" {
-			failOnError(os.MkdirAll(filepath.Dir(outputFile), 0700))
-			f, err := os.Create(outputFile)
-			failOnError(err)
-			defer f.Close()
+		for _, sr := range lr.steps {
+			if err := ss.logSamples(sink, sr.runs, append(append(append(cacheTags(lr.cache), memoryLimitTags(lr.limit)...), stepTags(sr.step)...), ss.netShapingTags()...)...); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(limits) > 1 {
+		if err := ss.logMemoryLimits(sink, results); err != nil {
+			return err
+		}
+	}
+
+	return writeParquetSamples(scen, results)
+}
+
+// scenarioFailure is a scenario which failed, which doesn't prevent other scenarios from running.
+type scenarioFailure struct {
+	scenFile string
+	err      error
+}
+
+// scenarioFailures records failures of scenarios run by a single invocation or iteration.
+type scenarioFailures []scenarioFailure
+
+// run runs the scenario and records its failure.
+func (sf *scenarioFailures) run(ctx context.Context, scenFile string) {
+	if err := runScenario(ctx, scenFile); err != nil {
+		log.Printf("scenario %v failed: %v", scenFile, err)
+		*sf = append(*sf, scenarioFailure{scenFile, err})
+	}
+}
+
+// logSummary logs all failures and returns an error if any scenario failed.
+func (sf scenarioFailures) logSummary(total int) error {
+	if len(sf) == 0 {
+		return nil
+	}
+
+	for _, f := range sf {
+		log.Printf("FAILED %v: %v", f.scenFile, f.err)
+	}
+
+	return errors.Errorf("%v of %v scenarios failed", len(sf), total)
+}
+
+func main() {
+	flag.Parse()
+	failOnError(loadConfig())
+
+	if flag.NArg() == 1 && flag.Arg(0) == doctorCommand {
+		failOnError(runDoctor(context.Background(), os.Stdout))
+
+		return
+	}
+
+	if *listScenarios {
+		scenFiles, err := listedScenarioFiles(flag.Args())
+		failOnError(err)
+		failOnError(listScenarioFiles(os.Stdout, scenFiles))
+
+		return
+	}
+
+	failOnError(validateStartup(flag.Args()))
+	failOnError(openSinks())
+
+	defer extraSinks.Close()
+
+	setupJournalLogging()
+
+	// SIGINT and SIGTERM cancel all phases of the running scenario, the service and suite modes
+	// also stop afterwards.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	closeLogging, err := setupCloudLogging(ctx)
+	failOnError(err)
+
+	defer closeLogging()
+
+	if *suiteFile != "" {
+		runSuite(ctx)
+		return
+	}
+
+	if *serviceMode {
+		runService(ctx, flag.Args())
+		return
+	}
+
+	failOnError(parseBuildInfo())
+	setLogLabel("revision", gitRevision)
+
+	if *deadline > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, *deadline)
+		defer cancel()
+	}
+
+	var failures scenarioFailures
 
-			logSamples(f, scen, runs)
-		} else {
-			logSamples(os.Stdout, scen, runs)
+	for _, scenFile := range flag.Args() {
+		if ctx.Err() != nil {
+			log.Printf("skipping remaining scenarios: %v", ctx.Err())
+			break
 		}
+
+		failures.run(ctx, scenFile)
 	}
+
+	failOnError(failures.logSummary(flag.NArg()))
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"runbench/pkg/bench"
+)
+
+// Output of each measured run is captured in <outputDir>/<scenario>/<gitTime>-<gitHash>.logs/run-N.log
+// (run-N-<step>.log for named steps, in subdirectories named after the cache state and memory
+// limit, or current and baseline with --compare-to-exe) up to --kopia-log-max-size and its path is
+// emitted as run_log.
+//
+// Only numbers needed for the results are kept in memory for each sample, scraped Prometheus
+// metrics are parsed immediately and only the last scrape of each run is kept. With --raw-samples
+// all samples including full scrapes are written next to the captured output.
+var (
+	kopiaLogMaxSize = flag.Int64("kopia-log-max-size", 16<<20, "Maximum size of the captured output of each measured run, further output is discarded")
+	echoKopiaOutput = flag.Bool("echo-kopia-output", false, "Also write output of measured commands to the console")
+	rawSamples      = flag.Bool("raw-samples", false, "Write all samples of each measured run including full Prometheus scrapes as JSON lines next to its captured output (run-N.samples.jsonl)")
+)
+
+// scenarioLogDir returns the directory of logs of the scenario for the current kopia revision,
+// next to its output file.
+func scenarioLogDir(outputFile string) string {
+	return strings.TrimSuffix(outputFile, ".line") + ".logs"
+}
+
+// runLogName returns the name of the subdirectory of logs of runs in the given states, which
+// are empty when not measured.
+func runLogName(states ...string) string {
+	var parts []string
+
+	for _, s := range states {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+
+	return strings.Join(parts, "-")
+}
+
+// rawSamplesFile returns the file raw samples of the run captured in logFile are written to
+// with --raw-samples.
+func rawSamplesFile(logFile string) string {
+	return strings.TrimSuffix(logFile, ".log") + ".samples.jsonl"
+}
+
+// runLog is a file capturing standard output and error of a measured run, up to --kopia-log-max-size.
+type runLog struct {
+	mu        sync.Mutex
+	f         *os.File
+	written   int64
+	truncated bool
+}
+
+func openRunLog(fname string) (*runLog, error) {
+	if err := os.MkdirAll(filepath.Dir(fname), 0o700); err != nil {
+		return nil, errors.Wrap(err, "unable to create log directory")
+	}
+
+	f, err := os.Create(fname)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create run log")
+	}
+
+	return &runLog{f: f}, nil
+}
+
+// Write implements io.Writer, output over the size cap is discarded without error so that
+// the measured command isn't affected.
+func (l *runLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := int64(len(p))
+	if remaining := *kopiaLogMaxSize - l.written; n > remaining {
+		n = remaining
+		l.truncated = true
+	}
+
+	if n > 0 {
+		if _, err := l.f.Write(p[0:n]); err != nil {
+			return 0, err
+		}
+
+		l.written += n
+	}
+
+	return len(p), nil
+}
+
+// writer returns the writer of one of the output streams of the command.
+func (l *runLog) writer(console io.Writer) io.Writer {
+	if *echoKopiaOutput {
+		return io.MultiWriter(l, console)
+	}
+
+	return l
+}
+
+func (l *runLog) Close() error {
+	if l.truncated {
+		fmt.Fprintf(l.f, "\n[output truncated at %v bytes]\n", l.written)
+	}
+
+	return l.f.Close()
+}
+
+// runLogPoint describes the captured output of a run.
+func runLogPoint(tags []bench.Tag, rr *runResult) bench.Point {
+	return bench.Point{
+		Measurement: "run_log",
+		Tags:        tags,
+		Fields: []bench.Field{
+			{Key: "path", Value: rr.logFile},
+			{Key: "size", Value: rr.logSize},
+			{Key: "truncated", Value: boolToInt(rr.logTruncated)},
+		},
+		Time: gitTime,
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"runbench/pkg/bench"
+)
+
+// The measured command is sampled by --samplers, by default "process" (CPU and RSS) and "prometheus"
+// (metrics scraped from kopia). Values of other samplers, "disk-io" (read and write rate of the
+// command) and "system-load" (load average and memory usage of the host), are emitted as
+// sampler_summary with avg_<value> and max_<value> fields.
+var samplerNames = flag.String("samplers", "process,prometheus", "Comma-separated list of samplers of the measured command, available: "+strings.Join(bench.SamplerNames(), ","))
+
+// parseSamplers returns names of samplers passed via --samplers, which must be registered.
+func parseSamplers() ([]string, error) {
+	known := map[string]bool{}
+
+	for _, n := range bench.SamplerNames() {
+		known[n] = true
+	}
+
+	var names []string
+
+	for _, n := range strings.Split(*samplerNames, ",") {
+		n = strings.TrimSpace(n)
+		if !known[n] {
+			return nil, errors.Errorf("unknown sampler %q in --samplers, available: %v", n, bench.SamplerNames())
+		}
+
+		names = append(names, n)
+	}
+
+	return names, nil
+}
+
+// withoutSampler returns the names of samplers without the given one.
+func withoutSampler(names []string, name string) []string {
+	var res []string
+
+	for _, n := range names {
+		if n != name {
+			res = append(res, n)
+		}
+	}
+
+	return res
+}
+
+// samplerValueFields returns the average and maximum of each value of additional samplers
+// (e.g. avg_load1 and max_load1) in the order of their names.
+func samplerValueFields(summ bench.Summary) []bench.Field {
+	var keys []string
+
+	for k := range summ.Values {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	var fields []bench.Field
+
+	for _, k := range keys {
+		fields = append(fields,
+			bench.Field{Key: "avg_" + k, Value: summ.Values[k].Avg},
+			bench.Field{Key: "max_" + k, Value: summ.Values[k].Max})
+	}
+
+	return fields
+}
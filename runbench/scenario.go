@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// scenarioState is the state of the running scenario shared by all of its runs: how its measured
+// command is run and the servers, proxies and helpers started for it, which are nil when the
+// scenario doesn't use them.
+type scenarioState struct {
+	name       string // file name of the script without extension
+	file       string
+	outputFile string
+
+	singlePrepare bool
+
+	// offset of sample times such that now + offset == gitTime, so that runs for a given kopia
+	// revision are clustered around its time.
+	timeOffset time.Duration
+
+	// measured command isn't kopia, see genericCommandMarker.
+	generic bool
+
+	// steps of each run are also measured as a single logical run, see cumulativeMarker.
+	cumulative bool
+
+	// measured commands write heap profiles, when comparing executables with --heap-profile-top.
+	heapProfiling bool
+
+	// URLs of metrics endpoints declared by the scenario by name, which is the 'endpoint' tag of
+	// their captured metrics.
+	endpoints map[string]string
+
+	// directory capturing output of measured runs of the current runMultiple, one file per run.
+	// Output goes to the console when empty.
+	runLogDir string
+
+	faultInjector *faultInjectionProxy
+	networkShaper *shapingProxy
+	netSandbox    *netnsSandbox
+	sourceChurner *churner
+
+	// memory limit of the measured command during the current runMultiple.
+	memoryLimiter *cgroupLimit
+
+	// servers emptied before each preparation so that repositories of previous runs don't pile up.
+	storageServers []storageServer
+
+	// closers stop what was started for the scenario, in reverse order.
+	closers []func()
+}
+
+// start starts servers, proxies and helpers declared by the scenario or enabled by flags and
+// exports their addresses to the scenario. Whatever was started is stopped by close, also when
+// start fails.
+func (ss *scenarioState) start(ctx context.Context, si *scenarioInfo) error {
+	ss.singlePrepare = si.singlePrepare
+	ss.cumulative = si.cumulative
+
+	if si.minio {
+		m, err := startMinio(ctx)
+		if err != nil {
+			return err
+		}
+
+		ss.closers = append(ss.closers, m.Close)
+		ss.storageServers = append(ss.storageServers, m)
+
+		log.Printf("   MinIO server at %v", m.addr)
+	}
+
+	if si.sftp {
+		s, err := startSFTPServer()
+		if err != nil {
+			return err
+		}
+
+		ss.closers = append(ss.closers, s.Close)
+		ss.storageServers = append(ss.storageServers, s)
+
+		log.Printf("   SFTP server at %v", s.listener.Addr())
+	}
+
+	if si.webDAV {
+		s, err := startWebDAVServer()
+		if err != nil {
+			return err
+		}
+
+		ss.closers = append(ss.closers, s.Close)
+		ss.storageServers = append(ss.storageServers, s)
+
+		log.Printf("   WebDAV server at %v", s.server.URL)
+	}
+
+	if spec := scenarioSpec(si.faultInjection, *faultInjection); spec != "" {
+		spec = os.Expand(spec, lookupScenarioEnv)
+
+		p, err := startFaultInjectionProxy(spec)
+		if err != nil {
+			return err
+		}
+
+		ss.faultInjector = p
+		ss.closers = append(ss.closers, p.Close)
+
+		setScenarioEnv("FAULTY_ADDR", p.Addr())
+		log.Printf("   fault injection %q via %v", spec, p.Addr())
+	}
+
+	if spec := scenarioSpec(si.networkShaping, *networkShaping); spec != "" {
+		spec = os.Expand(spec, lookupScenarioEnv)
+
+		p, err := startShapingProxy(spec)
+		if err != nil {
+			return err
+		}
+
+		ss.networkShaper = p
+		ss.closers = append(ss.closers, p.Close)
+
+		setScenarioEnv("SHAPED_ADDR", p.Addr())
+		log.Printf("   network shaping %q via %v", spec, p.Addr())
+	}
+
+	if spec := scenarioSpec(si.netns, *netnsShaping); spec != "" {
+		s, err := startNetnsSandbox(spec)
+		if err != nil {
+			return err
+		}
+
+		ss.netSandbox = s
+		ss.closers = append(ss.closers, s.Close)
+
+		setScenarioEnv("NETNS_HOST_ADDR", netnsHostAddr)
+		log.Printf("   network namespace %v shaped with %q", s.name, spec)
+	}
+
+	if spec := scenarioSpec(si.networkSource, *networkSource); spec != "" {
+		spec = os.Expand(spec, lookupScenarioEnv)
+
+		share, err := startNetworkShare(spec)
+		if err != nil {
+			return err
+		}
+
+		ss.closers = append(ss.closers, share.Close)
+
+		setScenarioEnv("NETWORK_SOURCE_DIR", share.mountPoint)
+		log.Printf("   %v source %v mounted at %v", share.protocol, share.dir, share.mountPoint)
+	}
+
+	if spec := scenarioSpec(si.churn, *sourceChurn); spec != "" {
+		c, err := newChurner(spec)
+		if err != nil {
+			return err
+		}
+
+		ss.sourceChurner = c
+
+		log.Printf("   churning source with %q", spec)
+	}
+
+	// declared values may refer to addresses of servers started above.
+	for _, spec := range si.env {
+		if err := declareScenarioEnv(spec); err != nil {
+			return err
+		}
+	}
+
+	if len(si.endpoints) > 0 {
+		endpoints, err := parseMetricsEndpoints(si.endpoints)
+		if err != nil {
+			return err
+		}
+
+		ss.endpoints = endpoints
+
+		for _, name := range ss.sortedEndpointNames() {
+			log.Printf("   metrics endpoint %v at %v", name, endpoints[name])
+		}
+	}
+
+	if !*inheritEnv {
+		log.Printf("   kopia environment: %v", strings.Join(envNames(kopiaEnv(*kopiaExe)), ","))
+	}
+
+	if si.generic {
+		if *repoSizeSource == repoSizeFromBlobStats {
+			return errors.Errorf("--repo-size-source=%v can't be used with '%v'", repoSizeFromBlobStats, genericCommandMarker)
+		}
+
+		ss.generic = true
+
+		log.Printf("   generic measured command")
+	}
+
+	return nil
+}
+
+// close stops servers, proxies and helpers started for the scenario.
+func (ss *scenarioState) close() {
+	for i := len(ss.closers) - 1; i >= 0; i-- {
+		ss.closers[i]()
+	}
+
+	ss.closers = nil
+}
+
+// scenarioSpec returns the value declared by a scenario marker, or the value of the flag
+// if the scenario doesn't declare it.
+func scenarioSpec(declared, flagValue string) string {
+	if declared != "" {
+		return declared
+	}
+
+	return flagValue
+}
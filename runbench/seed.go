@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+// Each run gets a seed exported as $RUN_SEED to the scenario script preparing it and its measured
+// command (where it's also expanded in the command line), and recorded as the 'seed' tag of
+// measurements of the run. Seeds of runs derived from --run-seed make scenarios deriving their
+// inputs from it reproducible.
+var runSeedBase = flag.Int64("run-seed", 0, "Export $RUN_SEED of each run as this value plus the number of the run, so that runs can be reproduced, random when 0")
+
+// runSeedVar is the variable exported to the scenario script and measured command of each run.
+const runSeedVar = "RUN_SEED"
+
+// randomSeedBase replaces --run-seed when not passed. It's drawn once, so that runs with the same
+// number get the same seed also without --run-seed, such as runs of the current and baseline
+// executables of --compare-to-exe.
+var randomSeedBase = rand.New(rand.NewSource(time.Now().UnixNano())).Int63n(math.MaxInt32) + 1
+
+// newRunSeed returns the seed of the given run, derived from --run-seed when passed.
+func newRunSeed(run int) int64 {
+	if *runSeedBase != 0 {
+		return *runSeedBase + int64(run)
+	}
+
+	return randomSeedBase + int64(run)
+}
+
+// setRunSeed exports the seed to the scenario script and measured command of the current run.
+func setRunSeed(seed int64) {
+	setScenarioEnv(runSeedVar, strconv.FormatInt(seed, 10))
+}
+
+// lookupCommandEnv is like lookupScenarioEnv, but keeps $RUN_SEED, which is only known
+// when the run starts and is expanded by withRunSeed.
+func lookupCommandEnv(key string) string {
+	if key == runSeedVar {
+		return "$" + runSeedVar
+	}
+
+	return lookupScenarioEnv(key)
+}
+
+// withRunSeed returns the arguments of the measured command with $RUN_SEED expanded.
+func withRunSeed(args []string, seed int64) []string {
+	res := make([]string, len(args))
+
+	for i, a := range args {
+		res[i] = strings.ReplaceAll(a, "$"+runSeedVar, strconv.FormatInt(seed, 10))
+	}
+
+	return res
+}
+
+// seedTags returns the tag recording the seed of a run.
+func seedTags(seed int64) []bench.Tag {
+	return []bench.Tag{{Key: "seed", Value: strconv.FormatInt(seed, 10)}}
+}
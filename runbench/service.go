@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// With --service the tool runs as a long-lived systemd service (Type=notify, see runbench.service),
+// repeating all scenarios every --service-interval. Scenarios whose output already exists for
+// the current kopia revision are skipped.
+var (
+	serviceMode     = flag.Bool("service", false, "Run as a long-lived (systemd) service, repeating all scenarios every --service-interval")
+	serviceInterval = flag.Duration("service-interval", time.Hour, "Interval between iterations in service mode")
+)
+
+// sdNotify sends a state notification to systemd, it's a no-op when not running under systemd
+// with Type=notify. See sd_notify(3).
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return errors.Wrap(err, "unable to connect to notify socket")
+	}
+
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+
+	return errors.Wrap(err, "unable to notify systemd")
+}
+
+// watchdogInterval returns half of the configured WatchdogSec, zero when systemd doesn't expect pings.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// pingWatchdog tells systemd watchdog that runbench is making progress. It's called from the main
+// loops after each run, scenario and build and while idle rather than by a timer, so that a hung
+// scenario trips the watchdog, which must therefore be longer than the longest run.
+func pingWatchdog() {
+	if watchdogInterval() == 0 {
+		return
+	}
+
+	if err := sdNotify("WATCHDOG=1"); err != nil {
+		log.Printf("unable to notify watchdog: %v", err)
+	}
+}
+
+// waitUntil waits until t or a signal received on hup or term, pinging the watchdog meanwhile.
+// It returns the received signal or nil at t.
+func waitUntil(t time.Time, hup, term chan os.Signal) os.Signal {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+
+	var tick <-chan time.Time
+
+	if iv := watchdogInterval(); iv > 0 {
+		ticker := time.NewTicker(iv)
+		defer ticker.Stop()
+
+		tick = ticker.C
+	}
+
+	for {
+		pingWatchdog()
+
+		select {
+		case <-timer.C:
+			return nil
+
+		case sig := <-hup:
+			return sig
+
+		case sig := <-term:
+			return sig
+
+		case <-tick:
+		}
+	}
+}
+
+// setupJournalLogging drops timestamps from log lines when stderr is connected to systemd journal,
+// which records its own.
+func setupJournalLogging() {
+	if os.Getenv("JOURNAL_STREAM") != "" {
+		log.SetFlags(0)
+	}
+}
+
+// runService runs all scenarios every --service-interval until SIGTERM or SIGINT is received.
+// SIGHUP reloads the config file before the next iteration.
+func runService(ctx context.Context, scenarios []string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+
+	defer signal.Stop(hup)
+	defer signal.Stop(term)
+
+	failOnError(sdNotify("READY=1"))
+
+	for {
+		var failures scenarioFailures
+
+		iteration := scenarios
+
+		if err := parseBuildInfo(); err != nil {
+			log.Printf("unable to determine kopia revision, skipping iteration: %v", err)
+			iteration = nil
+		}
+
+		setLogLabel("revision", gitRevision)
+
+		for _, scenFile := range iteration {
+			select {
+			case <-term:
+				stopService()
+				return
+			default:
+			}
+
+			_ = sdNotify("STATUS=running " + scenFile)
+			failures.run(ctx, scenFile)
+			pingWatchdog()
+		}
+
+		if err := failures.logSummary(len(iteration)); err != nil {
+			log.Print(err)
+		}
+
+		next := time.Now().Add(*serviceInterval)
+		_ = sdNotify("STATUS=idle, next iteration at " + next.Format(time.RFC3339))
+
+		switch waitUntil(next, hup, term) {
+		case nil:
+
+		case syscall.SIGHUP:
+			log.Printf("reloading config")
+
+			_ = sdNotify("RELOADING=1")
+			failOnError(reloadConfig())
+			_ = sdNotify("READY=1")
+
+		default:
+			stopService()
+			return
+		}
+	}
+}
+
+func stopService() {
+	log.Printf("stopping")
+
+	_ = sdNotify("STOPPING=1")
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"runbench/pkg/bench"
+)
+
+// --sinks=stdout,json:/tmp/results.json,influx:http://influx:8086?org=kopia&bucket=bench writes
+// measurements to the console, appends them to a JSON file and sends them directly to InfluxDB.
+var sinkSpecs = flag.String("sinks", "", "Comma-separated list of additional destinations of measurements besides the output file: stdout, line:<file>, json:<file> or influx:<url> (with $INFLUX_TOKEN)")
+
+// extraSinks receive all measurements written to output files of scenarios.
+var extraSinks bench.MultiSink
+
+func openSinks() error {
+	if *sinkSpecs == "" {
+		return nil
+	}
+
+	for _, spec := range strings.Split(*sinkSpecs, ",") {
+		s, err := bench.OpenSink(strings.TrimSpace(spec))
+		if err != nil {
+			_ = extraSinks.Close()
+
+			return errors.Wrap(err, "invalid --sinks")
+		}
+
+		extraSinks = append(extraSinks, s)
+	}
+
+	return nil
+}
+
+// outputSink returns a sink writing line protocol to the output file of a scenario and
+// to --sinks.
+func outputSink(f io.Writer) bench.Sink {
+	return append(bench.MultiSink{bench.NewLineProtocolSink(f)}, extraSinks...)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"runbench/pkg/bench"
+)
+
+// With --soak=24h each scenario is instead prepared once and its measured command repeated for the
+// given time to catch slow memory leaks (see runSoak). Each soak_run is timestamped with the time
+// the run completed and carries the trend of peak RSS over time in MiB per hour.
+var soakDuration = flag.Duration("soak", 0, "Instead of measuring repeated runs, keep snapshotting the (churning) source for this long and write measurements of each run as it completes")
+
+// soakTrend tracks memory usage of the measured command across runs of a soak test.
+type soakTrend struct {
+	elapsedHours []float64
+	maxRAM       []float64
+}
+
+func (t *soakTrend) add(elapsed time.Duration, maxRAM float64) {
+	t.elapsedHours = append(t.elapsedHours, elapsed.Hours())
+	t.maxRAM = append(t.maxRAM, maxRAM)
+}
+
+// growthPerHour returns the slope of the least-squares fit of peak RSS over time in MiB per hour,
+// which stays around zero unless the measured command leaks memory across runs.
+func (t *soakTrend) growthPerHour() float64 {
+	mx, my := bench.Mean(t.elapsedHours), bench.Mean(t.maxRAM)
+
+	var num, den float64
+
+	for i, x := range t.elapsedHours {
+		num += (x - mx) * (t.maxRAM[i] - my)
+		den += (x - mx) * (x - mx)
+	}
+
+	if den == 0 {
+		return 0
+	}
+
+	return num / den
+}
+
+// runSoak snapshots the source repeatedly for --soak, preparing the scenario only once so that
+// the repository keeps growing. When the scenario churns the source, the churn runs for the entire
+// soak test. Each run is emitted as soak_run and synced to the output file immediately, so that
+// partial results survive crashes of the host, and soak_summary is emitted at the end.
+// Interruption kills the current run, soak_summary still describes the completed ones.
+func (ss *scenarioState) runSoak(ctx context.Context, exe string, args []string) error {
+	if err := os.MkdirAll(filepath.Dir(ss.outputFile), 0o700); err != nil {
+		return errors.Wrap(err, "unable to create output directory")
+	}
+
+	f, err := os.Create(ss.outputFile)
+	if err != nil {
+		return errors.Wrap(err, "unable to create output file")
+	}
+
+	defer f.Close()
+
+	sink := outputSink(f)
+
+	tags, err := scenarioTags(ss.name)
+	if err != nil {
+		return err
+	}
+
+	tags = append(tags, ss.netShapingTags()...)
+
+	samples, err := openParquetSamples(ss.name)
+	if err != nil {
+		return err
+	}
+
+	// the Parquet file also describes the completed runs when the soak test fails.
+	defer func() {
+		if err := samples.Close(); err != nil {
+			log.Printf("unable to write samples: %v", err)
+		}
+	}()
+
+	log.Printf("soak test for %v, preparing...", *soakDuration)
+
+	// the preparation shares the seed of the first run.
+	seed := newRunSeed(1)
+	setRunSeed(seed)
+
+	if err := ss.resetStorageServers(ctx); err != nil {
+		return err
+	}
+
+	pr, err := runPrepare(ctx, ss.file, filepath.Join(scenarioLogDir(ss.outputFile), "prepare.log"), 1, seed)
+	if err != nil {
+		return errors.Wrap(err, "prepare failed")
+	}
+
+	if err := sink.Write(preparePoint(append(append([]bench.Tag(nil), tags...), seedTags(seed)...), pr)); err != nil {
+		return err
+	}
+
+	before, err := summarizeRepository(ctx, exe, withRunSeed(args, seed))
+	if err != nil {
+		return errors.Wrap(err, "error summarizing prepared repository")
+	}
+
+	if err := ss.sourceChurner.start(ctx); err != nil {
+		return err
+	}
+
+	var (
+		trend     soakTrend
+		results   []*bench.Result
+		t0        = time.Now()
+		runErr    error
+		completed int
+	)
+
+	defer setLogLabel("run", "")
+
+	for time.Since(t0) < *soakDuration && ctx.Err() == nil {
+		run := completed + 1
+
+		if run > 1 {
+			seed = newRunSeed(run)
+			setRunSeed(seed)
+		}
+
+		setLogLabel("run", strconv.Itoa(run))
+		log.Printf("Soak run #%v, elapsed %v, seed %v", run, time.Since(t0).Round(time.Second), seed)
+
+		ss.networkShaper.setActive(true)
+		ss.faultInjector.setActive(true)
+		rr, err := ss.runKopia(ctx, 0, filepath.Join(scenarioLogDir(ss.outputFile), fmt.Sprintf("soak-%v.log", run)), exe, withRunSeed(args, seed)...)
+		ss.faultInjector.setActive(false)
+		ss.networkShaper.setActive(false)
+
+		if ctx.Err() != nil {
+			log.Printf("soak test interrupted")
+			break
+		}
+
+		if err != nil {
+			runErr = errors.Wrapf(err, "soak run #%v failed", run)
+			break
+		}
+
+		completed++
+		elapsed := time.Since(t0)
+		summ := bench.Summarize([]*bench.Result{rr.Result})
+
+		trend.add(elapsed, summ.MaxRAM)
+		results = append(results, rr.Result)
+
+		if err := sink.Write(bench.Point{
+			Measurement: "soak_run",
+			Tags:        tags,
+			Fields: []bench.Field{
+				{Key: "run", Value: run},
+				{Key: "seed", Value: seed},
+				{Key: "elapsed", Value: bench.Fixed{Value: elapsed.Seconds(), Digits: 0}},
+				{Key: "duration", Value: bench.Fixed{Value: rr.Duration.Seconds(), Digits: 1}},
+				{Key: "avg_ram_rss", Value: summ.AvgRAM},
+				{Key: "max_ram_rss", Value: summ.MaxRAM},
+				{Key: "avg_cpu_percent", Value: summ.AvgCPU},
+				{Key: "heap_bytes_delta", Value: rr.go_memstats_alloc_bytes_delta},
+				{Key: "heap_bytes", Value: rr.go_memstats_alloc_bytes_total}, // deprecated
+				{Key: "repo_size", Value: rr.repoSizeBytes},
+				{Key: "size_delta", Value: rr.repoSizeBytes - before.totalSize},
+				{Key: "max_ram_growth_per_hour", Value: trend.growthPerHour()},
+			},
+			Time: time.Now(),
+		}); err != nil {
+			return err
+		}
+
+		if err := samples.write(rr.Result, run, "", "", ""); err != nil {
+			return err
+		}
+
+		withRun := append(append(append([]bench.Tag(nil), tags...), bench.Tag{Key: "run", Value: strconv.Itoa(run)}), seedTags(seed)...)
+
+		if err := sink.Write(append([]bench.Point{runLogPoint(withRun, rr)}, progressPoints(withRun, rr)...)...); err != nil {
+			return err
+		}
+
+		if err := f.Sync(); err != nil {
+			return errors.Wrap(err, "unable to flush output")
+		}
+
+		before = &repoSummary{numBlobs: rr.numRepoFiles, totalSize: rr.repoSizeBytes}
+
+		pingWatchdog()
+
+		log.Printf("  completed in %v max ram %.1f MiB, growth %.2f MiB/h", rr.Duration, summ.MaxRAM, trend.growthPerHour())
+	}
+
+	// the churn is killed along with the measured command on interruption.
+	churn, err := ss.sourceChurner.stop()
+	if err != nil && ctx.Err() == nil {
+		return err
+	}
+
+	if completed == 0 {
+		return runErr
+	}
+
+	summ := bench.Summarize(results)
+
+	if err := sink.Write(bench.Point{
+		Measurement: "soak_summary",
+		Tags:        tags,
+		Fields: []bench.Field{
+			{Key: "runs", Value: completed},
+			{Key: "failed", Value: boolToInt(runErr != nil)},
+			{Key: "elapsed", Value: bench.Fixed{Value: time.Since(t0).Seconds(), Digits: 0}},
+			{Key: "avg_duration", Value: bench.Fixed{Value: summ.AvgDuration, Digits: 1}},
+			{Key: "first_max_ram_rss", Value: trend.maxRAM[0]},
+			{Key: "last_max_ram_rss", Value: trend.maxRAM[len(trend.maxRAM)-1]},
+			{Key: "max_ram_growth_per_hour", Value: trend.growthPerHour()},
+			{Key: "churn_ops", Value: churn.ops},
+		},
+		Time: time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	return runErr
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/shlex"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// With --suite the tool runs as an unattended benchmark farm node running jobs of the suite file
+// (see suiteConfig) on their schedule. Builds are cached by commit and results are published to
+// the sinks of the suite.
+var suiteFile = flag.String("suite", "", "Path to YAML suite file, runs scheduled jobs of the suite until SIGTERM or SIGINT")
+
+// suiteConfig is the contents of the file passed via --suite, for example:
+//
+//	kopia-repo: https://github.com/kopia/kopia.git
+//	build-dir: /var/cache/runbench
+//	keep-builds: 10
+//	jobs:
+//	  - name: nightly
+//	    schedule: "0 2 * * *"
+//	    refs: [master]
+//	    scenarios: [scenarios/snapshot-linux-*.sh]
+//	  - name: releases
+//	    schedule: "@weekly"
+//	    refs: [v0.11.3, v0.12.0]
+//	    scenarios: [scenarios/*.sh]
+//	sinks:
+//	  - directory: /mnt/results
+//	  - command: influximport --output-dir=/var/lib/runbench/outputs
+//
+// Refs are branches, tags or commits of kopia-repo, each is built once per commit and the binary
+// is reused by later runs. Scenarios are glob patterns relative to the working directory.
+//
+// Output files written by a job are copied to each 'directory' sink (preserving the
+// <scenario>/<file> layout) and passed as additional arguments to each 'command' sink.
+type suiteConfig struct {
+	KopiaRepo  string      `yaml:"kopia-repo"`
+	BuildDir   string      `yaml:"build-dir"`
+	KeepBuilds int         `yaml:"keep-builds"`
+	Jobs       []suiteJob  `yaml:"jobs"`
+	Sinks      []suiteSink `yaml:"sinks"`
+}
+
+type suiteJob struct {
+	Name      string   `yaml:"name"`
+	Schedule  string   `yaml:"schedule"`
+	Refs      []string `yaml:"refs"`
+	Scenarios []string `yaml:"scenarios"`
+
+	schedule *cronSchedule
+	nextRun  time.Time
+}
+
+type suiteSink struct {
+	Directory string `yaml:"directory"`
+	Command   string `yaml:"command"`
+}
+
+func loadSuite() (*suiteConfig, error) {
+	b, err := os.ReadFile(*suiteFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read suite file")
+	}
+
+	sc := &suiteConfig{}
+
+	if err := yaml.Unmarshal(b, sc); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse suite file %q", *suiteFile)
+	}
+
+	if sc.KopiaRepo == "" || sc.BuildDir == "" {
+		return nil, errors.New("suite file must specify kopia-repo and build-dir")
+	}
+
+	if len(sc.Jobs) == 0 {
+		return nil, errors.New("suite file has no jobs")
+	}
+
+	for i := range sc.Jobs {
+		j := &sc.Jobs[i]
+
+		if len(j.Refs) == 0 || len(j.Scenarios) == 0 {
+			return nil, errors.Errorf("job %q must have refs and scenarios", j.Name)
+		}
+
+		if j.schedule, err = parseCron(j.Schedule); err != nil {
+			return nil, errors.Wrapf(err, "job %q", j.Name)
+		}
+	}
+
+	for _, s := range sc.Sinks {
+		if (s.Directory == "") == (s.Command == "") {
+			return nil, errors.New("each sink must have exactly one of directory or command")
+		}
+	}
+
+	return sc, nil
+}
+
+// runSuite runs jobs of the suite as they become due until SIGTERM or SIGINT is received.
+// SIGHUP reloads the suite file, jobs are rescheduled from the time of reload.
+func runSuite(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+
+	defer signal.Stop(hup)
+	defer signal.Stop(term)
+
+	sc, err := loadSuite()
+	failOnError(err)
+
+	failOnError(sc.schedule(time.Now()))
+	failOnError(sdNotify("READY=1"))
+
+	for {
+		job := sc.nextJob()
+		_ = sdNotify("STATUS=idle, next job " + job.Name + " at " + job.nextRun.Format(time.RFC3339))
+
+		log.Printf("next job %q at %v", job.Name, job.nextRun)
+
+		switch waitUntil(job.nextRun, hup, term) {
+		case nil:
+			if !sc.runJob(ctx, job, term) {
+				stopService()
+				return
+			}
+
+			failOnError(job.scheduleAfter(time.Now()))
+
+		case syscall.SIGHUP:
+			log.Printf("reloading suite")
+
+			_ = sdNotify("RELOADING=1")
+			sc, err = loadSuite()
+			failOnError(err)
+			failOnError(sc.schedule(time.Now()))
+			_ = sdNotify("READY=1")
+
+		default:
+			stopService()
+			return
+		}
+	}
+}
+
+// schedule computes the next run of each job after t.
+func (sc *suiteConfig) schedule(t time.Time) error {
+	for i := range sc.Jobs {
+		if err := sc.Jobs[i].scheduleAfter(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scheduleAfter computes the next run of the job after t, failing if its schedule doesn't fire
+// anymore instead of running it immediately.
+func (j *suiteJob) scheduleAfter(t time.Time) error {
+	j.nextRun = j.schedule.next(t)
+	if j.nextRun.IsZero() {
+		return errors.Errorf("job %q has no run after %v", j.Name, t)
+	}
+
+	return nil
+}
+
+// nextJob returns the job which is due first.
+func (sc *suiteConfig) nextJob() *suiteJob {
+	next := &sc.Jobs[0]
+
+	for i := range sc.Jobs {
+		if sc.Jobs[i].nextRun.Before(next.nextRun) {
+			next = &sc.Jobs[i]
+		}
+	}
+
+	return next
+}
+
+// runJob builds each ref of the job, runs its scenarios and publishes new output files.
+// It returns false if interrupted by a signal received on term.
+func (sc *suiteConfig) runJob(ctx context.Context, job *suiteJob, term chan os.Signal) bool {
+	setLogLabel("job", job.Name)
+	defer setLogLabel("job", "")
+
+	var scenarios []string
+
+	for _, pattern := range job.Scenarios {
+		matches, err := filepath.Glob(pattern)
+		failOnError(errors.Wrapf(err, "invalid scenario pattern %q", pattern))
+
+		scenarios = append(scenarios, matches...)
+	}
+
+	b := &builder{repo: sc.KopiaRepo, dir: sc.BuildDir, keep: sc.KeepBuilds}
+
+	for _, ref := range job.Refs {
+		_ = sdNotify("STATUS=building " + ref)
+
+		exe, err := b.build(ctx, ref)
+		pingWatchdog()
+
+		if err != nil {
+			log.Printf("unable to build %v: %v", ref, err)
+			continue
+		}
+
+		*kopiaExe = exe
+
+		if err := parseBuildInfo(); err != nil {
+			log.Printf("unable to determine revision of %v: %v", ref, err)
+			continue
+		}
+
+		setLogLabel("revision", gitRevision)
+
+		t0 := time.Now()
+
+		var (
+			outputs  []string
+			failures scenarioFailures
+		)
+
+		for _, scenFile := range scenarios {
+			select {
+			case <-term:
+				return false
+			default:
+			}
+
+			_ = sdNotify("STATUS=running " + scenFile + " at " + ref)
+			failures.run(ctx, scenFile)
+			pingWatchdog()
+
+			out := scenarioOutputFile(strings.TrimSuffix(filepath.Base(scenFile), ".sh"))
+			if st, err := os.Stat(out); err == nil && !st.ModTime().Before(t0) {
+				outputs = append(outputs, out)
+			}
+		}
+
+		if err := failures.logSummary(len(scenarios)); err != nil {
+			log.Printf("%v at %v", err, ref)
+		}
+
+		if err := sc.publish(ctx, outputs); err != nil {
+			log.Printf("unable to publish results of %v: %v", ref, err)
+		}
+	}
+
+	return true
+}
+
+// publish copies or passes output files to all sinks.
+func (sc *suiteConfig) publish(ctx context.Context, outputs []string) error {
+	if len(outputs) == 0 {
+		return nil
+	}
+
+	for _, s := range sc.Sinks {
+		if s.Directory != "" {
+			for _, out := range outputs {
+				rel, err := filepath.Rel(*outputDir, out)
+				if err != nil {
+					return err
+				}
+
+				if err := copyFile(out, filepath.Join(s.Directory, rel)); err != nil {
+					return errors.Wrapf(err, "unable to copy %v", out)
+				}
+			}
+
+			continue
+		}
+
+		args, err := shlex.Split(s.Command)
+		if err != nil || len(args) == 0 {
+			return errors.Errorf("invalid sink command %q", s.Command)
+		}
+
+		c := exec.CommandContext(ctx, args[0], append(args[1:], outputs...)...)
+		c.Stdout = os.Stderr
+		c.Stderr = os.Stderr
+
+		if err := c.Run(); err != nil {
+			return errors.Wrapf(err, "sink command %q failed", s.Command)
+		}
+	}
+
+	log.Printf("published %v output files to %v sinks", len(outputs), len(sc.Sinks))
+
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
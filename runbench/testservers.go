@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/net/webdav"
+)
+
+// markers that can be put in a script to have runbench start in-process SFTP and WebDAV servers
+// with password authentication for the scenario, exporting $SFTP_HOST, $SFTP_PORT, $SFTP_USERNAME,
+// $SFTP_PASSWORD, $SFTP_PATH, $SFTP_KNOWN_HOSTS and $WEBDAV_URL, $WEBDAV_USERNAME, $WEBDAV_PASSWORD
+// respectively. Their CPU and memory usage is accounted as runbench overhead. Like the MinIO
+// bucket, their directories are emptied before each preparation.
+const (
+	sftpMarker   = `# SFTP`
+	webDAVMarker = `# WEBDAV`
+)
+
+const testServerUsername = "runbench"
+
+// storageServer is a server started for the scenario which stores repositories of its runs.
+type storageServer interface {
+	// reset removes all data stored by previous runs.
+	reset(ctx context.Context) error
+}
+
+// removeContents removes all entries of the directory, keeping the directory itself.
+func removeContents(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resetStorageServers removes repositories of previous runs from servers of the scenario.
+func (ss *scenarioState) resetStorageServers(ctx context.Context) error {
+	for _, s := range ss.storageServers {
+		if err := s.reset(ctx); err != nil {
+			return errors.Wrap(err, "unable to remove repositories of previous runs")
+		}
+	}
+
+	return nil
+}
+
+// randomPassword returns a random password for a test server.
+func randomPassword() (string, error) {
+	var b [16]byte
+
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", errors.Wrap(err, "unable to generate password")
+	}
+
+	return hex.EncodeToString(b[:]), nil
+}
+
+func checkCredentials(user, password, wantPassword string) bool {
+	return user == testServerUsername && subtle.ConstantTimeCompare([]byte(password), []byte(wantPassword)) == 1
+}
+
+// webDAVServer is an in-process WebDAV server with basic authentication serving a temporary directory.
+type webDAVServer struct {
+	server *httptest.Server
+	dir    string
+}
+
+// startWebDAVServer starts WebDAV server and exports WEBDAV_URL, WEBDAV_USERNAME and WEBDAV_PASSWORD
+// to the scenario.
+func startWebDAVServer() (*webDAVServer, error) {
+	password, err := randomPassword()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "runbench-webdav")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create WebDAV directory")
+	}
+
+	h := &webdav.Handler{
+		FileSystem: webdav.Dir(dir),
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	s := &webDAVServer{
+		dir: dir,
+		server: httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u, p, ok := r.BasicAuth(); !ok || !checkCredentials(u, p, password) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="runbench"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})),
+	}
+
+	setScenarioEnv("WEBDAV_URL", s.server.URL)
+	setScenarioEnv("WEBDAV_USERNAME", testServerUsername)
+	setScenarioEnv("WEBDAV_PASSWORD", password)
+
+	return s, nil
+}
+
+// reset implements storageServer.
+func (s *webDAVServer) reset(ctx context.Context) error {
+	return removeContents(s.dir)
+}
+
+// Close stops the server and removes its data.
+func (s *webDAVServer) Close() {
+	s.server.Close()
+	os.RemoveAll(s.dir)
+}
+
+// sftpServer is an in-process SSH server with password authentication which only supports
+// the sftp subsystem.
+type sftpServer struct {
+	listener net.Listener
+	dir      string
+	config   *ssh.ServerConfig
+	wg       sync.WaitGroup
+}
+
+// startSFTPServer starts SFTP server and exports SFTP_HOST, SFTP_PORT, SFTP_USERNAME, SFTP_PASSWORD,
+// SFTP_PATH (directory to store repository in) and SFTP_KNOWN_HOSTS (path to known_hosts file
+// with the server's host key) to the scenario.
+func startSFTPServer() (*sftpServer, error) {
+	password, err := randomPassword()
+	if err != nil {
+		return nil, err
+	}
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to generate host key")
+	}
+
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create signer")
+	}
+
+	s := &sftpServer{
+		config: &ssh.ServerConfig{
+			PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+				if checkCredentials(c.User(), string(pass), password) {
+					return nil, nil
+				}
+
+				return nil, errors.Errorf("access denied")
+			},
+		},
+	}
+
+	s.config.AddHostKey(signer)
+
+	s.dir, err = os.MkdirTemp("", "runbench-sftp")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create SFTP directory")
+	}
+
+	dataDir := filepath.Join(s.dir, "data")
+	knownHostsFile := filepath.Join(s.dir, "known_hosts")
+
+	if err := os.Mkdir(dataDir, 0o700); err != nil {
+		os.RemoveAll(s.dir)
+		return nil, errors.Wrap(err, "unable to create SFTP data directory")
+	}
+
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(s.dir)
+		return nil, errors.Wrap(err, "unable to listen")
+	}
+
+	addr := s.listener.Addr().(*net.TCPAddr)
+	knownHostsLine := knownhosts.Line([]string{knownhosts.Normalize(addr.String())}, signer.PublicKey())
+
+	if err := os.WriteFile(knownHostsFile, []byte(knownHostsLine+"\n"), 0o600); err != nil {
+		s.Close()
+		return nil, errors.Wrap(err, "unable to write known_hosts")
+	}
+
+	s.wg.Add(1)
+
+	go s.acceptLoop()
+
+	setScenarioEnv("SFTP_HOST", addr.IP.String())
+	setScenarioEnv("SFTP_PORT", strconv.Itoa(addr.Port))
+	setScenarioEnv("SFTP_USERNAME", testServerUsername)
+	setScenarioEnv("SFTP_PASSWORD", password)
+	setScenarioEnv("SFTP_PATH", dataDir)
+	setScenarioEnv("SFTP_KNOWN_HOSTS", knownHostsFile)
+
+	return s, nil
+}
+
+// reset implements storageServer.
+func (s *sftpServer) reset(ctx context.Context) error {
+	return removeContents(filepath.Join(s.dir, "data"))
+}
+
+// Close stops accepting connections and removes server data.
+func (s *sftpServer) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+	os.RemoveAll(s.dir)
+}
+
+func (s *sftpServer) acceptLoop() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handleConn(conn)
+	}
+}
+
+func (s *sftpServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			_ = nc.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			for req := range requests {
+				// payload of subsystem request is a length-prefixed subsystem name.
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+			}
+		}()
+
+		go func() {
+			defer ch.Close()
+
+			server, err := sftp.NewServer(ch)
+			if err != nil {
+				return
+			}
+
+			_ = server.Serve()
+		}()
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"runbench/pkg/bench"
+)
+
+// startupProblems collects all problems found by validateStartup, so that they can be fixed at once.
+type startupProblems []string
+
+func (p *startupProblems) add(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+func (p *startupProblems) check(err error) {
+	if err != nil {
+		p.add("%v", err)
+	}
+}
+
+// validateStartup checks flags, scenario files and the environment they need before any scenario
+// starts, so that mistakes are reported immediately instead of after minutes of preparation.
+// Per-scenario overrides from --config are only validated when the scenario starts.
+func validateStartup(scenarios []string) error {
+	var p startupProblems
+
+	if *suiteFile != "" && *serviceMode {
+		p.add("--suite and --service can't be combined, the suite file lists its own scenarios")
+	}
+
+	if *deadline > 0 && (*suiteFile != "" || *serviceMode) {
+		p.add("--deadline is not used with --service and --suite, stop the service instead")
+	}
+
+	if *soakDuration > 0 && (*compareExe != "" || *cacheStates != "" || *memoryLimits != "") {
+		p.add("--soak can't be combined with --compare-to-exe, --cache-states or --memory-limits")
+	}
+
+	if *suiteFile == "" && len(scenarios) == 0 {
+		p.add("no scenario files given, usage: runbench [--flags] scenario1.sh ... scenarioN.sh, or runbench [--flags] doctor")
+	}
+
+	// the first run of each scenario is discarded as warmup, a single run is only enough when
+	// --min-duration repeats it.
+	if *minRepeat < 2 {
+		if *minDuration <= 0 {
+			p.add("--min-repeat must be at least 2 without --min-duration, the first run of each scenario is discarded as warmup")
+		} else {
+			log.Printf("--min-repeat=%v: the first run of each scenario is discarded as warmup, scenarios running longer than --min-duration won't produce measurements", *minRepeat)
+		}
+	}
+
+	if *samplingInterval <= 0 {
+		p.add("--sampling-interval must be positive")
+	}
+
+	if *kopiaLogMaxSize < 0 {
+		p.add("--kopia-log-max-size can't be negative")
+	}
+
+	if *serviceMode && *serviceInterval <= 0 {
+		p.add("--service-interval must be positive")
+	}
+
+	_, err := parseCaptureMetrics()
+	p.check(err)
+
+	_, err = parseCaptureHistograms()
+	p.check(err)
+
+	_, err = parseSamplers()
+	p.check(err)
+
+	_, err = bench.ParseTags(*runTags)
+	p.check(errors.Wrap(err, "invalid --run-tags"))
+
+	states, err := parseCacheStates()
+	p.check(errors.Wrap(err, "invalid --cache-states"))
+
+	_, err = parseMemoryLimits()
+	p.check(errors.Wrap(err, "invalid --memory-limits"))
+
+	switch *repoSizeSource {
+	case repoSizeFromDir, repoSizeFromBlobStats:
+	case repoSizeFromObjectStore:
+		if *repoURL == "" {
+			p.add("--repo-size-source=%v requires --repo-url", repoSizeFromObjectStore)
+		}
+	default:
+		p.add("unsupported --repo-size-source %q, expected %v, %v or %v", *repoSizeSource, repoSizeFromDir, repoSizeFromBlobStats, repoSizeFromObjectStore)
+	}
+
+	// with --suite kopia is built from the repository of the suite.
+	if *suiteFile == "" {
+		p.check(checkExecutable("kopia-exe", *kopiaExe))
+	}
+
+	if *compareExe != "" {
+		p.check(checkExecutable("compare-to-exe", *compareExe))
+	}
+
+	p.check(checkExecutable("go-exe", *goExe))
+
+	if *heapProfileTop > 0 && *compareExe == "" {
+		p.add("--heap-profile-top requires --compare-to-exe")
+	}
+
+	p.check(checkWritableDir("output-dir", *outputDir))
+
+	if *parquetDir != "" {
+		if *compareExe != "" {
+			p.add("--parquet-dir can't be combined with --compare-to-exe, samples of comparisons aren't kept")
+		}
+
+		p.check(checkWritableDir("parquet-dir", *parquetDir))
+	}
+
+	if *repoPath != "" {
+		p.check(checkWritableDir("repo-path", filepath.Dir(*repoPath)))
+	}
+
+	needsChurn := *sourceChurn != ""
+	needsNetns := *netnsShaping != ""
+	needsNetworkSource := *networkSource != ""
+	needsMinio := false
+
+	for _, scenFile := range scenarios {
+		si, err := parseScenario(scenFile)
+		if err != nil {
+			p.add("invalid scenario: %v", err)
+			continue
+		}
+
+		for _, spec := range si.env {
+			p.check(errors.Wrapf(declareScenarioEnv(spec), "invalid scenario %q", scenFile))
+		}
+
+		resetScenarioEnv()
+
+		sizeSource := *repoSizeSource
+
+		if si.repoSizeSource != "" {
+			sizeSource = si.repoSizeSource
+
+			if sizeSource != repoSizeFromDir && sizeSource != repoSizeFromBlobStats && sizeSource != repoSizeFromObjectStore {
+				p.add("unsupported REPO_SIZE_SOURCE %q in scenario %q", sizeSource, scenFile)
+			}
+		}
+
+		if si.generic && sizeSource == repoSizeFromBlobStats {
+			p.add("--repo-size-source=%v requires kopia, but scenario %q measures a generic command", repoSizeFromBlobStats, scenFile)
+		}
+
+		needsChurn = needsChurn || si.churn != ""
+		needsNetns = needsNetns || si.netns != ""
+		needsNetworkSource = needsNetworkSource || si.networkSource != ""
+		needsMinio = needsMinio || si.minio
+	}
+
+	if needsChurn {
+		p.check(checkExecutable("makemanyfiles-exe", *makeManyFilesExe))
+	}
+
+	if needsMinio {
+		p.check(checkExecutable("minio-exe", *minioExe))
+	}
+
+	if *memoryLimits != "" {
+		p.check(checkPrivileged("--memory-limits", true))
+	}
+
+	if needsNetns {
+		p.check(checkPrivileged("--netns (or '# NETNS' in a scenario)", true))
+	}
+
+	if needsNetworkSource {
+		p.check(checkPrivileged("--network-source (or '# NETWORK_SOURCE' in a scenario)", true))
+	}
+
+	for _, s := range states {
+		if s == cacheCold {
+			p.check(checkPrivileged("--cache-states=cold", false))
+		}
+	}
+
+	if len(p) == 0 {
+		return nil
+	}
+
+	return errors.Errorf("invalid configuration:\n  %v", strings.Join(p, "\n  "))
+}
+
+// checkExecutable verifies that the executable passed via the flag can be run.
+func checkExecutable(flagName, exe string) error {
+	if _, err := exec.LookPath(exe); err != nil {
+		return errors.Errorf("--%v: %q is not an executable file, pass the path of an installed binary (%v)", flagName, exe, err)
+	}
+
+	return nil
+}
+
+// checkWritableDir verifies that files can be created in the directory passed via the flag,
+// creating it if needed.
+func checkWritableDir(flagName, dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return errors.Errorf("--%v: unable to create %q: %v", flagName, dir, err)
+	}
+
+	f, err := os.CreateTemp(dir, ".runbench-check-*")
+	if err != nil {
+		return errors.Errorf("--%v: %q is not writable: %v", flagName, dir, err)
+	}
+
+	f.Close()
+
+	return os.Remove(f.Name())
+}
+
+// checkPrivileged verifies that a feature which needs root (and possibly Linux) can be used.
+func checkPrivileged(feature string, linuxOnly bool) error {
+	if linuxOnly && runtime.GOOS != "linux" {
+		return errors.Errorf("%v is only supported on Linux", feature)
+	}
+
+	if runtime.GOOS != "windows" && os.Geteuid() != 0 {
+		return errors.Errorf("%v requires root, run runbench with sudo", feature)
+	}
+
+	return nil
+}
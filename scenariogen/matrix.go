@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// allValues selects all values of a dimension in a suite.
+const allValues = "*"
+
+// matrix is the contents of the --matrix file.
+type matrix struct {
+	Template   string                `yaml:"template"`
+	Prefix     string                `yaml:"prefix"`
+	Dimensions []dimension           `yaml:"dimensions"`
+	Suites     []map[string][]string `yaml:"suites"`
+
+	tmpl *template.Template
+}
+
+type dimension struct {
+	Name   string              `yaml:"name"`
+	Values []map[string]string `yaml:"values"`
+}
+
+func (d dimension) value(name string) (map[string]string, bool) {
+	for _, v := range d.Values {
+		if v["name"] == name {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
+func loadMatrix(fname string) (*matrix, error) {
+	b, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read matrix file: %w", err)
+	}
+
+	m := &matrix{}
+
+	if err := yaml.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("unable to parse matrix file %q: %w", fname, err)
+	}
+
+	if m.Template == "" {
+		return nil, fmt.Errorf("missing template in %q", fname)
+	}
+
+	seen := map[string]bool{"scenario": true}
+
+	for _, d := range m.Dimensions {
+		if seen[d.Name] || d.Name == "" {
+			return nil, fmt.Errorf("invalid or duplicate dimension name %q", d.Name)
+		}
+
+		if len(d.Values) == 0 {
+			return nil, fmt.Errorf("dimension %q has no values", d.Name)
+		}
+
+		seen[d.Name] = true
+	}
+
+	// the template is relative to the matrix file.
+	tmplFile := filepath.Join(filepath.Dir(fname), m.Template)
+
+	tb, err := os.ReadFile(tmplFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read template: %w", err)
+	}
+
+	m.tmpl, err = template.New(m.Template).Option("missingkey=zero").Parse(string(tb))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse template %q: %w", tmplFile, err)
+	}
+
+	return m, nil
+}
+
+// generate returns contents of all scripts produced by the matrix by file name.
+func (m *matrix) generate() (map[string][]byte, error) {
+	scripts := map[string][]byte{}
+
+	for i, suite := range m.Suites {
+		for name := range suite {
+			if _, ok := m.dimension(name); !ok {
+				return nil, fmt.Errorf("suite %v: unknown dimension %q", i, name)
+			}
+		}
+
+		combos := []map[string]map[string]string{{}}
+
+		for _, d := range m.Dimensions {
+			values, err := d.selected(suite[d.Name])
+			if err != nil {
+				return nil, fmt.Errorf("suite %v: %w", i, err)
+			}
+
+			var next []map[string]map[string]string
+
+			for _, c := range combos {
+				for _, v := range values {
+					n := map[string]map[string]string{d.Name: v}
+					for k, cv := range c {
+						n[k] = cv
+					}
+
+					next = append(next, n)
+				}
+			}
+
+			combos = next
+		}
+
+		for _, c := range combos {
+			name := m.scenarioName(c)
+			c["scenario"] = map[string]string{"name": name}
+
+			var buf bytes.Buffer
+
+			if err := m.tmpl.Execute(&buf, c); err != nil {
+				return nil, fmt.Errorf("unable to generate %q: %w", name, err)
+			}
+
+			// without the marker the script would not be recognized as stale after it's removed from the matrix.
+			if !bytes.Contains(buf.Bytes(), []byte(generatedMarker)) {
+				return nil, fmt.Errorf("template must include %q", generatedMarker)
+			}
+
+			fname := name + ".sh"
+
+			if err := checkDuplicate(scripts, fname); err != nil {
+				return nil, err
+			}
+
+			scripts[fname] = buf.Bytes()
+		}
+	}
+
+	return scripts, nil
+}
+
+func (m *matrix) dimension(name string) (dimension, bool) {
+	for _, d := range m.Dimensions {
+		if d.Name == name {
+			return d, true
+		}
+	}
+
+	return dimension{}, false
+}
+
+// selected returns values of the dimension with given names, by default the first value.
+func (d dimension) selected(names []string) ([]map[string]string, error) {
+	if len(names) == 0 {
+		return d.Values[0:1], nil
+	}
+
+	var result []map[string]string
+
+	for _, n := range names {
+		if n == allValues {
+			return d.Values, nil
+		}
+
+		v, ok := d.value(n)
+		if !ok {
+			return nil, fmt.Errorf("dimension %q has no value %q", d.Name, n)
+		}
+
+		result = append(result, v)
+	}
+
+	return result, nil
+}
+
+// scenarioName joins the prefix and non-empty names of values in the order of dimensions.
+func (m *matrix) scenarioName(c map[string]map[string]string) string {
+	var parts []string
+
+	if m.Prefix != "" {
+		parts = append(parts, m.Prefix)
+	}
+
+	for _, d := range m.Dimensions {
+		if n := c[d.Name]["name"]; n != "" {
+			parts = append(parts, n)
+		}
+	}
+
+	return strings.Join(parts, "-")
+}
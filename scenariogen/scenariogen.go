@@ -0,0 +1,204 @@
+// Command scenariogen generates scenario scripts from a template and a parameter matrix, so that
+// scenarios which only differ in dataset, backend, compression, splitter or parallelism stay
+// consistent with each other.
+//
+// Usage: scenariogen [--matrix=scenarios/matrix.yaml] [--output-dir=<dir>] [--check] [--prune]
+//
+// The matrix file lists dimensions, each with a list of values, and suites, each selecting values
+// of some dimensions. Every suite generates one script for each combination of selected values,
+// dimensions not mentioned by the suite use their first value. For example:
+//
+//	template: snapshot.sh.tmpl
+//	prefix: snapshot
+//	dimensions:
+//	  - name: dataset
+//	    values:
+//	      - {name: linux, path: linux}
+//	  - name: compression
+//	    values:
+//	      - {name: ""}
+//	      - {name: zstd-fastest, compression: zstd-fastest}
+//	suites:
+//	  - dataset: [linux]
+//	    compression: ["*"]
+//
+// Values are arbitrary string maps available to the template as {{.<dimension>.<key>}}, their
+// names form the scenario name (in the order of dimensions, empty names are omitted), so adding
+// a dimension whose first value has an empty name doesn't rename existing scenarios. The name of the
+// scenario itself is available as {{.scenario.name}}.
+//
+// Generated scripts are marked with a comment, --prune removes marked scripts which are no longer
+// generated by the matrix. With --check nothing is written and the command fails if any script is
+// missing, out of date or stale, which is meant to be run by CI.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	matrixFile = flag.String("matrix", "scenarios/matrix.yaml", "Matrix file describing generated scenarios")
+	outputDir  = flag.String("output-dir", "", "Directory to write scenario scripts to (default: directory of the matrix file)")
+	check      = flag.Bool("check", false, "Only check that generated scripts are up to date")
+	prune      = flag.Bool("prune", false, "Remove previously generated scripts no longer produced by the matrix")
+)
+
+// generatedMarker is the comment identifying generated scripts.
+const generatedMarker = "# Generated by scenariogen, DO NOT EDIT."
+
+func main() {
+	flag.Parse()
+
+	if *outputDir == "" {
+		*outputDir = filepath.Dir(*matrixFile)
+	}
+
+	m, err := loadMatrix(*matrixFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	scripts, err := m.generate()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stale, err := staleScripts(*outputDir, scripts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *check {
+		if problems := checkScripts(*outputDir, scripts, stale); problems > 0 {
+			log.Fatalf("%v scenario scripts are not up to date, run scenariogen to regenerate them", problems)
+		}
+
+		log.Printf("all %v generated scenario scripts are up to date", len(scripts))
+
+		return
+	}
+
+	if err := writeScripts(*outputDir, scripts); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, fname := range stale {
+		if !*prune {
+			log.Printf("%v is no longer generated, use --prune to remove it", fname)
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(*outputDir, fname)); err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("removed %v", fname)
+	}
+}
+
+// writeScripts writes scripts whose contents changed.
+func writeScripts(dir string, scripts map[string][]byte) error {
+	var written int
+
+	for _, fname := range sortedNames(scripts) {
+		p := filepath.Join(dir, fname)
+
+		if existing, err := os.ReadFile(p); err == nil && bytes.Equal(existing, scripts[fname]) {
+			continue
+		}
+
+		if err := os.WriteFile(p, scripts[fname], 0o755); err != nil {
+			return err
+		}
+
+		written++
+	}
+
+	log.Printf("generated %v scenario scripts, %v changed", len(scripts), written)
+
+	return nil
+}
+
+// checkScripts logs and returns the number of scripts which are missing, modified or stale.
+func checkScripts(dir string, scripts map[string][]byte, stale []string) int {
+	var problems int
+
+	for _, fname := range sortedNames(scripts) {
+		existing, err := os.ReadFile(filepath.Join(dir, fname))
+
+		switch {
+		case os.IsNotExist(err):
+			log.Printf("%v is missing", fname)
+		case err != nil:
+			log.Printf("unable to read %v: %v", fname, err)
+		case !bytes.Equal(existing, scripts[fname]):
+			log.Printf("%v is out of date", fname)
+		default:
+			continue
+		}
+
+		problems++
+	}
+
+	for _, fname := range stale {
+		log.Printf("%v is no longer generated", fname)
+
+		problems++
+	}
+
+	return problems
+}
+
+// staleScripts returns names of previously generated scripts which the matrix no longer produces.
+func staleScripts(dir string, scripts map[string][]byte) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []string
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".sh") || scripts[e.Name()] != nil {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		if bytes.Contains(b, []byte(generatedMarker)) {
+			stale = append(stale, e.Name())
+		}
+	}
+
+	return stale, nil
+}
+
+func sortedNames(scripts map[string][]byte) []string {
+	var names []string
+
+	for n := range scripts {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func checkDuplicate(scripts map[string][]byte, fname string) error {
+	if scripts[fname] != nil {
+		return fmt.Errorf("scenario %q is generated more than once", strings.TrimSuffix(fname, ".sh"))
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"runbench/pkg/bench"
+)
+
+// client is a kopia client connected to the server.
+type client struct {
+	index      int
+	configFile string
+	dataset    string
+
+	// root object of the latest snapshot, restored by the restore phase.
+	rootObject string
+}
+
+func (c *client) kopia() bench.Kopia {
+	return bench.Kopia{Exe: *kopiaExe, ConfigFile: c.configFile, Password: userPassword}
+}
+
+func clientUser(i int) string {
+	return "client-" + strconv.Itoa(i) + "@" + hostName
+}
+
+// connectClient connects a new client to the server.
+func connectClient(ctx context.Context, dir string, i int) (*client, error) {
+	c := &client{
+		index:      i,
+		configFile: filepath.Join(dir, fmt.Sprintf("client-%v.config", i)),
+		dataset:    datasetOf(i),
+	}
+
+	_, err := c.kopia().Run(ctx, "repository", "connect", "server",
+		"--url=http://"+*address,
+		"--override-username=client-"+strconv.Itoa(i),
+		"--override-hostname="+hostName,
+	)
+
+	return c, err
+}
+
+// prepare performs unmeasured steps needed before the phase.
+func (c *client) prepare(ctx context.Context, phase string) error {
+	if phase == phaseRestore && c.rootObject == "" {
+		return c.findLatestSnapshot(ctx)
+	}
+
+	return nil
+}
+
+// run performs and measures the phase.
+func (c *client) run(ctx context.Context, phase string) (*bench.Result, error) {
+	var args []string
+
+	switch phase {
+	case phaseSnapshot:
+		args = []string{"snapshot", "create", c.dataset, "--no-progress"}
+
+	case phaseRestore:
+		target := c.configFile + ".restore"
+		if err := os.RemoveAll(target); err != nil {
+			return nil, err
+		}
+
+		defer os.RemoveAll(target)
+
+		args = []string{"restore", c.rootObject, target}
+	}
+
+	cmd := c.kopia().Command(ctx, args...)
+
+	r := &bench.CommandRunner{Interval: *samplingInterval}
+
+	res, err := r.Run(ctx, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("kopia %v failed: %w", phase, err)
+	}
+
+	if phase == phaseSnapshot {
+		// restore the snapshot just taken.
+		c.rootObject = ""
+	}
+
+	return res, nil
+}
+
+// findLatestSnapshot finds the root object of the latest snapshot of the client's dataset.
+func (c *client) findLatestSnapshot(ctx context.Context) error {
+	root, err := c.kopia().LatestRoot(ctx, c.dataset)
+	if err != nil {
+		return err
+	}
+
+	if root == "" {
+		return fmt.Errorf("no snapshots of %v, restore phase requires a preceding snapshot phase", c.dataset)
+	}
+
+	c.rootObject = root
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+// server is a running 'kopia server' process.
+type server struct {
+	cmd  *exec.Cmd
+	done chan error
+}
+
+// startServer creates a filesystem repository in the work directory, adds user accounts of all
+// clients and starts the server.
+func startServer(ctx context.Context, dir string) (*server, error) {
+	k := bench.Kopia{Exe: *kopiaExe, ConfigFile: filepath.Join(dir, "server.config"), Password: repoPassword}
+
+	if err := k.CreateRepository(ctx, filepath.Join(dir, "repo")); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < *numClients; i++ {
+		if _, err := k.Run(ctx, "server", "user", "add", clientUser(i), "--user-password="+userPassword); err != nil {
+			return nil, err
+		}
+	}
+
+	logFile, err := os.Create(filepath.Join(dir, "server.log"))
+	if err != nil {
+		return nil, err
+	}
+
+	c := k.Command(ctx, "server", "start",
+		"--address=http://"+*address,
+		"--insecure",
+		"--server-username=serverbench",
+		"--server-password="+userPassword,
+		"--metrics-listen-addr="+*metricsAddress,
+	)
+	c.Stdout = logFile
+	c.Stderr = logFile
+
+	if err := c.Start(); err != nil {
+		logFile.Close()
+		return nil, fmt.Errorf("unable to start server: %w", err)
+	}
+
+	s := &server{cmd: c, done: make(chan error, 1)}
+
+	go func() {
+		s.done <- c.Wait()
+		logFile.Close()
+	}()
+
+	if err := s.waitForListening(); err != nil {
+		s.stop()
+		return nil, fmt.Errorf("%w, see %v", err, logFile.Name())
+	}
+
+	log.Printf("kopia server listening on %v", *address)
+
+	return s, nil
+}
+
+// waitForListening waits until the server accepts connections.
+func (s *server) waitForListening() error {
+	deadline := time.Now().Add(*startTimeout)
+
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-s.done:
+			s.done <- err
+			return fmt.Errorf("server exited: %v", err)
+		default:
+		}
+
+		if conn, err := net.DialTimeout("tcp", *address, time.Second); err == nil {
+			conn.Close()
+			return nil
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return fmt.Errorf("server did not start listening on %v within %v", *address, *startTimeout)
+}
+
+// monitor starts sampling the server process and its Prometheus metrics.
+func (s *server) monitor(ctx context.Context) (*bench.Monitor, error) {
+	ps, err := bench.NewProcessSampler(ctx, s.cmd.Process.Pid)
+	if err != nil {
+		return nil, err
+	}
+
+	return bench.StartMonitor(ctx, *samplingInterval, ps, &bench.PrometheusSampler{URL: "http://" + *metricsAddress + "/metrics"}), nil
+}
+
+// stop interrupts the server and waits for it to exit, killing it if it doesn't exit in time.
+func (s *server) stop() {
+	if err := s.cmd.Process.Signal(os.Interrupt); err != nil {
+		s.cmd.Process.Kill()
+	}
+
+	select {
+	case <-s.done:
+	case <-time.After(10 * time.Second):
+		log.Printf("server did not stop, killing it")
+		s.cmd.Process.Kill()
+		<-s.done
+	}
+}
@@ -0,0 +1,240 @@
+// Command serverbench benchmarks kopia repository server: it starts 'kopia server', connects
+// --clients simulated clients to it and measures snapshot and restore operations performed by
+// all clients concurrently through the server API, sampling both the server and client processes.
+//
+// Usage: serverbench --dataset=<dir>[,<dir>...] [--clients=N] [--phases=snapshot,restore]
+//
+// Datasets are assigned to clients round-robin, each client snapshots its dataset using its own
+// config file and user account (client-<n>@serverbench) and then restores the snapshot to a
+// scratch directory. Each phase is repeated --repeat times.
+//
+// For each phase the tool writes InfluxDB-formatted measurements to --output (stdout by default):
+//
+//	server_bench_summary     - wall time of the phase and average and maximum client duration
+//	server_process_summary   - CPU and memory usage of the server during the phase
+//	client_process_summary   - CPU and memory usage of clients, averaged over all clients
+//
+// Measurements are tagged with the phase, the number of clients and --run-tags.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+const (
+	phaseSnapshot = "snapshot"
+	phaseRestore  = "restore"
+
+	repoPassword = "dummy"
+	userPassword = "serverbench-password"
+	hostName     = "serverbench"
+)
+
+var (
+	kopiaExe         = flag.String("kopia-exe", os.ExpandEnv("$HOME/go/bin/kopia"), "Path to kopia")
+	datasets         = flag.String("dataset", "", "Comma-separated list of directories to snapshot, assigned to clients round-robin")
+	numClients       = flag.Int("clients", 4, "Number of simulated clients")
+	phases           = flag.String("phases", phaseSnapshot+","+phaseRestore, "Comma-separated list of measured phases")
+	repeat           = flag.Int("repeat", 1, "Number of times each phase is repeated")
+	workDir          = flag.String("work-dir", "", "Directory for the repository, config files and restored files (default: new temporary directory)")
+	address          = flag.String("address", "127.0.0.1:51515", "Address of the server")
+	metricsAddress   = flag.String("metrics-address", "127.0.0.1:6667", "Address of the server's Prometheus endpoint")
+	samplingInterval = flag.Duration("sampling-interval", 100*time.Millisecond, "Interval between samples of the measured processes")
+	startTimeout     = flag.Duration("start-timeout", time.Minute, "Maximum time to wait for the server to start")
+	keep             = flag.Bool("keep", false, "Keep the work directory")
+	outputFile       = flag.String("output", "", "File to append measurements to (default: stdout)")
+	runTags          = flag.String("run-tags", "", "Comma-separated list of tags to attach to measurements")
+)
+
+func main() {
+	flag.Parse()
+
+	if *datasets == "" {
+		log.Fatal("missing --dataset")
+	}
+
+	if *numClients <= 0 || *repeat <= 0 {
+		log.Fatal("--clients and --repeat must be positive")
+	}
+
+	tags, err := bench.ParseTags(*runTags)
+	if err != nil {
+		log.Fatalf("invalid --run-tags: %v", err)
+	}
+
+	for _, p := range strings.Split(*phases, ",") {
+		if p != phaseSnapshot && p != phaseRestore {
+			log.Fatalf("unsupported phase %q", p)
+		}
+	}
+
+	if err := run(context.Background(), tags); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, tags []bench.Tag) error {
+	dir := *workDir
+	if dir == "" {
+		d, err := os.MkdirTemp("", "serverbench")
+		if err != nil {
+			return err
+		}
+
+		dir = d
+	}
+
+	if !*keep {
+		defer os.RemoveAll(dir)
+	}
+
+	srv, err := startServer(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	defer srv.stop()
+
+	var clients []*client
+
+	for i := 0; i < *numClients; i++ {
+		c, err := connectClient(ctx, dir, i)
+		if err != nil {
+			return err
+		}
+
+		clients = append(clients, c)
+	}
+
+	out, err := bench.OpenOutput(*outputFile)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	sink := bench.NewLineProtocolSink(out)
+
+	for r := 0; r < *repeat; r++ {
+		for _, p := range strings.Split(*phases, ",") {
+			log.Printf("running %v phase with %v clients (%v of %v)", p, len(clients), r+1, *repeat)
+
+			points, err := runPhase(ctx, srv, clients, p, tags)
+			if err != nil {
+				return fmt.Errorf("%v phase failed: %w", p, err)
+			}
+
+			if err := sink.Write(points...); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// runPhase runs the phase on all clients concurrently while monitoring the server.
+func runPhase(ctx context.Context, srv *server, clients []*client, phase string, tags []bench.Tag) ([]bench.Point, error) {
+	for i, c := range clients {
+		if err := c.prepare(ctx, phase); err != nil {
+			return nil, fmt.Errorf("client %v: %w", i, err)
+		}
+	}
+
+	monitor, err := srv.monitor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg      sync.WaitGroup
+		results = make([]*bench.Result, len(clients))
+		errs    = make([]error, len(clients))
+	)
+
+	t0 := time.Now()
+
+	for i, c := range clients {
+		wg.Add(1)
+
+		go func(i int, c *client) {
+			defer wg.Done()
+
+			results[i], errs[i] = c.run(ctx, phase)
+		}(i, c)
+	}
+
+	wg.Wait()
+
+	wall := time.Since(t0)
+	serverResult := monitor.Stop()
+
+	var maxClient, totalClient time.Duration
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("client %v: %w", i, err)
+		}
+
+		totalClient += results[i].Duration
+
+		if results[i].Duration > maxClient {
+			maxClient = results[i].Duration
+		}
+	}
+
+	tags = append([]bench.Tag{
+		{Key: "phase", Value: phase},
+		{Key: "clients", Value: strconv.Itoa(len(clients))},
+	}, tags...)
+
+	now := time.Now()
+	ss := bench.Summarize([]*bench.Result{serverResult})
+	cs := bench.Summarize(results)
+
+	log.Printf("  completed in %v, slowest client %v, server RAM max %.1f MiB CPU avg %.1f %%", wall, maxClient, ss.MaxRAM, ss.AvgCPU)
+
+	return []bench.Point{
+		{Measurement: "server_bench_summary", Tags: tags, Time: now, Fields: []bench.Field{
+			{Key: "duration", Value: bench.Fixed{Value: wall.Seconds(), Digits: 1}},
+			{Key: "avg_client_duration", Value: bench.Fixed{Value: totalClient.Seconds() / float64(len(clients)), Digits: 1}},
+			{Key: "max_client_duration", Value: bench.Fixed{Value: maxClient.Seconds(), Digits: 1}},
+		}},
+		{Measurement: "server_process_summary", Tags: tags, Time: now, Fields: []bench.Field{
+			{Key: "avg_ram_rss", Value: ss.AvgRAM},
+			{Key: "max_ram_rss", Value: ss.MaxRAM},
+			{Key: "avg_cpu_percent", Value: ss.AvgCPU},
+			{Key: "max_cpu_percent", Value: ss.MaxCPU},
+			{Key: "heap_bytes_delta", Value: serverResult.CounterDelta("go_memstats_alloc_bytes_total")},
+			{Key: "heap_bytes", Value: serverResult.LastCounter("go_memstats_alloc_bytes_total")}, // deprecated
+		}},
+		{Measurement: "client_process_summary", Tags: tags, Time: now, Fields: []bench.Field{
+			{Key: "avg_ram_rss", Value: cs.AvgRAM},
+			{Key: "max_ram_rss", Value: cs.MaxRAM},
+			{Key: "avg_cpu_percent", Value: cs.AvgCPU},
+			{Key: "max_cpu_percent", Value: cs.MaxCPU},
+		}},
+	}, nil
+}
+
+func datasetOf(i int) string {
+	ds := strings.Split(*datasets, ",")
+
+	p, err := filepath.Abs(ds[i%len(ds)])
+	if err != nil {
+		return ds[i%len(ds)]
+	}
+
+	return p
+}
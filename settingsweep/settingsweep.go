@@ -0,0 +1,235 @@
+// Command settingsweep measures snapshots of the same dataset with each combination of kopia
+// settings: compression algorithm, splitter, encryption and upload parallelism, so that the
+// trade-offs between them can be compared.
+//
+// Usage: settingsweep --dataset=<dir> [--compression=none,zstd-fastest] [--splitter=DYNAMIC-4M-BUZHASH] [--encryption=AES256-GCM-HMAC-SHA256] [--parallel=4]
+//
+// Each flag is a comma-separated list of values, 'none' disables compression and an empty list
+// element uses the kopia default. Every combination is measured --repeat times, each time with
+// a new filesystem repository created with the splitter and encryption and the compression set
+// as the global policy.
+//
+// Measurements are written to --output (stdout by default) as 'settings_sweep' lines tagged with
+// the configuration and --run-tags, with average duration, throughput, repository size, ratio of
+// dataset size to repository size and memory and CPU usage of the snapshot.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+const repoPassword = "dummy"
+
+var (
+	kopiaExe         = flag.String("kopia-exe", os.ExpandEnv("$HOME/go/bin/kopia"), "Path to kopia")
+	dataset          = flag.String("dataset", "", "Directory to snapshot")
+	compressions     = flag.String("compression", "none,zstd-fastest,s2-default,pgzip", "Comma-separated list of compression algorithms")
+	splitters        = flag.String("splitter", "DYNAMIC-4M-BUZHASH,DYNAMIC-8M-BUZHASH,FIXED-4M", "Comma-separated list of object splitters")
+	encryptions      = flag.String("encryption", "AES256-GCM-HMAC-SHA256,CHACHA20-POLY1305-HMAC-SHA256", "Comma-separated list of encryption algorithms")
+	parallelValues   = flag.String("parallel", "4", "Comma-separated list of --parallel values")
+	repeat           = flag.Int("repeat", 3, "Number of measured snapshots of each configuration")
+	workDir          = flag.String("work-dir", "", "Directory for repositories and config files (default: new temporary directory)")
+	samplingInterval = flag.Duration("sampling-interval", 100*time.Millisecond, "Interval between samples of the measured process")
+	keep             = flag.Bool("keep", false, "Keep the work directory")
+	outputFile       = flag.String("output", "", "File to append measurements to (default: stdout)")
+	runTags          = flag.String("run-tags", "", "Comma-separated list of tags to attach to measurements")
+)
+
+// configuration is a single combination of swept settings, empty values use kopia defaults.
+type configuration struct {
+	compression string
+	splitter    string
+	encryption  string
+	parallel    string
+}
+
+func (c configuration) tags() []bench.Tag {
+	return []bench.Tag{
+		{Key: "compression", Value: orDefault(c.compression)},
+		{Key: "splitter", Value: orDefault(c.splitter)},
+		{Key: "encryption", Value: orDefault(c.encryption)},
+		{Key: "parallel", Value: orDefault(c.parallel)},
+	}
+}
+
+func orDefault(v string) string {
+	if v == "" {
+		return "default"
+	}
+
+	return v
+}
+
+func main() {
+	flag.Parse()
+
+	if *dataset == "" {
+		log.Fatal("missing --dataset")
+	}
+
+	if *repeat <= 0 {
+		log.Fatal("--repeat must be positive")
+	}
+
+	tags, err := bench.ParseTags(*runTags)
+	if err != nil {
+		log.Fatalf("invalid --run-tags: %v", err)
+	}
+
+	var configs []configuration
+
+	for _, comp := range strings.Split(*compressions, ",") {
+		for _, spl := range strings.Split(*splitters, ",") {
+			for _, enc := range strings.Split(*encryptions, ",") {
+				for _, par := range strings.Split(*parallelValues, ",") {
+					configs = append(configs, configuration{comp, spl, enc, par})
+				}
+			}
+		}
+	}
+
+	if err := run(context.Background(), configs, tags); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, configs []configuration, tags []bench.Tag) error {
+	dir := *workDir
+	if dir == "" {
+		d, err := os.MkdirTemp("", "settingsweep")
+		if err != nil {
+			return err
+		}
+
+		dir = d
+	}
+
+	if !*keep {
+		defer os.RemoveAll(dir)
+	}
+
+	datasetSize, _, err := bench.DirSize(*dataset)
+	if err != nil {
+		return err
+	}
+
+	out, err := bench.OpenOutput(*outputFile)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	sink := bench.NewLineProtocolSink(out)
+
+	for i, cfg := range configs {
+		log.Printf("configuration %v of %v: %+v", i+1, len(configs), cfg)
+
+		pt, err := measure(ctx, filepath.Join(dir, fmt.Sprintf("config-%v", i)), cfg, datasetSize)
+		if err != nil {
+			return err
+		}
+
+		pt.Tags = append(pt.Tags, tags...)
+
+		if err := sink.Write(pt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// measure snapshots the dataset --repeat times with the configuration, each time into a new repository.
+func measure(ctx context.Context, dir string, cfg configuration, datasetSize int64) (bench.Point, error) {
+	var (
+		results  []*bench.Result
+		repoSize int64
+	)
+
+	for i := 0; i < *repeat; i++ {
+		k := bench.Kopia{Exe: *kopiaExe, ConfigFile: filepath.Join(dir, "kopia.config"), Password: repoPassword}
+		repoDir := filepath.Join(dir, "repo")
+
+		if err := os.RemoveAll(dir); err != nil {
+			return bench.Point{}, err
+		}
+
+		if err := createRepository(ctx, k, repoDir, cfg); err != nil {
+			return bench.Point{}, err
+		}
+
+		args := []string{"snapshot", "create", *dataset, "--no-progress", "--no-auto-maintenance"}
+		if cfg.parallel != "" {
+			args = append(args, "--parallel="+cfg.parallel)
+		}
+
+		c := k.Command(ctx, args...)
+		c.Stderr = os.Stderr
+
+		r := &bench.CommandRunner{Interval: *samplingInterval}
+
+		res, err := r.Run(ctx, c)
+		if err != nil {
+			return bench.Point{}, fmt.Errorf("snapshot failed: %w", err)
+		}
+
+		size, _, err := bench.DirSize(repoDir)
+		if err != nil {
+			return bench.Point{}, err
+		}
+
+		results = append(results, res)
+		repoSize += size
+	}
+
+	s := bench.Summarize(results)
+	avgRepoSize := float64(repoSize) / float64(len(results))
+	throughput := float64(datasetSize) / s.AvgDuration
+
+	log.Printf("  %.1fs, %.1f MiB/s, repository size %.0f", s.AvgDuration, throughput/(1<<20), avgRepoSize)
+
+	return bench.Point{
+		Measurement: "settings_sweep",
+		Tags:        cfg.tags(),
+		Fields: append(s.ProcessFields(),
+			bench.Field{Key: "throughput_bytes_per_sec", Value: throughput},
+			bench.Field{Key: "repo_size", Value: avgRepoSize},
+			bench.Field{Key: "size_ratio", Value: bench.Fixed{Value: float64(datasetSize) / avgRepoSize, Digits: 3}}),
+		Time: time.Now(),
+	}, nil
+}
+
+// createRepository creates a filesystem repository with the splitter, encryption and compression.
+func createRepository(ctx context.Context, k bench.Kopia, repoDir string, cfg configuration) error {
+	var args []string
+
+	if cfg.splitter != "" {
+		args = append(args, "--object-splitter="+cfg.splitter)
+	}
+
+	if cfg.encryption != "" {
+		args = append(args, "--encryption="+cfg.encryption)
+	}
+
+	if err := k.CreateRepository(ctx, repoDir, args...); err != nil {
+		return err
+	}
+
+	if cfg.compression == "" {
+		return nil
+	}
+
+	_, err := k.Run(ctx, "policy", "set", "--global", "--compression="+cfg.compression)
+
+	return err
+}
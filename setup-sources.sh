@@ -6,12 +6,14 @@ MAKEMANYFILES=~/go/bin/makemanyfiles
 RUNBENCH=~/go/bin/runbench
 
 setup_packages() {
-	sudo apt install -y axel golang-1.21-go
+	sudo apt install -y axel golang-1.21-go nfs-kernel-server samba cifs-utils
 }
 
 setup_tools() {
-	(cd makemanyfiles && go build -o $MAKEMANYFILES .)
+	# datasets must be identical on all hosts, so that results are comparable
+	(cd makemanyfiles && go test -run TestGoldenDigests . && go build -o $MAKEMANYFILES .)
 	(cd runbench && go build -o $RUNBENCH .)
+	(cd fetchdataset && go build -o ~/go/bin/fetchdataset .)
 }
 
 download_isos() {
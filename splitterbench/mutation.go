@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	mutationInsert    = "insert"
+	mutationDelete    = "delete"
+	mutationOverwrite = "overwrite"
+	mutationAppend    = "append"
+)
+
+var mutationKinds = []string{mutationInsert, mutationDelete, mutationOverwrite, mutationAppend}
+
+func isMutationKind(s string) bool {
+	for _, k := range mutationKinds {
+		if s == k {
+			return true
+		}
+	}
+
+	return false
+}
+
+func fileName(dir string, i int) string {
+	return filepath.Join(dir, fmt.Sprintf("file-%05d", i))
+}
+
+// generateSource writes --files random files, which are the same for every splitter.
+func generateSource(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	rnd := rand.New(rand.NewSource(*seed))
+	buf := make([]byte, *fileSize)
+
+	for i := 0; i < *numFiles; i++ {
+		rnd.Read(buf)
+
+		if err := os.WriteFile(fileName(dir, i), buf, 0o644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mutateSource applies the mutation to every file and returns the number of mutated bytes.
+func mutateSource(dir, mutation string) (int64, error) {
+	rnd := rand.New(rand.NewSource(*seed + 1))
+
+	var mutated int64
+
+	for i := 0; i < *numFiles; i++ {
+		fname := fileName(dir, i)
+
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			return 0, err
+		}
+
+		// mutations are applied from the end of the file, so that earlier offsets are not shifted,
+		// and offsets leave room for all deletions.
+		offsets := make([]int, *mutationsPerFile)
+		for j := range offsets {
+			offsets[j] = rnd.Intn(len(data) - *mutationsPerFile**mutationSize + 1)
+		}
+
+		sort.Sort(sort.Reverse(sort.IntSlice(offsets)))
+
+		for _, off := range offsets {
+			chunk := make([]byte, *mutationSize)
+			rnd.Read(chunk)
+
+			switch mutation {
+			case mutationInsert:
+				data = append(data[:off], append(chunk, data[off:]...)...)
+			case mutationDelete:
+				data = append(data[:off], data[off+*mutationSize:]...)
+			case mutationOverwrite:
+				copy(data[off:], chunk)
+			case mutationAppend:
+				data = append(data, chunk...)
+			}
+
+			mutated += int64(*mutationSize)
+		}
+
+		if err := os.WriteFile(fname, data, 0o644); err != nil {
+			return 0, err
+		}
+	}
+
+	return mutated, nil
+}
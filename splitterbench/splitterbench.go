@@ -0,0 +1,214 @@
+// Command splitterbench compares kopia's object splitters on data changed by controlled mutations:
+// for each splitter it snapshots generated files, mutates them and snapshots them again, reporting
+// how many new bytes the second snapshot stored along with its CPU cost.
+//
+// Usage: splitterbench [--splitters=FIXED-4M,DYNAMIC-4M-BUZHASH] [--mutations=insert,delete,overwrite,append] [--files=N] [--file-size=N]
+//
+// The source consists of --files random files of --file-size generated from --seed. Each mutation
+// is applied --mutations-per-file times to every file at offsets chosen by --seed:
+//
+//	insert     - inserts --mutation-size random bytes, shifting the rest of the file
+//	delete     - removes --mutation-size bytes, shifting the rest of the file
+//	overwrite  - overwrites --mutation-size bytes in place
+//	append     - appends --mutation-size random bytes
+//
+// Content-defined (dynamic) splitters are expected to store little more than the mutated bytes for
+// shifting mutations, while fixed-size splitters store everything after the first mutation.
+// Compression is disabled so that stored bytes are comparable.
+//
+// Measurements are written to --output (stdout by default) as 'splitter_comparison' lines tagged
+// with the splitter, mutation and --run-tags, with duration and CPU usage of both snapshots, the
+// number of mutated bytes, the number of bytes the second snapshot added to the repository and
+// their ratio.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"runbench/pkg/bench"
+)
+
+const repoPassword = "dummy"
+
+var (
+	kopiaExe         = flag.String("kopia-exe", os.ExpandEnv("$HOME/go/bin/kopia"), "Path to kopia")
+	splitters        = flag.String("splitters", "FIXED-1M,FIXED-4M,DYNAMIC-1M-BUZHASH,DYNAMIC-4M-BUZHASH,DYNAMIC-8M-BUZHASH,DYNAMIC-4M-RABINKARP", "Comma-separated list of compared splitters")
+	mutations        = flag.String("mutations", strings.Join(mutationKinds, ","), "Comma-separated list of mutations")
+	numFiles         = flag.Int("files", 20, "Number of generated files")
+	fileSize         = flag.Int("file-size", 64<<20, "Size of generated files")
+	mutationsPerFile = flag.Int("mutations-per-file", 4, "Number of mutations of each file")
+	mutationSize     = flag.Int("mutation-size", 100, "Number of bytes inserted, deleted, overwritten or appended by each mutation")
+	seed             = flag.Int64("seed", 1, "Seed of generated files and mutations")
+	workDir          = flag.String("work-dir", "", "Directory for the source files and repositories (default: new temporary directory)")
+	samplingInterval = flag.Duration("sampling-interval", 100*time.Millisecond, "Interval between samples of the measured process")
+	keep             = flag.Bool("keep", false, "Keep the work directory")
+	outputFile       = flag.String("output", "", "File to append measurements to (default: stdout)")
+	runTags          = flag.String("run-tags", "", "Comma-separated list of tags to attach to measurements")
+)
+
+func main() {
+	flag.Parse()
+
+	if *numFiles <= 0 || *fileSize <= 0 || *mutationsPerFile <= 0 || *mutationSize <= 0 {
+		log.Fatal("--files, --file-size, --mutations-per-file and --mutation-size must be positive")
+	}
+
+	if *mutationsPerFile**mutationSize > *fileSize {
+		log.Fatal("mutations of a file must not be larger than --file-size")
+	}
+
+	tags, err := bench.ParseTags(*runTags)
+	if err != nil {
+		log.Fatalf("invalid --run-tags: %v", err)
+	}
+
+	muts := strings.Split(*mutations, ",")
+
+	for _, m := range muts {
+		if !isMutationKind(m) {
+			log.Fatalf("unsupported mutation %q", m)
+		}
+	}
+
+	if err := run(context.Background(), strings.Split(*splitters, ","), muts, tags); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, spls, muts []string, tags []bench.Tag) error {
+	dir := *workDir
+	if dir == "" {
+		d, err := os.MkdirTemp("", "splitterbench")
+		if err != nil {
+			return err
+		}
+
+		dir = d
+	}
+
+	if !*keep {
+		defer os.RemoveAll(dir)
+	}
+
+	out, err := bench.OpenOutput(*outputFile)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	sink := bench.NewLineProtocolSink(out)
+
+	for _, mut := range muts {
+		for _, spl := range spls {
+			pt, err := measure(ctx, filepath.Join(dir, mut+"-"+spl), spl, mut)
+			if err != nil {
+				return fmt.Errorf("%v with %v: %w", spl, mut, err)
+			}
+
+			pt.Tags = append(pt.Tags, tags...)
+
+			if err := sink.Write(pt); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// measure snapshots freshly generated source with the splitter, mutates it and snapshots it again.
+func measure(ctx context.Context, dir, splitter, mutation string) (bench.Point, error) {
+	k := bench.Kopia{Exe: *kopiaExe, ConfigFile: filepath.Join(dir, "kopia.config"), Password: repoPassword}
+	repoDir := filepath.Join(dir, "repo")
+	sourceDir := filepath.Join(dir, "source")
+
+	if err := generateSource(sourceDir); err != nil {
+		return bench.Point{}, err
+	}
+
+	if err := k.CreateRepository(ctx, repoDir, "--object-splitter="+splitter); err != nil {
+		return bench.Point{}, err
+	}
+
+	if _, err := k.Run(ctx, "policy", "set", "--global", "--compression=none"); err != nil {
+		return bench.Point{}, err
+	}
+
+	initial, err := snapshot(ctx, k, sourceDir)
+	if err != nil {
+		return bench.Point{}, err
+	}
+
+	sizeBefore, _, err := bench.DirSize(repoDir)
+	if err != nil {
+		return bench.Point{}, err
+	}
+
+	mutated, err := mutateSource(sourceDir, mutation)
+	if err != nil {
+		return bench.Point{}, err
+	}
+
+	incremental, err := snapshot(ctx, k, sourceDir)
+	if err != nil {
+		return bench.Point{}, err
+	}
+
+	sizeAfter, _, err := bench.DirSize(repoDir)
+	if err != nil {
+		return bench.Point{}, err
+	}
+
+	newBytes := sizeAfter - sizeBefore
+	si := bench.Summarize([]*bench.Result{initial})
+	sm := bench.Summarize([]*bench.Result{incremental})
+
+	log.Printf("%v %v: mutated %v bytes, stored %v new bytes in %.1fs", splitter, mutation, mutated, newBytes, sm.AvgDuration)
+
+	if !*keep {
+		if err := os.RemoveAll(dir); err != nil {
+			return bench.Point{}, err
+		}
+	}
+
+	return bench.Point{
+		Measurement: "splitter_comparison",
+		Tags: []bench.Tag{
+			{Key: "splitter", Value: splitter},
+			{Key: "mutation", Value: mutation},
+		},
+		Fields: []bench.Field{
+			{Key: "initial_duration", Value: bench.Fixed{Value: si.AvgDuration, Digits: 1}},
+			{Key: "initial_avg_cpu_percent", Value: si.AvgCPU},
+			{Key: "duration", Value: bench.Fixed{Value: sm.AvgDuration, Digits: 1}},
+			{Key: "avg_cpu_percent", Value: sm.AvgCPU},
+			{Key: "repo_size", Value: sizeBefore},
+			{Key: "mutated_bytes", Value: mutated},
+			{Key: "new_bytes", Value: newBytes},
+			{Key: "new_bytes_ratio", Value: bench.Fixed{Value: float64(newBytes) / float64(mutated), Digits: 2}},
+		},
+		Time: time.Now(),
+	}, nil
+}
+
+func snapshot(ctx context.Context, k bench.Kopia, sourceDir string) (*bench.Result, error) {
+	c := k.Command(ctx, "snapshot", "create", sourceDir, "--no-progress", "--no-auto-maintenance")
+	c.Stderr = os.Stderr
+
+	r := &bench.CommandRunner{Interval: *samplingInterval}
+
+	res, err := r.Run(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot failed: %w", err)
+	}
+
+	return res, nil
+}